@@ -0,0 +1,338 @@
+package challenge11
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sleepFetcher takes a fixed time per fetch and records its own concurrency
+type sleepFetcher struct {
+	delay    time.Duration
+	inFlight int32
+	peak     int32
+}
+
+func (f *sleepFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	n := atomic.AddInt32(&f.inFlight, 1)
+	defer atomic.AddInt32(&f.inFlight, -1)
+	for {
+		peak := atomic.LoadInt32(&f.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&f.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(f.delay)
+	return []byte(url), nil
+}
+
+type titleProcessor struct{}
+
+func (titleProcessor) Process(ctx context.Context, content []byte) (ProcessedData, error) {
+	return ProcessedData{Title: string(content)}, nil
+}
+
+func testURLs(n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+	return urls
+}
+
+func TestLastRunStats(t *testing.T) {
+	fetcher := &sleepFetcher{delay: 20 * time.Millisecond}
+	aggregator := NewContentAggregator(fetcher, titleProcessor{}, 3, 100)
+	defer aggregator.Shutdown()
+
+	if stats := aggregator.LastRunStats(); stats != (RunStats{}) {
+		t.Errorf("Expected empty stats before any run, got %+v", stats)
+	}
+
+	results, err := aggregator.FetchAndProcess(context.Background(), testURLs(12))
+	if err != nil || len(results) != 12 {
+		t.Fatalf("Expected 12 results, got %d (%v)", len(results), err)
+	}
+
+	stats := aggregator.LastRunStats()
+	if stats.URLs != 12 {
+		t.Errorf("Expected 12 URLs, got %d", stats.URLs)
+	}
+	if stats.PeakConcurrency < 1 || stats.PeakConcurrency > 3 {
+		t.Errorf("Expected peak concurrency between 1 and 3, got %d", stats.PeakConcurrency)
+	}
+	if peak := atomic.LoadInt32(&fetcher.peak); int(peak) > stats.PeakConcurrency {
+		t.Errorf("Fetcher saw %d concurrent fetches, stats report %d", peak, stats.PeakConcurrency)
+	}
+	if stats.AvgLatency < fetcher.delay {
+		t.Errorf("Expected average latency >= %v, got %v", fetcher.delay, stats.AvgLatency)
+	}
+	if stats.P95Latency < stats.AvgLatency {
+		t.Errorf("Expected p95 latency >= average, got %v < %v", stats.P95Latency, stats.AvgLatency)
+	}
+
+	aggregator.ResetStats()
+	if stats := aggregator.LastRunStats(); stats != (RunStats{}) {
+		t.Errorf("Expected empty stats after reset, got %+v", stats)
+	}
+}
+
+func TestLastRunStatsRateLimitWait(t *testing.T) {
+	fetcher := &sleepFetcher{}
+	// The burst of 5 is served at once, the 3 remaining URLs wait ~200ms each
+	aggregator := NewContentAggregator(fetcher, titleProcessor{}, 2, 5)
+	defer aggregator.Shutdown()
+
+	if _, err := aggregator.FetchAndProcess(context.Background(), testURLs(8)); err != nil {
+		t.Fatalf("FetchAndProcess failed: %v", err)
+	}
+
+	stats := aggregator.LastRunStats()
+	if stats.RateLimitWait < 400*time.Millisecond {
+		t.Errorf("Expected rate limit wait >= 400ms, got %v", stats.RateLimitWait)
+	}
+	if stats.PeakConcurrency > 2 {
+		t.Errorf("Expected peak concurrency <= 2, got %d", stats.PeakConcurrency)
+	}
+}
+
+func TestRunCollectorPercentile(t *testing.T) {
+	rc := &runCollector{}
+	for i := 1; i <= 100; i++ {
+		rc.fetchStarted()
+		rc.fetchDone(time.Duration(i) * time.Millisecond)
+	}
+
+	stats := rc.snapshot(100)
+	if stats.P95Latency != 95*time.Millisecond {
+		t.Errorf("Expected p95 of 95ms, got %v", stats.P95Latency)
+	}
+	if stats.AvgLatency != 50500*time.Microsecond {
+		t.Errorf("Expected average of 50.5ms, got %v", stats.AvgLatency)
+	}
+	if stats.PeakConcurrency != 1 {
+		t.Errorf("Expected peak concurrency 1, got %d", stats.PeakConcurrency)
+	}
+}
+
+func assertDelays(t *testing.T, name string, b BackoffStrategy, expected []time.Duration) {
+	t.Helper()
+	for i, want := range expected {
+		if got := b.NextDelay(i + 1); got != want {
+			t.Errorf("%s: expected delay %v for attempt %d, got %v", name, want, i+1, got)
+		}
+	}
+}
+
+func TestBackoffStrategies(t *testing.T) {
+	ms := time.Millisecond
+
+	assertDelays(t, "constant", ConstantBackoff{Delay: 50 * ms},
+		[]time.Duration{50 * ms, 50 * ms, 50 * ms})
+
+	assertDelays(t, "exponential", ExponentialBackoff{Base: 10 * ms, Max: 50 * ms},
+		[]time.Duration{10 * ms, 20 * ms, 40 * ms, 50 * ms, 50 * ms})
+
+	// Uncapped delays saturate instead of overflowing
+	if d := (ExponentialBackoff{Base: time.Second}).NextDelay(100); d <= 0 {
+		t.Errorf("Expected a positive delay for a large attempt, got %v", d)
+	}
+
+	jitter := []float64{0, 0.5, 0.99, 0.5}
+	i := 0
+	random := func() float64 {
+		r := jitter[i%len(jitter)]
+		i++
+		return r
+	}
+	assertDelays(t, "jittered", JitteredExponentialBackoff{Base: 100 * ms, Max: 400 * ms, Rand: random},
+		[]time.Duration{50 * ms, 150 * ms, 398 * ms, 300 * ms})
+}
+
+// flakyFetcher fails its first calls, up to failures
+type flakyFetcher struct {
+	failures int32
+	calls    int32
+	fetched  chan struct{} // Signaled after each fetch if not nil
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	if f.fetched != nil {
+		defer func() { f.fetched <- struct{}{} }()
+	}
+	if atomic.AddInt32(&f.calls, 1) <= f.failures {
+		return nil, fmt.Errorf("temporary failure")
+	}
+	return []byte(url), nil
+}
+
+func TestFetchRetries(t *testing.T) {
+	fetcher := &flakyFetcher{failures: 2}
+	aggregator := NewContentAggregator(fetcher, titleProcessor{}, 1, 100,
+		WithRetry(2, ConstantBackoff{Delay: time.Millisecond}))
+	defer aggregator.Shutdown()
+
+	results, err := aggregator.FetchAndProcess(context.Background(), testURLs(1))
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Expected the retries to succeed, got %d results (%v)", len(results), err)
+	}
+	if calls := atomic.LoadInt32(&fetcher.calls); calls != 3 {
+		t.Errorf("Expected 3 fetch attempts, got %d", calls)
+	}
+
+	// Without retries the first failure is final
+	fetcher = &flakyFetcher{failures: 1}
+	noRetry := NewContentAggregator(fetcher, titleProcessor{}, 1, 100)
+	defer noRetry.Shutdown()
+	if _, err := noRetry.FetchAndProcess(context.Background(), testURLs(1)); err == nil {
+		t.Error("Expected an error without retries")
+	}
+}
+
+func TestRetriesAreRateLimited(t *testing.T) {
+	fetcher := &flakyFetcher{failures: 2}
+	// A burst of 1 at 5 per second, the 2 retries wait ~200ms each
+	aggregator := NewContentAggregator(fetcher, titleProcessor{}, 1, 5,
+		WithRetry(2, ConstantBackoff{Delay: time.Millisecond}))
+	aggregator.rateLimiter.SetBurst(1)
+	defer aggregator.Shutdown()
+
+	start := time.Now()
+	if _, err := aggregator.FetchAndProcess(context.Background(), testURLs(1)); err != nil {
+		t.Fatalf("FetchAndProcess failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("Expected the retries to wait for the rate limiter, took %v", elapsed)
+	}
+	if wait := aggregator.LastRunStats().RateLimitWait; wait < 350*time.Millisecond {
+		t.Errorf("Expected rate limit wait >= 350ms, got %v", wait)
+	}
+}
+
+func TestBackoffInterruptedByContext(t *testing.T) {
+	fetcher := &flakyFetcher{failures: 100, fetched: make(chan struct{}, 1)}
+	aggregator := NewContentAggregator(fetcher, titleProcessor{}, 1, 100,
+		WithRetry(5, ConstantBackoff{Delay: time.Hour}))
+	defer aggregator.Shutdown()
+
+	// Cancel once the first attempt failed, the aggregator is then in or
+	// about to enter the backoff
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-fetcher.fetched
+		cancel()
+	}()
+
+	start := time.Now()
+	aggregator.FetchAndProcess(ctx, testURLs(1))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected cancellation to interrupt the backoff, took %v", elapsed)
+	}
+	if calls := atomic.LoadInt32(&fetcher.calls); calls != 1 {
+		t.Errorf("Expected a single attempt before cancellation, got %d", calls)
+	}
+
+	if err := aggregator.sleep(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("Expected context.Canceled from a cancelled sleep, got %v", err)
+	}
+}
+
+// notFoundFetcher fails every fetch of the URLs ending in /missing
+type notFoundFetcher struct {
+	calls int32
+}
+
+func (f *notFoundFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	if strings.HasSuffix(url, "/missing") {
+		atomic.AddInt32(&f.calls, 1)
+		return nil, errNotFound
+	}
+	return []byte(url), nil
+}
+
+var errNotFound = errors.New("bad status code: 404")
+
+type deadLetter struct {
+	url      string
+	err      error
+	attempts int
+}
+
+// deadLetterSink records the dead letters it receives
+type deadLetterSink struct {
+	mu      sync.Mutex
+	letters []deadLetter
+}
+
+func (s *deadLetterSink) receive(url string, err error, attempts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters = append(s.letters, deadLetter{url, err, attempts})
+}
+
+func TestDeadLetterAfterRetries(t *testing.T) {
+	fetcher := &notFoundFetcher{}
+	sink := &deadLetterSink{}
+	aggregator := NewContentAggregator(fetcher, titleProcessor{}, 2, 100,
+		WithRetry(2, ConstantBackoff{Delay: time.Millisecond}), WithDeadLetter(sink.receive))
+	defer aggregator.Shutdown()
+
+	urls := append(testURLs(3), "https://example.com/missing")
+	results, err := aggregator.FetchAndProcess(context.Background(), urls)
+	if err == nil || len(results) != 3 {
+		t.Fatalf("Expected 3 results and an error, got %d results (%v)", len(results), err)
+	}
+
+	if len(sink.letters) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %+v", sink.letters)
+	}
+	letter := sink.letters[0]
+	if letter.url != "https://example.com/missing" || letter.attempts != 3 || !errors.Is(letter.err, errNotFound) {
+		t.Errorf("Expected the missing URL after 3 attempts with a 404, got %+v", letter)
+	}
+	if calls := atomic.LoadInt32(&fetcher.calls); calls != 3 {
+		t.Errorf("Expected 3 fetches of the missing URL, got %d", calls)
+	}
+}
+
+// failingProcessor rejects every content
+type failingProcessor struct{}
+
+func (failingProcessor) Process(ctx context.Context, content []byte) (ProcessedData, error) {
+	return ProcessedData{}, errors.New("title not found")
+}
+
+func TestDeadLetterUnprocessable(t *testing.T) {
+	sink := &deadLetterSink{}
+	aggregator := NewContentAggregator(&notFoundFetcher{}, failingProcessor{}, 1, 100, WithDeadLetter(sink.receive))
+	defer aggregator.Shutdown()
+
+	aggregator.FetchAndProcess(context.Background(), testURLs(1))
+	if len(sink.letters) != 1 || sink.letters[0].attempts != 1 || sink.letters[0].err.Error() != "title not found" {
+		t.Errorf("Expected the unprocessable URL as a dead letter, got %+v", sink.letters)
+	}
+}
+
+func TestDeadLetterSkipsCancellation(t *testing.T) {
+	sink := &deadLetterSink{}
+	aggregator := NewContentAggregator(&flakyFetcher{failures: 100}, titleProcessor{}, 1, 100,
+		WithRetry(5, ConstantBackoff{Delay: time.Hour}), WithDeadLetter(sink.receive))
+	defer aggregator.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	aggregator.FetchAndProcess(ctx, testURLs(1))
+	// Let the worker see the interrupted backoff
+	time.Sleep(20 * time.Millisecond)
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.letters) != 0 {
+		t.Errorf("Expected no dead letter for a cancelled run, got %+v", sink.letters)
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"fmt"
 	"strings"
 	"io"
+	"sort"
+	"math"
+	"math/rand"
 
 	"golang.org/x/time/rate"
 	"golang.org/x/net/html"
@@ -44,6 +47,151 @@ type ContentAggregator struct {
 	shutdown         chan struct{}
 	mu               sync.RWMutex
 	isShuttingDown   bool
+	statsMu          sync.Mutex
+	lastStats        RunStats
+	maxRetries       int
+	backoff          BackoffStrategy
+	deadLetter       DeadLetterFunc
+}
+
+// AggregatorOption configures optional ContentAggregator behaviors
+type AggregatorOption func(*ContentAggregator)
+
+// WithRetry retries a failed fetch up to maxRetries times, waiting between
+// attempts as given by the backoff strategy.
+func WithRetry(maxRetries int, backoff BackoffStrategy) AggregatorOption {
+	return func(ca *ContentAggregator) {
+		ca.maxRetries = maxRetries
+		ca.backoff = backoff
+	}
+}
+
+// DeadLetterFunc receives a URL that could not be processed, with the final
+// error and the number of fetch attempts made
+type DeadLetterFunc func(url string, err error, attempts int)
+
+// WithDeadLetter hands the URLs whose fetch failed after the retries, or
+// whose content could not be processed, to sink. URLs abandoned because the
+// context is done or the aggregator shuts down are not dead letters. The
+// sink may be called concurrently by the workers.
+func WithDeadLetter(sink DeadLetterFunc) AggregatorOption {
+	return func(ca *ContentAggregator) {
+		ca.deadLetter = sink
+	}
+}
+
+// BackoffStrategy gives the delay to wait before a retry, attempt starts at 1
+type BackoffStrategy interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same delay before each retry
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+func (b ConstantBackoff) NextDelay(attempt int) time.Duration {
+	return b.Delay
+}
+
+// ExponentialBackoff doubles the delay on each retry, starting at Base and
+// capped at Max when Max is set.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := float64(b.Base) * math.Pow(2, float64(attempt-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		return b.Max
+	}
+	if delay >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
+
+// JitteredExponentialBackoff randomizes the exponential delay d within
+// [d/2, d) so that workers retrying together spread out. Rand returns a
+// value in [0, 1), it defaults to math/rand.
+type JitteredExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+	Rand func() float64
+}
+
+func (b JitteredExponentialBackoff) NextDelay(attempt int) time.Duration {
+	random := b.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+	delay := ExponentialBackoff{b.Base, b.Max}.NextDelay(attempt)
+	return delay/2 + time.Duration(float64(delay/2)*random())
+}
+
+// RunStats holds the instrumentation of a FetchAndProcess run
+type RunStats struct {
+	URLs            int
+	PeakConcurrency int           // Maximum number of fetches in flight
+	AvgLatency      time.Duration // Average fetch latency
+	P95Latency      time.Duration // 95th percentile fetch latency
+	RateLimitWait   time.Duration // Total time workers waited on the rate limiter
+}
+
+// runCollector accumulates the stats of a run, it is shared by the workers
+type runCollector struct {
+	mu        sync.Mutex
+	inFlight  int
+	peak      int
+	latencies []time.Duration
+	rateWait  time.Duration
+}
+
+func (rc *runCollector) fetchStarted() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.inFlight++
+	if rc.inFlight > rc.peak {
+		rc.peak = rc.inFlight
+	}
+}
+
+func (rc *runCollector) fetchDone(latency time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.inFlight--
+	rc.latencies = append(rc.latencies, latency)
+}
+
+func (rc *runCollector) rateLimited(wait time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.rateWait += wait
+}
+
+func (rc *runCollector) snapshot(urls int) RunStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	stats := RunStats{URLs: urls, PeakConcurrency: rc.peak, RateLimitWait: rc.rateWait}
+	if len(rc.latencies) == 0 {
+		return stats
+	}
+
+	latencies := append([]time.Duration(nil), rc.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range(latencies) {
+		total += l
+	}
+	stats.AvgLatency = total / time.Duration(len(latencies))
+	// Nearest-rank percentile
+	stats.P95Latency = latencies[(len(latencies)*95+99)/100-1]
+	return stats
 }
 
 // NewContentAggregator creates a new ContentAggregator with the specified configuration
@@ -52,6 +200,7 @@ func NewContentAggregator(
 	processor ContentProcessor,
 	workerCount int,
 	requestsPerSecond int,
+	opts ...AggregatorOption,
 ) *ContentAggregator {
 	if workerCount <= 0 || requestsPerSecond <= 0 {
 		return nil
@@ -61,13 +210,20 @@ func NewContentAggregator(
 		return nil
 	}
 
-	return &ContentAggregator{
+	ca := &ContentAggregator{
 		fetcher:          fetcher,
 		processor:        processor,
 		workerCount:      workerCount,
 		rateLimiter:      rate.NewLimiter(rate.Limit(requestsPerSecond), requestsPerSecond),
 		shutdown:         make(chan struct{}),
 	}
+	for _, opt := range(opts) {
+		opt(ca)
+	}
+	if ca.maxRetries > 0 && ca.backoff == nil {
+		ca.backoff = ConstantBackoff{}
+	}
+	return ca
 }
 
 // FetchAndProcess concurrently fetches and processes content from multiple URLs
@@ -82,13 +238,33 @@ func (ca *ContentAggregator) FetchAndProcess(
 		return nil, errors.New("shutting down")
 	}
 
-	results, errs := ca.fanOut(ctx, urls)
+	stats := &runCollector{}
+	results, errs := ca.fanOut(ctx, urls, stats)
+
+	ca.statsMu.Lock()
+	ca.lastStats = stats.snapshot(len(urls))
+	ca.statsMu.Unlock()
+
 	if len(errs) > 0 {
 		return results, fmt.Errorf("got %d errors", len(errs))
 	}
 	return results, nil
 }
 
+// LastRunStats returns the stats of the last completed FetchAndProcess run
+func (ca *ContentAggregator) LastRunStats() RunStats {
+	ca.statsMu.Lock()
+	defer ca.statsMu.Unlock()
+	return ca.lastStats
+}
+
+// ResetStats clears the stats of the last run
+func (ca *ContentAggregator) ResetStats() {
+	ca.statsMu.Lock()
+	defer ca.statsMu.Unlock()
+	ca.lastStats = RunStats{}
+}
+
 // Shutdown performs cleanup and ensures all resources are properly released
 func (ca *ContentAggregator) Shutdown() error {
 	ca.mu.Lock()
@@ -109,6 +285,7 @@ func (ca *ContentAggregator) workerPool(
 	jobs <-chan string,
 	results chan<- ProcessedData,
 	errors chan<- error,
+	stats *runCollector,
 ) {
 	defer ca.wg.Done()
 
@@ -122,19 +299,17 @@ func (ca *ContentAggregator) workerPool(
 			if ! ok {
 				return
 			}
-			if err := ca.rateLimiter.Wait(ctx); err != nil {
-				select {
-				case errors <- fmt.Errorf("rate limiter error for %s: %v", url, err):
-				case <-ctx.Done():
-				case <-ca.shutdown:
-				}
-				continue
-			}
-
-			content, err := ca.fetcher.Fetch(ctx, url)
+			content, attempts, err := ca.fetchWithRetry(ctx, url, stats)
 			if err != nil {
+				// Nothing was fetched if the first rate limiter wait failed
+				msg := "fetch error"
+				if attempts == 0 {
+					msg = "rate limiter error"
+				} else {
+					ca.deadLettered(ctx, url, err, attempts)
+				}
 				select {
-				case errors <- fmt.Errorf("fetch error for %s: %v", url, err):
+				case errors <- fmt.Errorf("%s for %s: %v", msg, url, err):
 				case <-ctx.Done():
 				case <-ca.shutdown:
 				}
@@ -143,6 +318,7 @@ func (ca *ContentAggregator) workerPool(
 
 			data, err := ca.processor.Process(ctx, content)
 			if err != nil {
+				ca.deadLettered(ctx, url, err, attempts)
 				select {
 				case errors <- fmt.Errorf("processing error for %s: %v", url, err):
 				case <-ctx.Done():
@@ -163,10 +339,67 @@ func (ca *ContentAggregator) workerPool(
 	}
 }
 
+// fetchWithRetry fetches url, retrying failures with the configured backoff,
+// and returns the number of attempts made. Every attempt waits for the rate
+// limiter, retries included. A pending backoff is interrupted by ctx
+// cancellation or shutdown.
+func (ca *ContentAggregator) fetchWithRetry(ctx context.Context, url string, stats *runCollector) ([]byte, int, error) {
+	for attempt := 0; ; attempt++ {
+		waitStart := time.Now()
+		err := ca.rateLimiter.Wait(ctx)
+		stats.rateLimited(time.Since(waitStart))
+		if err != nil {
+			return nil, attempt, err
+		}
+
+		stats.fetchStarted()
+		fetchStart := time.Now()
+		content, err := ca.fetcher.Fetch(ctx, url)
+		stats.fetchDone(time.Since(fetchStart))
+
+		if err == nil || attempt >= ca.maxRetries {
+			return content, attempt + 1, err
+		}
+		if err := ca.sleep(ctx, ca.backoff.NextDelay(attempt+1)); err != nil {
+			return nil, attempt + 1, err
+		}
+	}
+}
+
+// deadLettered hands a failed URL to the dead-letter sink, unless the
+// failure comes from ctx or shutdown
+func (ca *ContentAggregator) deadLettered(ctx context.Context, url string, err error, attempts int) {
+	if ca.deadLetter == nil || ctx.Err() != nil {
+		return
+	}
+	select {
+	case <-ca.shutdown:
+		return
+	default:
+	}
+	ca.deadLetter(url, err, attempts)
+}
+
+// sleep waits for d unless ctx is done or the aggregator shuts down
+func (ca *ContentAggregator) sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ca.shutdown:
+		return errors.New("shutting down")
+	}
+}
+
 // fanOut implements a fan-out, fan-in pattern for processing multiple items concurrently
 func (ca *ContentAggregator) fanOut(
 	ctx context.Context,
 	urls []string,
+	stats *runCollector,
 ) ([]ProcessedData, []error) {
 	jobs := make(chan string, len(urls))
 	results := make(chan ProcessedData, len(urls))
@@ -174,7 +407,7 @@ func (ca *ContentAggregator) fanOut(
 
 	ca.wg.Add(ca.workerCount)
 	for range(ca.workerCount) {
-		go ca.workerPool(ctx, jobs, results, errs)
+		go ca.workerPool(ctx, jobs, results, errs, stats)
 	}
 
 	// Send jobs
@@ -0,0 +1,325 @@
+package challenge12
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func emptyFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	return path
+}
+
+func TestMultiWriter(t *testing.T) {
+	data := []byte(`{"name":"test"}`)
+
+	tests := []struct {
+		name    string
+		mode    WriteMode
+		wantErr bool
+	}{
+		{"all must succeed", AllMustSucceed, true},
+		{"best effort", BestEffort, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			good := emptyFile(t, dir, "out.json")
+			bad := filepath.Join(dir, "missing", "out.json")
+
+			mw := NewMultiWriter(tt.mode, NewFileWriter(good), NewFileWriter(bad))
+			err := mw.Write(context.Background(), data)
+
+			if tt.wantErr {
+				var pe *PipelineError
+				if !errors.As(err, &pe) || pe.Stage != "write" {
+					t.Fatalf("Expected a write PipelineError, got %v", err)
+				}
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("Expected the writer error to be wrapped, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// The successful writer wrote in both modes
+			written, err := os.ReadFile(good)
+			if err != nil || string(written) != string(data) {
+				t.Errorf("Expected %s to contain %s, got %s (%v)", good, data, written, err)
+			}
+		})
+	}
+}
+
+func TestMultiWriterBestEffortAllFail(t *testing.T) {
+	dir := t.TempDir()
+	mw := NewMultiWriter(BestEffort,
+		NewFileWriter(filepath.Join(dir, "a", "out.json")),
+		NewFileWriter(filepath.Join(dir, "b", "out.json")))
+
+	err := mw.Write(context.Background(), []byte(`{}`))
+	var pe *PipelineError
+	if !errors.As(err, &pe) || pe.Stage != "write" {
+		t.Fatalf("Expected a write PipelineError, got %v", err)
+	}
+}
+
+func TestMultiWriterInPipeline(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	if err := os.WriteFile(input, []byte(`{"name":"test"}`), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	out1, out2 := emptyFile(t, dir, "out1.json"), emptyFile(t, dir, "out2.json")
+
+	p := NewPipeline(NewFileReader(input), []Validator{NewJSONValidator()}, nil,
+		NewMultiWriter(AllMustSucceed, NewFileWriter(out1), NewFileWriter(out2)))
+	if err := p.Process(context.Background()); err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	for _, out := range []string{out1, out2} {
+		if data, _ := os.ReadFile(out); string(data) != `{"name":"test"}` {
+			t.Errorf("Expected %s to be written, got %s", out, data)
+		}
+	}
+}
+
+func hasField(name string) func(map[string]any) bool {
+	return func(record map[string]any) bool {
+		_, ok := record[name]
+		return ok
+	}
+}
+
+func TestConditionalTransformer(t *testing.T) {
+	upper := NewFieldTransformer("name", strings.ToUpper)
+	lower := NewFieldTransformer("name", strings.ToLower)
+	ct := NewConditionalTransformer(hasField("vip"), upper, lower)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"name":"Alice","vip":true}`, `{"name":"ALICE","vip":true}`},
+		{`{"name":"Bob"}`, `{"name":"bob"}`},
+	}
+	for _, tt := range tests {
+		result, err := ct.Transform([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("Transform(%s) failed: %v", tt.input, err)
+		}
+		if string(result) != tt.expected {
+			t.Errorf("Transform(%s) = %s, expected %s", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestConditionalTransformerNilBranch(t *testing.T) {
+	ct := NewConditionalTransformer(hasField("vip"), NewFieldTransformer("name", strings.ToUpper), nil)
+
+	input := `{"name":"Bob"}`
+	result, err := ct.Transform([]byte(input))
+	if err != nil || string(result) != input {
+		t.Errorf("Expected the record to pass through unchanged, got %s (%v)", result, err)
+	}
+
+	if NewConditionalTransformer(hasField("vip"), nil, nil) != nil {
+		t.Error("Expected nil with both branches nil")
+	}
+	if NewConditionalTransformer(nil, ct, nil) != nil {
+		t.Error("Expected nil without a predicate")
+	}
+
+	// A literal without a predicate takes the Else branch
+	literal := &ConditionalTransformer{Else: NewFieldTransformer("name", strings.ToLower)}
+	result, err = literal.Transform([]byte(`{"name":"Bob"}`))
+	if err != nil || string(result) != `{"name":"bob"}` {
+		t.Errorf("Expected the Else branch without a predicate, got %s (%v)", result, err)
+	}
+}
+
+func TestConditionalTransformerErrors(t *testing.T) {
+	ct := NewConditionalTransformer(hasField("vip"), NewFieldTransformer("name", strings.ToUpper), nil)
+
+	var te *TransformError
+	_, err := ct.Transform([]byte(`not json`))
+	if !errors.As(err, &te) || te.Stage != "condition" || !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected a condition TransformError, got %v", err)
+	}
+
+	// The branch error is wrapped with the branch taken
+	_, err = ct.Transform([]byte(`{"vip":true}`))
+	if !errors.As(err, &te) || te.Stage != "condition/then" || !errors.Is(err, ErrMissingField) {
+		t.Errorf("Expected a condition/then TransformError, got %v", err)
+	}
+}
+
+func TestEncodingTransformersRoundTrip(t *testing.T) {
+	original := []byte(`{"name":"test","tags":["a","b"]}`)
+
+	tests := []struct {
+		name    string
+		encode  Transformer
+		decode  Transformer
+		encoded func([]byte) bool
+	}{
+		{"gzip", NewGzipTransformer(), NewGunzipTransformer(), func(b []byte) bool {
+			return len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b
+		}},
+		{"base64", NewBase64Transformer(), NewBase64DecodeTransformer(), func(b []byte) bool {
+			return string(b) == "eyJuYW1lIjoidGVzdCIsInRhZ3MiOlsiYSIsImIiXX0="
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.encode.Transform(original)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if !tt.encoded(encoded) {
+				t.Errorf("Unexpected encoded output: %q", encoded)
+			}
+
+			decoded, err := tt.decode.Transform(encoded)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if string(decoded) != string(original) {
+				t.Errorf("Expected round trip to yield %s, got %s", original, decoded)
+			}
+		})
+	}
+
+	// Chained, as in a pipeline writing to a text sink
+	data := original
+	for _, tr := range []Transformer{NewGzipTransformer(), NewBase64Transformer(), NewBase64DecodeTransformer(), NewGunzipTransformer()} {
+		var err error
+		if data, err = tr.Transform(data); err != nil {
+			t.Fatalf("Chained transform failed: %v", err)
+		}
+	}
+	if string(data) != string(original) {
+		t.Errorf("Expected chained round trip to yield %s, got %s", original, data)
+	}
+}
+
+func TestDecodeTransformersCorruptInput(t *testing.T) {
+	gzipped, _ := NewGzipTransformer().Transform([]byte(`{"name":"test"}`))
+
+	tests := []struct {
+		name   string
+		decode Transformer
+		input  []byte
+		stage  string
+	}{
+		{"gunzip not gzip", NewGunzipTransformer(), []byte("plain text"), "gunzip"},
+		{"gunzip truncated", NewGunzipTransformer(), gzipped[:len(gzipped)-6], "gunzip"},
+		{"base64 invalid", NewBase64DecodeTransformer(), []byte("not base64!"), "base64 decode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.decode.Transform(tt.input)
+			var te *TransformError
+			if !errors.As(err, &te) || te.Stage != tt.stage {
+				t.Fatalf("Expected a %s TransformError, got %v", tt.stage, err)
+			}
+			if !errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("Expected ErrInvalidFormat, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPRoundTrip(t *testing.T) {
+	var received []byte
+	var contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"name":"test"}`))
+		case http.MethodPost:
+			contentType = r.Header.Get("Content-Type")
+			received, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer ts.Close()
+
+	p := NewPipeline(NewHTTPReader(ts.URL), []Validator{NewJSONValidator()},
+		[]Transformer{NewFieldTransformer("name", strings.ToUpper)},
+		NewHTTPWriter(ts.URL, ""))
+	if err := p.Process(context.Background()); err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if string(received) != `{"name":"TEST"}` {
+		t.Errorf("Expected the transformed data to be posted, got %s", received)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Expected the default content type, got %s", contentType)
+	}
+}
+
+func TestHTTPNilClient(t *testing.T) {
+	var received []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			received, _ = io.ReadAll(r.Body)
+			return
+		}
+		w.Write([]byte(`{"name":"test"}`))
+	}))
+	defer ts.Close()
+
+	data, err := (&HTTPReader{URL: ts.URL}).Read(context.Background())
+	if err != nil || string(data) != `{"name":"test"}` {
+		t.Errorf("Expected the reader to fall back to the default client, got %s (%v)", data, err)
+	}
+	writer := &HTTPWriter{URL: ts.URL, ContentType: "application/json"}
+	if err := writer.Write(context.Background(), data); err != nil {
+		t.Errorf("Expected the writer to fall back to the default client, got %v", err)
+	}
+	if string(received) != `{"name":"test"}` {
+		t.Errorf("Expected the data to be posted, got %s", received)
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	var pe *PipelineError
+	err := NewHTTPWriter(ts.URL, "text/plain").Write(context.Background(), []byte("data"))
+	if !errors.As(err, &pe) || pe.Stage != "write" || !errors.Is(err, ErrUnexpectedStatus) {
+		t.Errorf("Expected a write PipelineError for a 500, got %v", err)
+	}
+
+	_, err = NewHTTPReader(ts.URL).Read(context.Background())
+	if !errors.As(err, &pe) || pe.Stage != "read" || !errors.Is(err, ErrUnexpectedStatus) {
+		t.Errorf("Expected a read PipelineError for a 500, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewHTTPReader(ts.URL).Read(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := NewHTTPWriter(ts.URL, "").Write(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -1,11 +1,17 @@
 package challenge12
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"sync"
 )
 
 type Reader interface {
@@ -70,6 +76,7 @@ var (
 	ErrProcessingFailed     = errors.New("processing failed")
 	ErrTransformationFailed = errors.New("transform error")
 	ErrDestinationFull      = errors.New("destination is full")
+	ErrUnexpectedStatus     = errors.New("unexpected HTTP status")
 )
 
 type Pipeline struct {
@@ -157,6 +164,51 @@ func (fr *FileReader) Read(ctx context.Context) ([]byte, error) {
 	}
 }
 
+// HTTPReader reads the body of a GET request, a nil Client uses
+// http.DefaultClient
+type HTTPReader struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPReader(url string) *HTTPReader {
+	return &HTTPReader{URL: url, Client: http.DefaultClient}
+}
+
+// httpClient returns client, or http.DefaultClient when it is nil
+func httpClient(client *http.Client) *http.Client {
+	if client == nil {
+		return http.DefaultClient
+	}
+	return client
+}
+
+func (hr *HTTPReader) Read(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hr.URL, nil)
+	if err != nil {
+		return nil, &PipelineError{Stage: "read", Err: err}
+	}
+
+	resp, err := httpClient(hr.Client).Do(req)
+	if err != nil {
+		return nil, &PipelineError{Stage: "read", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &PipelineError{
+			Stage: "read",
+			Err:   fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode),
+		}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &PipelineError{Stage: "read", Err: err}
+	}
+	return data, nil
+}
+
 type JSONValidator struct{}
 
 func NewJSONValidator() *JSONValidator {
@@ -233,6 +285,108 @@ func (ft *FieldTransformer) Transform(data []byte) ([]byte, error) {
 	return result, nil
 }
 
+// ConditionalTransformer applies Then to the records matching Predicate
+// and Else to the others, a nil branch leaves the record unchanged. A nil
+// Predicate matches no record.
+type ConditionalTransformer struct {
+	Predicate func(map[string]any) bool
+	Then      Transformer
+	Else      Transformer
+}
+
+func NewConditionalTransformer(predicate func(map[string]any) bool, then, otherwise Transformer) *ConditionalTransformer {
+	if predicate == nil || (then == nil && otherwise == nil) {
+		return nil
+	}
+	return &ConditionalTransformer{Predicate: predicate, Then: then, Else: otherwise}
+}
+
+func (ct *ConditionalTransformer) Transform(data []byte) ([]byte, error) {
+	var parsedData map[string]any
+	if err := json.Unmarshal(data, &parsedData); err != nil {
+		return nil, &TransformError{Stage: "condition", Err: ErrInvalidFormat}
+	}
+
+	branch, stage := ct.Else, "condition/else"
+	if ct.Predicate != nil && ct.Predicate(parsedData) {
+		branch, stage = ct.Then, "condition/then"
+	}
+	if branch == nil {
+		return data, nil
+	}
+
+	result, err := branch.Transform(data)
+	if err != nil {
+		return nil, &TransformError{Stage: stage, Err: err}
+	}
+	return result, nil
+}
+
+type GzipTransformer struct{}
+
+func NewGzipTransformer() *GzipTransformer {
+	return &GzipTransformer{}
+}
+
+func (gt *GzipTransformer) Transform(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, &TransformError{Stage: "gzip", Err: err}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, &TransformError{Stage: "gzip", Err: err}
+	}
+	return buf.Bytes(), nil
+}
+
+type GunzipTransformer struct{}
+
+func NewGunzipTransformer() *GunzipTransformer {
+	return &GunzipTransformer{}
+}
+
+func (gt *GunzipTransformer) Transform(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, &TransformError{Stage: "gunzip", Err: fmt.Errorf("%w: %v", ErrInvalidFormat, err)}
+	}
+	defer zr.Close()
+
+	result, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, &TransformError{Stage: "gunzip", Err: fmt.Errorf("%w: %v", ErrInvalidFormat, err)}
+	}
+	return result, nil
+}
+
+type Base64Transformer struct{}
+
+func NewBase64Transformer() *Base64Transformer {
+	return &Base64Transformer{}
+}
+
+func (bt *Base64Transformer) Transform(data []byte) ([]byte, error) {
+	result := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(result, data)
+	return result, nil
+}
+
+type Base64DecodeTransformer struct{}
+
+func NewBase64DecodeTransformer() *Base64DecodeTransformer {
+	return &Base64DecodeTransformer{}
+}
+
+func (bt *Base64DecodeTransformer) Transform(data []byte) ([]byte, error) {
+	result := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(result, bytes.TrimSpace(data))
+	if err != nil {
+		return nil, &TransformError{Stage: "base64 decode", Err: fmt.Errorf("%w: %v", ErrInvalidFormat, err)}
+	}
+	return result[:n], nil
+}
+
 type FileWriter struct {
 	Filename string
 }
@@ -261,3 +415,90 @@ func (fw *FileWriter) Write(ctx context.Context, data []byte) error {
 		return nil
 	}
 }
+
+type WriteMode int
+
+const (
+	// AllMustSucceed fails the write when any writer fails
+	AllMustSucceed WriteMode = iota
+	// BestEffort fails the write only when every writer fails
+	BestEffort
+)
+
+// MultiWriter writes the data to several writers concurrently
+type MultiWriter struct {
+	Writers []Writer
+	Mode    WriteMode
+}
+
+func NewMultiWriter(mode WriteMode, writers ...Writer) *MultiWriter {
+	return &MultiWriter{Writers: writers, Mode: mode}
+}
+
+func (mw *MultiWriter) Write(ctx context.Context, data []byte) error {
+	if len(mw.Writers) == 0 {
+		return &PipelineError{Stage: "write", Err: errors.New("no writers")}
+	}
+
+	errs := make([]error, len(mw.Writers))
+	var wg sync.WaitGroup
+	for i, w := range mw.Writers {
+		wg.Add(1)
+		go func(i int, w Writer) {
+			defer wg.Done()
+			if err := w.Write(ctx, data); err != nil {
+				errs[i] = fmt.Errorf("writer %d: %w", i, err)
+			}
+		}(i, w)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed == 0 || (mw.Mode == BestEffort && failed < len(mw.Writers)) {
+		return nil
+	}
+	return &PipelineError{Stage: "write", Err: errors.Join(errs...)}
+}
+
+// HTTPWriter posts the data, a nil Client uses http.DefaultClient
+type HTTPWriter struct {
+	URL         string
+	ContentType string
+	Client      *http.Client
+}
+
+func NewHTTPWriter(url, contentType string) *HTTPWriter {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return &HTTPWriter{URL: url, ContentType: contentType, Client: http.DefaultClient}
+}
+
+func (hw *HTTPWriter) Write(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hw.URL, bytes.NewReader(data))
+	if err != nil {
+		return &PipelineError{Stage: "write", Err: err}
+	}
+	req.Header.Set("Content-Type", hw.ContentType)
+
+	resp, err := httpClient(hw.Client).Do(req)
+	if err != nil {
+		return &PipelineError{Stage: "write", Err: err}
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &PipelineError{
+			Stage: "write",
+			Err:   fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode),
+		}
+	}
+	return nil
+}
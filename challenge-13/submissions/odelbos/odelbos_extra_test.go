@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupStore(t *testing.T) (*ProductStore, []Product) {
+	t.Helper()
+	db, err := InitDB(filepath.Join(t.TempDir(), "inventory.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := NewProductStore(db)
+	products := []Product{
+		{Name: "Product 1", Price: 9.99, Quantity: 10, Category: "Test"},
+		{Name: "Product 2", Price: 19.99, Quantity: 20, Category: "Test"},
+	}
+	for i := range products {
+		if err := store.CreateProduct(&products[i]); err != nil {
+			t.Fatalf("Failed to create test product: %v", err)
+		}
+	}
+	return store, products
+}
+
+func assertQuantity(t *testing.T, store *ProductStore, id int64, expected int) {
+	t.Helper()
+	p, err := store.GetProduct(id)
+	if err != nil {
+		t.Fatalf("Failed to retrieve product %d: %v", id, err)
+	}
+	if p.Quantity != expected {
+		t.Errorf("Expected quantity %d for product %d, got %d", expected, id, p.Quantity)
+	}
+}
+
+func TestPreviewBatchUpdateInventory(t *testing.T) {
+	store, products := setupStore(t)
+
+	changes, err := store.PreviewBatchUpdateInventory(map[int64]int{
+		products[1].ID: 15,
+		products[0].ID: 5,
+	})
+	if err != nil {
+		t.Fatalf("Failed to preview batch update: %v", err)
+	}
+
+	expected := []InventoryChange{
+		{ID: products[0].ID, Name: "Product 1", OldQuantity: 10, NewQuantity: 5},
+		{ID: products[1].ID, Name: "Product 2", OldQuantity: 20, NewQuantity: 15},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %d", len(expected), len(changes))
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected change %+v, got %+v", expected[i], changes[i])
+		}
+	}
+
+	// Nothing must have been committed
+	assertQuantity(t, store, products[0].ID, 10)
+	assertQuantity(t, store, products[1].ID, 20)
+}
+
+func TestPreviewBatchUpdateInventoryUnknownID(t *testing.T) {
+	store, products := setupStore(t)
+
+	unknown := products[1].ID + 99
+	_, err := store.PreviewBatchUpdateInventory(map[int64]int{
+		products[0].ID: 5,
+		unknown:        15,
+	})
+	if err == nil {
+		t.Fatal("Expected error for non-existent product, got nil")
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("id: %d", unknown)) {
+		t.Errorf("Expected error to report the unknown id, got %v", err)
+	}
+	assertQuantity(t, store, products[0].ID, 10)
+}
+
+func TestBatchUpdateInventoryNegativeQuantity(t *testing.T) {
+	store, products := setupStore(t)
+
+	updates := map[int64]int{products[0].ID: 5, products[1].ID: -1}
+	_, err := store.PreviewBatchUpdateInventory(updates)
+	if err == nil {
+		t.Fatal("Expected preview error for negative quantity, got nil")
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("id: %d", products[1].ID)) {
+		t.Errorf("Expected preview error to report the id, got %v", err)
+	}
+
+	err = store.BatchUpdateInventory(updates)
+	if err == nil {
+		t.Fatal("Expected error for negative quantity, got nil")
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("id: %d", products[1].ID)) {
+		t.Errorf("Expected error to report the id, got %v", err)
+	}
+	assertQuantity(t, store, products[0].ID, 10)
+	assertQuantity(t, store, products[1].ID, 20)
+}
+
+func TestBatchUpdateInventoryCommits(t *testing.T) {
+	store, products := setupStore(t)
+
+	err := store.BatchUpdateInventory(map[int64]int{products[0].ID: 5, products[1].ID: 15})
+	if err != nil {
+		t.Fatalf("Failed to perform batch update: %v", err)
+	}
+	assertQuantity(t, store, products[0].ID, 5)
+	assertQuantity(t, store, products[1].ID, 15)
+
+	// A later preview sees the committed quantities
+	changes, err := store.PreviewBatchUpdateInventory(map[int64]int{products[0].ID: 1})
+	if err != nil {
+		t.Fatalf("Failed to preview batch update: %v", err)
+	}
+	if len(changes) != 1 || changes[0].OldQuantity != 5 {
+		t.Errorf("Expected old quantity 5, got %+v", changes)
+	}
+}
+
+func TestBulkCreateProducts(t *testing.T) {
+	store, existing := setupStore(t)
+
+	// Enough products for several statements
+	products := make([]*Product, 1000)
+	for i := range products {
+		products[i] = &Product{Name: fmt.Sprintf("Bulk %d", i), Price: float64(i), Quantity: i, Category: "Bulk"}
+	}
+	if err := store.BulkCreateProducts(products); err != nil {
+		t.Fatalf("Unexpected bulk insert error: %v", err)
+	}
+
+	seen := map[int64]bool{existing[0].ID: true, existing[1].ID: true}
+	for i, p := range products {
+		if p.ID == 0 || seen[p.ID] {
+			t.Fatalf("Expected product %d to get a new ID, got %d", i, p.ID)
+		}
+		seen[p.ID] = true
+		stored, err := store.GetProduct(p.ID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve product %d: %v", p.ID, err)
+		}
+		if *stored != *p {
+			t.Errorf("Expected %+v stored under ID %d, got %+v", *p, p.ID, *stored)
+		}
+	}
+
+	listed, err := store.ListProducts("Bulk")
+	if err != nil {
+		t.Fatalf("Failed to list products: %v", err)
+	}
+	if len(listed) != len(products) {
+		t.Errorf("Expected %d bulk products, got %d", len(products), len(listed))
+	}
+	if err := store.BulkCreateProducts(nil); err != nil {
+		t.Errorf("Expected an empty batch to be a no-op, got %v", err)
+	}
+}
+
+func TestBulkCreateProductsRollback(t *testing.T) {
+	store, _ := setupStore(t)
+	_, err := store.db.Exec(`CREATE TRIGGER reject_broken BEFORE INSERT ON products
+		WHEN NEW.name = 'broken' BEGIN SELECT RAISE(ABORT, 'broken product'); END`)
+	if err != nil {
+		t.Fatalf("Failed to create the trigger: %v", err)
+	}
+
+	// The failing row is in the last statement, after others succeeded
+	products := make([]*Product, 600)
+	for i := range products {
+		products[i] = &Product{Name: fmt.Sprintf("Bulk %d", i), Category: "Bulk"}
+	}
+	products[550].Name = "broken"
+
+	err = store.BulkCreateProducts(products)
+	if err == nil || !strings.Contains(err.Error(), "broken product") {
+		t.Fatalf("Expected the broken product to fail the batch, got %v", err)
+	}
+	listed, err := store.ListProducts("Bulk")
+	if err != nil {
+		t.Fatalf("Failed to list products: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected the batch to be rolled back, got %d products", len(listed))
+	}
+	for i, p := range products {
+		if p.ID != 0 {
+			t.Fatalf("Expected product %d to keep no ID, got %d", i, p.ID)
+		}
+	}
+}
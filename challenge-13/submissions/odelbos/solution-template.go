@@ -3,6 +3,8 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"slices"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -121,45 +123,151 @@ func (ps *ProductStore) ListProducts(category string) ([]*Product, error) {
 	return products, nil
 }
 
-// BatchUpdateInventory updates the quantity of multiple products in a single transaction
+// InventoryChange describes the quantity change of a product in a batch update
+type InventoryChange struct {
+	ID          int64
+	Name        string
+	OldQuantity int
+	NewQuantity int
+}
+
+// BatchUpdateInventory updates the quantity of multiple products in a single
+// transaction, a negative quantity rolls back the whole batch
 func (ps *ProductStore) BatchUpdateInventory(updates map[int64]int) error {
+	_, err := ps.batchUpdateInventory(updates, false)
+	return err
+}
+
+// PreviewBatchUpdateInventory runs a batch update inside a transaction that is
+// always rolled back, it returns the changes the update would apply
+func (ps *ProductStore) PreviewBatchUpdateInventory(updates map[int64]int) ([]InventoryChange, error) {
+	return ps.batchUpdateInventory(updates, true)
+}
+
+func (ps *ProductStore) batchUpdateInventory(updates map[int64]int, dryRun bool) ([]InventoryChange, error) {
 	tx, err := ps.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`UPDATE products SET quantity=? WHERE id=?`)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 	defer stmt.Close()
 
-	for id, val := range updates {
-		res, err := stmt.Exec(val, id)
-		if err != nil {
-			tx.Rollback()
-			return err
+	// Apply the updates in id order so the reported changes are stable
+	ids := make([]int64, 0, len(updates))
+	for id := range updates {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	changes := make([]InventoryChange, 0, len(ids))
+	for _, id := range ids {
+		if updates[id] < 0 {
+			return nil, fmt.Errorf("quantity must not be negative, id: %d", id)
+		}
+		change := InventoryChange{ID: id, NewQuantity: updates[id]}
+		err := tx.QueryRow("SELECT name, quantity FROM products WHERE id=?", id).Scan(&change.Name, &change.OldQuantity)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("product does not exists, id: %d", id)
+		} else if err != nil {
+			return nil, err
 		}
 
-		nb, err := res.RowsAffected()
-		if err != nil {
-			tx.Rollback()
-			return err
+		if _, err := stmt.Exec(change.NewQuantity, id); err != nil {
+			return nil, err
 		}
-		if nb == 0 {
-			tx.Rollback()
-			return fmt.Errorf("product does not exists, id: %d", id)
+		changes = append(changes, change)
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// bulkInsertMaxParams bounds the parameters of one INSERT statement, SQLite
+// builds before 3.32 refuse more than 999
+const bulkInsertMaxParams = 999
+
+// BulkCreateProducts inserts the products in a single transaction, using
+// multi-row INSERT statements of up to bulkInsertMaxParams parameters. The
+// IDs are set once every row is committed, on any failure the whole batch
+// is rolled back and the products are left untouched.
+func (ps *ProductStore) BulkCreateProducts(products []*Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	tx, err := ps.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const columns = 4
+	chunkSize := bulkInsertMaxParams / columns
+	ids := make([]int64, 0, len(products))
+	for start := 0; start < len(products); start += chunkSize {
+		chunk := products[start:min(start+chunkSize, len(products))]
+		chunkIDs, err := insertProducts(tx, chunk)
+		if err != nil {
+			return fmt.Errorf("bulk insert of products %d to %d: %w", start, start+len(chunk)-1, err)
 		}
+		ids = append(ids, chunkIDs...)
 	}
 
 	if err := tx.Commit(); err != nil {
-		tx.Rollback()
 		return err
 	}
+	for i, p := range products {
+		p.ID = ids[i]
+	}
 	return nil
 }
 
+// insertProducts inserts the products with one statement and returns their
+// IDs in the order of the products
+func insertProducts(tx *sql.Tx, products []*Product) ([]int64, error) {
+	query := "INSERT INTO products (name, price, quantity, category) VALUES " +
+		strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?), ", len(products)), ", ") +
+		" RETURNING id"
+	args := make([]any, 0, 4*len(products))
+	for _, p := range products {
+		args = append(args, p.Name, p.Price, p.Quantity, p.Category)
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0, len(products))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) != len(products) {
+		return nil, fmt.Errorf("inserted %d products, expected %d", len(ids), len(products))
+	}
+
+	// RETURNING yields the rows in no particular order, but the rowids of
+	// one statement are allocated increasing in the order of the VALUES
+	slices.Sort(ids)
+	return ids, nil
+}
+
 func main() {
 	// Optional: you can write code here to test your implementation
 }
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// refusedAddr returns a local address nobody listens on
+func refusedAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func TestOrderServiceClose(t *testing.T) {
+	addr := refusedAddr(t)
+	s, err := ConnectToServices(addr, addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	userConn, productConn := s.userConn, s.productConn
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected close error: %v", err)
+	}
+	if userConn.GetState() != connectivity.Shutdown || productConn.GetState() != connectivity.Shutdown {
+		t.Errorf("Expected both connections to be shut down, got %v and %v", userConn.GetState(), productConn.GetState())
+	}
+
+	if _, err := s.CreateOrder(context.Background(), 1, 1, 1); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("Expected ErrServiceClosed from CreateOrder, got %v", err)
+	}
+	if _, err := s.GetOrder(1); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("Expected ErrServiceClosed from GetOrder, got %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Expected a second Close to be a no-op, got %v", err)
+	}
+}
+
+func TestOrderServiceReconnecting(t *testing.T) {
+	s := NewOrderService(NewUserServiceServer(), NewProductServiceServer())
+	s.reconnecting = true
+
+	_, err := s.CreateOrder(context.Background(), 1, 1, 1)
+	if !errors.Is(err, ErrReconnecting) || status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected an Unavailable ErrReconnecting, got %v", err)
+	}
+
+	s.reconnecting = false
+	if order, err := s.CreateOrder(context.Background(), 1, 1, 1); err != nil || order.ID != 1 {
+		t.Errorf("Expected the order to be created once reconnected, got %v, %v", order, err)
+	}
+}
+
+func TestIdleReaperReconnects(t *testing.T) {
+	addr := refusedAddr(t)
+	s, err := ConnectToServices(addr, addr, WithIdleReaper(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	failed, client := s.userConn, s.userClient
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.RLock()
+		replaced := s.userConn != failed
+		s.mu.RUnlock()
+		if replaced {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the reaper to replace the failed connection")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if failed.GetState() != connectivity.Shutdown {
+		t.Errorf("Expected the replaced connection to be closed, got %v", failed.GetState())
+	}
+	if s.userClient != client {
+		t.Error("Expected the user client to be kept across the reconnection")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Unexpected close error: %v", err)
+	}
+	if s.userConn.GetState() != connectivity.Shutdown {
+		t.Errorf("Expected the new connection to be closed, got %v", s.userConn.GetState())
+	}
+}
+
+func TestIdleReaperToleratesFlakyConnections(t *testing.T) {
+	// The challenge services answer HTTP/1, their gRPC connections never
+	// become ready
+	userAddr, productAddr := refusedAddr(t), refusedAddr(t)
+	if _, err := StartUserService(userAddr); err != nil {
+		t.Fatalf("Failed to start the user service: %v", err)
+	}
+	if _, err := StartProductService(productAddr); err != nil {
+		t.Fatalf("Failed to start the product service: %v", err)
+	}
+	s, err := ConnectToServices(userAddr, productAddr, WithIdleReaper(time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer s.Close()
+	userConn, productConn, userClient := s.userConn, s.productConn, s.userClient
+
+	// No redial, hence no ErrReconnecting, before minReconnectWait
+	deadline := time.Now().Add(minReconnectWait / 2)
+	for time.Now().Before(deadline) {
+		if _, _, err := s.clients(); err != nil {
+			t.Fatalf("Expected the clients to stay available, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.userConn != userConn || s.productConn != productConn || s.userClient != userClient {
+		t.Error("Expected the connections to be kept while grpc retries them")
+	}
+}
+
+func TestConnWatchBackoff(t *testing.T) {
+	var w connWatch
+	now := time.Now()
+	if w.due(connectivity.TransientFailure, now) {
+		t.Fatal("Expected no redial on the first failure")
+	}
+	if w.due(connectivity.Connecting, now.Add(minReconnectWait/2)) {
+		t.Error("Expected no redial before minReconnectWait")
+	}
+	if !w.due(connectivity.TransientFailure, now.Add(minReconnectWait)) {
+		t.Fatal("Expected a redial after minReconnectWait of failure")
+	}
+	// The next redial waits twice as long
+	now = now.Add(minReconnectWait)
+	if w.due(connectivity.TransientFailure, now.Add(minReconnectWait)) {
+		t.Error("Expected the wait to double after a redial")
+	}
+	if !w.due(connectivity.TransientFailure, now.Add(2*minReconnectWait)) {
+		t.Error("Expected a redial after twice minReconnectWait")
+	}
+
+	// A ready connection resets the backoff
+	w.due(connectivity.Ready, now)
+	if w.due(connectivity.TransientFailure, now) || w.wait != minReconnectWait {
+		t.Errorf("Expected the backoff to restart from minReconnectWait, got %v", w.wait)
+	}
+}
+
+// countingUserService counts the downstream calls
+type countingUserService struct {
+	UserService
+	calls int
+}
+
+func (c *countingUserService) ValidateUser(ctx context.Context, userID int64) (bool, error) {
+	c.calls++
+	return c.UserService.ValidateUser(ctx, userID)
+}
+
+// fieldViolations extracts the field -> description violations of err
+func fieldViolations(t *testing.T, err error) map[string]string {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	violations := map[string]string{}
+	for _, detail := range st.Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				violations[v.GetField()] = v.GetDescription()
+			}
+		}
+	}
+	return violations
+}
+
+func TestCreateOrderValidation(t *testing.T) {
+	users := &countingUserService{UserService: NewUserServiceServer()}
+	s := NewOrderService(users, NewProductServiceServer())
+
+	tests := []struct {
+		name              string
+		userID, productID int64
+		quantity          int32
+		expected          map[string]string
+		downstreamCalls   int
+	}{
+		{"zero quantity", 1, 1, 0, map[string]string{"quantity": "must be > 0"}, 0},
+		{"all invalid", -1, 0, -2, map[string]string{
+			"user_id":    "must be > 0",
+			"product_id": "must be > 0",
+			"quantity":   "must be > 0",
+		}, 0},
+		{"unknown user", 999, 1, 1, map[string]string{"user_id": "unknown user"}, 1},
+		{"unknown product", 1, 999, 1, map[string]string{"product_id": "unknown product"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users.calls = 0
+			_, err := s.CreateOrder(context.Background(), tt.userID, tt.productID, tt.quantity)
+			if got := fieldViolations(t, err); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected violations %v, got %v", tt.expected, got)
+			}
+			if users.calls != tt.downstreamCalls {
+				t.Errorf("Expected %d downstream calls, got %d", tt.downstreamCalls, users.calls)
+			}
+		})
+	}
+
+	order, err := s.CreateOrder(context.Background(), 1, 2, 3)
+	if err != nil || order.Total != 3*499.99 {
+		t.Errorf("Expected a valid order to proceed, got %v, %v", order, err)
+	}
+	if _, err := s.CreateOrder(context.Background(), 3, 1, 1); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for an inactive user, got %v", err)
+	}
+}
+
+// countingProductService counts the downstream calls
+type countingProductService struct {
+	ProductService
+	products, inventory int
+}
+
+func (c *countingProductService) GetProduct(ctx context.Context, productID int64) (*Product, error) {
+	c.products++
+	return c.ProductService.GetProduct(ctx, productID)
+}
+
+func (c *countingProductService) CheckInventory(ctx context.Context, productID int64, quantity int32) (bool, error) {
+	c.inventory++
+	return c.ProductService.CheckInventory(ctx, productID, quantity)
+}
+
+func TestCreateOrderLookupCache(t *testing.T) {
+	users := &countingUserService{UserService: NewUserServiceServer()}
+	productServer := NewProductServiceServer()
+	products := &countingProductService{ProductService: productServer}
+	s := NewOrderService(users, products)
+	s.SetLookupTTL(time.Minute)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.CreateOrder(context.Background(), 1, 1, 1); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if users.calls != 1 || products.products != 1 {
+		t.Errorf("Expected one validation and one product fetch, got %d and %d", users.calls, products.products)
+	}
+	if products.inventory != 3 {
+		t.Errorf("Expected the inventory checked for every order, got %d", products.inventory)
+	}
+
+	// A sold out product is rejected even though the product is cached
+	productServer.products[1].Inventory = 0
+	if _, err := s.CreateOrder(context.Background(), 1, 1, 1); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted from the live inventory, got %v", err)
+	}
+
+	// Past the TTL the user is validated again
+	users.UserService.(*UserServiceServer).users[1].Active = false
+	now = now.Add(time.Minute)
+	if _, err := s.CreateOrder(context.Background(), 1, 2, 1); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied once the cached status expired, got %v", err)
+	}
+	if users.calls != 2 {
+		t.Errorf("Expected a second validation after the TTL, got %d", users.calls)
+	}
+
+	// Inactive users are cached too, errors are not
+	s.CreateOrder(context.Background(), 1, 2, 1)
+	s.CreateOrder(context.Background(), 999, 2, 1)
+	s.CreateOrder(context.Background(), 999, 2, 1)
+	if users.calls != 4 {
+		t.Errorf("Expected only the unknown user to be validated again, got %d calls", users.calls)
+	}
+}
+
+func TestCreateOrderLookupCacheDisabled(t *testing.T) {
+	users := &countingUserService{UserService: NewUserServiceServer()}
+	products := &countingProductService{ProductService: NewProductServiceServer()}
+	s := NewOrderService(users, products)
+	s.SetLookupTTL(0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.CreateOrder(context.Background(), 1, 1, 1); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if users.calls != 2 || products.products != 2 {
+		t.Errorf("Expected every order to reach the services, got %d and %d", users.calls, products.products)
+	}
+}
+
+func TestGetProducts(t *testing.T) {
+	server := NewProductServiceServer()
+	found, notFound, err := server.GetProducts(context.Background(), []int64{2, 99, 1, 99, 2, 42})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(found) != 2 || found[1].Name != "Laptop" || found[2].Name != "Phone" {
+		t.Errorf("Expected products 1 and 2, got %v", found)
+	}
+	if !reflect.DeepEqual(notFound, []int64{99, 42}) {
+		t.Errorf("Expected missing IDs [99 42], got %v", notFound)
+	}
+
+	// The products are copies of the catalog
+	found[1].Inventory = 0
+	if product, _ := server.GetProduct(context.Background(), 1); product.Inventory != 10 {
+		t.Errorf("Expected the catalog untouched, got inventory %d", product.Inventory)
+	}
+
+	found, notFound, err = server.GetProducts(context.Background(), nil)
+	if err != nil || len(found) != 0 || len(notFound) != 0 {
+		t.Errorf("Expected no products for no IDs, got %v, %v, %v", found, notFound, err)
+	}
+}
+
+func TestProductServiceClientGetProducts(t *testing.T) {
+	addr := refusedAddr(t)
+	server, err := StartProductService(addr)
+	if err != nil {
+		t.Fatalf("Failed to start the product service: %v", err)
+	}
+	defer server.Stop()
+	conn, err := dialService(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	client := NewProductServiceClient(conn).(*ProductServiceClient)
+
+	found, notFound, err := client.GetProducts(context.Background(), []int64{3, 7, 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(found) != 2 || found[1].Price != 999.99 || found[3].Inventory != 0 || found[3].Name != "Headphones" {
+		t.Errorf("Expected products 1 and 3, got %v", found)
+	}
+	if !reflect.DeepEqual(notFound, []int64{7}) {
+		t.Errorf("Expected missing IDs [7], got %v", notFound)
+	}
+}
+
+// blockingProductService holds every CheckInventory call until release is
+// closed, entered receiving one value per call
+type blockingProductService struct {
+	ProductService
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingProductService() *blockingProductService {
+	return &blockingProductService{
+		ProductService: NewProductServiceServer(),
+		entered:        make(chan struct{}, 10),
+		release:        make(chan struct{}),
+	}
+}
+
+func (b *blockingProductService) CheckInventory(ctx context.Context, productID int64, quantity int32) (bool, error) {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.ProductService.CheckInventory(ctx, productID, quantity)
+}
+
+// createOrderAsync runs CreateOrder in a goroutine, the result is sent on
+// the returned channel
+func createOrderAsync(s *OrderService) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.CreateOrder(context.Background(), 1, 1, 1)
+		done <- err
+	}()
+	return done
+}
+
+func waitEntered(t *testing.T, b *blockingProductService, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-b.entered:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %d calls in flight", n)
+		}
+	}
+}
+
+func TestCreateOrderMaxInFlight(t *testing.T) {
+	products := newBlockingProductService()
+	s := NewOrderService(NewUserServiceServer(), products)
+	s.SetMaxInFlight(2, 0)
+
+	first, second := createOrderAsync(s), createOrderAsync(s)
+	waitEntered(t, products, 2)
+
+	// Saturated, the overflow is rejected right away
+	for i := 0; i < 3; i++ {
+		_, err := s.CreateOrder(context.Background(), 1, 1, 1)
+		if !errors.Is(err, ErrTooManyOrders) || status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("Expected ErrTooManyOrders, got %v", err)
+		}
+	}
+	// An invalid request does not need a permit
+	if _, err := s.CreateOrder(context.Background(), 0, 1, 1); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+
+	close(products.release)
+	for _, done := range []<-chan error{first, second} {
+		if err := <-done; err != nil {
+			t.Errorf("Expected the in-flight order to complete, got %v", err)
+		}
+	}
+
+	// The permits are released whatever the outcome
+	for i := 0; i < 3; i++ {
+		if _, err := s.CreateOrder(context.Background(), 99, 1, 1); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected the unknown user to be rejected, got %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.CreateOrder(context.Background(), 1, 1, 1); err != nil {
+			t.Errorf("Expected the order to be created, got %v", err)
+		}
+	}
+}
+
+func TestCreateOrderPermitWait(t *testing.T) {
+	products := newBlockingProductService()
+	s := NewOrderService(NewUserServiceServer(), products)
+	s.SetMaxInFlight(1, time.Second)
+
+	inFlight := createOrderAsync(s)
+	waitEntered(t, products, 1)
+
+	// Waiting for a permit stops with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.CreateOrder(ctx, 1, 1, 1); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.CreateOrder(canceled, 1, 1, 1); status.Code(err) != codes.Canceled {
+		t.Errorf("Expected Canceled, got %v", err)
+	}
+
+	// A waiting call gets the permit once released
+	waiting := createOrderAsync(s)
+	time.Sleep(20 * time.Millisecond)
+	close(products.release)
+	for _, done := range []<-chan error{inFlight, waiting} {
+		if err := <-done; err != nil {
+			t.Errorf("Expected the order to complete, got %v", err)
+		}
+	}
+}
+
+func TestPreviewOrder(t *testing.T) {
+	s := NewOrderService(NewUserServiceServer(), NewProductServiceServer())
+	s.SetPricingRules(PercentDiscount{Percent: 10}, TieredDiscount{{MinQuantity: 5, Percent: 5}})
+
+	preview, err := s.PreviewOrder(context.Background(), 1, 2, 4)
+	if err != nil {
+		t.Fatalf("Unexpected preview error: %v", err)
+	}
+	if preview.Subtotal != 1999.96 || preview.Discount != 200 || preview.Total != 1799.96 {
+		t.Errorf("Expected 1999.96 less 10%%, got %+v", preview)
+	}
+
+	// Both rules apply from 5 items on
+	preview, err = s.PreviewOrder(context.Background(), 1, 2, 5)
+	if err != nil {
+		t.Fatalf("Unexpected preview error: %v", err)
+	}
+	if preview.Discount != 374.99 || preview.Total != 2124.96 {
+		t.Errorf("Expected 2499.95 less 15%%, got %+v", preview)
+	}
+
+	// The order charges the previewed total
+	order, err := s.CreateOrder(context.Background(), 1, 2, 5)
+	if err != nil {
+		t.Fatalf("Unexpected order error: %v", err)
+	}
+	if order.Total != preview.Total {
+		t.Errorf("Expected the order total %v, got %v", preview.Total, order.Total)
+	}
+}
+
+func TestPreviewOrderErrors(t *testing.T) {
+	s := NewOrderService(NewUserServiceServer(), NewProductServiceServer())
+
+	if _, err := s.PreviewOrder(context.Background(), 1, 3, 1); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted for low inventory, got %v", err)
+	}
+	if _, err := s.PreviewOrder(context.Background(), 3, 1, 1); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for an inactive user, got %v", err)
+	}
+	if _, err := s.PreviewOrder(context.Background(), 1, 1, 0); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a zero quantity, got %v", err)
+	}
+}
+
+func TestPreviewOrderStoresNothing(t *testing.T) {
+	products := NewProductServiceServer()
+	s := NewOrderService(NewUserServiceServer(), products)
+	s.SetMaxInFlight(1, 0)
+
+	// Previews take no in-flight permit, hold the only one
+	release, err := s.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected acquire error: %v", err)
+	}
+	defer release()
+	for i := 0; i < 3; i++ {
+		if _, err := s.PreviewOrder(context.Background(), 1, 1, 10); err != nil {
+			t.Fatalf("Unexpected preview error: %v", err)
+		}
+	}
+
+	if len(s.orders) != 0 || s.nextOrderID != 1 {
+		t.Errorf("Expected no order stored, got %d orders", len(s.orders))
+	}
+	if _, err := s.GetOrder(1); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected order 1 not to exist, got %v", err)
+	}
+	if product, _ := products.GetProduct(context.Background(), 1); product.Inventory != 10 {
+		t.Errorf("Expected the inventory to stay at 10, got %d", product.Inventory)
+	}
+}
+
+func TestPricingRuleThresholds(t *testing.T) {
+	s := NewOrderService(NewUserServiceServer(), NewProductServiceServer())
+	s.SetPricingRules(TieredDiscount{{MinQuantity: 10, Percent: 10}, {MinQuantity: 5, Percent: 5}})
+
+	tests := []struct {
+		name     string
+		quantity int32
+		total    float64
+	}{
+		{"Below every tier", 4, 1999.96},
+		{"At the first tier", 5, 2374.95},     // 2499.95 - 124.9975
+		{"Below the second tier", 9, 4274.91}, // 4499.91 - 224.9955
+		{"At the second tier", 10, 4499.91},   // 4999.90 - 499.99
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := s.CreateOrder(context.Background(), 1, 2, tt.quantity)
+			if err != nil {
+				t.Fatalf("Unexpected order error: %v", err)
+			}
+			if order.Total != tt.total {
+				t.Errorf("Expected total %v, got %v", tt.total, order.Total)
+			}
+			stored, _ := s.GetOrder(order.ID)
+			if stored.Total != tt.total {
+				t.Errorf("Expected the stored total %v, got %v", tt.total, stored.Total)
+			}
+		})
+	}
+}
+
+func TestProductPricingRules(t *testing.T) {
+	s := NewOrderService(NewUserServiceServer(), NewProductServiceServer())
+	s.SetPricingRules(PercentDiscount{Percent: 5})
+	s.SetProductPricingRules(1, TieredDiscount{{MinQuantity: 2, Percent: 10}})
+
+	// The laptop gets both rules, 1999.98 - 299.997
+	if preview, err := s.PreviewOrder(context.Background(), 1, 1, 2); err != nil || preview.Total != 1699.98 {
+		t.Errorf("Expected a laptop total of 1699.98, got %+v, %v", preview, err)
+	}
+	// The phone only the global one, 999.98 - 49.999
+	if preview, err := s.PreviewOrder(context.Background(), 1, 2, 2); err != nil || preview.Total != 949.98 {
+		t.Errorf("Expected a phone total of 949.98, got %+v, %v", preview, err)
+	}
+
+	s.SetProductPricingRules(1)
+	if preview, err := s.PreviewOrder(context.Background(), 1, 1, 2); err != nil || preview.Total != 1899.98 {
+		t.Errorf("Expected the laptop rules to be removed, got %+v, %v", preview, err)
+	}
+}
+
+func TestRoundCents(t *testing.T) {
+	for amount, want := range map[float64]float64{
+		199.996:   200,
+		374.9925:  374.99,
+		124.9975:  125,
+		0.005:     0.01,
+		1999.98:   1999.98,
+		10.000001: 10,
+	} {
+		if got := roundCents(amount); got != want {
+			t.Errorf("roundCents(%v) = %v, want %v", amount, got, want)
+		}
+	}
+}
+
+// waitDups waits until n callers joined the call in flight for key
+func waitDups(t *testing.T, g *flightGroup, key flightKey, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		g.mu.Lock()
+		f, ok := g.flights[key]
+		dups := 0
+		if ok {
+			dups = f.dups
+		}
+		g.mu.Unlock()
+		if dups == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d callers to join, got %d", n, dups)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUserServiceClientCoalescesCalls(t *testing.T) {
+	var requests atomic.Int32
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		entered <- struct{}{}
+		<-release
+		fmt.Fprint(w, `{"valid": true}`)
+	}))
+	defer backend.Close()
+	client := &UserServiceClient{baseURL: backend.URL}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			valid, err := client.ValidateUser(context.Background(), 5)
+			if err == nil && !valid {
+				err = errors.New("user 5 not valid")
+			}
+			results <- err
+		}()
+	}
+	<-entered
+	waitDups(t, &client.flights, flightKey{"ValidateUser", 5}, callers-1)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for err := range results {
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected 1 backend request, got %d", got)
+	}
+}
+
+func TestUserServiceClientDoesNotCacheErrors(t *testing.T) {
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"id": 5, "username": "eve", "active": true}`)
+	}))
+	defer backend.Close()
+	client := &UserServiceClient{baseURL: backend.URL}
+
+	if _, err := client.GetUser(context.Background(), 5); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got %v", err)
+	}
+	user, err := client.GetUser(context.Background(), 5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if user.ID != 5 || requests.Load() != 2 {
+		t.Errorf("Expected user 5 from a second request, got %+v after %d requests", user, requests.Load())
+	}
+}
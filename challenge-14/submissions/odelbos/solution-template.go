@@ -3,15 +3,21 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
@@ -91,6 +97,7 @@ func (s *UserServiceServer) ValidateUser(ctx context.Context, userID int64) (boo
 
 // ProductServiceServer implements the ProductService
 type ProductServiceServer struct {
+	mu       sync.RWMutex
 	products map[int64]*Product
 }
 
@@ -106,6 +113,8 @@ func NewProductServiceServer() *ProductServiceServer {
 
 // GetProduct retrieves a product by ID
 func (s *ProductServiceServer) GetProduct(ctx context.Context, productID int64) (*Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	product, ok := s.products[productID]
 	if ! ok {
 		return nil, status.Errorf(codes.NotFound, "product not found")
@@ -118,6 +127,8 @@ func (s *ProductServiceServer) CheckInventory(ctx context.Context, productID int
 	if quantity <= 0 {
 		return false, status.Errorf(codes.InvalidArgument, "quantity must be > 0")
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	product, ok := s.products[productID]
 	if ! ok {
 		return false, status.Errorf(codes.NotFound, "product not found")
@@ -128,6 +139,32 @@ func (s *ProductServiceServer) CheckInventory(ctx context.Context, productID int
 	return true, nil
 }
 
+// GetProducts retrieves several products at once, all read under the same
+// lock. The products found are copies keyed by ID, the missing IDs are
+// returned in request order without duplicates.
+func (s *ProductServiceServer) GetProducts(ctx context.Context, ids []int64) (map[int64]*Product, []int64, error) {
+	found := make(map[int64]*Product, len(ids))
+	notFound := []int64{}
+	missing := make(map[int64]bool)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, id := range(ids) {
+		if _, ok := found[id]; ok || missing[id] {
+			continue
+		}
+		product, ok := s.products[id]
+		if ! ok {
+			missing[id] = true
+			notFound = append(notFound, id)
+			continue
+		}
+		copied := *product
+		found[id] = &copied
+	}
+	return found, notFound, nil
+}
+
 // gRPC method handlers for UserService
 func (s *UserServiceServer) GetUserRPC(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error) {
 	user, err := s.GetUser(ctx, req.UserId)
@@ -154,6 +191,14 @@ func (s *ProductServiceServer) GetProductRPC(ctx context.Context, req *GetProduc
 	return &GetProductResponse{Product: product}, nil
 }
 
+func (s *ProductServiceServer) GetProductsRPC(ctx context.Context, req *GetProductsRequest) (*GetProductsResponse, error) {
+	products, notFound, err := s.GetProducts(ctx, req.ProductIds)
+	if err != nil {
+		return nil, err
+	}
+	return &GetProductsResponse{Products: products, NotFound: notFound}, nil
+}
+
 func (s *ProductServiceServer) CheckInventoryRPC(ctx context.Context, req *CheckInventoryRequest) (*CheckInventoryResponse, error) {
 	available, err := s.CheckInventory(ctx, req.ProductId, req.Quantity)
 	if err != nil {
@@ -187,6 +232,15 @@ type GetProductResponse struct {
 	Product *Product `json:"product"`
 }
 
+type GetProductsRequest struct {
+	ProductIds []int64 `json:"product_ids"`
+}
+
+type GetProductsResponse struct {
+	Products map[int64]*Product `json:"products"`
+	NotFound []int64            `json:"not_found"`
+}
+
 type CheckInventoryRequest struct {
 	ProductId int64 `json:"product_id"`
 	Quantity  int32 `json:"quantity"`
@@ -196,12 +250,121 @@ type CheckInventoryResponse struct {
 	Available bool `json:"available"`
 }
 
+var (
+	// ErrServiceClosed is returned by the calls made after OrderService.Close
+	ErrServiceClosed = status.Error(codes.Unavailable, "order service is closed")
+	// ErrReconnecting is returned while a failed connection is being replaced
+	ErrReconnecting = status.Error(codes.Unavailable, "order service is reconnecting, retry later")
+	// ErrTooManyOrders is returned when no in-flight permit is available
+	ErrTooManyOrders = status.Error(codes.ResourceExhausted, "too many orders in flight, retry later")
+)
+
+// DefaultLookupTTL is how long CreateOrder reuses a user validation or a
+// product fetched by a previous order
+const DefaultLookupTTL = 5 * time.Second
+
+// cachedUser is the result of a ValidateUser call
+type cachedUser struct {
+	active  bool
+	expires time.Time
+}
+
+// cachedProduct is the result of a GetProduct call
+type cachedProduct struct {
+	product *Product
+	expires time.Time
+}
+
+// PricingRule computes an amount taken off an order. Discount receives the
+// undiscounted subtotal and returns the amount to subtract, zero when the
+// rule does not apply.
+type PricingRule interface {
+	Discount(product *Product, quantity int32, subtotal float64) float64
+}
+
+// PercentDiscount takes Percent off every order
+type PercentDiscount struct {
+	Percent float64
+}
+
+// Discount implements PricingRule
+func (d PercentDiscount) Discount(product *Product, quantity int32, subtotal float64) float64 {
+	return subtotal * d.Percent / 100
+}
+
+// DiscountTier takes Percent off the orders of at least MinQuantity items
+type DiscountTier struct {
+	MinQuantity int32
+	Percent     float64
+}
+
+// TieredDiscount applies the highest tier reached by the order quantity, the
+// tiers may be given in any order. A single tier is a bulk discount.
+type TieredDiscount []DiscountTier
+
+// Discount implements PricingRule
+func (d TieredDiscount) Discount(product *Product, quantity int32, subtotal float64) float64 {
+	var best *DiscountTier
+	for i := range(d) {
+		if quantity >= d[i].MinQuantity && (best == nil || d[i].MinQuantity > best.MinQuantity) {
+			best = &d[i]
+		}
+	}
+	if best == nil {
+		return 0
+	}
+	return subtotal * best.Percent / 100
+}
+
+// roundCents rounds an amount to the nearest cent, halves away from zero
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// OrderPreview is the price an order would have, see PreviewOrder
+type OrderPreview struct {
+	UserID    int64   `json:"user_id"`
+	ProductID int64   `json:"product_id"`
+	Quantity  int32   `json:"quantity"`
+	Subtotal  float64 `json:"subtotal"`
+	Discount  float64 `json:"discount"`
+	Total     float64 `json:"total"`
+}
+
 // OrderService handles order creation
 type OrderService struct {
 	userClient    UserService
 	productClient ProductService
+	ordersMu      sync.Mutex
 	orders        map[int64]*Order
 	nextOrderID   int64
+
+	// Pricing rules applied to the orders and the previews, the rules of a
+	// product come after the global ones
+	pricingMu    sync.RWMutex
+	pricing      []PricingRule
+	productRules map[int64][]PricingRule
+
+	// Admission control, CreateOrder calls are unbounded if inflight is nil
+	limitMu    sync.Mutex
+	inflight   chan struct{}
+	permitWait time.Duration
+
+	// Lookups reused by back-to-back orders, inventory is never cached
+	cacheMu   sync.Mutex
+	lookupTTL time.Duration
+	users     map[int64]cachedUser
+	products  map[int64]cachedProduct
+	now       func() time.Time
+
+	// Connection lifecycle, only set by ConnectToServices
+	mu           sync.RWMutex
+	userConn     *grpc.ClientConn
+	productConn  *grpc.ClientConn
+	closed       bool
+	reconnecting bool
+	stopReaper   chan struct{}
+	reaperDone   chan struct{}
 }
 
 // NewOrderService creates a new OrderService
@@ -211,12 +374,212 @@ func NewOrderService(userClient UserService, productClient ProductService) *Orde
 		productClient: productClient,
 		orders:        make(map[int64]*Order),
 		nextOrderID:   1,
+		productRules:  make(map[int64][]PricingRule),
+		lookupTTL:     DefaultLookupTTL,
+		users:         make(map[int64]cachedUser),
+		products:      make(map[int64]cachedProduct),
+		now:           time.Now,
 	}
 }
 
-// CreateOrder creates a new order
-func (s *OrderService) CreateOrder(ctx context.Context, userID, productID int64, quantity int32) (*Order, error) {
-	isValid, err := s.userClient.ValidateUser(ctx, userID)
+// SetLookupTTL sets how long user validations and products are reused, a
+// TTL of zero or less disables the cache. Entries already cached are dropped.
+func (s *OrderService) SetLookupTTL(ttl time.Duration) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.lookupTTL = ttl
+	s.users = make(map[int64]cachedUser)
+	s.products = make(map[int64]cachedProduct)
+}
+
+// SetMaxInFlight bounds the CreateOrder calls running at once to n, zero or
+// less removing the bound. A call beyond the bound waits up to wait for a
+// permit, or until its context is done, then fails with ErrTooManyOrders.
+// The calls in flight keep their permit from the previous bound.
+func (s *OrderService) SetMaxInFlight(n int, wait time.Duration) {
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	s.inflight = nil
+	if n > 0 {
+		s.inflight = make(chan struct{}, n)
+	}
+	s.permitWait = wait
+}
+
+// SetPricingRules replaces the rules applied to the totals of every order, no
+// rule keeping the plain price times quantity
+func (s *OrderService) SetPricingRules(rules ...PricingRule) {
+	s.pricingMu.Lock()
+	defer s.pricingMu.Unlock()
+	s.pricing = append([]PricingRule(nil), rules...)
+}
+
+// SetProductPricingRules replaces the rules applied to the orders of one
+// product on top of the global rules, no rule removing them
+func (s *OrderService) SetProductPricingRules(productID int64, rules ...PricingRule) {
+	s.pricingMu.Lock()
+	defer s.pricingMu.Unlock()
+	if len(rules) == 0 {
+		delete(s.productRules, productID)
+		return
+	}
+	s.productRules[productID] = append([]PricingRule(nil), rules...)
+}
+
+// price applies the pricing rules to the product price times quantity. Both
+// amounts are rounded to cents and the discount never exceeds the subtotal.
+func (s *OrderService) price(product *Product, quantity int32) (subtotal, discount float64) {
+	subtotal = roundCents(product.Price * float64(quantity))
+	s.pricingMu.RLock()
+	defer s.pricingMu.RUnlock()
+	for _, rules := range([][]PricingRule{s.pricing, s.productRules[product.ID]}) {
+		for _, rule := range(rules) {
+			discount += rule.Discount(product, quantity, subtotal)
+		}
+	}
+	discount = roundCents(discount)
+	if discount < 0 {
+		discount = 0
+	}
+	if discount > subtotal {
+		discount = subtotal
+	}
+	return subtotal, discount
+}
+
+// acquire takes an in-flight permit, the returned release gives it back
+func (s *OrderService) acquire(ctx context.Context) (func(), error) {
+	s.limitMu.Lock()
+	sem, wait := s.inflight, s.permitWait
+	s.limitMu.Unlock()
+	if sem == nil {
+		return func() {}, nil
+	}
+	release := func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if wait <= 0 {
+		return nil, ErrTooManyOrders
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-timer.C:
+		return nil, ErrTooManyOrders
+	}
+}
+
+// validateUser returns whether the user is active, calling ValidateUser
+// only when no result younger than the TTL is cached. Errors are not cached.
+func (s *OrderService) validateUser(ctx context.Context, client UserService, userID int64) (bool, error) {
+	s.cacheMu.Lock()
+	entry, ok := s.users[userID]
+	s.cacheMu.Unlock()
+	if ok && s.now().Before(entry.expires) {
+		return entry.active, nil
+	}
+
+	active, err := client.ValidateUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	s.cacheMu.Lock()
+	if s.lookupTTL > 0 {
+		s.users[userID] = cachedUser{active, s.now().Add(s.lookupTTL)}
+	}
+	s.cacheMu.Unlock()
+	return active, nil
+}
+
+// getProduct is the cached counterpart of GetProduct, see validateUser
+func (s *OrderService) getProduct(ctx context.Context, client ProductService, productID int64) (*Product, error) {
+	s.cacheMu.Lock()
+	entry, ok := s.products[productID]
+	s.cacheMu.Unlock()
+	if ok && s.now().Before(entry.expires) {
+		return entry.product, nil
+	}
+
+	product, err := client.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	s.cacheMu.Lock()
+	if s.lookupTTL > 0 {
+		s.products[productID] = cachedProduct{product, s.now().Add(s.lookupTTL)}
+	}
+	s.cacheMu.Unlock()
+	return product, nil
+}
+
+// clients returns the service clients, or an error if the service is closed
+// or mid-reconnect
+func (s *OrderService) clients() (UserService, ProductService, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, nil, ErrServiceClosed
+	}
+	if s.reconnecting {
+		return nil, nil, ErrReconnecting
+	}
+	return s.userClient, s.productClient, nil
+}
+
+// fieldViolation describes why a request field is invalid
+func fieldViolation(field, description string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: description}
+}
+
+// invalidArgument returns an InvalidArgument status error carrying the
+// violations as google.rpc.BadRequest details
+func invalidArgument(violations ...*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(codes.InvalidArgument, "invalid order request")
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// validateOrderRequest checks the order fields before any downstream call
+func validateOrderRequest(userID, productID int64, quantity int32) error {
+	var violations []*errdetails.BadRequest_FieldViolation
+	if userID <= 0 {
+		violations = append(violations, fieldViolation("user_id", "must be > 0"))
+	}
+	if productID <= 0 {
+		violations = append(violations, fieldViolation("product_id", "must be > 0"))
+	}
+	if quantity <= 0 {
+		violations = append(violations, fieldViolation("quantity", "must be > 0"))
+	}
+	if len(violations) > 0 {
+		return invalidArgument(violations...)
+	}
+	return nil
+}
+
+// checkOrder validates the user, the product and the inventory of an order
+// and returns the product. It calls the services but changes nothing.
+func (s *OrderService) checkOrder(ctx context.Context, userID, productID int64, quantity int32) (*Product, error) {
+	userClient, productClient, err := s.clients()
+	if err != nil {
+		return nil, err
+	}
+
+	isValid, err := s.validateUser(ctx, userClient, userID)
+	if status.Code(err) == codes.NotFound {
+		return nil, invalidArgument(fieldViolation("user_id", "unknown user"))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -224,25 +587,73 @@ func (s *OrderService) CreateOrder(ctx context.Context, userID, productID int64,
 		return nil, status.Errorf(codes.PermissionDenied, "invalid user")
 	}
 
-	product, err := s.productClient.GetProduct(ctx, productID)
+	product, err := s.getProduct(ctx, productClient, productID)
+	if status.Code(err) == codes.NotFound {
+		return nil, invalidArgument(fieldViolation("product_id", "unknown product"))
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	available, err := s.productClient.CheckInventory(ctx, productID, quantity)
+	// Inventory changes with every order, it always goes to the source
+	available, err := productClient.CheckInventory(ctx, productID, quantity)
 	if err != nil {
 		return nil, err
 	}
 	if ! available {
 		return nil, status.Errorf(codes.ResourceExhausted, "low inventory")
 	}
+	return product, nil
+}
+
+// PreviewOrder runs the checks of CreateOrder and returns the total the order
+// would have with the pricing rules applied. It is read-only: no order is
+// stored, no stock reserved and no in-flight permit taken.
+func (s *OrderService) PreviewOrder(ctx context.Context, userID, productID int64, quantity int32) (*OrderPreview, error) {
+	if err := validateOrderRequest(userID, productID, quantity); err != nil {
+		return nil, err
+	}
+	product, err := s.checkOrder(ctx, userID, productID, quantity)
+	if err != nil {
+		return nil, err
+	}
+	subtotal, discount := s.price(product, quantity)
+	return &OrderPreview{
+		UserID:    userID,
+		ProductID: productID,
+		Quantity:  quantity,
+		Subtotal:  subtotal,
+		Discount:  discount,
+		Total:     roundCents(subtotal - discount),
+	}, nil
+}
 
+// CreateOrder creates a new order. The user validation and the product are
+// reused from previous orders for up to the lookup TTL. A valid request
+// holds an in-flight permit until it returns, see SetMaxInFlight.
+func (s *OrderService) CreateOrder(ctx context.Context, userID, productID int64, quantity int32) (*Order, error) {
+	if err := validateOrderRequest(userID, productID, quantity); err != nil {
+		return nil, err
+	}
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	product, err := s.checkOrder(ctx, userID, productID, quantity)
+	if err != nil {
+		return nil, err
+	}
+	subtotal, discount := s.price(product, quantity)
+
+	s.ordersMu.Lock()
+	defer s.ordersMu.Unlock()
 	order := &Order{
 		ID:        s.nextOrderID,
 		UserID:    userID,
 		ProductID: productID,
 		Quantity:  quantity,
-		Total:     product.Price * float64(quantity),
+		Total:     roundCents(subtotal - discount),
 	}
 	s.orders[s.nextOrderID] = order
 	s.nextOrderID++
@@ -251,13 +662,133 @@ func (s *OrderService) CreateOrder(ctx context.Context, userID, productID int64,
 
 // GetOrder retrieves an order by ID
 func (s *OrderService) GetOrder(orderID int64) (*Order, error) {
+	if _, _, err := s.clients(); errors.Is(err, ErrServiceClosed) {
+		return nil, err
+	}
+	s.ordersMu.Lock()
 	order, ok := s.orders[orderID]
+	s.ordersMu.Unlock()
 	if ! ok {
 		return nil, status.Errorf(codes.NotFound, "order not found")
 	}
 	return order, nil
 }
 
+// Close stops the reaper and closes both service connections, the service
+// rejects any further call
+func (s *OrderService) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.stopReaper != nil {
+		close(s.stopReaper)
+		<-s.reaperDone
+	}
+	var errs []error
+	for _, conn := range []*grpc.ClientConn{s.userConn, s.productConn} {
+		if conn != nil {
+			errs = append(errs, conn.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// The reaper redials a connection that has not been ready for
+// minReconnectWait, the wait doubling after each redial up to
+// maxReconnectWait. grpc.ClientConn retries with its own backoff meanwhile.
+const (
+	minReconnectWait = time.Second
+	maxReconnectWait = time.Minute
+)
+
+// connWatch tracks how long a connection has been failing
+type connWatch struct {
+	failingSince time.Time
+	wait         time.Duration
+}
+
+// due reports whether a connection in the given state must be redialed
+func (w *connWatch) due(state connectivity.State, now time.Time) bool {
+	if state != connectivity.TransientFailure && state != connectivity.Connecting {
+		w.failingSince, w.wait = time.Time{}, 0
+		return false
+	}
+	if w.wait == 0 {
+		w.wait = minReconnectWait
+	}
+	if w.failingSince.IsZero() {
+		w.failingSince = now
+		return false
+	}
+	if now.Sub(w.failingSince) < w.wait {
+		return false
+	}
+	w.failingSince = now
+	w.wait = min(2*w.wait, maxReconnectWait)
+	return true
+}
+
+// reaper checks the connections every interval until Close
+func (s *OrderService) reaper(interval time.Duration) {
+	defer close(s.reaperDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var user, product connWatch
+	for {
+		select {
+		case <-s.stopReaper:
+			return
+		case now := <-ticker.C:
+			s.reap(now, &user, &product)
+		}
+	}
+}
+
+// reap replaces the connections failing for longer than their wait. The
+// clients are kept, along with the calls they coalesce.
+func (s *OrderService) reap(now time.Time, user, product *connWatch) {
+	s.mu.RLock()
+	userConn, productConn := s.userConn, s.productConn
+	s.mu.RUnlock()
+
+	if user.due(userConn.GetState(), now) {
+		s.reconnect(userConn, func(conn *grpc.ClientConn) {
+			s.userConn = conn
+		})
+	}
+	if product.due(productConn.GetState(), now) {
+		s.reconnect(productConn, func(conn *grpc.ClientConn) {
+			s.productConn = conn
+		})
+	}
+}
+
+// reconnect dials the target of old again and swaps in the new connection,
+// calls made in the meantime fail with ErrReconnecting
+func (s *OrderService) reconnect(old *grpc.ClientConn, swap func(*grpc.ClientConn)) {
+	s.mu.Lock()
+	s.reconnecting = true
+	s.mu.Unlock()
+
+	conn, err := dialService(old.Target())
+
+	s.mu.Lock()
+	s.reconnecting = false
+	if err != nil {
+		s.mu.Unlock()
+		log.Printf("Reconnect to %s failed: %v", old.Target(), err)
+		return
+	}
+	swap(conn)
+	s.mu.Unlock()
+	old.Close()
+}
+
 // LoggingInterceptor is a server interceptor for logging
 func LoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	log.Printf("Request received: %s", info.FullMethod)
@@ -362,6 +893,29 @@ func StartProductService(port string) (*grpc.Server, error) {
 		json.NewEncoder(w).Encode(product)
 	})
 
+	mux.HandleFunc("/product/get-batch", func(w http.ResponseWriter, r *http.Request) {
+		var ids []int64
+		if param := r.URL.Query().Get("ids"); param != "" {
+			for _, field := range(strings.Split(param, ",")) {
+				id, err := strconv.ParseInt(field, 10, 64)
+				if err != nil {
+					http.Error(w, "invalid product id: "+field, http.StatusBadRequest)
+					return
+				}
+				ids = append(ids, id)
+			}
+		}
+
+		resp, err := productServer.GetProductsRPC(r.Context(), &GetProductsRequest{ProductIds: ids})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
 	mux.HandleFunc("/product/check-inventory", func(w http.ResponseWriter, r *http.Request) {
 		productIDStr := r.URL.Query().Get("id")
 		quantityStr := r.URL.Query().Get("quantity")
@@ -394,31 +948,119 @@ func StartProductService(port string) (*grpc.Server, error) {
 	return s, nil
 }
 
-// Connect to both services and return an OrderService
-func ConnectToServices(userServiceAddr, productServiceAddr string) (*OrderService, error) {
-	userConn, err := grpc.Dial(userServiceAddr, 
+// ConnectOption configures the connections opened by ConnectToServices
+type ConnectOption func(*connectConfig)
+
+type connectConfig struct {
+	reapInterval time.Duration
+}
+
+// WithIdleReaper checks the connections every interval and reconnects the
+// ones that keep failing, backing off between reconnections
+func WithIdleReaper(interval time.Duration) ConnectOption {
+	return func(c *connectConfig) {
+		c.reapInterval = interval
+	}
+}
+
+// dialService opens a client connection to a service
+func dialService(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr,
 		grpc.WithInsecure(),
 		grpc.WithUnaryInterceptor(AuthInterceptor))
+}
+
+// Connect to both services and return an OrderService, the connections are
+// released by OrderService.Close
+func ConnectToServices(userServiceAddr, productServiceAddr string, opts ...ConnectOption) (*OrderService, error) {
+	var cfg connectConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	userConn, err := dialService(userServiceAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to user service: %v", err)
 	}
 
-	productConn, err := grpc.Dial(productServiceAddr, 
-		grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(AuthInterceptor))
+	productConn, err := dialService(productServiceAddr)
 	if err != nil {
+		userConn.Close()
 		return nil, fmt.Errorf("failed to connect to product service: %v", err)
 	}
 
 	userClient := NewUserServiceClient(userConn)
 	productClient := NewProductServiceClient(productConn)
 
-	return NewOrderService(userClient, productClient), nil
+	s := NewOrderService(userClient, productClient)
+	s.userConn, s.productConn = userConn, productConn
+	if cfg.reapInterval > 0 {
+		s.stopReaper = make(chan struct{})
+		s.reaperDone = make(chan struct{})
+		go s.reaper(cfg.reapInterval)
+	}
+	return s, nil
+}
+
+// flightKey identifies a user service call, the calls sharing a key are
+// identical
+type flightKey struct {
+	method string
+	userID int64
+}
+
+// flight is a call in progress, done is closed once val and err are set
+type flight struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+	dups int
+}
+
+// flightGroup coalesces identical calls in flight: the callers arriving
+// while a call runs wait for it and share its result. Nothing is kept once
+// the call returns, so an error is only seen by the callers already waiting.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[flightKey]*flight
+}
+
+// do runs fn unless a call with the same key is in flight, in which case it
+// waits for that call or for ctx to be done
+func (g *flightGroup) do(ctx context.Context, key flightKey, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[flightKey]*flight)
+	}
+	if f, ok := g.flights[key]; ok {
+		f.dups++
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.val, f.err
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	f.val, f.err = fn()
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.val, f.err
 }
 
 // Client implementations
+
+// UserServiceClient coalesces the concurrent GetUser or ValidateUser calls
+// for the same user into one backend request
 type UserServiceClient struct {
 	baseURL string
+	flights flightGroup
 }
 
 func NewUserServiceClient(conn *grpc.ClientConn) UserService {
@@ -428,6 +1070,18 @@ func NewUserServiceClient(conn *grpc.ClientConn) UserService {
 }
 
 func (c *UserServiceClient) GetUser(ctx context.Context, userID int64) (*User, error) {
+	val, err := c.flights.do(ctx, flightKey{"GetUser", userID}, func() (interface{}, error) {
+		return c.getUser(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Each caller gets its own copy of the shared user
+	user := *val.(*User)
+	return &user, nil
+}
+
+func (c *UserServiceClient) getUser(ctx context.Context, userID int64) (*User, error) {
 	resp, err := http.Get(fmt.Sprintf("%s/user/get?id=%d", c.baseURL, userID))
 	if err != nil {
 		return nil, err
@@ -447,6 +1101,16 @@ func (c *UserServiceClient) GetUser(ctx context.Context, userID int64) (*User, e
 }
 
 func (c *UserServiceClient) ValidateUser(ctx context.Context, userID int64) (bool, error) {
+	val, err := c.flights.do(ctx, flightKey{"ValidateUser", userID}, func() (interface{}, error) {
+		return c.validateUser(ctx, userID)
+	})
+	if err != nil {
+		return false, err
+	}
+	return val.(bool), nil
+}
+
+func (c *UserServiceClient) validateUser(ctx context.Context, userID int64) (bool, error) {
 	resp, err := http.Get(fmt.Sprintf("%s/user/validate?id=%d", c.baseURL, userID))
 	if err != nil {
 		return false, err
@@ -491,6 +1155,39 @@ func (c *ProductServiceClient) GetProduct(ctx context.Context, productID int64)
 	return &product, nil
 }
 
+// GetProducts fetches several products in a single round trip, returning the
+// products found and the missing IDs
+func (c *ProductServiceClient) GetProducts(ctx context.Context, ids []int64) (map[int64]*Product, []int64, error) {
+	if len(ids) == 0 {
+		return map[int64]*Product{}, []int64{}, nil
+	}
+	fields := make([]string, len(ids))
+	for i, id := range(ids) {
+		fields[i] = strconv.FormatInt(id, 10)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("http://%s/product/get-batch?ids=%s", c.conn.Target(), strings.Join(fields, ",")), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, status.Errorf(codes.Internal, "get products failed: %s", resp.Status)
+	}
+
+	var result GetProductsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, err
+	}
+	return result.Products, result.NotFound, nil
+}
+
 func (c *ProductServiceClient) CheckInventory(ctx context.Context, productID int64, quantity int32) (bool, error) {
 	resp, err := http.Get(fmt.Sprintf("http://%s/product/check-inventory?id=%d&quantity=%d", c.conn.Target(), productID, quantity))
 	if err != nil {
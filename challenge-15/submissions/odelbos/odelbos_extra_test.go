@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRedirectURI = "https://client.example.com/callback"
+
+func newNonceServer(t *testing.T, clientIDs ...string) *OAuth2Server {
+	t.Helper()
+	return newServerWithClock(t, realClock{}, clientIDs...)
+}
+
+// newServerWithClock is newNonceServer timed by clock
+func newServerWithClock(t *testing.T, clock Clock, clientIDs ...string) *OAuth2Server {
+	t.Helper()
+	server := NewOAuth2ServerWithClock(clock)
+	for _, id := range clientIDs {
+		err := server.RegisterClient(&OAuth2ClientInfo{
+			ClientID:      id,
+			ClientSecret:  id + "-secret",
+			RedirectURIs:  []string{testRedirectURI},
+			AllowedScopes: []string{"read", "admin"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to register client: %v", err)
+		}
+	}
+	return server
+}
+
+// authorize runs the authorization request for the read scope and returns
+// the redirect query
+func authorize(t *testing.T, server *OAuth2Server, clientID, nonce string) url.Values {
+	t.Helper()
+	return authorizeScope(t, server, clientID, "read", nonce)
+}
+
+func authorizeScope(t *testing.T, server *OAuth2Server, clientID, scope, nonce string) url.Values {
+	t.Helper()
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {testRedirectURI},
+		"scope":         {scope},
+		"state":         {"xyz"},
+	}
+	if nonce != "" {
+		query.Set("nonce", nonce)
+	}
+	w := httptest.NewRecorder()
+	server.HandleAuthorize(w, httptest.NewRequest("GET", "/authorize?"+query.Encode(), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status 302, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid redirect: %v", err)
+	}
+	return location.Query()
+}
+
+// exchange redeems a code and returns the status and the error code, if any
+func exchange(server *OAuth2Server, clientID, code string) (int, string) {
+	w := postToken(server, clientID, code)
+	var resp errorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return w.Code, resp.Error
+}
+
+// redeem exchanges a code that must be valid for tokens
+func redeem(t *testing.T, server *OAuth2Server, clientID, code string) tokenResponse {
+	t.Helper()
+	w := postToken(server, clientID, code)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Invalid token response: %v", err)
+	}
+	return resp
+}
+
+func postToken(server *OAuth2Server, clientID, code string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {clientID},
+		"client_secret": {clientID + "-secret"},
+	}
+	req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	server.HandleToken(w, req)
+	return w
+}
+
+func TestNonceSingleUse(t *testing.T) {
+	server := newNonceServer(t, "client")
+
+	// The same authorization request is replayed before any exchange
+	first := authorize(t, server, "client", "nonce-1").Get("code")
+	replayed := authorize(t, server, "client", "nonce-1").Get("code")
+	if first == "" || replayed == "" {
+		t.Fatal("Expected authorization codes")
+	}
+	if server.authCodes[first].Nonce != "nonce-1" {
+		t.Errorf("Expected the nonce stored on the code, got %q", server.authCodes[first].Nonce)
+	}
+
+	if status, _ := exchange(server, "client", first); status != http.StatusOK {
+		t.Fatalf("Expected the first exchange to succeed, got %d", status)
+	}
+	if status, code := exchange(server, "client", replayed); status != http.StatusBadRequest || code != "invalid_grant" {
+		t.Errorf("Expected the replayed code to be rejected, got %d %q", status, code)
+	}
+
+	// A consumed nonce cannot start a new authorization
+	query := authorize(t, server, "client", "nonce-1")
+	if query.Get("error") != "invalid_request" || query.Get("code") != "" {
+		t.Errorf("Expected invalid_request without a code, got %v", query)
+	}
+	if query.Get("state") != "xyz" {
+		t.Errorf("Expected the state to be echoed, got %q", query.Get("state"))
+	}
+}
+
+func TestNonceScopedToClient(t *testing.T) {
+	server := newNonceServer(t, "alpha", "beta")
+
+	code := authorize(t, server, "alpha", "shared").Get("code")
+	if status, _ := exchange(server, "alpha", code); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	code = authorize(t, server, "beta", "shared").Get("code")
+	if status, _ := exchange(server, "beta", code); status != http.StatusOK {
+		t.Errorf("Expected another client to use the same nonce, got %d", status)
+	}
+}
+
+func TestCodeSingleUse(t *testing.T) {
+	server := newNonceServer(t, "client")
+
+	// Without a nonce, every flow succeeds once
+	for i := 0; i < 2; i++ {
+		code := authorize(t, server, "client", "").Get("code")
+		if status, _ := exchange(server, "client", code); status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		if status, errCode := exchange(server, "client", code); status != http.StatusBadRequest || errCode != "invalid_auth_code" {
+			t.Errorf("Expected a redeemed code to be rejected, got %d %q", status, errCode)
+		}
+	}
+
+	// A failed exchange redeems the code too
+	code := authorize(t, server, "client", "nonce-2").Get("code")
+	server.authCodes[code].RedirectURI = "https://attacker.example.com"
+	if status, _ := exchange(server, "client", code); status != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", status)
+	}
+	if _, ok := server.authCodes[code]; ok {
+		t.Error("Expected the code to be removed after a failed exchange")
+	}
+}
+
+func TestScopeLifetime(t *testing.T) {
+	server := newNonceServer(t, "client")
+	if err := server.SetScopeLifetime("admin", 5*time.Minute); err != nil {
+		t.Fatalf("Failed to set the scope lifetime: %v", err)
+	}
+	if err := server.SetScopeLifetime("read", 0); err == nil {
+		t.Error("Expected a non-positive lifetime to be rejected")
+	}
+
+	read := redeem(t, server, "client", authorizeScope(t, server, "client", "read", "").Get("code"))
+	admin := redeem(t, server, "client", authorizeScope(t, server, "client", "read admin", "").Get("code"))
+	if read.ExpiresIn != int(defaultTokenLifetime.Seconds()) {
+		t.Errorf("Expected expires_in %d for read, got %d", int(defaultTokenLifetime.Seconds()), read.ExpiresIn)
+	}
+	if admin.ExpiresIn != 300 {
+		t.Errorf("Expected expires_in 300 for read admin, got %d", admin.ExpiresIn)
+	}
+
+	readToken, err := server.ValidateToken(read.AccessToken)
+	if err != nil {
+		t.Fatalf("Expected a valid read token: %v", err)
+	}
+	adminToken, err := server.ValidateToken(admin.AccessToken)
+	if err != nil {
+		t.Fatalf("Expected a valid admin token: %v", err)
+	}
+	if !adminToken.ExpiresAt.Before(readToken.ExpiresAt) {
+		t.Errorf("Expected the admin token to expire first, got %v and %v", adminToken.ExpiresAt, readToken.ExpiresAt)
+	}
+
+	// Past the admin lifetime only the read token is valid
+	server.mu.Lock()
+	for _, token := range server.tokens {
+		token.ExpiresAt = token.ExpiresAt.Add(-6 * time.Minute)
+	}
+	server.mu.Unlock()
+	if _, err := server.ValidateToken(admin.AccessToken); err == nil {
+		t.Error("Expected the admin token to be expired")
+	}
+	if _, err := server.ValidateToken(read.AccessToken); err != nil {
+		t.Errorf("Expected the read token to be valid: %v", err)
+	}
+
+	// A refreshed token keeps the shorter lifetime
+	refreshed, _, err := server.RefreshAccessToken(admin.RefreshToken)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if d := time.Until(refreshed.ExpiresAt); d > 5*time.Minute || d < 4*time.Minute {
+		t.Errorf("Expected the refreshed token to live 5 minutes, got %v", d)
+	}
+}
+
+func TestFakeClockTimer(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	fired := clock.After(time.Second)
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Error("Expected Stop to report a pending timer")
+	}
+	clock.Advance(time.Second)
+	select {
+	case at := <-fired:
+		if !at.Equal(clock.Now()) {
+			t.Errorf("Expected the timer to fire at %v, got %v", clock.Now(), at)
+		}
+	default:
+		t.Error("Expected the timer to fire once due")
+	}
+	select {
+	case <-stopped.C():
+		t.Error("Expected a stopped timer not to fire")
+	default:
+	}
+}
+
+func TestCodeExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	server := newServerWithClock(t, clock, "client")
+
+	code := authorize(t, server, "client", "").Get("code")
+	clock.Advance(5*time.Minute + time.Nanosecond)
+	if status, errCode := exchange(server, "client", code); status != http.StatusBadRequest || errCode != "invalid_auth_code" {
+		t.Errorf("Expected an expired code to be rejected, got %d %q", status, errCode)
+	}
+
+	code = authorize(t, server, "client", "").Get("code")
+	clock.Advance(5 * time.Minute)
+	redeem(t, server, "client", code)
+}
+
+func TestTokenExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	server := newServerWithClock(t, clock, "client")
+	tokens := redeem(t, server, "client", authorize(t, server, "client", "").Get("code"))
+
+	clock.Advance(defaultTokenLifetime)
+	if _, err := server.ValidateToken(tokens.AccessToken); err != nil {
+		t.Errorf("Expected the token to be valid until its expiry: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := server.ValidateToken(tokens.AccessToken); err == nil {
+		t.Error("Expected the token to be expired")
+	}
+
+	refreshed, _, err := server.RefreshAccessToken(tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if want := clock.Now().Add(defaultTokenLifetime); !refreshed.ExpiresAt.Equal(want) {
+		t.Errorf("Expected the refreshed token to expire at %v, got %v", want, refreshed.ExpiresAt)
+	}
+}
+
+func TestRefreshTokenExpiresIn(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	server := newServerWithClock(t, clock, "client")
+	tokens := redeem(t, server, "client", authorize(t, server, "client", "").Get("code"))
+
+	clock.Advance(time.Hour)
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+		"client_id":     {"client"},
+		"client_secret": {"client-secret"},
+	}
+	req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	server.HandleToken(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Invalid token response: %v", err)
+	}
+	if want := int(defaultTokenLifetime.Seconds()); resp.ExpiresIn != want {
+		t.Errorf("Expected expires_in %d on the server clock, got %d", want, resp.ExpiresIn)
+	}
+}
+
+func TestRefreshTokenExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	server := newServerWithClock(t, clock, "client")
+	tokens := redeem(t, server, "client", authorize(t, server, "client", "").Get("code"))
+
+	clock.Advance(24*time.Hour + time.Nanosecond)
+	if _, _, err := server.RefreshAccessToken(tokens.RefreshToken); err == nil {
+		t.Error("Expected the refresh token to be expired")
+	}
+}
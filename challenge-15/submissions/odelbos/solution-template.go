@@ -43,6 +43,12 @@ type OAuth2Server struct {
 	refreshTokens map[string]*RefreshToken
 	// users stores user credentials for demonstration purposes
 	users map[string]*User
+	// usedNonces maps the consumed nonces (keyed by client) to when they can be forgotten
+	usedNonces map[string]time.Time
+	// scopeLifetimes caps the lifetime of the access tokens granted a scope
+	scopeLifetimes map[string]time.Duration
+	// clock dates the codes, tokens and nonces
+	clock Clock
 	// mutex for concurrent access to data
 	mu sync.RWMutex
 }
@@ -87,8 +93,18 @@ type AuthorizationCode struct {
 	CodeChallenge string
 	// CodeChallengeMethod is for PKCE
 	CodeChallengeMethod string
+	// Nonce is the optional single-use value sent by the client
+	Nonce string
 }
 
+// defaultTokenLifetime is the lifetime of an access token granted scopes
+// without a configured lifetime
+const defaultTokenLifetime = time.Hour
+
+// nonceTTL is how long a consumed nonce is remembered, it outlives the
+// codes and refresh tokens that could carry a replay
+const nonceTTL = 24 * time.Hour
+
 // Token represents an issued access token
 type Token struct {
 	// AccessToken is the token string
@@ -117,18 +133,163 @@ type RefreshToken struct {
 	ExpiresAt time.Time
 }
 
+// Clock abstracts time so that the expiry of codes and tokens can be tested
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a one-shot timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it returns false if it already fired
+	Stop() bool
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a Clock that only moves when advanced, the expiry of codes
+// and tokens can be reached without waiting for it
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	at     time.Time
+	c      chan time.Time
+	active bool
+}
+
+// NewFakeClock returns a FakeClock reading start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1), active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock and fires the timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range(c.timers) {
+		if ! t.active {
+			continue
+		}
+		if c.now.Before(t.at) {
+			pending = append(pending, t)
+			continue
+		}
+		t.active = false
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
 // NewOAuth2Server creates a new OAuth2Server
 func NewOAuth2Server() *OAuth2Server {
+	return NewOAuth2ServerWithClock(realClock{})
+}
+
+// NewOAuth2ServerWithClock creates a new OAuth2Server timed by the given clock
+func NewOAuth2ServerWithClock(clock Clock) *OAuth2Server {
 	server := &OAuth2Server{
-		clients:       make(map[string]*OAuth2ClientInfo),
-		authCodes:     make(map[string]*AuthorizationCode),
-		tokens:        make(map[string]*Token),
-		refreshTokens: make(map[string]*RefreshToken),
-		users:         make(map[string]*User),
+		clients:        make(map[string]*OAuth2ClientInfo),
+		authCodes:      make(map[string]*AuthorizationCode),
+		tokens:         make(map[string]*Token),
+		refreshTokens:  make(map[string]*RefreshToken),
+		users:          make(map[string]*User),
+		usedNonces:     make(map[string]time.Time),
+		scopeLifetimes: make(map[string]time.Duration),
+		clock:          clock,
 	}
 	return server
 }
 
+// SetScopeLifetime caps the lifetime of the access tokens granted scope,
+// e.g. shorter-lived tokens for "admin"
+func (s *OAuth2Server) SetScopeLifetime(scope string, lifetime time.Duration) error {
+	if lifetime <= 0 {
+		return errors.New("lifetime must be positive")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scopeLifetimes[scope] = lifetime
+	return nil
+}
+
+// tokenLifetime returns the shortest lifetime of the scopes, s.mu must be held
+func (s *OAuth2Server) tokenLifetime(scopes []string) time.Duration {
+	lifetime := time.Duration(0)
+	for _, scope := range scopes {
+		l, ok := s.scopeLifetimes[scope]
+		if ! ok {
+			l = defaultTokenLifetime
+		}
+		if lifetime == 0 || l < lifetime {
+			lifetime = l
+		}
+	}
+	if lifetime == 0 {
+		return defaultTokenLifetime
+	}
+	return lifetime
+}
+
 // RegisterClient registers a new OAuth2 client
 func (s *OAuth2Server) RegisterClient(client *OAuth2ClientInfo) error {
 	s.mu.Lock()
@@ -184,12 +345,26 @@ func (s *OAuth2Server) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
 	state := r.URL.Query().Get("state")
 	codeChallenge := r.URL.Query().Get("code_challenge")
 	codeChallengeMethod := r.URL.Query().Get("code_challenge_method")
+	nonce := r.URL.Query().Get("nonce")
 
 	if ! slices.Contains(client.RedirectURIs, redirectURI) {
 		http.Error(w, "invalid redirect URI", http.StatusBadRequest)
 		return
 	}
 
+	if nonce != "" && s.nonceUsed(clientID, nonce) {
+		redirectURL, _ := url.Parse(redirectURI)
+		query := redirectURL.Query()
+		query.Set("error", "invalid_request")
+		query.Set("error_description", "nonce already used")
+		if state != "" {
+			query.Set("state", state)
+		}
+		redirectURL.RawQuery = query.Encode()
+		http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+		return
+	}
+
 	requestedScopes := strings.Split(scope, " ")
 	for _, sc := range requestedScopes {
 		if ! slices.Contains(client.AllowedScopes, sc) {
@@ -210,9 +385,10 @@ func (s *OAuth2Server) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
 		UserID:              clientID,
 		RedirectURI:         redirectURI,
 		Scopes:              requestedScopes,
-		ExpiresAt:           time.Now().Add(5 * time.Minute),
+		ExpiresAt:           s.clock.Now().Add(5 * time.Minute),
 		CodeChallenge:       codeChallenge,
 		CodeChallengeMethod: codeChallengeMethod,
+		Nonce:               nonce,
 	}
 
 	redirectURL, _ := url.Parse(redirectURI)
@@ -293,7 +469,9 @@ func (s *OAuth2Server) handleAutorizationCode(w http.ResponseWriter, r *http.Req
 	codeVerifier := r.Form.Get("code_verifier")
 
 	authCode, ok := s.authCodes[code]
-	if ! ok || authCode.ExpiresAt.Before(time.Now()) || authCode.RedirectURI != redirectURI {
+	// A code is redeemed once, even if the exchange fails
+	delete(s.authCodes, code)
+	if ! ok || authCode.ExpiresAt.Before(s.clock.Now()) || authCode.RedirectURI != redirectURI {
 		writeJSONError(w, "invalid_auth_code", "invalid authorization code", http.StatusBadRequest)
 		return
 	}
@@ -305,6 +483,14 @@ func (s *OAuth2Server) handleAutorizationCode(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	if authCode.Nonce != "" {
+		if s.nonceUsed(authCode.ClientID, authCode.Nonce) {
+			writeJSONError(w, "invalid_grant", "nonce already used", http.StatusBadRequest)
+			return
+		}
+		s.consumeNonce(authCode.ClientID, authCode.Nonce)
+	}
+
 	accessToken, err := GenerateRandomString(32)
 	if err != nil {
 		writeJSONError(w, "server_error", "internal server error", http.StatusInternalServerError)
@@ -317,26 +503,25 @@ func (s *OAuth2Server) handleAutorizationCode(w http.ResponseWriter, r *http.Req
 	}
 
 	// Store tokens
+	lifetime := s.tokenLifetime(authCode.Scopes)
 	s.tokens[accessToken] = &Token{
 		AccessToken: accessToken,
 		ClientID:    clientID,
 		UserID:      authCode.UserID,
 		Scopes:      authCode.Scopes,
-		ExpiresAt:   time.Now().Add(time.Hour)}
+		ExpiresAt:   s.clock.Now().Add(lifetime)}
 
 	s.refreshTokens[refreshToken] = &RefreshToken{
 		RefreshToken: refreshToken,
 		ClientID:     clientID,
 		UserID:       authCode.UserID,
 		Scopes:       authCode.Scopes,
-		ExpiresAt:    time.Now().Add(24 * time.Hour)}
-
-	delete(s.authCodes, code)
+		ExpiresAt:    s.clock.Now().Add(24 * time.Hour)}
 
 	response := &tokenResponse{
 		accessToken,
 		"Bearer",
-		3600,
+		int(lifetime.Seconds()),
 		refreshToken,
 		strings.Join(authCode.Scopes, " ")}
 
@@ -344,6 +529,29 @@ func (s *OAuth2Server) handleAutorizationCode(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(response)
 }
 
+// nonceKey scopes a nonce to the client that sent it
+func nonceKey(clientID, nonce string) string {
+	return clientID + "\x00" + nonce
+}
+
+// nonceUsed reports whether a nonce was consumed, s.mu must be held
+func (s *OAuth2Server) nonceUsed(clientID, nonce string) bool {
+	expiry, ok := s.usedNonces[nonceKey(clientID, nonce)]
+	return ok && s.clock.Now().Before(expiry)
+}
+
+// consumeNonce records a nonce as used and forgets the expired ones, s.mu
+// must be held
+func (s *OAuth2Server) consumeNonce(clientID, nonce string) {
+	now := s.clock.Now()
+	for key, expiry := range s.usedNonces {
+		if ! now.Before(expiry) {
+			delete(s.usedNonces, key)
+		}
+	}
+	s.usedNonces[nonceKey(clientID, nonce)] = now.Add(nonceTTL)
+}
+
 func (s *OAuth2Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -362,7 +570,7 @@ func (s *OAuth2Server) handleRefreshToken(w http.ResponseWriter, r *http.Request
 	response := &tokenResponse{
 		accessToken.AccessToken,
 		"Bearer",
-		3600,
+		int(accessToken.ExpiresAt.Sub(s.clock.Now()).Round(time.Second).Seconds()),
 		refreshToken.RefreshToken,
 		strings.Join(refreshToken.Scopes, " ")}
 
@@ -376,7 +584,7 @@ func (s *OAuth2Server) ValidateToken(token string) (*Token, error) {
 	defer s.mu.RUnlock()
 
 	t, ok := s.tokens[token]
-	if ! ok || t.ExpiresAt.Before(time.Now()) {
+	if ! ok || t.ExpiresAt.Before(s.clock.Now()) {
 		return nil, errors.New("invalid token")
 	}
 	return t, nil
@@ -388,7 +596,7 @@ func (s *OAuth2Server) RefreshAccessToken(refreshToken string) (*Token, *Refresh
 	defer s.mu.Unlock()
 
 	rt, ok := s.refreshTokens[refreshToken]
-	if ! ok || rt.ExpiresAt.Before(time.Now()) {
+	if ! ok || rt.ExpiresAt.Before(s.clock.Now()) {
 		return nil, nil, errors.New("invalid token")
 	}
 
@@ -406,14 +614,14 @@ func (s *OAuth2Server) RefreshAccessToken(refreshToken string) (*Token, *Refresh
 		ClientID:    rt.ClientID,
 		UserID:      rt.UserID,
 		Scopes:      rt.Scopes,
-		ExpiresAt:   time.Now().Add(time.Hour)}
+		ExpiresAt:   s.clock.Now().Add(s.tokenLifetime(rt.Scopes))}
 
 	newRT := &RefreshToken{
 		RefreshToken: newRefreshToken,
 		ClientID:     rt.ClientID,
 		UserID:       rt.UserID,
 		Scopes:       rt.Scopes,
-		ExpiresAt:    time.Now().Add(24 * time.Hour)}
+		ExpiresAt:    s.clock.Now().Add(24 * time.Hour)}
 
 	s.tokens[accessToken] = token
 	s.refreshTokens[newRefreshToken] = newRT
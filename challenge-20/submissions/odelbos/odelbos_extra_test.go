@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errProbe = errors.New("probe failed")
+
+// halfOpenBreaker returns a breaker tripped by one failure and ready to
+// move to half-open
+func halfOpenBreaker(t *testing.T, wait time.Duration) CircuitBreaker {
+	t.Helper()
+	cb := NewCircuitBreaker(Config{
+		MaxRequests:  1,
+		Timeout:      20 * time.Millisecond,
+		HalfOpenWait: wait,
+		ReadyToTrip: func(m Metrics) bool {
+			return m.ConsecutiveFailures >= 1
+		},
+	})
+	cb.Call(context.Background(), func() (interface{}, error) {
+		return nil, errors.New("failure")
+	})
+	if cb.GetState() != StateOpen {
+		t.Fatalf("Expected the breaker to be open, got %v", cb.GetState())
+	}
+	time.Sleep(30 * time.Millisecond)
+	return cb
+}
+
+// startProbe starts a half-open probe returning err once release is closed
+func startProbe(cb CircuitBreaker, err error) (release chan struct{}) {
+	release = make(chan struct{})
+	started := make(chan struct{})
+	go cb.Call(context.Background(), func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, err
+	})
+	<-started
+	return release
+}
+
+type callResult struct {
+	value interface{}
+	err   error
+}
+
+// callAsync makes a call returning "queued" in the background
+func callAsync(ctx context.Context, cb CircuitBreaker) <-chan callResult {
+	done := make(chan callResult, 1)
+	go func() {
+		value, err := cb.Call(ctx, func() (interface{}, error) {
+			return "queued", nil
+		})
+		done <- callResult{value, err}
+	}()
+	return done
+}
+
+func TestHalfOpenWaitProceedsAfterProbe(t *testing.T) {
+	cb := halfOpenBreaker(t, time.Second)
+	release := startProbe(cb, nil)
+
+	done := callAsync(context.Background(), cb)
+	select {
+	case res := <-done:
+		t.Fatalf("Expected the call to wait for the probe, got %v, %v", res.value, res.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case res := <-done:
+		if res.err != nil || res.value != "queued" {
+			t.Errorf("Expected the queued call to proceed, got %v, %v", res.value, res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The queued call did not proceed")
+	}
+	if cb.GetState() != StateClosed {
+		t.Errorf("Expected the breaker to be closed, got %v", cb.GetState())
+	}
+}
+
+func TestHalfOpenWaitShortCircuitsAfterFailedProbe(t *testing.T) {
+	cb := halfOpenBreaker(t, time.Second)
+	release := startProbe(cb, errProbe)
+
+	done := callAsync(context.Background(), cb)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if res := <-done; !errors.Is(res.err, ErrCircuitBreakerOpen) {
+		t.Errorf("Expected ErrCircuitBreakerOpen, got %v, %v", res.value, res.err)
+	}
+}
+
+func TestHalfOpenWaitDeadline(t *testing.T) {
+	cb := halfOpenBreaker(t, 20*time.Millisecond)
+	release := startProbe(cb, nil)
+	defer close(release)
+
+	start := time.Now()
+	if res := <-callAsync(context.Background(), cb); !errors.Is(res.err, ErrTooManyRequests) {
+		t.Errorf("Expected ErrTooManyRequests, got %v, %v", res.value, res.err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the call to wait for the deadline, returned after %v", elapsed)
+	}
+}
+
+func TestHalfOpenWaitContext(t *testing.T) {
+	cb := halfOpenBreaker(t, time.Second)
+	release := startProbe(cb, nil)
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := callAsync(ctx, cb)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case res := <-done:
+		if !errors.Is(res.err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v, %v", res.value, res.err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("The call ignored the context")
+	}
+}
+
+func TestHalfOpenWithoutWaitRejects(t *testing.T) {
+	cb := halfOpenBreaker(t, 0)
+	release := startProbe(cb, nil)
+	defer close(release)
+
+	select {
+	case res := <-callAsync(context.Background(), cb):
+		if !errors.Is(res.err, ErrTooManyRequests) {
+			t.Errorf("Expected ErrTooManyRequests, got %v, %v", res.value, res.err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Expected the call to be rejected immediately")
+	}
+}
+
+// waitTimers waits until n timers are pending
+func (c *FakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		pending := 0
+		for _, timer := range c.timers {
+			if timer.active {
+				pending++
+			}
+		}
+		c.mu.Unlock()
+		if pending == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d timers, got %d", n, pending)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func fail() (interface{}, error) {
+	return nil, errors.New("failure")
+}
+
+func succeed() (interface{}, error) {
+	return "ok", nil
+}
+
+func TestClockOpenToHalfOpen(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var transitions []State
+	cb := NewCircuitBreakerWithClock(Config{
+		Timeout:     time.Minute,
+		ReadyToTrip: func(m Metrics) bool { return m.ConsecutiveFailures >= 2 },
+		OnStateChange: func(name string, from, to State) {
+			transitions = append(transitions, to)
+		},
+	}, clock)
+
+	cb.Call(context.Background(), fail)
+	cb.Call(context.Background(), fail)
+	if got := cb.GetMetrics().LastFailureTime; !got.Equal(clock.Now()) {
+		t.Errorf("Expected the last failure at %v, got %v", clock.Now(), got)
+	}
+
+	clock.Advance(time.Minute - time.Nanosecond)
+	if _, err := cb.Call(context.Background(), succeed); !errors.Is(err, ErrCircuitBreakerOpen) {
+		t.Fatalf("Expected ErrCircuitBreakerOpen before the timeout, got %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := cb.Call(context.Background(), succeed); err != nil {
+		t.Fatalf("Expected the probe to run once the timeout elapsed, got %v", err)
+	}
+
+	want := []State{StateOpen, StateHalfOpen, StateClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("Expected transitions %v, got %v", want, transitions)
+		}
+	}
+}
+
+func TestClockIntervalResetsMetrics(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	cb := NewCircuitBreakerWithClock(Config{Interval: time.Minute}, clock)
+
+	cb.Call(context.Background(), fail)
+	cb.Call(context.Background(), succeed)
+	if m := cb.GetMetrics(); m.Requests != 2 {
+		t.Fatalf("Expected 2 requests, got %+v", m)
+	}
+	clock.Advance(time.Minute)
+	cb.Call(context.Background(), succeed)
+	if m := cb.GetMetrics(); m.Requests != 0 || m.Failures != 0 {
+		t.Errorf("Expected the metrics to be reset after the interval, got %+v", m)
+	}
+}
+
+func TestClockHalfOpenWaitDeadline(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	cb := NewCircuitBreakerWithClock(Config{
+		Timeout:      time.Minute,
+		HalfOpenWait: time.Second,
+		ReadyToTrip:  func(m Metrics) bool { return m.ConsecutiveFailures >= 1 },
+	}, clock)
+	cb.Call(context.Background(), fail)
+	clock.Advance(time.Minute)
+	release := startProbe(cb, nil)
+	defer close(release)
+
+	done := callAsync(context.Background(), cb)
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Second)
+	select {
+	case res := <-done:
+		if !errors.Is(res.err, ErrTooManyRequests) {
+			t.Errorf("Expected ErrTooManyRequests, got %v, %v", res.value, res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The call did not give up once the wait elapsed")
+	}
+}
@@ -45,6 +45,7 @@ type Config struct {
 	MaxRequests   uint32                                  // Max requests allowed in half-open state
 	Interval      time.Duration                           // Statistical window for closed state
 	Timeout       time.Duration                           // Time to wait before half-open
+	HalfOpenWait  time.Duration                           // Time excess half-open requests wait for the probes, 0 rejects them
 	ReadyToTrip   func(Metrics) bool                      // Function to determine when to trip
 	OnStateChange func(name string, from State, to State) // State change callback
 }
@@ -56,14 +57,124 @@ type CircuitBreaker interface {
 	GetMetrics() Metrics
 }
 
+// Clock abstracts time so that the breaker timeouts can be tested without
+// sleeping
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a one-shot timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it returns false if it already fired
+	Stop() bool
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a Clock that only moves when advanced, its timers fire on
+// Advance so the open and half-open timeouts elapse on demand
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	at     time.Time
+	c      chan time.Time
+	active bool
+}
+
+// NewFakeClock returns a FakeClock reading start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1), active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock and fires the timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range(c.timers) {
+		if ! t.active {
+			continue
+		}
+		if c.now.Before(t.at) {
+			pending = append(pending, t)
+			continue
+		}
+		t.active = false
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
 // circuitBreakerImpl is the concrete implementation of CircuitBreaker
 type circuitBreakerImpl struct {
 	name             string
 	config           Config
+	clock            Clock
 	state            State
 	metrics          Metrics
 	lastStateChange  time.Time
 	halfOpenRequests uint32
+	halfOpenDone     chan struct{} // closed when the half-open state is left
 	mutex            sync.RWMutex
 }
 
@@ -75,6 +186,11 @@ var (
 
 // NewCircuitBreaker creates a new circuit breaker with the given configuration
 func NewCircuitBreaker(config Config) CircuitBreaker {
+	return NewCircuitBreakerWithClock(config, realClock{})
+}
+
+// NewCircuitBreakerWithClock is NewCircuitBreaker timed by the given clock
+func NewCircuitBreakerWithClock(config Config, clock Clock) CircuitBreaker {
 	// Set default values if not provided
 	if config.MaxRequests == 0 {
 		config.MaxRequests = 1
@@ -94,8 +210,9 @@ func NewCircuitBreaker(config Config) CircuitBreaker {
 	return &circuitBreakerImpl{
 		name:            "circuit-breaker",
 		config:          config,
+		clock:           clock,
 		state:           StateClosed,
-		lastStateChange: time.Now(),
+		lastStateChange: clock.Now(),
 	}
 }
 
@@ -113,7 +230,12 @@ func (cb *circuitBreakerImpl) Call(ctx context.Context, operation func() (interf
 	}
 
 	if err := cb.canExecute(); err != nil {
-		return nil, err
+		if !errors.Is(err, ErrTooManyRequests) || cb.config.HalfOpenWait <= 0 {
+			return nil, err
+		}
+		if err := cb.waitHalfOpen(ctx); err != nil {
+			return nil, err
+		}
 	}
 
 	result, err := operation()
@@ -158,7 +280,7 @@ func (cb *circuitBreakerImpl) setState(newState State) {
 
 	prevState := cb.state
 	cb.state = newState
-	cb.lastStateChange = time.Now()
+	cb.lastStateChange = cb.clock.Now()
 
 	if newState == StateClosed {
 		cb.metrics = Metrics{}
@@ -167,6 +289,10 @@ func (cb *circuitBreakerImpl) setState(newState State) {
 
 	if newState == StateHalfOpen {
 		cb.halfOpenRequests = 0
+		cb.halfOpenDone = make(chan struct{})
+	}
+	if prevState == StateHalfOpen {
+		close(cb.halfOpenDone)
 	}
 
 	if cb.config.OnStateChange != nil {
@@ -188,7 +314,9 @@ func (cb *circuitBreakerImpl) canExecute() error {
 		return nil
 	case StateOpen:
 		if cb.isReady() {
+			// This request is the first probe
 			cb.setState(StateHalfOpen)
+			cb.halfOpenRequests++
 			return nil
 		}
 		return ErrCircuitBreakerOpen
@@ -203,6 +331,26 @@ func (cb *circuitBreakerImpl) canExecute() error {
 	}
 }
 
+// waitHalfOpen waits up to HalfOpenWait for the half-open probes to settle
+// the state, then tries again: the request proceeds if the breaker closed
+// and is short-circuited if it opened again
+func (cb *circuitBreakerImpl) waitHalfOpen(ctx context.Context) error {
+	cb.mutex.RLock()
+	settled := cb.halfOpenDone
+	cb.mutex.RUnlock()
+
+	timer := cb.clock.NewTimer(cb.config.HalfOpenWait)
+	defer timer.Stop()
+	select {
+	case <-settled:
+		return cb.canExecute()
+	case <-timer.C():
+		return ErrTooManyRequests
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // recordSuccess records a successful operation
 func (cb *circuitBreakerImpl) recordSuccess() {
 	// TODO: Implement success recording
@@ -218,9 +366,9 @@ func (cb *circuitBreakerImpl) recordSuccess() {
 		cb.setState(StateClosed)
 	}
 
-	if time.Since(cb.lastStateChange) >= cb.config.Interval {
+	if now := cb.clock.Now(); now.Sub(cb.lastStateChange) >= cb.config.Interval {
 		cb.metrics = Metrics{}
-		cb.lastStateChange = time.Now()
+		cb.lastStateChange = now
 	}
 }
 
@@ -236,7 +384,7 @@ func (cb *circuitBreakerImpl) recordFailure() {
 	cb.metrics.Requests++
 	cb.metrics.Failures++
 	cb.metrics.ConsecutiveFailures++
-	cb.metrics.LastFailureTime = time.Now()
+	cb.metrics.LastFailureTime = cb.clock.Now()
 
 	if cb.state == StateHalfOpen {
 		cb.setState(StateOpen)
@@ -259,7 +407,7 @@ func (cb *circuitBreakerImpl) shouldTrip() bool {
 func (cb *circuitBreakerImpl) isReady() bool {
 	// TODO: Implement readiness check
 	// Check if enough time has passed since last state change (Timeout duration)
-	return time.Since(cb.lastStateChange) >= cb.config.Timeout
+	return cb.clock.Now().Sub(cb.lastStateChange) >= cb.config.Timeout
 }
 
 // Example usage and testing helper functions
@@ -0,0 +1,887 @@
+package generics
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listValues collects the list elements from front to back
+func listValues[T any](l *List[T]) []T {
+	values := make([]T, 0, l.Len())
+	l.ForEach(func(v T) bool {
+		values = append(values, v)
+		return true
+	})
+	return values
+}
+
+// TestList tests the doubly-linked List implementation
+func TestTriple(t *testing.T) {
+	triple := NewTriple("a", 1, true)
+	if triple.First != "a" || triple.Second != 1 || !triple.Third {
+		t.Errorf("Expected the fields a, 1, true, got %+v", triple)
+	}
+	s, n, b := triple.Unpack()
+	if s != "a" || n != 1 || !b {
+		t.Errorf("Expected Unpack to return a, 1, true, got %v, %v, %v", s, n, b)
+	}
+	first, second := NewPair(2.5, "x").Unpack()
+	if first != 2.5 || second != "x" {
+		t.Errorf("Expected Unpack to return 2.5, x, got %v, %v", first, second)
+	}
+}
+
+func TestZip(t *testing.T) {
+	pairs := Zip([]int{1, 2, 3}, []string{"a", "b"})
+	if want := []Pair[int, string]{{1, "a"}, {2, "b"}}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("Expected %v, got %v", want, pairs)
+	}
+
+	tests := []struct {
+		name string
+		as   []int
+		bs   []string
+		cs   []bool
+		want []Triple[int, string, bool]
+	}{
+		{"Same length", []int{1, 2}, []string{"a", "b"}, []bool{true, false},
+			[]Triple[int, string, bool]{{1, "a", true}, {2, "b", false}}},
+		{"First shortest", []int{1}, []string{"a", "b"}, []bool{true, false},
+			[]Triple[int, string, bool]{{1, "a", true}}},
+		{"Third shortest", []int{1, 2, 3}, []string{"a", "b", "c"}, []bool{true, false},
+			[]Triple[int, string, bool]{{1, "a", true}, {2, "b", false}}},
+		{"One empty", []int{1, 2}, nil, []bool{true},
+			[]Triple[int, string, bool]{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Zip3(tt.as, tt.bs, tt.cs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	got := Enumerate([]string{"a", "b", "c"})
+	want := []Pair[int, string]{{0, "a"}, {1, "b"}, {2, "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := Enumerate([]int(nil)); len(got) != 0 {
+		t.Errorf("Expected no pair for an empty slice, got %v", got)
+	}
+}
+
+func TestList(t *testing.T) {
+	t.Run("PushAndPop", func(t *testing.T) {
+		l := NewList[int]()
+		l.PushBack(2)
+		l.PushBack(3)
+		l.PushFront(1)
+
+		if l.Len() != 3 {
+			t.Errorf("Expected length 3, got %d", l.Len())
+		}
+		if v, err := l.PopFront(); err != nil || v != 1 {
+			t.Errorf("Expected PopFront to return 1, got %v (err: %v)", v, err)
+		}
+		if v, err := l.PopBack(); err != nil || v != 3 {
+			t.Errorf("Expected PopBack to return 3, got %v (err: %v)", v, err)
+		}
+		if v, err := l.PopBack(); err != nil || v != 2 {
+			t.Errorf("Expected PopBack to return 2, got %v (err: %v)", v, err)
+		}
+		if _, err := l.PopFront(); err != ErrEmptyCollection {
+			t.Errorf("Expected ErrEmptyCollection, got %v", err)
+		}
+		if l.Front() != nil || l.Back() != nil {
+			t.Error("Expected empty list to have no front or back")
+		}
+	})
+
+	t.Run("InsertInTheMiddle", func(t *testing.T) {
+		l := NewList[string]()
+		a := l.PushBack("a")
+		d := l.PushBack("d")
+		c := l.InsertBefore("c", d)
+		l.InsertAfter("b", a)
+		l.InsertAfter("e", d)
+
+		expected := []string{"a", "b", "c", "d", "e"}
+		if got := listValues(l); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+		if c.Prev().Value != "b" || c.Next().Value != "d" {
+			t.Errorf("Expected c to be linked between b and d")
+		}
+		if l.Back().Value != "e" {
+			t.Errorf("Expected back to be e, got %v", l.Back().Value)
+		}
+	})
+
+	t.Run("RemoveAndIterate", func(t *testing.T) {
+		l := NewList[int]()
+		nodes := make([]*Node[int], 5)
+		for i := range nodes {
+			nodes[i] = l.PushBack(i)
+		}
+
+		l.Remove(nodes[2])
+		l.Remove(nodes[0])
+		l.Remove(nodes[4])
+		l.InsertAfter(10, nodes[1])
+		l.PushFront(-1)
+
+		expected := []int{-1, 1, 10, 3}
+		if got := listValues(l); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+		if l.Len() != 4 {
+			t.Errorf("Expected length 4, got %d", l.Len())
+		}
+
+		// Removing a node twice or a node of another list is a no-op
+		if l.Remove(nodes[2]) {
+			t.Error("Expected removing a detached node to return false")
+		}
+		other := NewList[int]()
+		if l.Remove(other.PushBack(1)) || l.InsertBefore(1, other.Front()) != nil {
+			t.Error("Expected nodes of another list to be rejected")
+		}
+		if l.Len() != 4 {
+			t.Errorf("Expected length 4, got %d", l.Len())
+		}
+	})
+
+	t.Run("ForEachStops", func(t *testing.T) {
+		l := NewList[int]()
+		for i := 1; i <= 5; i++ {
+			l.PushBack(i)
+		}
+		var visited []int
+		l.ForEach(func(v int) bool {
+			visited = append(visited, v)
+			return v < 3
+		})
+		if !reflect.DeepEqual(visited, []int{1, 2, 3}) {
+			t.Errorf("Expected iteration to stop at 3, got %v", visited)
+		}
+	})
+}
+
+// trieKeys returns the keys of the entries
+func trieKeys[V any](entries []KeyValue[string, V]) []string {
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+	return keys
+}
+
+// TestTrie tests the Trie implementation
+func TestTrie(t *testing.T) {
+	newTrie := func() *Trie[int] {
+		tr := NewTrie[int]()
+		for i, key := range []string{"go", "gopher", "golang", "gin", "git", "rust", ""} {
+			tr.Insert(key, i)
+		}
+		return tr
+	}
+
+	t.Run("GetAndOverwrite", func(t *testing.T) {
+		tr := newTrie()
+		if v, ok := tr.Get("golang"); !ok || v != 2 {
+			t.Errorf("Expected golang -> 2, got %v (ok: %v)", v, ok)
+		}
+		if _, ok := tr.Get("gol"); ok {
+			t.Error("Expected a prefix without value to be missing")
+		}
+		if v, ok := tr.Get(""); !ok || v != 6 {
+			t.Errorf("Expected empty key -> 6, got %v (ok: %v)", v, ok)
+		}
+
+		tr.Insert("go", 42)
+		if v, _ := tr.Get("go"); v != 42 {
+			t.Errorf("Expected overwritten value 42, got %v", v)
+		}
+		if tr.Len() != 7 {
+			t.Errorf("Expected 7 keys after overwrite, got %d", tr.Len())
+		}
+	})
+
+	t.Run("WithPrefix", func(t *testing.T) {
+		tr := newTrie()
+		tests := map[string][]string{
+			"go":  {"go", "golang", "gopher"},
+			"gi":  {"gin", "git"},
+			"g":   {"gin", "git", "go", "golang", "gopher"},
+			"gop": {"gopher"},
+			"x":   {},
+			"":    {"", "gin", "git", "go", "golang", "gopher", "rust"},
+		}
+		for prefix, expected := range tests {
+			if got := trieKeys(tr.WithPrefix(prefix)); !reflect.DeepEqual(got, expected) {
+				t.Errorf("WithPrefix(%q): expected %v, got %v", prefix, expected, got)
+			}
+		}
+	})
+
+	t.Run("DeletePrunes", func(t *testing.T) {
+		tr := newTrie()
+		if !tr.Delete("gopher") {
+			t.Error("Expected gopher to be deleted")
+		}
+		if tr.Delete("gopher") || tr.Delete("gol") {
+			t.Error("Expected deleting a missing key to return false")
+		}
+		if _, ok := tr.root.children['g'].children['o'].children['p']; ok {
+			t.Error("Expected the gopher branch to be pruned")
+		}
+		if v, ok := tr.Get("go"); !ok || v != 0 {
+			t.Error("Expected go to survive the deletion of gopher")
+		}
+
+		tr.Delete("go")
+		if got := trieKeys(tr.WithPrefix("go")); !reflect.DeepEqual(got, []string{"golang"}) {
+			t.Errorf("Expected [golang], got %v", got)
+		}
+		tr.Delete("golang")
+		if _, ok := tr.root.children['g'].children['o']; ok {
+			t.Error("Expected the go branch to be pruned")
+		}
+		if tr.Len() != 4 {
+			t.Errorf("Expected 4 keys, got %d", tr.Len())
+		}
+	})
+}
+
+func isEven(n int) bool  { return n%2 == 0 }
+func square(n int) int   { return n * n }
+func label(n int) string { return "n" + strconv.Itoa(n) }
+
+func TestIterator(t *testing.T) {
+	data := Range(0, 100).Collect()
+	if len(data) != 100 || data[0] != 0 || data[99] != 99 {
+		t.Fatalf("Unexpected Range values: %v", data)
+	}
+
+	// Lazy and eager chains give the same results
+	eager := Map(Filter(data, isEven), square)[:5]
+	lazy := MapIterator(FromSlice(data).Filter(isEven), square).Take(5).Collect()
+	if !reflect.DeepEqual(eager, lazy) {
+		t.Errorf("Expected lazy chain %v to match eager %v", lazy, eager)
+	}
+
+	eagerStr := Map(Filter(data, isEven), label)[3:6]
+	lazyStr := MapIterator(FromSlice(data).Filter(isEven).Skip(3).Take(3), label).Collect()
+	if !reflect.DeepEqual(eagerStr, lazyStr) {
+		t.Errorf("Expected lazy chain %v to match eager %v", lazyStr, eagerStr)
+	}
+
+	if got := FromSlice(data).Skip(200).Collect(); len(got) != 0 {
+		t.Errorf("Expected skipping past the end to be empty, got %v", got)
+	}
+	if got := Range(5, 5).Collect(); len(got) != 0 {
+		t.Errorf("Expected an empty range, got %v", got)
+	}
+	if got := FromSlice([]int{1, 2}).Take(10).Collect(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("Expected Take to stop at the end, got %v", got)
+	}
+}
+
+func TestIteratorIsLazy(t *testing.T) {
+	calls := 0
+	counted := func(n int) bool {
+		calls++
+		return isEven(n)
+	}
+
+	it := FromSlice(Range(0, 1000).Collect()).Filter(counted).Take(3)
+	if calls != 0 {
+		t.Errorf("Expected no work before iterating, got %d calls", calls)
+	}
+	var seen []int
+	it.ForEach(func(n int) bool {
+		seen = append(seen, n)
+		return true
+	})
+	if !reflect.DeepEqual(seen, []int{0, 2, 4}) || calls != 5 {
+		t.Errorf("Expected [0 2 4] after 5 predicate calls, got %v after %d", seen, calls)
+	}
+
+	seen = nil
+	Range(0, 10).ForEach(func(n int) bool {
+		seen = append(seen, n)
+		return n < 2
+	})
+	if !reflect.DeepEqual(seen, []int{0, 1, 2}) {
+		t.Errorf("Expected ForEach to stop when fn returns false, got %v", seen)
+	}
+}
+
+func TestIteratorAllocations(t *testing.T) {
+	data := Range(0, 10000).Collect()
+
+	eager := testing.AllocsPerRun(10, func() {
+		_ = Map(Filter(data, isEven), square)[:10]
+	})
+	lazy := testing.AllocsPerRun(10, func() {
+		_ = MapIterator(FromSlice(data).Filter(isEven), square).Take(10).Collect()
+	})
+	if lazy >= eager {
+		t.Errorf("Expected the lazy chain to allocate less than the eager one, got %.0f >= %.0f", lazy, eager)
+	}
+}
+
+func BenchmarkEagerChain(b *testing.B) {
+	data := Range(0, 10000).Collect()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = Map(Filter(data, isEven), square)[:10]
+	}
+}
+
+func BenchmarkLazyChain(b *testing.B) {
+	data := Range(0, 10000).Collect()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = MapIterator(FromSlice(data).Filter(isEven), square).Take(10).Collect()
+	}
+}
+
+// waitForGoroutines waits for the goroutine count to drop back to n
+func waitForGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected at most %d goroutines, got %d", n, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	before := runtime.NumGoroutine()
+	pool := NewWorkerPool(context.Background(), 4, func(_ context.Context, n int) int {
+		return n * n
+	})
+
+	go func() {
+		for i := 1; i <= 100; i++ {
+			if err := pool.Submit(i); err != nil {
+				t.Errorf("Unexpected submit error: %v", err)
+			}
+		}
+		pool.Close()
+	}()
+
+	sum, count := 0, 0
+	for result := range pool.Results() {
+		sum += result
+		count++
+	}
+	pool.Wait()
+
+	if count != 100 || sum != 338350 {
+		t.Errorf("Expected 100 results summing to 338350, got %d summing to %d", count, sum)
+	}
+	if err := pool.Submit(1); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Expected ErrPoolClosed after Close, got %v", err)
+	}
+	pool.Close()
+	waitForGoroutines(t, before)
+}
+
+func TestWorkerPoolCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var started atomic.Int32
+	pool := NewWorkerPool(ctx, 2, func(ctx context.Context, n int) int {
+		if started.Add(1) > 5 {
+			<-ctx.Done()
+		}
+		return n
+	})
+
+	submitted := make(chan error, 1)
+	go func() {
+		for i := range 100 {
+			if err := pool.Submit(i); err != nil {
+				submitted <- err
+				return
+			}
+		}
+		submitted <- nil
+	}()
+
+	count := 0
+	for range pool.Results() {
+		if count++; count == 5 {
+			cancel()
+		}
+	}
+	pool.Wait()
+
+	if err := <-submitted; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Submit to fail with context.Canceled, got %v", err)
+	}
+	if count < 5 || count >= 100 {
+		t.Errorf("Expected the batch to stop midway, got %d results", count)
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestWorkerPoolWaitWithoutDrain(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := NewWorkerPool(ctx, 3, func(_ context.Context, s string) int {
+		return len(s)
+	})
+	for range 10 {
+		go pool.Submit("job")
+	}
+
+	// Nobody reads the results, cancellation still releases the workers
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	pool.Wait()
+	pool.Close()
+	waitForGoroutines(t, before)
+}
+
+// fakeClock is a manual Clock, timers fire from Advance
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	at     time.Time
+	f      func()
+	active bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+// Advance moves the clock forward and runs the timers that became due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if t.active && !t.at.After(c.now) {
+			t.active = false
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// recorder collects the arguments of the calls it receives
+type recorder[T any] struct {
+	mu    sync.Mutex
+	calls []T
+}
+
+func (r *recorder[T]) call(arg T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, arg)
+}
+
+func (r *recorder[T]) values() []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.calls)
+}
+
+func TestDebounce(t *testing.T) {
+	clock := newFakeClock()
+	rec := &recorder[string]{}
+	db := DebounceWithClock(clock, 100*time.Millisecond, rec.call)
+
+	db.Call("a")
+	clock.Advance(60 * time.Millisecond)
+	db.Call("b")
+	clock.Advance(60 * time.Millisecond)
+	db.Call("c")
+	clock.Advance(99 * time.Millisecond)
+	if got := rec.values(); len(got) != 0 {
+		t.Fatalf("Expected no call before the quiet period, got %v", got)
+	}
+	clock.Advance(time.Millisecond)
+	if got := rec.values(); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Fatalf("Expected the latest argument only, got %v", got)
+	}
+
+	// Nothing pending, nothing more fires
+	clock.Advance(time.Second)
+	if got := rec.values(); len(got) != 1 {
+		t.Fatalf("Expected 1 call, got %v", got)
+	}
+
+	db.Call("d")
+	clock.Advance(100 * time.Millisecond)
+	if got := rec.values(); !reflect.DeepEqual(got, []string{"c", "d"}) {
+		t.Errorf("Expected calls [c d], got %v", got)
+	}
+}
+
+func TestDebounceCancelFlush(t *testing.T) {
+	clock := newFakeClock()
+	rec := &recorder[int]{}
+	db := DebounceWithClock(clock, time.Second, rec.call)
+
+	db.Call(1)
+	db.Cancel()
+	clock.Advance(2 * time.Second)
+	if got := rec.values(); len(got) != 0 {
+		t.Fatalf("Expected a cancelled call not to run, got %v", got)
+	}
+
+	db.Call(2)
+	db.Call(3)
+	db.Flush()
+	if got := rec.values(); !reflect.DeepEqual(got, []int{3}) {
+		t.Fatalf("Expected Flush to run the latest call, got %v", got)
+	}
+	clock.Advance(2 * time.Second)
+	db.Flush()
+	if got := rec.values(); len(got) != 1 {
+		t.Errorf("Expected a flushed call to run once, got %v", got)
+	}
+}
+
+func TestDebounceConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	rec := &recorder[int]{}
+	db := DebounceWithClock(clock, time.Second, rec.call)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.Call(i)
+		}()
+	}
+	wg.Wait()
+	clock.Advance(time.Second)
+	if got := rec.values(); len(got) != 1 {
+		t.Errorf("Expected a single call, got %v", got)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	clock := newFakeClock()
+	rec := &recorder[int]{}
+	throttled := ThrottleWithClock(clock, 100*time.Millisecond, rec.call)
+
+	throttled(1)
+	throttled(2)
+	clock.Advance(99 * time.Millisecond)
+	throttled(3)
+	clock.Advance(time.Millisecond)
+	throttled(4)
+	throttled(5)
+	clock.Advance(250 * time.Millisecond)
+	throttled(6)
+	if got := rec.values(); !reflect.DeepEqual(got, []int{1, 4, 6}) {
+		t.Errorf("Expected calls [1 4 6], got %v", got)
+	}
+}
+
+func TestThrottleConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	var calls atomic.Int32
+	throttled := ThrottleWithClock(clock, time.Second, func(int) { calls.Add(1) })
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttled(i)
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected 1 call, got %d", n)
+	}
+}
+
+func TestRingBufferWraparound(t *testing.T) {
+	rb := NewRingBuffer[int](3)
+	if _, err := rb.Pop(); !errors.Is(err, ErrEmptyCollection) {
+		t.Errorf("Expected ErrEmptyCollection from an empty buffer, got %v", err)
+	}
+	if _, err := rb.Peek(); !errors.Is(err, ErrEmptyCollection) {
+		t.Errorf("Expected ErrEmptyCollection from an empty buffer, got %v", err)
+	}
+
+	// Pops move the head, so the next pushes wrap around the backing array
+	rb.Push(1)
+	rb.Push(2)
+	rb.Push(3)
+	for _, want := range []int{1, 2} {
+		if v, err := rb.Pop(); err != nil || v != want {
+			t.Errorf("Expected %d, got %d, %v", want, v, err)
+		}
+	}
+	rb.Push(4)
+	rb.Push(5)
+	if got := rb.Slice(); !slices.Equal(got, []int{3, 4, 5}) {
+		t.Errorf("Expected [3 4 5], got %v", got)
+	}
+	if v, _ := rb.Peek(); v != 3 || rb.Len() != 3 {
+		t.Errorf("Expected the oldest element 3 and len 3, got %d and %d", v, rb.Len())
+	}
+
+	// Many cycles keep the FIFO order
+	var popped []int
+	for i := 6; i <= 20; i++ {
+		v, _ := rb.Pop()
+		popped = append(popped, v)
+		rb.Push(i)
+	}
+	if want := []int{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17}; !slices.Equal(popped, want) {
+		t.Errorf("Expected pops %v, got %v", want, popped)
+	}
+	for _, want := range []int{18, 19, 20} {
+		if v, err := rb.Pop(); err != nil || v != want {
+			t.Errorf("Expected %d, got %d, %v", want, v, err)
+		}
+	}
+	if rb.Len() != 0 || len(rb.Slice()) != 0 {
+		t.Errorf("Expected an empty buffer, got %v", rb.Slice())
+	}
+}
+
+func TestRingBufferOverwrite(t *testing.T) {
+	rb := NewRingBuffer[string](3)
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		if err := rb.Push(s); err != nil {
+			t.Fatalf("Expected overwrite mode to accept every push, got %v", err)
+		}
+	}
+	if got := rb.Slice(); !slices.Equal(got, []string{"c", "d", "e"}) {
+		t.Errorf("Expected the oldest elements overwritten, got %v", got)
+	}
+	if rb.Len() != 3 || rb.Cap() != 3 {
+		t.Errorf("Expected len 3 and cap 3, got %d and %d", rb.Len(), rb.Cap())
+	}
+
+	// Slice is a copy
+	snapshot := rb.Slice()
+	snapshot[0] = "changed"
+	if v, _ := rb.Peek(); v != "c" {
+		t.Errorf("Expected Slice to return a copy, got %q", v)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for a zero capacity")
+		}
+	}()
+	NewRingBuffer[int](0)
+}
+
+func TestRingBufferStrict(t *testing.T) {
+	rb := NewStrictRingBuffer[int](2)
+	rb.Push(1)
+	rb.Push(2)
+	if err := rb.Push(3); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("Expected ErrBufferFull, got %v", err)
+	}
+	if got := rb.Slice(); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("Expected the buffer unchanged, got %v", got)
+	}
+
+	rb.Pop()
+	if err := rb.Push(3); err != nil {
+		t.Errorf("Expected a push after a pop to succeed, got %v", err)
+	}
+	if got := rb.Slice(); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("Expected [2 3], got %v", got)
+	}
+}
+
+// barrier waits until the scheduler ran every task due by now, tasks running
+// in order
+func barrier(t *testing.T, s *Scheduler, clock *fakeClock) {
+	t.Helper()
+	done := make(chan struct{})
+	s.Schedule(clock.Now(), func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the scheduler")
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	clock := newFakeClock()
+	s := NewSchedulerWithClock(clock)
+	defer s.Stop()
+	var rec recorder[string]
+	base := clock.Now()
+	for _, task := range []struct {
+		name  string
+		delay time.Duration
+	}{{"c", 3 * time.Second}, {"a", time.Second}, {"b1", 2 * time.Second}, {"b2", 2 * time.Second}, {"d", 10 * time.Second}} {
+		name := task.name
+		s.Schedule(base.Add(task.delay), func() { rec.call(name) })
+	}
+
+	clock.Advance(time.Second)
+	barrier(t, s, clock)
+	if got := rec.values(); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("Expected [a] after 1s, got %v", got)
+	}
+
+	clock.Advance(2 * time.Second)
+	barrier(t, s, clock)
+	if got := rec.values(); !slices.Equal(got, []string{"a", "b1", "b2", "c"}) {
+		t.Errorf("Expected [a b1 b2 c] after 3s, got %v", got)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Expected 1 pending task, got %d", s.Len())
+	}
+
+	// Each task runs exactly once however far the clock goes
+	clock.Advance(10 * time.Second)
+	clock.Advance(time.Hour)
+	barrier(t, s, clock)
+	if got := rec.values(); !slices.Equal(got, []string{"a", "b1", "b2", "c", "d"}) {
+		t.Errorf("Expected every task once, got %v", got)
+	}
+
+	// A task in the past runs right away
+	s.Schedule(base, func() { rec.call("late") })
+	barrier(t, s, clock)
+	if got := rec.values(); got[len(got)-1] != "late" {
+		t.Errorf("Expected the late task to run, got %v", got)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	clock := newFakeClock()
+	s := NewSchedulerWithClock(clock)
+	defer s.Stop()
+	var rec recorder[string]
+
+	x := s.Schedule(clock.Now().Add(time.Second), func() { rec.call("x") })
+	y := s.Schedule(clock.Now().Add(2*time.Second), func() { rec.call("y") })
+	if !s.Cancel(x) {
+		t.Error("Expected the pending task to be cancelled")
+	}
+	if s.Cancel(x) || s.Cancel(999) {
+		t.Error("Expected an unknown task not to be cancelled")
+	}
+
+	clock.Advance(3 * time.Second)
+	barrier(t, s, clock)
+	if got := rec.values(); !slices.Equal(got, []string{"y"}) {
+		t.Errorf("Expected only y to run, got %v", got)
+	}
+	if s.Cancel(y) {
+		t.Error("Expected a task that ran not to be cancelled")
+	}
+	if s.Len() != 0 {
+		t.Errorf("Expected no pending task, got %d", s.Len())
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	clock := newFakeClock()
+	s := NewSchedulerWithClock(clock)
+	var ran atomic.Bool
+	s.Schedule(clock.Now().Add(time.Second), func() { ran.Store(true) })
+
+	s.Stop()
+	if id := s.Schedule(clock.Now(), func() { ran.Store(true) }); id != 0 {
+		t.Errorf("Expected no task after Stop, got ID %d", id)
+	}
+	clock.Advance(time.Minute)
+	s.Stop()
+	waitForGoroutines(t, before)
+	if ran.Load() {
+		t.Error("Expected no task to run after Stop")
+	}
+}
+
+func TestSchedulerStopFromTask(t *testing.T) {
+	before := runtime.NumGoroutine()
+	clock := newFakeClock()
+	s := NewSchedulerWithClock(clock)
+	var ran atomic.Bool
+	s.Schedule(clock.Now(), func() { go s.Stop() })
+	s.Schedule(clock.Now().Add(time.Second), func() { ran.Store(true) })
+
+	waitForGoroutines(t, before)
+	clock.Advance(time.Minute)
+	if ran.Load() {
+		t.Error("Expected no task to run once a task stopped the scheduler")
+	}
+	if id := s.Schedule(clock.Now(), func() {}); id != 0 {
+		t.Errorf("Expected no task after Stop, got ID %d", id)
+	}
+}
+
+func TestSchedulerRealClock(t *testing.T) {
+	s := NewScheduler()
+	defer s.Stop()
+	done := make(chan time.Time, 1)
+	start := time.Now()
+	s.Schedule(start.Add(20*time.Millisecond), func() { done <- time.Now() })
+
+	select {
+	case at := <-done:
+		if at.Sub(start) < 20*time.Millisecond {
+			t.Errorf("Expected the task to run after 20ms, ran after %v", at.Sub(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the task")
+	}
+}
@@ -1,8 +1,12 @@
 package generics
 
 import (
+	"container/heap"
+	"context"
 	"errors"
 	"slices"
+	"sync"
+	"time"
 )
 
 // ErrEmptyCollection is returned when an operation cannot be performed on an empty collection
@@ -28,6 +32,28 @@ func (p Pair[T, U]) Swap() Pair[U, T] {
 	return Pair[U, T]{First: p.Second, Second: p.First}
 }
 
+// Unpack returns the elements of the pair, for a, b := p.Unpack()
+func (p Pair[T, U]) Unpack() (T, U) {
+	return p.First, p.Second
+}
+
+// Triple represents a generic group of three values of potentially different types
+type Triple[A, B, C any] struct {
+	First  A
+	Second B
+	Third  C
+}
+
+// NewTriple creates a new triple with the given values
+func NewTriple[A, B, C any](first A, second B, third C) Triple[A, B, C] {
+	return Triple[A, B, C]{First: first, Second: second, Third: third}
+}
+
+// Unpack returns the elements of the triple, for a, b, c := t.Unpack()
+func (t Triple[A, B, C]) Unpack() (A, B, C) {
+	return t.First, t.Second, t.Third
+}
+
 //
 // 2. Generic Stack
 //
@@ -267,3 +293,948 @@ func RemoveDuplicates[T comparable](slice []T) []T {
 	}
 	return result
 }
+
+// Zip pairs the elements of two slices by index, stopping at the shorter one
+func Zip[A, B any](as []A, bs []B) []Pair[A, B] {
+	n := min(len(as), len(bs))
+	result := make([]Pair[A, B], n)
+	for i := range(n) {
+		result[i] = NewPair(as[i], bs[i])
+	}
+	return result
+}
+
+// Zip3 groups the elements of three slices by index, stopping at the shortest one
+func Zip3[A, B, C any](as []A, bs []B, cs []C) []Triple[A, B, C] {
+	n := min(len(as), len(bs), len(cs))
+	result := make([]Triple[A, B, C], n)
+	for i := range(n) {
+		result[i] = NewTriple(as[i], bs[i], cs[i])
+	}
+	return result
+}
+
+// Enumerate pairs each element of a slice with its index
+func Enumerate[T any](slice []T) []Pair[int, T] {
+	result := make([]Pair[int, T], len(slice))
+	for i, val := range(slice) {
+		result[i] = NewPair(i, val)
+	}
+	return result
+}
+
+//
+// 6. Generic Doubly-Linked List
+//
+
+// Node is an element of a List, it is the handle used for O(1) insertions and removals
+type Node[T any] struct {
+	Value T
+	prev  *Node[T]
+	next  *Node[T]
+	list  *List[T]
+}
+
+// Next returns the next node or nil if this is the last one
+func (n *Node[T]) Next() *Node[T] {
+	return n.next
+}
+
+// Prev returns the previous node or nil if this is the first one
+func (n *Node[T]) Prev() *Node[T] {
+	return n.prev
+}
+
+// List is a generic doubly-linked list
+type List[T any] struct {
+	head *Node[T]
+	tail *Node[T]
+	size int
+}
+
+// NewList creates a new empty list
+func NewList[T any]() *List[T] {
+	return &List[T]{}
+}
+
+// Len returns the number of elements in the list
+func (l *List[T]) Len() int {
+	return l.size
+}
+
+// Front returns the first node or nil if the list is empty
+func (l *List[T]) Front() *Node[T] {
+	return l.head
+}
+
+// Back returns the last node or nil if the list is empty
+func (l *List[T]) Back() *Node[T] {
+	return l.tail
+}
+
+// PushFront adds an element at the front of the list and returns its node
+func (l *List[T]) PushFront(value T) *Node[T] {
+	if l.head == nil {
+		return l.insertFirst(value)
+	}
+	return l.InsertBefore(value, l.head)
+}
+
+// PushBack adds an element at the back of the list and returns its node
+func (l *List[T]) PushBack(value T) *Node[T] {
+	if l.tail == nil {
+		return l.insertFirst(value)
+	}
+	return l.InsertAfter(value, l.tail)
+}
+
+// PopFront removes and returns the first element
+// Returns an error if the list is empty
+func (l *List[T]) PopFront() (T, error) {
+	if l.head == nil {
+		var zero T
+		return zero, ErrEmptyCollection
+	}
+	node := l.head
+	l.Remove(node)
+	return node.Value, nil
+}
+
+// PopBack removes and returns the last element
+// Returns an error if the list is empty
+func (l *List[T]) PopBack() (T, error) {
+	if l.tail == nil {
+		var zero T
+		return zero, ErrEmptyCollection
+	}
+	node := l.tail
+	l.Remove(node)
+	return node.Value, nil
+}
+
+// InsertBefore inserts an element right before mark and returns its node
+// Returns nil if mark does not belong to the list
+func (l *List[T]) InsertBefore(value T, mark *Node[T]) *Node[T] {
+	if mark == nil || mark.list != l {
+		return nil
+	}
+	node := &Node[T]{Value: value, prev: mark.prev, next: mark, list: l}
+	if mark.prev == nil {
+		l.head = node
+	} else {
+		mark.prev.next = node
+	}
+	mark.prev = node
+	l.size++
+	return node
+}
+
+// InsertAfter inserts an element right after mark and returns its node
+// Returns nil if mark does not belong to the list
+func (l *List[T]) InsertAfter(value T, mark *Node[T]) *Node[T] {
+	if mark == nil || mark.list != l {
+		return nil
+	}
+	node := &Node[T]{Value: value, prev: mark, next: mark.next, list: l}
+	if mark.next == nil {
+		l.tail = node
+	} else {
+		mark.next.prev = node
+	}
+	mark.next = node
+	l.size++
+	return node
+}
+
+// Remove unlinks the node from the list in O(1)
+// Returns false if the node does not belong to the list
+func (l *List[T]) Remove(node *Node[T]) bool {
+	if node == nil || node.list != l {
+		return false
+	}
+	if node.prev == nil {
+		l.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
+	if node.next == nil {
+		l.tail = node.prev
+	} else {
+		node.next.prev = node.prev
+	}
+	node.prev, node.next, node.list = nil, nil, nil
+	l.size--
+	return true
+}
+
+// ForEach calls fn on each element from front to back, stopping when fn returns false
+func (l *List[T]) ForEach(fn func(T) bool) {
+	for node := l.head; node != nil; node = node.next {
+		if ! fn(node.Value) {
+			return
+		}
+	}
+}
+
+func (l *List[T]) insertFirst(value T) *Node[T] {
+	node := &Node[T]{Value: value, list: l}
+	l.head, l.tail = node, node
+	l.size = 1
+	return node
+}
+
+//
+// 7. Generic Trie
+//
+
+// KeyValue is a generic key/value pair
+type KeyValue[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type trieNode[V any] struct {
+	children map[rune]*trieNode[V]
+	value    V
+	hasValue bool
+}
+
+// Trie is a generic prefix tree mapping string keys to values
+type Trie[V any] struct {
+	root *trieNode[V]
+	size int
+}
+
+// NewTrie creates a new empty trie
+func NewTrie[V any]() *Trie[V] {
+	return &Trie[V]{root: &trieNode[V]{children: make(map[rune]*trieNode[V])}}
+}
+
+// Len returns the number of keys in the trie
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// Insert adds a key to the trie, overwriting the value of an existing key
+func (t *Trie[V]) Insert(key string, value V) {
+	node := t.root
+	for _, r := range key {
+		child, ok := node.children[r]
+		if ! ok {
+			child = &trieNode[V]{children: make(map[rune]*trieNode[V])}
+			node.children[r] = child
+		}
+		node = child
+	}
+	if ! node.hasValue {
+		t.size++
+	}
+	node.value, node.hasValue = value, true
+}
+
+// Get returns the value of a key and whether it exists
+func (t *Trie[V]) Get(key string) (V, bool) {
+	node := t.find(key)
+	if node == nil || ! node.hasValue {
+		var zero V
+		return zero, false
+	}
+	return node.value, true
+}
+
+// Delete removes a key and prunes the branches left empty
+// Returns false if the key does not exist
+func (t *Trie[V]) Delete(key string) bool {
+	runes := []rune(key)
+	path := make([]*trieNode[V], 0, len(runes)+1)
+	node := t.root
+	path = append(path, node)
+	for _, r := range runes {
+		node = node.children[r]
+		if node == nil {
+			return false
+		}
+		path = append(path, node)
+	}
+	if ! node.hasValue {
+		return false
+	}
+
+	var zero V
+	node.value, node.hasValue = zero, false
+	t.size--
+
+	// Walk back up and drop the nodes which no longer lead to a value
+	for i := len(runes); i > 0; i-- {
+		n := path[i]
+		if n.hasValue || len(n.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, runes[i-1])
+	}
+	return true
+}
+
+// WithPrefix returns all the entries whose key starts with prefix, in lexicographic order
+func (t *Trie[V]) WithPrefix(prefix string) []KeyValue[string, V] {
+	result := make([]KeyValue[string, V], 0)
+	node := t.find(prefix)
+	if node == nil {
+		return result
+	}
+	var walk func(n *trieNode[V], key []rune)
+	walk = func(n *trieNode[V], key []rune) {
+		if n.hasValue {
+			result = append(result, KeyValue[string, V]{Key: string(key), Value: n.value})
+		}
+		keys := make([]rune, 0, len(n.children))
+		for r := range(n.children) {
+			keys = append(keys, r)
+		}
+		slices.Sort(keys)
+		for _, r := range(keys) {
+			walk(n.children[r], append(key, r))
+		}
+	}
+	walk(node, []rune(prefix))
+	return result
+}
+
+func (t *Trie[V]) find(key string) *trieNode[V] {
+	node := t.root
+	for _, r := range key {
+		node = node.children[r]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+//
+// 8. Lazy Iterators
+//
+
+// Iterator is a lazy pull-based sequence, values are computed on demand by Next
+// so chained transformations do not allocate intermediate slices
+type Iterator[T any] struct {
+	next func() (T, bool)
+}
+
+// Next returns the next value, or false once the iterator is exhausted
+func (it *Iterator[T]) Next() (T, bool) {
+	return it.next()
+}
+
+// FromSlice creates an iterator over the elements of a slice
+func FromSlice[T any](slice []T) *Iterator[T] {
+	i := 0
+	return &Iterator[T]{next: func() (T, bool) {
+		if i >= len(slice) {
+			var zero T
+			return zero, false
+		}
+		i++
+		return slice[i-1], true
+	}}
+}
+
+// Range creates an iterator over the integers in [start, end)
+func Range(start, end int) *Iterator[int] {
+	i := start
+	return &Iterator[int]{next: func() (int, bool) {
+		if i >= end {
+			return 0, false
+		}
+		i++
+		return i - 1, true
+	}}
+}
+
+// MapIterator lazily applies a function to each value of an iterator
+// (Go methods cannot introduce the U type parameter, hence a function)
+func MapIterator[T, U any](it *Iterator[T], mapper func(T) U) *Iterator[U] {
+	return &Iterator[U]{next: func() (U, bool) {
+		val, ok := it.Next()
+		if ! ok {
+			var zero U
+			return zero, false
+		}
+		return mapper(val), true
+	}}
+}
+
+// Filter lazily keeps the values for which the predicate returns true
+func (it *Iterator[T]) Filter(predicate func(T) bool) *Iterator[T] {
+	return &Iterator[T]{next: func() (T, bool) {
+		for {
+			val, ok := it.Next()
+			if ! ok || predicate(val) {
+				return val, ok
+			}
+		}
+	}}
+}
+
+// Take stops the iteration after n values
+func (it *Iterator[T]) Take(n int) *Iterator[T] {
+	return &Iterator[T]{next: func() (T, bool) {
+		if n <= 0 {
+			var zero T
+			return zero, false
+		}
+		n--
+		return it.Next()
+	}}
+}
+
+// Skip drops the first n values
+func (it *Iterator[T]) Skip(n int) *Iterator[T] {
+	return &Iterator[T]{next: func() (T, bool) {
+		for ; n > 0; n-- {
+			if _, ok := it.Next(); ! ok {
+				var zero T
+				return zero, false
+			}
+		}
+		return it.Next()
+	}}
+}
+
+// Collect consumes the iterator and returns its values
+func (it *Iterator[T]) Collect() []T {
+	result := make([]T, 0)
+	for val, ok := it.Next(); ok; val, ok = it.Next() {
+		result = append(result, val)
+	}
+	return result
+}
+
+// ForEach consumes the iterator, calling fn for each value until it returns false
+func (it *Iterator[T]) ForEach(fn func(T) bool) {
+	for val, ok := it.Next(); ok; val, ok = it.Next() {
+		if ! fn(val) {
+			return
+		}
+	}
+}
+
+//
+// 9. Generic Worker Pool
+//
+
+// ErrPoolClosed is returned when submitting a job to a closed worker pool
+var ErrPoolClosed = errors.New("worker pool is closed")
+
+// WorkerPool processes jobs of type T with a fixed number of workers and
+// publishes their results of type R on the Results channel.
+// Results must be drained, otherwise the workers block once its buffer is full.
+type WorkerPool[T, R any] struct {
+	ctx     context.Context
+	fn      func(context.Context, T) R
+	jobs    chan T
+	results chan R
+	done    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// NewWorkerPool starts a pool of workers applying fn to the submitted jobs.
+// Cancelling ctx stops the workers, jobs not yet started are dropped and fn
+// receives ctx to abort the jobs in progress.
+func NewWorkerPool[T, R any](ctx context.Context, workers int, fn func(context.Context, T) R) *WorkerPool[T, R] {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &WorkerPool[T, R]{
+		ctx:     ctx,
+		fn:      fn,
+		jobs:    make(chan T),
+		results: make(chan R, workers),
+		done:    make(chan struct{}),
+	}
+	p.wg.Add(workers)
+	for range workers {
+		go p.work()
+	}
+	go func() {
+		p.wg.Wait()
+		close(p.results)
+		close(p.done)
+	}()
+	return p
+}
+
+// work runs jobs until the pool is closed or its context is cancelled
+func (p *WorkerPool[T, R]) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case job, ok := <-p.jobs:
+			if ! ok || p.ctx.Err() != nil {
+				return
+			}
+			result := p.fn(p.ctx, job)
+			select {
+			case p.results <- result:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Submit hands a job to a worker, blocking until one is available.
+// Returns ErrPoolClosed after Close, or the context error once cancelled.
+func (p *WorkerPool[T, R]) Submit(job T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// Results returns the channel of job results, it is closed once all workers exit
+func (p *WorkerPool[T, R]) Results() <-chan R {
+	return p.results
+}
+
+// Close stops accepting jobs, the workers exit after finishing the submitted ones
+func (p *WorkerPool[T, R]) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ! p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+}
+
+// Wait blocks until all workers have exited, i.e. after Close or cancellation
+func (p *WorkerPool[T, R]) Wait() {
+	<-p.done
+}
+
+//
+// 10. Debounce and Throttle
+//
+
+// Timer is a pending call scheduled by a Clock
+type Timer interface {
+	// Stop cancels the call, it returns false if the call already ran or was stopped
+	Stop() bool
+}
+
+// Clock abstracts time so that Debounce and Throttle can be tested
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Debouncer delays calls to a function until no call happened for a while,
+// only the latest argument is passed. It is safe for concurrent use.
+type Debouncer[T any] struct {
+	clock   Clock
+	d       time.Duration
+	fn      func(T)
+	mu      sync.Mutex
+	timer   Timer
+	arg     T
+	pending bool
+	gen     uint64 // invalidates a timer that fired while being stopped
+}
+
+// Debounce returns a Debouncer calling fn once d elapsed since the last Call
+func Debounce[T any](d time.Duration, fn func(T)) *Debouncer[T] {
+	return DebounceWithClock(realClock{}, d, fn)
+}
+
+// DebounceWithClock is Debounce driven by the given clock
+func DebounceWithClock[T any](clock Clock, d time.Duration, fn func(T)) *Debouncer[T] {
+	return &Debouncer[T]{clock: clock, d: d, fn: fn}
+}
+
+// Call records arg and restarts the quiet period
+func (db *Debouncer[T]) Call(arg T) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.stop()
+	db.arg, db.pending = arg, true
+	gen := db.gen
+	db.timer = db.clock.AfterFunc(db.d, func() { db.fire(gen) })
+}
+
+// Cancel drops the pending call, if any
+func (db *Debouncer[T]) Cancel() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.stop()
+}
+
+// Flush runs the pending call now instead of at the end of the quiet period
+func (db *Debouncer[T]) Flush() {
+	db.mu.Lock()
+	arg, pending := db.arg, db.pending
+	db.stop()
+	db.mu.Unlock()
+	if pending {
+		db.fn(arg)
+	}
+}
+
+// fire runs the pending call scheduled as generation gen
+func (db *Debouncer[T]) fire(gen uint64) {
+	db.mu.Lock()
+	if gen != db.gen || ! db.pending {
+		db.mu.Unlock()
+		return
+	}
+	arg := db.arg
+	db.stop()
+	db.mu.Unlock()
+	db.fn(arg)
+}
+
+// stop clears the pending call, db.mu must be held
+func (db *Debouncer[T]) stop() {
+	if db.timer != nil {
+		db.timer.Stop()
+		db.timer = nil
+	}
+	var zero T
+	db.arg, db.pending = zero, false
+	db.gen++
+}
+
+// Throttle returns a function calling fn at most once per d, calls made
+// within d of the last one that ran are dropped. It is safe for concurrent use.
+func Throttle[T any](d time.Duration, fn func(T)) func(T) {
+	return ThrottleWithClock(realClock{}, d, fn)
+}
+
+// ThrottleWithClock is Throttle driven by the given clock
+func ThrottleWithClock[T any](clock Clock, d time.Duration, fn func(T)) func(T) {
+	var mu sync.Mutex
+	var last time.Time
+	called := false
+	return func(arg T) {
+		mu.Lock()
+		now := clock.Now()
+		if called && now.Sub(last) < d {
+			mu.Unlock()
+			return
+		}
+		called, last = true, now
+		mu.Unlock()
+		fn(arg)
+	}
+}
+
+//
+// 11. Generic Ring Buffer
+//
+
+// ErrBufferFull is returned by Push on a full strict ring buffer
+var ErrBufferFull = errors.New("ring buffer is full")
+
+// RingBuffer is a fixed capacity FIFO buffer. When full, Push overwrites the
+// oldest element, or fails with ErrBufferFull in strict mode.
+type RingBuffer[T any] struct {
+	items  []T
+	head   int // index of the oldest element
+	size   int
+	strict bool
+}
+
+// NewRingBuffer creates a ring buffer overwriting its oldest element when
+// full. It panics if capacity is less than 1.
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	if capacity < 1 {
+		panic("generics: ring buffer capacity must be at least 1")
+	}
+	return &RingBuffer[T]{items: make([]T, capacity)}
+}
+
+// NewStrictRingBuffer creates a ring buffer rejecting pushes when full
+func NewStrictRingBuffer[T any](capacity int) *RingBuffer[T] {
+	rb := NewRingBuffer[T](capacity)
+	rb.strict = true
+	return rb
+}
+
+// Push appends value as the newest element
+func (rb *RingBuffer[T]) Push(value T) error {
+	if rb.size == len(rb.items) {
+		if rb.strict {
+			return ErrBufferFull
+		}
+		rb.items[rb.head] = value
+		rb.head = (rb.head + 1) % len(rb.items)
+		return nil
+	}
+	rb.items[(rb.head+rb.size)%len(rb.items)] = value
+	rb.size++
+	return nil
+}
+
+// Pop removes and returns the oldest element
+// Returns an error if the buffer is empty
+func (rb *RingBuffer[T]) Pop() (T, error) {
+	var zero T
+	if rb.size == 0 {
+		return zero, ErrEmptyCollection
+	}
+	val := rb.items[rb.head]
+	rb.items[rb.head] = zero // do not retain popped values
+	rb.head = (rb.head + 1) % len(rb.items)
+	rb.size--
+	return val, nil
+}
+
+// Peek returns the oldest element without removing it
+// Returns an error if the buffer is empty
+func (rb *RingBuffer[T]) Peek() (T, error) {
+	if rb.size == 0 {
+		var zero T
+		return zero, ErrEmptyCollection
+	}
+	return rb.items[rb.head], nil
+}
+
+// Len returns the number of elements in the buffer
+func (rb *RingBuffer[T]) Len() int {
+	return rb.size
+}
+
+// Cap returns the capacity of the buffer
+func (rb *RingBuffer[T]) Cap() int {
+	return len(rb.items)
+}
+
+// Slice returns a copy of the elements, oldest first
+func (rb *RingBuffer[T]) Slice() []T {
+	result := make([]T, 0, rb.size)
+	end := rb.head + rb.size
+	if end <= len(rb.items) {
+		return append(result, rb.items[rb.head:end]...)
+	}
+	result = append(result, rb.items[rb.head:]...)
+	return append(result, rb.items[:end-len(rb.items)]...)
+}
+
+//
+// 12. Scheduler
+//
+
+// TaskID identifies a task of a Scheduler, IDs start at 1
+type TaskID uint64
+
+// scheduledTask is a pending task, index is its position in the heap
+type scheduledTask struct {
+	id    TaskID
+	at    time.Time
+	fn    func()
+	index int
+}
+
+// taskHeap is a min-heap of tasks by due time, then by scheduling order
+type taskHeap []*scheduledTask
+
+func (h taskHeap) Len() int {
+	return len(h)
+}
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].id < h[j].id
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *taskHeap) Push(x any) {
+	task := x.(*scheduledTask)
+	task.index = len(*h)
+	*h = append(*h, task)
+}
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	task := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	task.index = -1
+	return task
+}
+
+// Scheduler runs functions at given times. The pending tasks are kept in a
+// min-heap and a single timer is armed for the earliest one, the tasks run
+// one at a time from the scheduler goroutine. It is safe for concurrent use.
+type Scheduler struct {
+	clock   Clock
+	mu      sync.Mutex
+	tasks   taskHeap
+	byID    map[TaskID]*scheduledTask
+	lastID  TaskID
+	timer   Timer
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	closed  bool
+}
+
+// NewScheduler starts a scheduler, Stop releases its goroutine
+func NewScheduler() *Scheduler {
+	return NewSchedulerWithClock(realClock{})
+}
+
+// NewSchedulerWithClock is NewScheduler driven by the given clock
+func NewSchedulerWithClock(clock Clock) *Scheduler {
+	s := &Scheduler{
+		clock:   clock,
+		byID:    make(map[TaskID]*scheduledTask),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Schedule runs fn at the given time, right away if it is already past. It
+// returns 0 once the scheduler is stopped, fn never running.
+func (s *Scheduler) Schedule(at time.Time, fn func()) TaskID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0
+	}
+	s.lastID++
+	task := &scheduledTask{id: s.lastID, at: at, fn: fn}
+	heap.Push(&s.tasks, task)
+	s.byID[task.id] = task
+	if s.tasks[0] == task {
+		// The timer must be re-armed for the new earliest task
+		s.signal()
+	}
+	return task.id
+}
+
+// Cancel removes a pending task, it returns false if the task already ran or
+// was cancelled
+func (s *Scheduler) Cancel(id TaskID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.byID[id]
+	if ! ok {
+		return false
+	}
+	heap.Remove(&s.tasks, task.index)
+	delete(s.byID, id)
+	return true
+}
+
+// Len returns the number of pending tasks
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks)
+}
+
+// Stop drops the pending tasks and waits for the running one, if any. Tasks
+// run on the scheduler goroutine, so a task calling Stop would wait for
+// itself forever: a task must stop its scheduler with go s.Stop() instead.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.stopped
+		return
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.tasks, s.byID = nil, nil
+	close(s.done)
+	s.mu.Unlock()
+	<-s.stopped
+}
+
+// signal wakes the scheduler goroutine without blocking
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the scheduler goroutine
+func (s *Scheduler) run() {
+	defer close(s.stopped)
+	for {
+		for _, task := range s.due() {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			task.fn()
+		}
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// due removes the tasks due by now, in order, and arms the timer for the
+// next one
+func (s *Scheduler) due() []*scheduledTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	now := s.clock.Now()
+	var due []*scheduledTask
+	for len(s.tasks) > 0 && ! s.tasks[0].at.After(now) {
+		task := heap.Pop(&s.tasks).(*scheduledTask)
+		delete(s.byID, task.id)
+		due = append(due, task)
+	}
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.tasks) > 0 {
+		s.timer = s.clock.AfterFunc(s.tasks[0].at.Sub(now), s.signal)
+	}
+	return due
+}
@@ -0,0 +1,1047 @@
+package cache
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMRUCache(t *testing.T) {
+	cache := NewMRUCache(2)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Get("a")
+
+	// "a" is the most recently used, it is evicted instead of "b"
+	cache.Put("c", 3)
+	if _, found := cache.Get("a"); found {
+		t.Error("Expected the most recently used key to be evicted")
+	}
+	if val, found := cache.Get("b"); !found || val != 2 {
+		t.Errorf("Expected b=2 to be retained, got %v, %v", val, found)
+	}
+	if cache.Size() != 2 || cache.Capacity() != 2 {
+		t.Errorf("Expected size 2 and capacity 2, got %d and %d", cache.Size(), cache.Capacity())
+	}
+
+	cache.Put("b", 20)
+	if val, _ := cache.Get("b"); val != 20 {
+		t.Errorf("Expected the update to replace the value, got %v", val)
+	}
+	if !cache.Delete("b") || cache.Delete("b") || cache.Size() != 1 {
+		t.Error("Expected Delete to remove the key once")
+	}
+
+	if rate := cache.HitRate(); rate != 0.75 {
+		t.Errorf("Expected a hit rate of 0.75, got %v", rate)
+	}
+	cache.Clear()
+	if cache.Size() != 0 || cache.HitRate() != 0 {
+		t.Errorf("Expected Clear to reset the entries and stats, got size %d and rate %v", cache.Size(), cache.HitRate())
+	}
+
+	if NewMRUCache(0) != nil || NewCache(MRU, 0) != nil {
+		t.Error("Expected a nil cache for a zero capacity")
+	}
+	if _, ok := NewCache(MRU, 2).(*MRUCache); !ok {
+		t.Error("Expected NewCache(MRU) to return an MRUCache")
+	}
+}
+
+func TestNewCacheZeroCapacity(t *testing.T) {
+	for _, policy := range []CachePolicy{LRU, MRU, ARC} {
+		if cache := NewCache(policy, 0); cache != nil {
+			t.Errorf("Expected NewCache(%v, 0) to be nil, got %#v", policy, cache)
+		}
+		if cache := NewThreadSafeCacheWithPolicy(policy, 0); cache != nil {
+			t.Errorf("Expected NewThreadSafeCacheWithPolicy(%v, 0) to be nil, got %#v", policy, cache)
+		}
+	}
+}
+
+// scan reads keys through the cache, inserting them on miss
+func scan(cache Cache, keys ...string) {
+	for _, key := range keys {
+		if _, found := cache.Get(key); !found {
+			cache.Put(key, key)
+		}
+	}
+}
+
+func TestMRUCacheScanning(t *testing.T) {
+	for _, policy := range []CachePolicy{LRU, MRU} {
+		cache := NewCache(policy, 3)
+		scan(cache, "hot1", "hot2", "hot1", "hot2")
+
+		// A one-off scan keeps the hot entries in MRU, flushes them in LRU
+		for i := range 10 {
+			scan(cache, "scan"+strconv.Itoa(i))
+		}
+		_, hot1 := cache.Get("hot1")
+		_, hot2 := cache.Get("hot2")
+		if retained := hot1 && hot2; retained != (policy == MRU) {
+			t.Errorf("Policy %d: expected hot entries retained=%v, got %v", policy, policy == MRU, retained)
+		}
+	}
+
+	// A cyclic scan larger than the cache never hits in LRU
+	keys := []string{"k0", "k1", "k2", "k3"}
+	lru, mru := NewCache(LRU, 3), NewCache(MRU, 3)
+	for range 10 {
+		scan(lru, keys...)
+		scan(mru, keys...)
+	}
+	if lru.HitRate() != 0 {
+		t.Errorf("Expected LRU to thrash, got a hit rate of %v", lru.HitRate())
+	}
+	if mru.HitRate() < 0.5 {
+		t.Errorf("Expected MRU to keep most of the cycle, got a hit rate of %v", mru.HitRate())
+	}
+}
+
+// present returns which of the keys are present, without touching the stats
+// or the recency of the entries
+func present(cache Cache, keys ...string) []string {
+	var found []string
+	for _, key := range keys {
+		var ok bool
+		switch c := cache.(type) {
+		case *LRUCache:
+			_, ok = c.cache[key]
+		case *MRUCache:
+			_, ok = c.cache[key]
+		case *LFUCache:
+			_, ok = c.cache[key]
+		case *LFUWithAging:
+			_, ok = c.cache[key]
+		case *FIFOCache:
+			_, ok = c.items[key]
+		case *ARCCache:
+			_, ok = c.items[key]
+		}
+		if ok {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+func TestResizeEvictionOrder(t *testing.T) {
+	tests := []struct {
+		policy CachePolicy
+		want   string
+	}{
+		// a and c were read, d was inserted last
+		{LRU, "c d"},
+		{MRU, "a b"},
+		// b and d have the lowest frequency, b is the older
+		{LFU, "a c"},
+		{FIFO, "c d"},
+	}
+	for _, tt := range tests {
+		cache := NewCache(tt.policy, 4)
+		cache.Put("a", 1)
+		cache.Put("b", 2)
+		cache.Put("c", 3)
+		cache.Get("a")
+		cache.Get("c")
+		cache.Put("d", 4)
+		if tt.policy == LFU {
+			cache.Get("a")
+		}
+
+		cache.(Resizer).Resize(2)
+		got := strings.Join(present(cache, "a", "b", "c", "d"), " ")
+		if got != tt.want || cache.Size() != 2 || cache.Capacity() != 2 {
+			t.Errorf("Policy %d: expected %q with capacity 2, got %q with size %d and capacity %d",
+				tt.policy, tt.want, got, cache.Size(), cache.Capacity())
+		}
+	}
+}
+
+func TestResizeGrow(t *testing.T) {
+	for _, policy := range []CachePolicy{LRU, MRU, LFU, FIFO} {
+		cache := NewCache(policy, 2)
+		cache.Put("a", 1)
+		cache.Put("b", 2)
+
+		cache.(Resizer).Resize(4)
+		cache.Put("c", 3)
+		cache.Put("d", 4)
+		if got := present(cache, "a", "b", "c", "d"); len(got) != 4 || cache.Capacity() != 4 {
+			t.Errorf("Policy %d: expected every key kept with capacity 4, got %v and %d", policy, got, cache.Capacity())
+		}
+
+		// The new capacity is enforced by later puts
+		cache.Put("e", 5)
+		if cache.Size() != 4 {
+			t.Errorf("Policy %d: expected size 4, got %d", policy, cache.Size())
+		}
+	}
+}
+
+func TestResizeToZero(t *testing.T) {
+	for _, policy := range []CachePolicy{LRU, MRU, LFU, FIFO} {
+		cache := NewThreadSafeCacheWithPolicy(policy, 3)
+		cache.Put("a", 1)
+		cache.Put("b", 2)
+
+		cache.(Resizer).Resize(-1)
+		if cache.Size() != 0 || cache.Capacity() != 0 {
+			t.Errorf("Policy %d: expected an empty cache with capacity 0, got %d and %d", policy, cache.Size(), cache.Capacity())
+		}
+		cache.Put("c", 3)
+		if _, found := cache.Get("c"); found {
+			t.Errorf("Policy %d: expected a zero capacity cache to store nothing", policy)
+		}
+
+		cache.(Resizer).Resize(1)
+		cache.Put("c", 3)
+		if val, found := cache.Get("c"); !found || val != 3 {
+			t.Errorf("Policy %d: expected c=3 after growing, got %v, %v", policy, val, found)
+		}
+	}
+}
+
+func TestResizeConcurrent(t *testing.T) {
+	cache := NewThreadSafeCacheWithPolicy(LFU, 50)
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := range 200 {
+				key := strconv.Itoa(i*1000 + j%60)
+				cache.Put(key, j)
+				cache.Get(key)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				cache.(Resizer).Resize(j % 20)
+			}
+		}()
+	}
+	wg.Wait()
+	if cache.Size() > cache.Capacity() {
+		t.Errorf("Expected size within capacity, got %d > %d", cache.Size(), cache.Capacity())
+	}
+}
+
+// pollute makes "hot" very popular, then only uses "steady" and finally
+// inserts a new key, forcing an eviction
+func pollute(cache Cache) {
+	cache.Put("hot", 1)
+	for range 100 {
+		cache.Get("hot")
+	}
+	cache.Put("steady", 2)
+	for range 50 {
+		cache.Get("steady")
+	}
+	cache.Put("new", 3)
+}
+
+func TestLFUWithAging(t *testing.T) {
+	// Plain LFU keeps the once-hot entry and evicts the one in use
+	lfu := NewLFUCache(2)
+	pollute(lfu)
+	if got := strings.Join(present(lfu, "hot", "steady", "new"), " "); got != "hot new" {
+		t.Errorf("Expected plain LFU to keep hot, got %q", got)
+	}
+
+	aging := NewLFUWithAging(2, 20, 0.5)
+	pollute(aging)
+	if got := strings.Join(present(aging, "hot", "steady", "new"), " "); got != "steady new" {
+		t.Errorf("Expected the aged hot entry to be evicted, got %q", got)
+	}
+	if aging.Size() != 2 {
+		t.Errorf("Expected size 2, got %d", aging.Size())
+	}
+
+	// Aging does not change the hit accounting
+	if lfu.HitRate() != aging.HitRate() || aging.HitRate() != 1 {
+		t.Errorf("Expected a hit rate of 1 for both caches, got %v and %v", lfu.HitRate(), aging.HitRate())
+	}
+}
+
+func TestLFUWithAgingDecay(t *testing.T) {
+	cache := NewLFUWithAging(3, 4, 0.5)
+	cache.Put("a", 1)
+	for range 10 {
+		cache.Get("a")
+	}
+	freq := func(key string) int { return cache.cache[key].freq }
+
+	// 11 accesses, aged at the 4th and 8th: ((1+3)/2+4)/2 + 3
+	if got := freq("a"); got != 6 {
+		t.Errorf("Expected frequency 6 after aging, got %d", got)
+	}
+
+	// Frequencies never drop below 1 and the minimum stays consistent
+	cache.Put("b", 2)
+	for range 20 {
+		cache.Put("c", 3)
+	}
+	if freq("b") != 1 || cache.minFreq != 1 {
+		t.Errorf("Expected b at frequency 1 and minFreq 1, got %d and %d", freq("b"), cache.minFreq)
+	}
+	cache.Put("d", 4)
+	if _, found := cache.cache["b"]; found {
+		t.Error("Expected b to be evicted first")
+	}
+
+	for _, args := range [][2]float64{{0, 0.5}, {4, 0}, {4, 1}} {
+		if NewLFUWithAging(3, int(args[0]), args[1]) != nil {
+			t.Errorf("Expected nil for interval %v and factor %v", args[0], args[1])
+		}
+	}
+}
+
+func ringKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func ringOwners(t *testing.T, ring *ConsistentHashRing, keys []string) map[string]string {
+	t.Helper()
+	owners := make(map[string]string, len(keys))
+	for _, key := range keys {
+		shard, ok := ring.Get(key)
+		if !ok {
+			t.Fatalf("Get(%q) found no shard", key)
+		}
+		owners[key] = shard
+	}
+	return owners
+}
+
+func newTestRing(shards ...string) *ConsistentHashRing {
+	ring := NewConsistentHashRing(DefaultReplicas)
+	for _, shard := range shards {
+		ring.AddShard(shard)
+	}
+	return ring
+}
+
+func TestConsistentHashRingDistribution(t *testing.T) {
+	shards := []string{"shard-a", "shard-b", "shard-c", "shard-d"}
+	ring := newTestRing(shards...)
+	keys := ringKeys(10000)
+
+	counts := make(map[string]int)
+	for _, shard := range ringOwners(t, ring, keys) {
+		counts[shard]++
+	}
+	mean := float64(len(keys)) / float64(len(shards))
+	for _, shard := range shards {
+		if n := float64(counts[shard]); n < 0.7*mean || n > 1.3*mean {
+			t.Errorf("shard %s owns %d keys, want within 30%% of %.0f", shard, counts[shard], mean)
+		}
+	}
+}
+
+func TestConsistentHashRingRemoveShard(t *testing.T) {
+	ring := newTestRing("shard-a", "shard-b", "shard-c", "shard-d")
+	keys := ringKeys(10000)
+	before := ringOwners(t, ring, keys)
+
+	if !ring.RemoveShard("shard-b") {
+		t.Fatal("RemoveShard(shard-b) = false")
+	}
+	if ring.RemoveShard("shard-b") {
+		t.Error("second RemoveShard(shard-b) = true")
+	}
+
+	after := ringOwners(t, ring, keys)
+	targets := make(map[string]bool)
+	for _, key := range keys {
+		if before[key] == "shard-b" {
+			targets[after[key]] = true
+			continue
+		}
+		if after[key] != before[key] {
+			t.Fatalf("key %s moved from %s to %s", key, before[key], after[key])
+		}
+	}
+	if targets["shard-b"] {
+		t.Error("keys still routed to the removed shard")
+	}
+	if len(targets) < 2 {
+		t.Errorf("keys of the removed shard went to %v, want them spread", targets)
+	}
+
+	ring.AddShard("shard-b")
+	restored := ringOwners(t, ring, keys)
+	for _, key := range keys {
+		if restored[key] != before[key] {
+			t.Fatalf("key %s routed to %s after re-adding, want %s", key, restored[key], before[key])
+		}
+	}
+}
+
+func TestConsistentHashRingAddShard(t *testing.T) {
+	ring := newTestRing("shard-a", "shard-b", "shard-c", "shard-d")
+	keys := ringKeys(10000)
+	before := ringOwners(t, ring, keys)
+
+	if !ring.AddShard("shard-e") {
+		t.Fatal("AddShard(shard-e) = false")
+	}
+	if ring.AddShard("shard-e") {
+		t.Error("second AddShard(shard-e) = true")
+	}
+
+	moved := 0
+	for key, shard := range ringOwners(t, ring, keys) {
+		if shard == before[key] {
+			continue
+		}
+		if shard != "shard-e" {
+			t.Fatalf("key %s moved from %s to %s", key, before[key], shard)
+		}
+		moved++
+	}
+	if fraction := float64(moved) / float64(len(keys)); fraction < 0.1 || fraction > 0.3 {
+		t.Errorf("%.2f of the keys moved, want about 1/5", fraction)
+	}
+}
+
+func TestConsistentHashRingEmpty(t *testing.T) {
+	ring := NewConsistentHashRing(0)
+	if _, ok := ring.Get("key"); ok {
+		t.Error("Get on an empty ring found a shard")
+	}
+	if ring.replicas != DefaultReplicas {
+		t.Errorf("replicas = %d, want %d", ring.replicas, DefaultReplicas)
+	}
+}
+
+func TestShardedCache(t *testing.T) {
+	factory := func() Cache { return NewThreadSafeCacheWithPolicy(LRU, 100) }
+	cache := NewShardedCache(factory, DefaultReplicas, "shard-a", "shard-b", "shard-c")
+	if cache == nil {
+		t.Fatal("NewShardedCache returned nil")
+	}
+	if got := cache.Capacity(); got != 300 {
+		t.Errorf("Capacity() = %d, want 300", got)
+	}
+
+	keys := ringKeys(60)
+	for i, key := range keys {
+		cache.Put(key, i)
+	}
+	if got := cache.Size(); got != len(keys) {
+		t.Fatalf("Size() = %d, want %d", got, len(keys))
+	}
+	for i, key := range keys {
+		if value, found := cache.Get(key); !found || value != i {
+			t.Fatalf("Get(%s) = %v, %v, want %d", key, value, found, i)
+		}
+	}
+
+	owners := ringOwners(t, cache.ring, keys)
+	if !cache.RemoveShard("shard-b") {
+		t.Fatal("RemoveShard(shard-b) = false")
+	}
+	removed := 0
+	for _, key := range keys {
+		_, found := cache.Get(key)
+		if owners[key] == "shard-b" {
+			removed++
+			if found {
+				t.Errorf("Get(%s) found a key of the removed shard", key)
+			}
+		} else if !found {
+			t.Errorf("Get(%s) missed a key of a remaining shard", key)
+		}
+	}
+	if got := cache.Size(); got != len(keys)-removed {
+		t.Errorf("Size() = %d, want %d", got, len(keys)-removed)
+	}
+
+	if cache.AddShard("shard-a") {
+		t.Error("AddShard(shard-a) = true for an existing shard")
+	}
+	if cache.RemoveShard("shard-z") {
+		t.Error("RemoveShard(shard-z) = true for an unknown shard")
+	}
+	cache.RemoveShard("shard-c")
+	if cache.RemoveShard("shard-a") {
+		t.Error("RemoveShard removed the last shard")
+	}
+	if got := strings.Join(cache.Shards(), ","); got != "shard-a" {
+		t.Errorf("Shards() = %s, want shard-a", got)
+	}
+}
+
+func TestShardedCacheNoResurrection(t *testing.T) {
+	for _, policy := range []CachePolicy{LRU, ARC} {
+		factory := func() Cache { return NewThreadSafeCacheWithPolicy(policy, 1000) }
+		cache := NewShardedCache(factory, DefaultReplicas, "shard-a")
+		keys := ringKeys(200)
+		for i, key := range keys {
+			cache.Put(key, i)
+		}
+
+		// The keys moved to shard-b leave no copy behind in shard-a
+		cache.AddShard("shard-b")
+		for i, key := range keys {
+			cache.Put(key, i)
+		}
+		if got := cache.Size(); got != len(keys) {
+			t.Errorf("policy %d: Size() = %d after AddShard, want %d", policy, got, len(keys))
+		}
+		for _, key := range keys {
+			cache.Delete(key)
+		}
+		cache.RemoveShard("shard-b")
+		for _, key := range keys {
+			if value, found := cache.Get(key); found {
+				t.Errorf("policy %d: Get(%s) = %v after Delete", policy, key, value)
+			}
+		}
+	}
+}
+
+func TestShardedCacheNilFactory(t *testing.T) {
+	if NewShardedCache(nil, DefaultReplicas, "shard-a") != nil {
+		t.Error("NewShardedCache accepted a nil factory")
+	}
+	if NewShardedCache(func() Cache { return nil }, DefaultReplicas, "shard-a") != nil {
+		t.Error("NewShardedCache accepted a factory returning nil")
+	}
+}
+
+var ttlPolicies = map[string]CachePolicy{"LRU": LRU, "LFU": LFU, "FIFO": FIFO, "MRU": MRU, "ARC": ARC}
+
+func TestDefaultTTL(t *testing.T) {
+	for name, policy := range ttlPolicies {
+		t.Run(name, func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			cache := NewCache(policy, 10, WithDefaultTTL(time.Minute), WithClock(clock))
+			cache.Put("a", 1)
+			cache.Put("b", 2)
+
+			clock.Advance(59 * time.Second)
+			if value, found := cache.Get("a"); !found || value != 1 {
+				t.Fatalf("Get(a) = %v, %v before the TTL, want 1", value, found)
+			}
+
+			clock.Advance(time.Second)
+			if got := cache.Size(); got != 0 {
+				t.Errorf("Size() = %d once expired, want 0", got)
+			}
+			if _, found := cache.Get("a"); found {
+				t.Error("Get(a) found an expired entry")
+			}
+			// One hit and one miss
+			if got := cache.HitRate(); got != 0.5 {
+				t.Errorf("HitRate() = %v, want 0.5", got)
+			}
+			// The expired entry is gone, b is removed on its own Get
+			if cache.Delete("a") {
+				t.Error("Delete(a) found the entry removed by Get")
+			}
+			if !cache.Delete("b") {
+				t.Error("Delete(b) = false, want the expired entry still stored")
+			}
+		})
+	}
+}
+
+func TestPutWithTTL(t *testing.T) {
+	for name, policy := range ttlPolicies {
+		t.Run(name, func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			cache := NewCache(policy, 10, WithDefaultTTL(time.Minute), WithClock(clock))
+			expirer, ok := cache.(Expirer)
+			if !ok {
+				t.Fatal("cache does not implement Expirer")
+			}
+			expirer.PutWithTTL("short", 1, time.Second)
+			expirer.PutWithTTL("forever", 2, 0)
+			cache.Put("default", 3)
+
+			// Putting again renews the TTL
+			clock.Advance(50 * time.Second)
+			cache.Put("default", 4)
+
+			clock.Advance(30 * time.Second)
+			for key, want := range map[string]bool{"short": false, "forever": true, "default": true} {
+				if _, found := cache.Get(key); found != want {
+					t.Errorf("Get(%s) found = %v, want %v", key, found, want)
+				}
+			}
+			clock.Advance(time.Hour)
+			if got := present(cache, "forever", "default"); strings.Join(got, ",") != "forever,default" {
+				t.Errorf("present = %v before the Get, want the expired entry still stored", got)
+			}
+			if _, found := cache.Get("default"); found {
+				t.Error("Get(default) found an expired entry")
+			}
+			if got := present(cache, "default"); len(got) != 0 {
+				t.Errorf("present = %v, want the expired entry removed by Get", got)
+			}
+		})
+	}
+}
+
+func TestNoTTLByDefault(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := NewLRUCache(2, WithClock(clock))
+	cache.Put("a", 1)
+	clock.Advance(24 * time.Hour)
+	if _, found := cache.Get("a"); !found {
+		t.Error("Get(a) missed an entry put without TTL")
+	}
+}
+
+func TestThreadSafeCachePutWithTTL(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := NewThreadSafeCacheWithPolicy(LFU, 10, WithClock(clock)).(*ThreadSafeCache)
+	cache.PutWithTTL("a", 1, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Get("a")
+			cache.Size()
+		}()
+	}
+	wg.Wait()
+
+	clock.Advance(time.Minute)
+	if _, found := cache.Get("a"); found {
+		t.Error("Get(a) found an expired entry")
+	}
+	if got := cache.Size(); got != 0 {
+		t.Errorf("Size() = %d, want 0", got)
+	}
+}
+
+// debugKeys returns the keys of the snapshot, in order
+func debugKeys(entries []DebugEntry) string {
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestDebugStateOrder(t *testing.T) {
+	tests := []struct {
+		policy CachePolicy
+		want   string
+	}{
+		{LRU, "b,d,c,a"},
+		{MRU, "a,c,d,b"},
+		{FIFO, "a,b,c,d"},
+		{LFU, "b,d,c,a"},
+	}
+	for _, tt := range tests {
+		cache := NewCache(tt.policy, 4)
+		for i, key := range []string{"a", "b", "c", "d"} {
+			cache.Put(key, i)
+		}
+		cache.Get("a")
+		cache.Get("c")
+		cache.Get("a")
+
+		debugger := cache.(Debugger)
+		if got := debugKeys(debugger.DebugState()); got != tt.want {
+			t.Errorf("policy %d: DebugState() = %s, want %s", tt.policy, got, tt.want)
+		}
+		// The snapshot is read-only: same order, no hit counted
+		if got := debugKeys(debugger.DebugState()); got != tt.want {
+			t.Errorf("policy %d: second DebugState() = %s, want %s", tt.policy, got, tt.want)
+		}
+		if got := cache.HitRate(); got != 1 {
+			t.Errorf("policy %d: HitRate() = %v, want 1", tt.policy, got)
+		}
+
+		// The first entry of the snapshot is the next one evicted
+		victim := debugger.DebugState()[0].Key
+		cache.Put("e", 4)
+		if got := present(cache, victim); len(got) != 0 {
+			t.Errorf("policy %d: %s was not evicted", tt.policy, victim)
+		}
+	}
+}
+
+func TestDebugStateLFUFrequencies(t *testing.T) {
+	cache := NewLFUCache(3)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Put("c", 3)
+	for _, key := range []string{"a", "a", "c", "b", "a"} {
+		cache.Get(key)
+	}
+
+	// c reached frequency 2 before b, it is evicted first
+	want := []DebugEntry{{"c", 3, 2, time.Time{}}, {"b", 2, 2, time.Time{}}, {"a", 1, 4, time.Time{}}}
+	got := cache.DebugState()
+	if len(got) != len(want) {
+		t.Fatalf("DebugState() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("DebugState()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// plainCache hides the optional interfaces of the cache it wraps
+type plainCache struct {
+	Cache
+}
+
+func TestDebugStateThreadSafe(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := NewThreadSafeCacheWithPolicy(LRU, 10, WithDefaultTTL(time.Minute), WithClock(clock)).(*ThreadSafeCache)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.Put(strconv.Itoa(i*50+j%10), j)
+				cache.DebugState()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	state := cache.DebugState()
+	if len(state) != 10 {
+		t.Fatalf("DebugState() has %d entries, want 10", len(state))
+	}
+	if want := clock.Now().Add(time.Minute); !state[0].ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %v, want %v", state[0].ExpiresAt, want)
+	}
+	if got := NewThreadSafeCache(plainCache{NewLRUCache(2)}).DebugState(); got != nil {
+		t.Errorf("DebugState() = %v for a cache without Debugger, want nil", got)
+	}
+}
+
+// evictRecorder records the entries passed to an OnEvict callback
+type evictRecorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (r *evictRecorder) onEvict(key string, value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, key)
+}
+
+func (r *evictRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.keys)
+}
+
+func TestOnEvict(t *testing.T) {
+	tests := []struct {
+		policy CachePolicy
+		want   []string
+	}{
+		{LRU, []string{"a", "b"}},
+		{MRU, []string{"c", "d"}},
+		{LFU, []string{"a", "b"}},
+		{FIFO, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.policy)), func(t *testing.T) {
+			recorder := &evictRecorder{}
+			cache := NewCache(tt.policy, 3, WithOnEvict(recorder.onEvict))
+			for _, key := range []string{"a", "b", "c"} {
+				cache.Put(key, key)
+			}
+			if recorder.count() != 0 {
+				t.Fatalf("OnEvict called %d times before the cache was full", recorder.count())
+			}
+			cache.Put("c", "updated")
+			cache.Put("d", 4)
+			cache.Put("e", 5)
+
+			if !slices.Equal(recorder.keys, tt.want) {
+				t.Errorf("evicted %v, want %v", recorder.keys, tt.want)
+			}
+			cache.Delete("e")
+			cache.Clear()
+			if recorder.count() != 2 {
+				t.Errorf("OnEvict called %d times, Delete and Clear should not fire it", recorder.count())
+			}
+		})
+	}
+}
+
+func TestOnEvictResizeAndExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	recorder := &evictRecorder{}
+	cache := NewLFUCache(4, WithOnEvict(recorder.onEvict), WithClock(clock))
+	cache.PutWithTTL("short", 1, time.Second)
+	cache.Put("a", 2)
+	cache.Put("b", 3)
+
+	clock.Advance(time.Second)
+	cache.Get("short")
+	cache.Resize(1)
+
+	if want := []string{"short", "a"}; !slices.Equal(recorder.keys, want) {
+		t.Errorf("evicted %v, want %v", recorder.keys, want)
+	}
+}
+
+func TestOnEvictFireOnDelete(t *testing.T) {
+	for _, policy := range []CachePolicy{LRU, LFU, FIFO, MRU} {
+		recorder := &evictRecorder{}
+		cache := NewCache(policy, 3, WithOnEvict(recorder.onEvict), WithFireOnDelete())
+		for _, key := range []string{"a", "b", "c", "d"} {
+			cache.Put(key, key)
+		}
+		cache.Delete("d")
+		cache.Delete("missing")
+		cache.Clear()
+		cache.Clear()
+
+		if recorder.count() != 4 {
+			t.Errorf("policy %d: OnEvict called %d times, want 4", policy, recorder.count())
+		}
+	}
+}
+
+func TestOnEvictThreadSafe(t *testing.T) {
+	var cache *ThreadSafeCache
+	recorder := &evictRecorder{}
+	onEvict := func(key string, value interface{}) {
+		// Reentering the cache deadlocks unless the lock is released
+		cache.Size()
+		recorder.onEvict(key, value)
+	}
+	cache = NewThreadSafeCacheWithPolicy(LRU, 10, WithOnEvict(onEvict)).(*ThreadSafeCache)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.Put(strconv.Itoa(i*50+j), j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := recorder.count(); got != 190 {
+		t.Errorf("OnEvict called %d times, want 190", got)
+	}
+	if cache.Size() != 10 {
+		t.Errorf("Size() = %d, want 10", cache.Size())
+	}
+}
+
+func blobSize(value interface{}) int64 {
+	return int64(len(value.([]byte)))
+}
+
+func TestLRUCacheWithBytes(t *testing.T) {
+	if NewLRUCacheWithBytes(0, blobSize) != nil || NewLRUCacheWithBytes(10, nil) != nil {
+		t.Fatal("NewLRUCacheWithBytes accepted an invalid budget or size function")
+	}
+	recorder := &evictRecorder{}
+	cache := NewLRUCacheWithBytes(10, blobSize, WithOnEvict(recorder.onEvict))
+	if cache.Capacity() != 10 {
+		t.Errorf("Capacity() = %d, want the byte budget 10", cache.Capacity())
+	}
+
+	cache.Put("a", make([]byte, 4))
+	cache.Put("b", make([]byte, 4))
+	cache.Get("a")
+	if cache.Bytes() != 8 {
+		t.Errorf("Bytes() = %d, want 8", cache.Bytes())
+	}
+
+	// b is the least recently used and makes room for c
+	cache.Put("c", make([]byte, 5))
+	if want := []string{"b"}; !slices.Equal(recorder.keys, want) {
+		t.Errorf("evicted %v, want %v", recorder.keys, want)
+	}
+	if cache.Bytes() != 9 || cache.Size() != 2 {
+		t.Errorf("Bytes() = %d and Size() = %d, want 9 and 2", cache.Bytes(), cache.Size())
+	}
+
+	// Growing a value evicts the others, shrinking it frees its bytes
+	cache.Put("c", make([]byte, 8))
+	if cache.Bytes() != 8 || cache.Size() != 1 {
+		t.Errorf("Bytes() = %d and Size() = %d after growing c, want 8 and 1", cache.Bytes(), cache.Size())
+	}
+	cache.Put("c", make([]byte, 1))
+	cache.Put("d", make([]byte, 9))
+	if cache.Bytes() != 10 || cache.Size() != 2 {
+		t.Errorf("Bytes() = %d and Size() = %d after shrinking c, want 10 and 2", cache.Bytes(), cache.Size())
+	}
+
+	cache.Delete("d")
+	if cache.Bytes() != 1 {
+		t.Errorf("Bytes() = %d after Delete, want 1", cache.Bytes())
+	}
+	cache.Clear()
+	if cache.Bytes() != 0 {
+		t.Errorf("Bytes() = %d after Clear, want 0", cache.Bytes())
+	}
+}
+
+// A value larger than the whole budget is rejected: it evicts nothing else
+// and the previous value of its key is dropped
+func TestLRUCacheWithBytesOversizedValue(t *testing.T) {
+	recorder := &evictRecorder{}
+	cache := NewLRUCacheWithBytes(10, blobSize, WithOnEvict(recorder.onEvict))
+	cache.Put("a", make([]byte, 5))
+	cache.Put("b", make([]byte, 5))
+
+	cache.Put("big", make([]byte, 11))
+	if _, ok := cache.Get("big"); ok {
+		t.Error("Expected an oversized value to be rejected")
+	}
+	if cache.Size() != 2 || recorder.count() != 0 {
+		t.Errorf("Expected the cache untouched, got Size() = %d and evicted %v", cache.Size(), recorder.keys)
+	}
+
+	cache.Put("a", make([]byte, 11))
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expected the previous value of a to be dropped")
+	}
+	if cache.Bytes() != 5 || !slices.Equal(recorder.keys, []string{"a"}) {
+		t.Errorf("Bytes() = %d and evicted %v, want 5 and [a]", cache.Bytes(), recorder.keys)
+	}
+}
+
+func TestLRUCacheWithBytesResize(t *testing.T) {
+	cache := NewLRUCacheWithBytes(10, blobSize)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Put(key, make([]byte, 3))
+	}
+	cache.Resize(5)
+	if cache.Capacity() != 5 || cache.Bytes() != 3 {
+		t.Errorf("Capacity() = %d and Bytes() = %d, want 5 and 3", cache.Capacity(), cache.Bytes())
+	}
+	if _, ok := cache.Get("c"); !ok {
+		t.Error("Expected the most recently used entry to be kept")
+	}
+}
+
+func TestARCCacheBasics(t *testing.T) {
+	if NewARCCache(0) != nil || NewCache(ARC, 0) != nil {
+		t.Fatal("Expected no ARC cache without capacity")
+	}
+	cache := NewARCCache(3)
+	for i := 0; i < 10; i++ {
+		cache.Put(strconv.Itoa(i), i)
+		if cache.Size() > 3 {
+			t.Fatalf("Size() = %d, exceeds the capacity", cache.Size())
+		}
+	}
+	if value, ok := cache.Get("9"); !ok || value != 9 {
+		t.Errorf("Get(9) = %v, %v, want the latest entry", value, ok)
+	}
+	if _, ok := cache.Get("0"); ok {
+		t.Error("Expected the first entry to be evicted")
+	}
+	if !cache.Delete("9") || cache.Delete("9") || cache.Size() != 2 {
+		t.Errorf("Delete failed, Size() = %d", cache.Size())
+	}
+	cache.Clear()
+	if cache.Size() != 0 || cache.HitRate() != 0 {
+		t.Errorf("Clear left Size() = %d and HitRate() = %v", cache.Size(), cache.HitRate())
+	}
+}
+
+// A Put of a key evicted from t1 grows the target size of t1, and the key
+// comes back as a frequent entry
+func TestARCCacheGhostHitAdapts(t *testing.T) {
+	recorder := &evictRecorder{}
+	cache := NewARCCache(2, WithOnEvict(recorder.onEvict))
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Get("a")
+	cache.Put("c", 3)
+	if !slices.Equal(recorder.keys, []string{"b"}) || cache.b1.Len() != 1 {
+		t.Fatalf("Expected b to be evicted from t1 as a ghost, got %v and %d ghosts", recorder.keys, cache.b1.Len())
+	}
+
+	// t1 now reaches its target size, the frequent a makes room for b
+	cache.Put("b", 2)
+	if cache.p != 1 {
+		t.Errorf("p = %d after a ghost hit in b1, want 1", cache.p)
+	}
+	if elem, ok := cache.items["b"]; !ok || !elem.Value.(*arcItem).frequent {
+		t.Error("Expected b to come back in t2")
+	}
+	if !slices.Equal(recorder.keys, []string{"b", "a"}) || cache.b2.Len() != 1 {
+		t.Errorf("Expected a to be evicted from t2 as a ghost, got %v and %d ghosts", recorder.keys, cache.b2.Len())
+	}
+
+	// A ghost hit in b2 shrinks t1 back
+	cache.Put("a", 1)
+	if cache.p != 0 {
+		t.Errorf("p = %d after a ghost hit in b2, want 0", cache.p)
+	}
+}
+
+func TestARCCacheDropsOnceSeenEntriesWithoutGhost(t *testing.T) {
+	recorder := &evictRecorder{}
+	cache := NewARCCache(2, WithOnEvict(recorder.onEvict))
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Put(key, key)
+	}
+	if !slices.Equal(recorder.keys, []string{"a"}) || len(cache.ghosts) != 0 {
+		t.Errorf("Expected a dropped without a ghost, got %v and %d ghosts", recorder.keys, len(cache.ghosts))
+	}
+}
+
+// access reads a key, storing it on a miss
+func access(cache Cache, key string) {
+	if _, ok := cache.Get(key); !ok {
+		cache.Put(key, key)
+	}
+}
+
+// A small hot set is read between scans of keys never seen again: the
+// scans flush LRU but only churn the recency list of ARC
+func TestARCCacheBeatsLRUOnScans(t *testing.T) {
+	arc, lru := NewARCCache(4), NewLRUCache(4)
+	var trace []string
+	for _, key := range []string{"a", "b", "c", "a", "b", "c"} {
+		trace = append(trace, key)
+	}
+	for round := 0; round < 20; round++ {
+		trace = append(trace, "a", "b", "c")
+		for i := 0; i < 4; i++ {
+			trace = append(trace, "scan-"+strconv.Itoa(round)+"-"+strconv.Itoa(i))
+		}
+	}
+	for _, key := range trace {
+		access(arc, key)
+		access(lru, key)
+	}
+
+	if arc.HitRate() <= lru.HitRate() {
+		t.Errorf("ARC hit rate %.2f, want more than the LRU one %.2f", arc.HitRate(), lru.HitRate())
+	}
+	if arc.HitRate() < 0.4 {
+		t.Errorf("ARC hit rate %.2f, want the hot set served from the cache", arc.HitRate())
+	}
+}
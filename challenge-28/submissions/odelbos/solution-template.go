@@ -2,8 +2,12 @@ package cache
 
 import (
 	"sync"
+	"sync/atomic"
 	"container/list"
+	"hash/fnv"
 	"slices"
+	"strconv"
+	"time"
 )
 
 // Cache interface defines the contract for all cache implementations
@@ -17,6 +21,32 @@ type Cache interface {
 	HitRate() float64
 }
 
+// Resizer is implemented by the caches whose capacity can change at runtime
+type Resizer interface {
+	Resize(newCapacity int)
+}
+
+// Expirer is implemented by the caches whose entries can expire
+type Expirer interface {
+	PutWithTTL(key string, value interface{}, ttl time.Duration)
+}
+
+// DebugEntry describes a cached entry for debugging, see Debugger
+type DebugEntry struct {
+	Key       string
+	Value     any
+	Frequency int       // LFU access count, 0 for the other policies
+	ExpiresAt time.Time // zero if the entry never expires
+}
+
+// Debugger is implemented by the caches that can explain their evictions.
+// DebugState returns a snapshot of the entries ordered from the next one to
+// be evicted to the last one, without counting hits or misses nor changing
+// the order.
+type Debugger interface {
+	DebugState() []DebugEntry
+}
+
 // CachePolicy represents the eviction policy type
 type CachePolicy int
 
@@ -24,44 +54,337 @@ const (
 	LRU CachePolicy = iota
 	LFU
 	FIFO
+	MRU
+	ARC
 )
 
+//
+// Entry Expiration
+//
+
+// Clock abstracts time so that the entry TTLs can be tested deterministically
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a one-shot timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it returns false if it already fired
+	Stop() bool
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a Clock moved forward by hand, an entry expires as soon as
+// Advance crosses its TTL
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	at     time.Time
+	c      chan time.Time
+	active bool
+}
+
+// NewFakeClock returns a FakeClock reading start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1), active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock and fires the timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range(c.timers) {
+		if ! t.active {
+			continue
+		}
+		if c.now.Before(t.at) {
+			pending = append(pending, t)
+			continue
+		}
+		t.active = false
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+// CacheOption configures a cache built by one of the constructors
+type CacheOption func(*cacheOptions)
+
+type cacheOptions struct {
+	ttl  expiration
+	hook *evictHook
+}
+
+func newCacheOptions(opts []CacheOption) cacheOptions {
+	o := cacheOptions{ttl: expiration{clock: realClock{}}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithClock makes the cache read the time from clock instead of the time
+// package
+func WithClock(clock Clock) CacheOption {
+	return func(o *cacheOptions) { o.ttl.clock = clock }
+}
+
+// WithDefaultTTL makes Put expire its entries after ttl, zero or less
+// keeping them until evicted
+func WithDefaultTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) { o.ttl.defaultTTL = ttl }
+}
+
+// expiration holds the TTL settings of a cache. Expired entries are removed
+// lazily by Get, until then they still take a slot.
+type expiration struct {
+	defaultTTL time.Duration
+	clock      Clock
+}
+
+// deadline returns when an entry put now with ttl expires, the zero time if
+// it never does
+func (e expiration) deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return e.clock.Now().Add(ttl)
+}
+
+// expired reports whether the deadline has passed
+func (e expiration) expired(deadline time.Time) bool {
+	return ! deadline.IsZero() && ! e.clock.Now().Before(deadline)
+}
 
 //
-// LRU Cache Implementation
+// Eviction Callback
 //
 
-type lruItem struct {
+// WithOnEvict calls onEvict once for every entry the cache drops on its own,
+// to make room, on Resize or once expired
+func WithOnEvict(onEvict func(key string, value interface{})) CacheOption {
+	return func(o *cacheOptions) {
+		if o.hook == nil {
+			o.hook = &evictHook{}
+		}
+		o.hook.onEvict = onEvict
+	}
+}
+
+// WithFireOnDelete also calls the OnEvict callback for the entries removed
+// by Delete and Clear
+func WithFireOnDelete() CacheOption {
+	return func(o *cacheOptions) {
+		if o.hook == nil {
+			o.hook = &evictHook{}
+		}
+		o.hook.fireOnDelete = true
+	}
+}
+
+// evictHook calls the OnEvict callback of a cache, a nil hook does nothing.
+// A deferred hook queues the calls until take, so that ThreadSafeCache runs
+// them once its lock is released.
+type evictHook struct {
+	onEvict      func(key string, value interface{})
+	fireOnDelete bool
+	deferred     bool
+	pending      []evictedEntry
+}
+
+type evictedEntry struct {
 	key   string
 	value any
 }
 
+// evicted reports an entry dropped by the cache
+func (h *evictHook) evicted(key string, value any) {
+	if h == nil || h.onEvict == nil {
+		return
+	}
+	if h.deferred {
+		h.pending = append(h.pending, evictedEntry{key, value})
+		return
+	}
+	h.onEvict(key, value)
+}
+
+// deletes reports whether the entries removed by Delete and Clear are
+// reported
+func (h *evictHook) deletes() bool {
+	return h != nil && h.onEvict != nil && h.fireOnDelete
+}
+
+// deleted reports an entry removed by Delete or Clear
+func (h *evictHook) deleted(key string, value any) {
+	if h.deletes() {
+		h.evicted(key, value)
+	}
+}
+
+// take returns the queued calls and empties the queue
+func (h *evictHook) take() []evictedEntry {
+	if h == nil {
+		return nil
+	}
+	pending := h.pending
+	h.pending = nil
+	return pending
+}
+
+// evictNotifier is implemented by the caches built with an OnEvict callback
+type evictNotifier interface {
+	evictionHook() *evictHook
+}
+
+
+//
+// LRU Cache Implementation
+//
+
+type lruItem struct {
+	key       string
+	value     any
+	expiresAt time.Time
+	size      int64
+}
+
+// LRUCache is bounded by its number of entries, or by the total size of
+// its values when built by NewLRUCacheWithBytes
 type LRUCache struct {
 	capacity int
 	cache    map[string]*list.Element
 	list     *list.List
 	hits     int
 	misses   int
+	ttl      expiration
+	hook     *evictHook
 	mu       sync.RWMutex
+
+	sizeOf   func(value interface{}) int64 // nil when bounded by entries
+	maxBytes int64
+	bytes    int64
 }
 
 // NewLRUCache creates a new LRU cache with the specified capacity
-func NewLRUCache(capacity int) *LRUCache {
+func NewLRUCache(capacity int, opts ...CacheOption) *LRUCache {
 	if capacity < 1 {
 		return nil
 	}
+	o := newCacheOptions(opts)
 	return &LRUCache{
 		capacity: capacity,
 		cache:    make(map[string]*list.Element),
-		list:  list.New(),
+		list:     list.New(),
+		ttl:      o.ttl,
+		hook:     o.hook,
 	}
 }
 
+// NewLRUCacheWithBytes creates an LRU cache holding values whose sizes, as
+// returned by sizeOf, add up to at most maxBytes. A value larger than
+// maxBytes is rejected, dropping the previous value of its key.
+func NewLRUCacheWithBytes(maxBytes int64, sizeOf func(value interface{}) int64, opts ...CacheOption) *LRUCache {
+	if maxBytes < 1 || sizeOf == nil {
+		return nil
+	}
+	o := newCacheOptions(opts)
+	return &LRUCache{
+		cache:    make(map[string]*list.Element),
+		list:     list.New(),
+		ttl:      o.ttl,
+		hook:     o.hook,
+		sizeOf:   sizeOf,
+		maxBytes: maxBytes,
+	}
+}
+
+// weighed reports whether the cache is bounded by the size of its values
+func (c *LRUCache) weighed() bool {
+	return c.sizeOf != nil
+}
+
+// full reports whether entries must be evicted to fit the capacity
+func (c *LRUCache) full() bool {
+	if c.weighed() {
+		return c.bytes > c.maxBytes
+	}
+	return len(c.cache) > c.capacity
+}
+
 func (c *LRUCache) Get(key string) (interface{}, bool) {
 	c.mu.Lock()                     // Fix DATA RACE error within the tests
 	defer c.mu.Unlock()
 
 	if item, ok := c.cache[key]; ok {
+		if entry := item.Value.(*lruItem); c.ttl.expired(entry.expiresAt) {
+			c.remove(item)
+			c.hook.evicted(entry.key, entry.value)
+			c.misses++
+			return nil, false
+		}
 		c.list.MoveToFront(item)
 		c.hits++
 		return item.Value.(*lruItem).value, true
@@ -71,49 +394,154 @@ func (c *LRUCache) Get(key string) (interface{}, bool) {
 }
 
 func (c *LRUCache) Put(key string, value interface{}) {
+	c.PutWithTTL(key, value, c.ttl.defaultTTL)
+}
+
+// PutWithTTL stores the value until ttl elapses, zero or less for no expiry
+func (c *LRUCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.put(key, value, c.ttl.deadline(ttl), c.list.Back)
+}
+
+// put stores the value as the most recently used, evicting the element
+// returned by victim on overflow
+func (c *LRUCache) put(key string, value any, expiresAt time.Time, victim func() *list.Element) {
+	var size int64
+	if c.weighed() {
+		size = max(c.sizeOf(value), 0)
+		if size > c.maxBytes {
+			if item, ok := c.cache[key]; ok {
+				c.remove(item)
+				c.hook.evicted(key, item.Value.(*lruItem).value)
+			}
+			return
+		}
+	}
+
 	if item, ok := c.cache[key]; ok {
 		c.list.MoveToFront(item)
-		item.Value.(*lruItem).value = value
-		return
+		entry := item.Value.(*lruItem)
+		c.bytes += size - entry.size
+		entry.value, entry.expiresAt, entry.size = value, expiresAt, size
+	} else {
+		if ! c.weighed() && c.capacity == 0 {
+			return
+		}
+		if ! c.weighed() && len(c.cache) >= c.capacity {
+			c.evict(victim)
+		}
+		c.cache[key] = c.list.PushFront(&lruItem{key, value, expiresAt, size})
+		c.bytes += size
 	}
 
-	if len(c.cache) >= c.capacity {
-		back := c.list.Back()
-		if back != nil {
-			backItem := back.Value.(*lruItem)
-			delete(c.cache, backItem.key)
-			c.list.Remove(back)
-		}
+	for c.full() {
+		c.evict(victim)
 	}
+}
 
-	item := c.list.PushFront(&lruItem{key, value})
-	c.cache[key] = item
+// evict removes the element returned by victim, if any
+func (c *LRUCache) evict(victim func() *list.Element) {
+	evicted := victim()
+	if evicted != nil {
+		c.remove(evicted)
+		entry := evicted.Value.(*lruItem)
+		c.hook.evicted(entry.key, entry.value)
+	}
+}
+
+func (c *LRUCache) remove(item *list.Element) {
+	delete(c.cache, item.Value.(*lruItem).key)
+	c.bytes -= item.Value.(*lruItem).size
+	c.list.Remove(item)
+}
+
+// Resize changes the capacity, in bytes for a cache built by
+// NewLRUCacheWithBytes, evicting the least recently used entries until the
+// cache fits. A negative capacity is treated as zero.
+func (c *LRUCache) Resize(newCapacity int) {
+	c.resize(newCapacity, c.list.Back)
+}
+
+func (c *LRUCache) resize(newCapacity int, victim func() *list.Element) {
+	if c.weighed() {
+		c.maxBytes = int64(max(newCapacity, 0))
+	} else {
+		c.capacity = max(newCapacity, 0)
+	}
+	for c.full() {
+		c.evict(victim)
+	}
 }
 
 func (c *LRUCache) Delete(key string) bool {
 	if item, ok := c.cache[key]; ok {
-		c.list.Remove(item)
-		delete(c.cache, key)
+		c.remove(item)
+		c.hook.deleted(key, item.Value.(*lruItem).value)
 		return true
 	}
 	return false
 }
 
 func (c *LRUCache) Clear() {
+	if c.hook.deletes() {
+		for e := c.list.Back(); e != nil; e = e.Prev() {
+			c.hook.deleted(e.Value.(*lruItem).key, e.Value.(*lruItem).value)
+		}
+	}
 	c.cache = make(map[string]*list.Element)
 	c.list.Init()
+	c.bytes = 0
 	c.hits = 0
 	c.misses = 0
 }
 
+// Size returns the number of entries not expired yet
 func (c *LRUCache) Size() int {
-	return len(c.cache)
+	size := 0
+	for _, item := range c.cache {
+		if ! c.ttl.expired(item.Value.(*lruItem).expiresAt) {
+			size++
+		}
+	}
+	return size
+}
+
+// DebugState lists the entries from the least to the most recently used
+func (c *LRUCache) DebugState() []DebugEntry {
+	return c.debugState(c.list.Back, (*list.Element).Prev)
 }
 
+// debugState walks the recency list from first using next
+func (c *LRUCache) debugState(first func() *list.Element, next func(*list.Element) *list.Element) []DebugEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]DebugEntry, 0, c.list.Len())
+	for e := first(); e != nil; e = next(e) {
+		item := e.Value.(*lruItem)
+		entries = append(entries, DebugEntry{Key: item.key, Value: item.value, ExpiresAt: item.expiresAt})
+	}
+	return entries
+}
+
+func (c *LRUCache) evictionHook() *evictHook {
+	return c.hook
+}
+
+// Capacity returns the maximum number of entries, or the byte budget for a
+// cache built by NewLRUCacheWithBytes
 func (c *LRUCache) Capacity() int {
+	if c.weighed() {
+		return int(c.maxBytes)
+	}
 	return c.capacity
 }
 
+// Bytes returns the total size of the values held, always zero unless the
+// cache was built by NewLRUCacheWithBytes. Expired entries count until
+// removed.
+func (c *LRUCache) Bytes() int64 {
+	return c.bytes
+}
+
 func (c *LRUCache) HitRate() float64 {
 	total := c.hits + c.misses
 	if total == 0 {
@@ -122,15 +550,62 @@ func (c *LRUCache) HitRate() float64 {
 	return float64(c.hits) / float64(total)
 }
 
+//
+// MRU Cache Implementation
+//
+
+// MRUCache evicts the most recently used entry, it keeps the older entries
+// of scanning workloads where an LRU cache would thrash. It shares the LRU
+// recency list, only the eviction end differs.
+type MRUCache struct {
+	LRUCache
+}
+
+// NewMRUCache creates a new MRU cache with the specified capacity
+func NewMRUCache(capacity int, opts ...CacheOption) *MRUCache {
+	if capacity < 1 {
+		return nil
+	}
+	o := newCacheOptions(opts)
+	return &MRUCache{LRUCache{
+		capacity: capacity,
+		cache:    make(map[string]*list.Element),
+		list:     list.New(),
+		ttl:      o.ttl,
+		hook:     o.hook,
+	}}
+}
+
+func (c *MRUCache) Put(key string, value interface{}) {
+	c.PutWithTTL(key, value, c.ttl.defaultTTL)
+}
+
+// PutWithTTL stores the value until ttl elapses, zero or less for no expiry
+func (c *MRUCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.put(key, value, c.ttl.deadline(ttl), c.list.Front)
+}
+
+// DebugState lists the entries from the most to the least recently used
+func (c *MRUCache) DebugState() []DebugEntry {
+	return c.debugState(c.list.Front, (*list.Element).Next)
+}
+
+// Resize changes the capacity, evicting the most recently used entries
+// until the cache fits. A negative capacity is treated as zero.
+func (c *MRUCache) Resize(newCapacity int) {
+	c.resize(newCapacity, c.list.Front)
+}
+
 //
 // LFU Cache Implementation
 //
 
 type lfuItem struct {
-	key      string
-	value    any
-	freq     int
-	node     *list.Element
+	key       string
+	value     any
+	freq      int
+	node      *list.Element
+	expiresAt time.Time
 }
 
 type LFUCache struct {
@@ -140,19 +615,30 @@ type LFUCache struct {
 	minFreq  int
 	hits     int
 	misses   int
+	ttl      expiration
+	hook     *evictHook
 }
 
 // NewLFUCache creates a new LFU cache with the specified capacity
-func NewLFUCache(capacity int) *LFUCache {
+func NewLFUCache(capacity int, opts ...CacheOption) *LFUCache {
+	o := newCacheOptions(opts)
 	return &LFUCache{
 		capacity: capacity,
 		cache:    make(map[string]*lfuItem),
 		freqs:    make(map[int]*list.List),
+		ttl:      o.ttl,
+		hook:     o.hook,
 	}
 }
 
 func (c *LFUCache) Get(key string) (interface{}, bool) {
 	if item, ok := c.cache[key]; ok {
+		if c.ttl.expired(item.expiresAt) {
+			c.remove(item)
+			c.hook.evicted(item.key, item.value)
+			c.misses++
+			return nil, false
+		}
 		c.hits++
 		c.increment(item)
 		return item.value, true
@@ -162,12 +648,18 @@ func (c *LFUCache) Get(key string) (interface{}, bool) {
 }
 
 func (c *LFUCache) Put(key string, value interface{}) {
+	c.PutWithTTL(key, value, c.ttl.defaultTTL)
+}
+
+// PutWithTTL stores the value until ttl elapses, zero or less for no expiry
+func (c *LFUCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
 	if c.capacity == 0 {
 		return
 	}
 
+	expiresAt := c.ttl.deadline(ttl)
 	if item, ok := c.cache[key]; ok {
-		item.value = value
+		item.value, item.expiresAt = value, expiresAt
 		c.increment(item)
 		return
 	}
@@ -176,7 +668,7 @@ func (c *LFUCache) Put(key string, value interface{}) {
 		c.evict()
 	}
 
-	item := &lfuItem{key: key, value: value, freq:  1}
+	item := &lfuItem{key: key, value: value, freq: 1, expiresAt: expiresAt}
 	if c.freqs[1] == nil {
 		c.freqs[1] = list.New()
 	}
@@ -185,16 +677,32 @@ func (c *LFUCache) Put(key string, value interface{}) {
 	c.minFreq = 1
 }
 
+// Resize changes the capacity, evicting the least frequently used entries,
+// oldest first among equal frequencies, until the cache fits. A negative
+// capacity is treated as zero.
+func (c *LFUCache) Resize(newCapacity int) {
+	c.capacity = max(newCapacity, 0)
+	for len(c.cache) > c.capacity {
+		c.evict()
+	}
+}
+
 func (c *LFUCache) Delete(key string) bool {
 	item, ok := c.cache[key]
 	if !ok {
 		return false
 	}
 	c.remove(item)
+	c.hook.deleted(key, item.value)
 	return true
 }
 
 func (c *LFUCache) Clear() {
+	if c.hook.deletes() {
+		for _, entry := range c.DebugState() {
+			c.hook.deleted(entry.Key, entry.Value)
+		}
+	}
 	c.cache = make(map[string]*lfuItem)
 	c.freqs = make(map[int]*list.List)
 	c.minFreq = 0
@@ -202,8 +710,38 @@ func (c *LFUCache) Clear() {
 	c.misses = 0
 }
 
+// Size returns the number of entries not expired yet
 func (c *LFUCache) Size() int {
-	return len(c.cache)
+	size := 0
+	for _, item := range c.cache {
+		if ! c.ttl.expired(item.expiresAt) {
+			size++
+		}
+	}
+	return size
+}
+
+// DebugState lists the entries by increasing frequency, the oldest first
+// among equal frequencies
+func (c *LFUCache) DebugState() []DebugEntry {
+	freqs := make([]int, 0, len(c.freqs))
+	for freq := range c.freqs {
+		freqs = append(freqs, freq)
+	}
+	slices.Sort(freqs)
+
+	entries := make([]DebugEntry, 0, len(c.cache))
+	for _, freq := range freqs {
+		for e := c.freqs[freq].Front(); e != nil; e = e.Next() {
+			item := e.Value.(*lfuItem)
+			entries = append(entries, DebugEntry{item.key, item.value, item.freq, item.expiresAt})
+		}
+	}
+	return entries
+}
+
+func (c *LFUCache) evictionHook() *evictHook {
+	return c.hook
 }
 
 func (c *LFUCache) Capacity() int {
@@ -246,6 +784,7 @@ func (c *LFUCache) evict() {
 	}
 	item := front.Value.(*lfuItem)
 	c.remove(item)
+	c.hook.evicted(item.key, item.value)
 }
 
 func (c *LFUCache) remove(entry *lfuItem) {
@@ -254,66 +793,203 @@ func (c *LFUCache) remove(entry *lfuItem) {
 	if c.freqs[freq].Len() == 0 {
 		delete(c.freqs, freq)
 		if c.minFreq == freq {
-			c.minFreq++
+			c.minFreq = c.lowestFreq()
 		}
 	}
 	delete(c.cache, entry.key)
 }
 
+// lowestFreq returns the smallest frequency in use, the next one is not
+// necessarily minFreq + 1 once an entry is removed
+func (c *LFUCache) lowestFreq() int {
+	lowest := 0
+	for freq := range c.freqs {
+		if lowest == 0 || freq < lowest {
+			lowest = freq
+		}
+	}
+	return lowest
+}
+
+//
+// LFU with Aging Cache Implementation
+//
+
+// LFUWithAging is an LFU cache whose frequencies decay: every interval
+// accesses (Get or Put), each frequency is multiplied by factor. Entries that
+// were hot long ago lose their lead and can be evicted, instead of polluting
+// the cache forever.
+type LFUWithAging struct {
+	LFUCache
+	interval int
+	factor   float64
+	ops      int
+}
+
+// NewLFUWithAging creates an aging LFU cache, it returns nil unless interval
+// is at least 1 and factor is in (0, 1)
+func NewLFUWithAging(capacity, interval int, factor float64, opts ...CacheOption) *LFUWithAging {
+	if interval < 1 || factor <= 0 || factor >= 1 {
+		return nil
+	}
+	return &LFUWithAging{
+		LFUCache: *NewLFUCache(capacity, opts...),
+		interval: interval,
+		factor:   factor,
+	}
+}
+
+func (c *LFUWithAging) Get(key string) (interface{}, bool) {
+	c.tick()
+	return c.LFUCache.Get(key)
+}
+
+func (c *LFUWithAging) Put(key string, value interface{}) {
+	c.tick()
+	c.LFUCache.Put(key, value)
+}
+
+func (c *LFUWithAging) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.tick()
+	c.LFUCache.PutWithTTL(key, value, ttl)
+}
+
+func (c *LFUWithAging) Clear() {
+	c.LFUCache.Clear()
+	c.ops = 0
+}
+
+// tick counts an access and ages the frequencies every interval accesses
+func (c *LFUWithAging) tick() {
+	c.ops++
+	if c.ops%c.interval == 0 {
+		c.age()
+	}
+}
+
+// age scales every frequency by factor, keeping at least 1. The buckets are
+// rebuilt from the lowest frequency up, so entries merged into the same
+// bucket keep their eviction order.
+func (c *LFUWithAging) age() {
+	old := make([]int, 0, len(c.freqs))
+	for freq := range c.freqs {
+		old = append(old, freq)
+	}
+	slices.Sort(old)
+
+	freqs := make(map[int]*list.List)
+	for _, freq := range old {
+		for e := c.freqs[freq].Front(); e != nil; e = e.Next() {
+			item := e.Value.(*lfuItem)
+			item.freq = max(1, int(float64(freq)*c.factor))
+			if freqs[item.freq] == nil {
+				freqs[item.freq] = list.New()
+			}
+			item.node = freqs[item.freq].PushBack(item)
+		}
+	}
+	c.freqs = freqs
+	c.minFreq = c.lowestFreq()
+}
+
 //
 // FIFO Cache Implementation
 //
 
 type fifoItem struct {
-    key   string
-    value any
+    key       string
+    value     any
+    expiresAt time.Time
 }
 
 type FIFOCache struct {
     capacity int
     queue    []fifoItem
-    items    map[string]any
+    items    map[string]fifoItem
     hits     int
     misses   int
+    ttl      expiration
+    hook     *evictHook
 }
 
 // NewFIFOCache creates a new FIFO cache with the specified capacity
-func NewFIFOCache(capacity int) *FIFOCache {
+func NewFIFOCache(capacity int, opts ...CacheOption) *FIFOCache {
+    o := newCacheOptions(opts)
     return &FIFOCache{
         capacity: capacity,
         queue:    make([]fifoItem, 0, capacity),
-        items:    make(map[string]any),
+        items:    make(map[string]fifoItem),
+        ttl:      o.ttl,
+        hook:     o.hook,
     }
 }
 
 func (c *FIFOCache) Get(key string) (interface{}, bool) {
-    val, ok := c.items[key]
+    item, ok := c.items[key]
+    if ok && c.ttl.expired(item.expiresAt) {
+        c.remove(key)
+        c.hook.evicted(key, item.value)
+        ok = false
+    }
     if ok {
         c.hits++
-        return val, true
+        return item.value, true
     }
     c.misses++
     return nil, false
 }
 
 func (c *FIFOCache) Put(key string, value interface{}) {
+    c.PutWithTTL(key, value, c.ttl.defaultTTL)
+}
+
+// PutWithTTL stores the value until ttl elapses, zero or less for no expiry.
+// Replacing a value keeps the key at its place in the queue.
+func (c *FIFOCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+    item := fifoItem{key, value, c.ttl.deadline(ttl)}
     if _, ok := c.items[key]; ok {
-        c.items[key] = value
+        c.items[key] = item
+        return
+    }
+    if c.capacity == 0 {
         return
     }
     if len(c.queue) >= c.capacity {
-        old := c.queue[0]
-        c.queue = c.queue[1:]
-        delete(c.items, old.key)
+        c.evict()
+    }
+    c.queue = append(c.queue, item)
+    c.items[key] = item
+}
+
+// evict removes the oldest entry
+func (c *FIFOCache) evict() {
+    old := c.queue[0]
+    c.queue = c.queue[1:]
+    item := c.items[old.key]
+    delete(c.items, old.key)
+    c.hook.evicted(item.key, item.value)
+}
+
+// Resize changes the capacity, evicting the oldest entries until the cache
+// fits. A negative capacity is treated as zero.
+func (c *FIFOCache) Resize(newCapacity int) {
+    c.capacity = max(newCapacity, 0)
+    for len(c.queue) > c.capacity {
+        c.evict()
     }
-    c.queue = append(c.queue, fifoItem{key, value})
-    c.items[key] = value
 }
 
 func (c *FIFOCache) Delete(key string) bool {
-    if _, ok := c.items[key]; ! ok {
+    item, ok := c.items[key]
+    if ! ok {
         return false
     }
+    c.remove(key)
+    c.hook.deleted(key, item.value)
+    return true
+}
+
+func (c *FIFOCache) remove(key string) {
     delete(c.items, key)
     for i, item := range c.queue {
         if item.key == key {
@@ -321,18 +997,43 @@ func (c *FIFOCache) Delete(key string) bool {
             break
         }
     }
-    return true
 }
 
 func (c *FIFOCache) Clear() {
+    if c.hook.deletes() {
+        for _, queued := range c.queue {
+            c.hook.deleted(queued.key, c.items[queued.key].value)
+        }
+    }
     c.queue = make([]fifoItem, 0, c.capacity)
-    c.items = make(map[string]any)
+    c.items = make(map[string]fifoItem)
     c.hits = 0
     c.misses = 0
 }
 
+// Size returns the number of entries not expired yet
 func (c *FIFOCache) Size() int {
-	return len(c.items)
+	size := 0
+	for _, item := range c.items {
+		if ! c.ttl.expired(item.expiresAt) {
+			size++
+		}
+	}
+	return size
+}
+
+// DebugState lists the entries in insertion order, the oldest first
+func (c *FIFOCache) DebugState() []DebugEntry {
+	entries := make([]DebugEntry, 0, len(c.queue))
+	for _, queued := range c.queue {
+		item := c.items[queued.key]
+		entries = append(entries, DebugEntry{Key: item.key, Value: item.value, ExpiresAt: item.expiresAt})
+	}
+	return entries
+}
+
+func (c *FIFOCache) evictionHook() *evictHook {
+	return c.hook
 }
 
 func (c *FIFOCache) Capacity() int {
@@ -347,44 +1048,300 @@ func (c *FIFOCache) HitRate() float64 {
     return float64(c.hits) / float64(total)
 }
 
+//
+// ARC Cache Implementation
+//
+
+// ARCCache is an Adaptive Replacement Cache. Entries seen once live in t1
+// and entries seen again in t2, both kept in LRU order. The ghost lists b1
+// and b2 remember the keys recently evicted from each, a Put of a ghost key
+// moves the target size p of t1 towards the list that would have kept it.
+// A scan only churns t1 while the frequently used entries stay in t2.
+type ARCCache struct {
+	capacity int
+	p        int        // target size of t1
+	t1, t2   *list.List // *arcItem, most recent at the front
+	b1, b2   *list.List // *arcGhost, most recent at the front
+	items    map[string]*list.Element
+	ghosts   map[string]*list.Element
+	hits     int
+	misses   int
+	ttl      expiration
+	hook     *evictHook
+}
+
+type arcItem struct {
+	key       string
+	value     any
+	expiresAt time.Time
+	frequent  bool // in t2
+}
+
+type arcGhost struct {
+	key      string
+	frequent bool // in b2
+}
+
+// NewARCCache creates a new ARC cache with the specified capacity
+func NewARCCache(capacity int, opts ...CacheOption) *ARCCache {
+	if capacity < 1 {
+		return nil
+	}
+	o := newCacheOptions(opts)
+	return &ARCCache{
+		capacity: capacity,
+		t1:       list.New(),
+		t2:       list.New(),
+		b1:       list.New(),
+		b2:       list.New(),
+		items:    make(map[string]*list.Element),
+		ghosts:   make(map[string]*list.Element),
+		ttl:      o.ttl,
+		hook:     o.hook,
+	}
+}
+
+func (c *ARCCache) Get(key string) (interface{}, bool) {
+	elem, ok := c.items[key]
+	if ! ok {
+		c.misses++
+		return nil, false
+	}
+	item := elem.Value.(*arcItem)
+	if c.ttl.expired(item.expiresAt) {
+		c.remove(elem)
+		c.hook.evicted(item.key, item.value)
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.promote(elem)
+	return item.value, true
+}
+
+func (c *ARCCache) Put(key string, value interface{}) {
+	c.PutWithTTL(key, value, c.ttl.defaultTTL)
+}
+
+// PutWithTTL stores the value until ttl elapses, zero or less for no expiry
+func (c *ARCCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	expiresAt := c.ttl.deadline(ttl)
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*arcItem)
+		item.value, item.expiresAt = value, expiresAt
+		c.promote(elem)
+		return
+	}
+
+	item := &arcItem{key: key, value: value, expiresAt: expiresAt}
+	if elem, ok := c.ghosts[key]; ok {
+		// The key was evicted too early, grow the list that held it
+		ghost := elem.Value.(*arcGhost)
+		if ghost.frequent {
+			c.p = max(c.p - max(c.b1.Len() / c.b2.Len(), 1), 0)
+		} else {
+			c.p = min(c.p + max(c.b2.Len() / c.b1.Len(), 1), c.capacity)
+		}
+		c.forget(elem)
+		c.makeRoom(ghost.frequent)
+		item.frequent = true
+		c.items[key] = c.t2.PushFront(item)
+		return
+	}
+
+	if c.t1.Len() + c.b1.Len() >= c.capacity {
+		if c.b1.Len() > 0 {
+			c.forget(c.b1.Back())
+			c.makeRoom(false)
+		} else {
+			// t1 fills the cache, its oldest entry leaves no ghost
+			oldest := c.t1.Back()
+			c.remove(oldest)
+			c.hook.evicted(oldest.Value.(*arcItem).key, oldest.Value.(*arcItem).value)
+		}
+	} else if total := c.t1.Len() + c.t2.Len() + c.b1.Len() + c.b2.Len(); total >= c.capacity {
+		if total >= 2 * c.capacity {
+			c.forget(c.b2.Back())
+		}
+		c.makeRoom(false)
+	}
+	c.items[key] = c.t1.PushFront(item)
+}
+
+// promote moves an entry to the front of t2
+func (c *ARCCache) promote(elem *list.Element) {
+	item := elem.Value.(*arcItem)
+	if item.frequent {
+		c.t2.MoveToFront(elem)
+		return
+	}
+	c.t1.Remove(elem)
+	item.frequent = true
+	c.items[item.key] = c.t2.PushFront(item)
+}
+
+// makeRoom evicts an entry if the cache is full, from t1 while it exceeds
+// its target size and from t2 otherwise. The evicted key becomes a ghost.
+func (c *ARCCache) makeRoom(ghostWasFrequent bool) {
+	if c.t1.Len() + c.t2.Len() < c.capacity {
+		return
+	}
+	var victim *list.Element
+	if c.t1.Len() > 0 && (c.t1.Len() > c.p || (ghostWasFrequent && c.t1.Len() == c.p) || c.t2.Len() == 0) {
+		victim = c.t1.Back()
+	} else {
+		victim = c.t2.Back()
+	}
+	item := victim.Value.(*arcItem)
+	c.remove(victim)
+	ghosts := c.b1
+	if item.frequent {
+		ghosts = c.b2
+	}
+	c.ghosts[item.key] = ghosts.PushFront(&arcGhost{item.key, item.frequent})
+	c.hook.evicted(item.key, item.value)
+}
+
+// remove takes an entry out of t1 or t2
+func (c *ARCCache) remove(elem *list.Element) {
+	item := elem.Value.(*arcItem)
+	if item.frequent {
+		c.t2.Remove(elem)
+	} else {
+		c.t1.Remove(elem)
+	}
+	delete(c.items, item.key)
+}
+
+// forget takes a key out of b1 or b2
+func (c *ARCCache) forget(elem *list.Element) {
+	ghost := elem.Value.(*arcGhost)
+	if ghost.frequent {
+		c.b2.Remove(elem)
+	} else {
+		c.b1.Remove(elem)
+	}
+	delete(c.ghosts, ghost.key)
+}
+
+// Delete removes the entry, and forgets the key if it is a ghost
+func (c *ARCCache) Delete(key string) bool {
+	if elem, ok := c.ghosts[key]; ok {
+		c.forget(elem)
+	}
+	elem, ok := c.items[key]
+	if ! ok {
+		return false
+	}
+	c.remove(elem)
+	c.hook.deleted(key, elem.Value.(*arcItem).value)
+	return true
+}
+
+func (c *ARCCache) Clear() {
+	if c.hook.deletes() {
+		for _, entries := range([]*list.List{c.t1, c.t2}) {
+			for e := entries.Back(); e != nil; e = e.Prev() {
+				c.hook.deleted(e.Value.(*arcItem).key, e.Value.(*arcItem).value)
+			}
+		}
+	}
+	c.t1.Init()
+	c.t2.Init()
+	c.b1.Init()
+	c.b2.Init()
+	c.items = make(map[string]*list.Element)
+	c.ghosts = make(map[string]*list.Element)
+	c.p = 0
+	c.hits = 0
+	c.misses = 0
+}
+
+// Size returns the number of entries not expired yet
+func (c *ARCCache) Size() int {
+	size := 0
+	for _, elem := range c.items {
+		if ! c.ttl.expired(elem.Value.(*arcItem).expiresAt) {
+			size++
+		}
+	}
+	return size
+}
+
+func (c *ARCCache) Capacity() int {
+	return c.capacity
+}
+
+func (c *ARCCache) HitRate() float64 {
+	total := c.hits + c.misses
+	if total == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(total)
+}
+
+func (c *ARCCache) evictionHook() *evictHook {
+	return c.hook
+}
+
 //
 // Thread-Safe Cache Wrapper
 //
 
 type ThreadSafeCache struct {
 	cache Cache
+	hook  *evictHook
 	mu    sync.RWMutex
 }
 
-// NewThreadSafeCache wraps any cache implementation to make it thread-safe
+// NewThreadSafeCache wraps any cache implementation to make it thread-safe.
+// The OnEvict callback of the cache is deferred until the lock is released,
+// the cache must then only be used through the wrapper.
 func NewThreadSafeCache(cache Cache) *ThreadSafeCache {
 	if cache == nil {
 		return nil
 	}
-	return &ThreadSafeCache{cache: cache}
+	c := &ThreadSafeCache{cache: cache}
+	if notifier, ok := cache.(evictNotifier); ok && notifier.evictionHook() != nil {
+		c.hook = notifier.evictionHook()
+		c.hook.deferred = true
+	}
+	return c
+}
+
+// unlock releases the write lock, then runs the eviction callbacks queued
+// while it was held
+func (c *ThreadSafeCache) unlock() {
+	pending := c.hook.take()
+	c.mu.Unlock()
+	for _, entry := range pending {
+		c.hook.onEvict(entry.key, entry.value)
+	}
 }
 
+// Get takes the write lock, a Get updates the recency or frequency of the
+// entry and removes it once expired
 func (c *ThreadSafeCache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.unlock()
 	return c.cache.Get(key)
 }
 
 func (c *ThreadSafeCache) Put(key string, value interface{}) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 	c.cache.Put(key, value)
 }
 
 func (c *ThreadSafeCache) Delete(key string) bool {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 	return c.cache.Delete(key)
 }
 
 func (c *ThreadSafeCache) Clear() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 	c.cache.Clear()
 }
 
@@ -406,27 +1363,375 @@ func (c *ThreadSafeCache) HitRate() float64 {
 	return c.cache.HitRate()
 }
 
+// PutWithTTL stores the value in the wrapped cache until ttl elapses. If the
+// cache does not implement Expirer the value is stored with a plain Put.
+func (c *ThreadSafeCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	if expirer, ok := c.cache.(Expirer); ok {
+		expirer.PutWithTTL(key, value, ttl)
+		return
+	}
+	c.cache.Put(key, value)
+}
+
+// DebugState returns the snapshot of the wrapped cache, nil if it does not
+// implement Debugger
+func (c *ThreadSafeCache) DebugState() []DebugEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if debugger, ok := c.cache.(Debugger); ok {
+		return debugger.DebugState()
+	}
+	return nil
+}
+
+// Resize resizes the wrapped cache, it does nothing if the cache does not
+// implement Resizer
+func (c *ThreadSafeCache) Resize(newCapacity int) {
+	c.mu.Lock()
+	defer c.unlock()
+	if resizer, ok := c.cache.(Resizer); ok {
+		resizer.Resize(newCapacity)
+	}
+}
+
+//
+// Consistent Hashing
+//
+
+// DefaultReplicas is the number of virtual nodes of a shard on the ring
+const DefaultReplicas = 100
+
+// ConsistentHashRing maps keys to shards. Every shard owns replicas points
+// on the ring and a key belongs to the first point at or after its hash, so
+// adding or removing a shard only moves the keys of that shard.
+// It is not safe for concurrent use.
+type ConsistentHashRing struct {
+	replicas int
+	points   []uint64          // sorted
+	owners   map[uint64]string // point -> shard
+	shards   map[string]bool
+}
+
+// NewConsistentHashRing creates an empty ring, replicas lower than 1 are
+// replaced by DefaultReplicas
+func NewConsistentHashRing(replicas int) *ConsistentHashRing {
+	if replicas < 1 {
+		replicas = DefaultReplicas
+	}
+	return &ConsistentHashRing{
+		replicas: replicas,
+		owners:   make(map[uint64]string),
+		shards:   make(map[string]bool),
+	}
+}
+
+// AddShard places the virtual nodes of a shard on the ring, it returns
+// false if the shard is already there
+func (r *ConsistentHashRing) AddShard(shard string) bool {
+	if r.shards[shard] {
+		return false
+	}
+	r.shards[shard] = true
+	for i := range(r.replicas) {
+		point := ringHash(shard + "#" + strconv.Itoa(i))
+		if _, taken := r.owners[point]; taken {
+			// Keep the first owner, the shard simply has one point less
+			continue
+		}
+		r.owners[point] = shard
+		r.points = append(r.points, point)
+	}
+	slices.Sort(r.points)
+	return true
+}
+
+// RemoveShard takes the virtual nodes of a shard off the ring, it returns
+// false if the shard is unknown
+func (r *ConsistentHashRing) RemoveShard(shard string) bool {
+	if ! r.shards[shard] {
+		return false
+	}
+	delete(r.shards, shard)
+	r.points = slices.DeleteFunc(r.points, func(point uint64) bool {
+		if r.owners[point] != shard {
+			return false
+		}
+		delete(r.owners, point)
+		return true
+	})
+	return true
+}
+
+// Get returns the shard owning the key, or false if the ring is empty
+func (r *ConsistentHashRing) Get(key string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+	i, _ := slices.BinarySearch(r.points, ringHash(key))
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]], true
+}
+
+// Shards returns the names of the shards on the ring, sorted
+func (r *ConsistentHashRing) Shards() []string {
+	shards := make([]string, 0, len(r.shards))
+	for shard := range r.shards {
+		shards = append(shards, shard)
+	}
+	slices.Sort(shards)
+	return shards
+}
+
+// ringHash is FNV-1a followed by the murmur3 finalizer, FNV alone spreads
+// short similar strings such as "shard#1", "shard#2" poorly
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+//
+// Sharded Cache
+//
+
+// ShardedCache spreads the keys over several caches routed through a
+// ConsistentHashRing. The shards are built by the factory, it is safe for
+// concurrent use as long as they are (see NewThreadSafeCacheWithPolicy).
+//
+// Entries are not migrated when the shards change: the keys of a removed
+// shard are lost, and the few keys remapped to a new shard become misses.
+// Their old copies are dropped so they cannot come back once the new shard
+// is removed again.
+type ShardedCache struct {
+	ring    *ConsistentHashRing
+	shards  map[string]Cache
+	factory func() Cache
+	hits    atomic.Int64
+	misses  atomic.Int64
+	mu      sync.RWMutex
+}
+
+// NewShardedCache creates a sharded cache with the named shards, it returns
+// nil if the factory is nil or does not build a cache
+func NewShardedCache(factory func() Cache, replicas int, names ...string) *ShardedCache {
+	if factory == nil {
+		return nil
+	}
+	c := &ShardedCache{
+		ring:    NewConsistentHashRing(replicas),
+		shards:  make(map[string]Cache),
+		factory: factory,
+	}
+	for _, name := range names {
+		if _, failed := c.addShard(name); failed {
+			return nil
+		}
+	}
+	return c
+}
+
+// AddShard adds an empty shard, it returns false if the shard already
+// exists or the factory does not build a cache
+func (c *ShardedCache) AddShard(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	added, _ := c.addShard(name)
+	return added
+}
+
+// addShard reports whether the shard was added, and whether the factory
+// failed
+func (c *ShardedCache) addShard(name string) (bool, bool) {
+	if _, ok := c.shards[name]; ok {
+		return false, false
+	}
+	shard := c.factory()
+	if shard == nil {
+		return false, true
+	}
+	c.shards[name] = shard
+	c.ring.AddShard(name)
+	c.dropRemapped(name)
+	return true, false
+}
+
+// dropRemapped deletes from the other shards the keys the ring now routes to
+// the named shard. A shard that cannot list its entries is cleared.
+func (c *ShardedCache) dropRemapped(name string) {
+	for other, shard := range c.shards {
+		if other == name || shard.Size() == 0 {
+			continue
+		}
+		var entries []DebugEntry
+		if debugger, ok := shard.(Debugger); ok {
+			entries = debugger.DebugState()
+		}
+		if entries == nil {
+			shard.Clear()
+			continue
+		}
+		for _, entry := range entries {
+			if owner, _ := c.ring.Get(entry.Key); owner == name {
+				shard.Delete(entry.Key)
+			}
+		}
+	}
+}
+
+// RemoveShard removes a shard and its entries, it returns false if the
+// shard is unknown or is the last one
+func (c *ShardedCache) RemoveShard(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.shards[name]; ! ok || len(c.shards) == 1 {
+		return false
+	}
+	delete(c.shards, name)
+	c.ring.RemoveShard(name)
+	return true
+}
+
+// Shards returns the names of the shards, sorted
+func (c *ShardedCache) Shards() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Shards()
+}
+
+// shard returns the cache owning the key, the caller holds c.mu
+func (c *ShardedCache) shard(key string) Cache {
+	name, ok := c.ring.Get(key)
+	if ! ok {
+		return nil
+	}
+	return c.shards[name]
+}
+
+func (c *ShardedCache) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if shard := c.shard(key); shard != nil {
+		if value, found := shard.Get(key); found {
+			c.hits.Add(1)
+			return value, true
+		}
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+func (c *ShardedCache) Put(key string, value interface{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if shard := c.shard(key); shard != nil {
+		shard.Put(key, value)
+	}
+}
+
+// PutWithTTL stores the value in the shard owning the key, with a plain Put
+// if the shard does not implement Expirer
+func (c *ShardedCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	shard := c.shard(key)
+	if expirer, ok := shard.(Expirer); ok {
+		expirer.PutWithTTL(key, value, ttl)
+	} else if shard != nil {
+		shard.Put(key, value)
+	}
+}
+
+func (c *ShardedCache) Delete(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if shard := c.shard(key); shard != nil {
+		return shard.Delete(key)
+	}
+	return false
+}
+
+func (c *ShardedCache) Clear() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, shard := range c.shards {
+		shard.Clear()
+	}
+	c.hits.Store(0)
+	c.misses.Store(0)
+}
+
+func (c *ShardedCache) Size() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	size := 0
+	for _, shard := range c.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+func (c *ShardedCache) Capacity() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	capacity := 0
+	for _, shard := range c.shards {
+		capacity += shard.Capacity()
+	}
+	return capacity
+}
+
+func (c *ShardedCache) HitRate() float64 {
+	hits, misses := c.hits.Load(), c.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
 //
 // Cache Factory Functions
 //
 
 // NewCache creates a cache with the specified policy and capacity
-func NewCache(policy CachePolicy, capacity int) Cache {
+func NewCache(policy CachePolicy, capacity int, opts ...CacheOption) Cache {
 	switch policy {
 	case LRU:
-		return NewLRUCache(capacity)
+		if cache := NewLRUCache(capacity, opts...); cache != nil {
+			return cache
+		}
+		return nil
 	case LFU:
-		return NewLFUCache(capacity)
+		return NewLFUCache(capacity, opts...)
 	case FIFO:
-		return NewFIFOCache(capacity)
+		return NewFIFOCache(capacity, opts...)
+	case MRU:
+		if cache := NewMRUCache(capacity, opts...); cache != nil {
+			return cache
+		}
+		return nil
+	case ARC:
+		if cache := NewARCCache(capacity, opts...); cache != nil {
+			return cache
+		}
+		return nil
 	default:
 		return nil
 	}
 }
 
 // NewThreadSafeCacheWithPolicy creates a thread-safe cache with the specified policy
-func NewThreadSafeCacheWithPolicy(policy CachePolicy, capacity int) Cache {
-	cache := NewCache(policy, capacity)
+func NewThreadSafeCacheWithPolicy(policy CachePolicy, capacity int, opts ...CacheOption) Cache {
+	cache := NewCache(policy, capacity, opts...)
 	if cache == nil {
 		return nil
 	}
@@ -0,0 +1,438 @@
+package challenge7
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+func ledgerAccount(t *testing.T) *BankAccount {
+	t.Helper()
+	account, err := NewBankAccount("ACC1", "Alice", 100.0, 0.0)
+	if err != nil {
+		t.Fatalf("Failed to create account: %v", err)
+	}
+	target, _ := NewBankAccount("ACC2", "Bob", 0.0, 0.0)
+
+	if err := account.Deposit(50.25); err != nil {
+		t.Fatalf("Deposit failed: %v", err)
+	}
+	if err := account.Withdraw(20.0); err != nil {
+		t.Fatalf("Withdraw failed: %v", err)
+	}
+	if err := account.Transfer(30.0, target); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	// Spread the ledger over one entry per day
+	for i := range account.Ledger {
+		account.Ledger[i].Time = day.AddDate(0, 0, i)
+	}
+	return account
+}
+
+func TestLedgerRecordsOperations(t *testing.T) {
+	account := ledgerAccount(t)
+
+	types := []string{TxOpen, TxDeposit, TxWithdrawal, TxTransferOut}
+	amounts := []float64{100.0, 50.25, -20.0, -30.0}
+	if len(account.Ledger) != len(types) {
+		t.Fatalf("Expected %d ledger entries, got %d", len(types), len(account.Ledger))
+	}
+	var sum float64
+	for i, tx := range account.Ledger {
+		if tx.Type != types[i] || tx.Amount != amounts[i] {
+			t.Errorf("Expected entry %d to be %s %.2f, got %s %.2f", i, types[i], amounts[i], tx.Type, tx.Amount)
+		}
+		sum += tx.Amount
+	}
+	if sum != account.Balance {
+		t.Errorf("Expected ledger sum %.2f to match balance %.2f", sum, account.Balance)
+	}
+}
+
+func TestExportStatementCSV(t *testing.T) {
+	account := ledgerAccount(t)
+
+	// Window covers the deposit and the withdrawal
+	data, err := account.ExportStatement(day.AddDate(0, 0, 1), day.AddDate(0, 0, 3), "csv")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+
+	expected := [][]string{
+		{"date", "type", "amount", "balance"},
+		{"2024-03-02T00:00:00Z", "opening_balance", "", "100.00"},
+		{"2024-03-02T00:00:00Z", "deposit", "50.25", "150.25"},
+		{"2024-03-03T00:00:00Z", "withdrawal", "-20.00", "130.25"},
+		{"2024-03-04T00:00:00Z", "closing_balance", "", "130.25"},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d: %v", len(expected), len(rows), rows)
+	}
+	for i := range expected {
+		if strings.Join(rows[i], ",") != strings.Join(expected[i], ",") {
+			t.Errorf("Expected row %d to be %v, got %v", i, expected[i], rows[i])
+		}
+	}
+}
+
+func TestExportStatementJSON(t *testing.T) {
+	account := ledgerAccount(t)
+
+	data, err := account.ExportStatement(day.AddDate(0, 0, 2), day.AddDate(0, 0, 10), "json")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"opening_balance":150.25`) {
+		t.Errorf("Expected amounts with two decimals, got %s", data)
+	}
+
+	var st struct {
+		AccountID      string  `json:"account_id"`
+		OpeningBalance float64 `json:"opening_balance"`
+		ClosingBalance float64 `json:"closing_balance"`
+		Transactions   []struct {
+			Type    string  `json:"type"`
+			Amount  float64 `json:"amount"`
+			Balance float64 `json:"balance"`
+		} `json:"transactions"`
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if st.AccountID != "ACC1" || st.OpeningBalance != 150.25 || st.ClosingBalance != 100.25 {
+		t.Errorf("Unexpected statement summary: %+v", st)
+	}
+	if len(st.Transactions) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(st.Transactions))
+	}
+	// The running balance starts from the opening balance
+	balance := st.OpeningBalance
+	for _, tx := range st.Transactions {
+		balance += tx.Amount
+		if Money(tx.Balance).String() != Money(balance).String() {
+			t.Errorf("Expected running balance %.2f, got %.2f", balance, tx.Balance)
+		}
+	}
+	if st.Transactions[1].Type != TxTransferOut {
+		t.Errorf("Expected last transaction to be a transfer, got %s", st.Transactions[1].Type)
+	}
+}
+
+func TestExportStatementEmptyWindow(t *testing.T) {
+	account := ledgerAccount(t)
+	from, to := day.AddDate(1, 0, 0), day.AddDate(1, 1, 0)
+
+	data, err := account.ExportStatement(from, to, "csv")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if string(data) != "date,type,amount,balance\n" {
+		t.Errorf("Expected header only, got %q", data)
+	}
+
+	data, err = account.ExportStatement(from, to, "json")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"transactions":[]`) || !strings.Contains(string(data), `"closing_balance":100.25`) {
+		t.Errorf("Expected an empty transaction list, got %s", data)
+	}
+}
+
+func TestExportStatementInvalid(t *testing.T) {
+	account := ledgerAccount(t)
+
+	var accErr *AccountError
+	if _, err := account.ExportStatement(day, day.AddDate(0, 0, 1), "xml"); !errors.As(err, &accErr) {
+		t.Errorf("Expected AccountError for unsupported format, got %v", err)
+	}
+	if _, err := account.ExportStatement(day.AddDate(0, 0, 1), day, "csv"); !errors.As(err, &accErr) {
+		t.Errorf("Expected AccountError for an inverted window, got %v", err)
+	}
+}
+
+func TestWithdrawInsufficientFundsReleasesLock(t *testing.T) {
+	account, _ := NewBankAccount("ACC1", "Alice", 100.0, 50.0)
+	if err := account.Withdraw(80.0); err == nil {
+		t.Fatal("Expected insufficient funds error")
+	}
+	// Would deadlock if the failed withdrawal kept the lock
+	if err := account.Deposit(10.0); err != nil {
+		t.Errorf("Deposit failed: %v", err)
+	}
+}
+
+func TestNewJointAccount(t *testing.T) {
+	account, err := NewJointAccount("JOINT", []string{"Alice", "Bob"}, 500.0, 0.0)
+	if err != nil {
+		t.Fatalf("Failed to create joint account: %v", err)
+	}
+	if account.Owner != "Alice" || strings.Join(account.Owners, ",") != "Alice,Bob" {
+		t.Errorf("Unexpected owners: %s %v", account.Owner, account.Owners)
+	}
+
+	var accErr *AccountError
+	for _, owners := range [][]string{nil, {"Alice", ""}, {"Alice", "Alice"}} {
+		if _, err := NewJointAccount("JOINT", owners, 500.0, 0.0); !errors.As(err, &accErr) {
+			t.Errorf("Expected AccountError for owners %v, got %v", owners, err)
+		}
+	}
+}
+
+func TestOwnerWithdrawalLimits(t *testing.T) {
+	account, _ := NewJointAccount("JOINT", []string{"Alice", "Bob"}, 1000.0, 100.0)
+	if err := account.AuthorizeOwner("Bob", 50.0); err != nil {
+		t.Fatalf("Failed to set Bob's limit: %v", err)
+	}
+
+	var limitErr *ExceedsLimitError
+	if err := account.WithdrawAs("Bob", 60.0); !errors.As(err, &limitErr) {
+		t.Errorf("Expected ExceedsLimitError for Bob, got %v", err)
+	}
+	if err := account.WithdrawAs("Bob", 50.0); err != nil {
+		t.Errorf("Expected Bob to withdraw within his limit, got %v", err)
+	}
+	if err := account.WithdrawAs("Alice", 600.0); err != nil {
+		t.Errorf("Expected Alice to withdraw with the default limit, got %v", err)
+	}
+
+	// Account rules still apply within the owner limit
+	var fundsErr *InsufficientFundsError
+	if err := account.WithdrawAs("Alice", 300.0); !errors.As(err, &fundsErr) {
+		t.Errorf("Expected InsufficientFundsError, got %v", err)
+	}
+	if account.Balance != 350.0 {
+		t.Errorf("Expected balance 350.00, got %.2f", account.Balance)
+	}
+
+	target, _ := NewBankAccount("TARGET", "Carol", 0.0, 0.0)
+	if err := account.TransferAs("Bob", 60.0, target); !errors.As(err, &limitErr) {
+		t.Errorf("Expected ExceedsLimitError for Bob's transfer, got %v", err)
+	}
+	if err := account.TransferAs("Bob", 40.0, target); err != nil {
+		t.Errorf("Expected Bob's transfer to succeed, got %v", err)
+	}
+	if target.Balance != 40.0 || account.Balance != 310.0 {
+		t.Errorf("Unexpected balances after transfer: %.2f %.2f", account.Balance, target.Balance)
+	}
+}
+
+func TestWithdrawUsesPrimaryOwnerLimit(t *testing.T) {
+	account, _ := NewJointAccount("JOINT", []string{"Alice", "Bob"}, 1000.0, 0.0)
+	target, _ := NewBankAccount("TARGET", "Carol", 0.0, 0.0)
+	if err := account.AuthorizeOwner("Alice", 50.0); err != nil {
+		t.Fatalf("Failed to set Alice's limit: %v", err)
+	}
+
+	var limitErr *ExceedsLimitError
+	if err := account.Withdraw(60.0); !errors.As(err, &limitErr) {
+		t.Errorf("Expected ExceedsLimitError for Withdraw, got %v", err)
+	}
+	if err := account.Transfer(60.0, target); !errors.As(err, &limitErr) {
+		t.Errorf("Expected ExceedsLimitError for Transfer, got %v", err)
+	}
+	results := BatchTransfer([]TransferSpec{{From: account, To: target, Amount: 60.0}})
+	if !errors.As(results[0].Err, &limitErr) {
+		t.Errorf("Expected ExceedsLimitError for BatchTransfer, got %v", results[0].Err)
+	}
+	if err := account.Withdraw(50.0); err != nil {
+		t.Errorf("Expected Withdraw within the limit to succeed, got %v", err)
+	}
+	if account.Balance != 950.0 || target.Balance != 0.0 {
+		t.Errorf("Unexpected balances: %.2f %.2f", account.Balance, target.Balance)
+	}
+}
+
+func TestDebitErrorOp(t *testing.T) {
+	account, _ := NewBankAccount("ACC", "Alice", 100.0, 50.0)
+	target, _ := NewBankAccount("TARGET", "Carol", 0.0, 0.0)
+
+	var limitErr *ExceedsLimitError
+	if err := account.Withdraw(MaxTransactionAmount + 1); !errors.As(err, &limitErr) || limitErr.Op != TxWithdrawal {
+		t.Errorf("Expected ExceedsLimitError with op %s, got %v", TxWithdrawal, err)
+	}
+	var negErr *NegativeAmountError
+	if err := account.Transfer(-1.0, target); !errors.As(err, &negErr) || negErr.Op != TxTransferOut {
+		t.Errorf("Expected NegativeAmountError with op %s, got %v", TxTransferOut, err)
+	}
+	var fundsErr *InsufficientFundsError
+	if err := account.Withdraw(60.0); !errors.As(err, &fundsErr) || fundsErr.Op != TxWithdrawal {
+		t.Errorf("Expected InsufficientFundsError with op %s, got %v", TxWithdrawal, err)
+	}
+	if err := account.Transfer(60.0, target); !errors.As(err, &fundsErr) || fundsErr.Op != TxTransferOut {
+		t.Errorf("Expected InsufficientFundsError with op %s, got %v", TxTransferOut, err)
+	}
+}
+
+func TestOwnersOfLiteralAccount(t *testing.T) {
+	account := &BankAccount{ID: "LIT", Owner: "Alice", Balance: 100.0}
+	if err := account.Withdraw(10.0); err != nil {
+		t.Errorf("Expected the owner to withdraw, got %v", err)
+	}
+	if err := account.AuthorizeOwner("Bob", 20.0); err != nil {
+		t.Fatalf("Failed to authorize Bob: %v", err)
+	}
+	if err := account.WithdrawAs("Bob", 20.0); err != nil {
+		t.Errorf("Expected Bob to withdraw, got %v", err)
+	}
+	if len(account.Owners) != 2 || account.Owners[0] != "Alice" {
+		t.Errorf("Unexpected owners: %v", account.Owners)
+	}
+}
+
+func TestOwnerAuthorization(t *testing.T) {
+	account, _ := NewJointAccount("JOINT", []string{"Alice", "Bob"}, 1000.0, 0.0)
+	target, _ := NewBankAccount("TARGET", "Carol", 0.0, 0.0)
+
+	var accErr *AccountError
+	if err := account.WithdrawAs("Mallory", 10.0); !errors.As(err, &accErr) {
+		t.Errorf("Expected AccountError for an unauthorized owner, got %v", err)
+	}
+	if err := account.TransferAs("Mallory", 10.0, target); !errors.As(err, &accErr) {
+		t.Errorf("Expected AccountError for an unauthorized transfer, got %v", err)
+	}
+	if account.Balance != 1000.0 || target.Balance != 0.0 {
+		t.Errorf("Expected balances unchanged, got %.2f %.2f", account.Balance, target.Balance)
+	}
+
+	if err := account.AuthorizeOwner("Carol", 100.0); err != nil {
+		t.Fatalf("Failed to authorize Carol: %v", err)
+	}
+	if err := account.WithdrawAs("Carol", 100.0); err != nil {
+		t.Errorf("Expected Carol to withdraw once authorized, got %v", err)
+	}
+
+	if err := account.RemoveOwner("Alice"); err != nil {
+		t.Fatalf("Failed to remove Alice: %v", err)
+	}
+	if account.Owner != "Bob" {
+		t.Errorf("Expected Bob to become the primary owner, got %s", account.Owner)
+	}
+	if err := account.WithdrawAs("Alice", 10.0); !errors.As(err, &accErr) {
+		t.Errorf("Expected AccountError for a removed owner, got %v", err)
+	}
+	if err := account.RemoveOwner("Alice"); !errors.As(err, &accErr) {
+		t.Errorf("Expected AccountError when removing a non-owner, got %v", err)
+	}
+
+	if err := account.RemoveOwner("Bob"); err != nil {
+		t.Fatalf("Failed to remove Bob: %v", err)
+	}
+	if err := account.RemoveOwner("Carol"); !errors.As(err, &accErr) {
+		t.Errorf("Expected AccountError when removing the last owner, got %v", err)
+	}
+	if len(account.Owners) != 1 {
+		t.Errorf("Expected the last owner to remain, got %v", account.Owners)
+	}
+}
+
+func TestBatchTransferPartialFailure(t *testing.T) {
+	a, _ := NewBankAccount("A", "Alice", 100.0, 10.0)
+	b, _ := NewBankAccount("B", "Bob", 50.0, 0.0)
+	c, _ := NewBankAccount("C", "Carol", 0.0, 0.0)
+
+	results := BatchTransfer([]TransferSpec{
+		{From: a, To: b, Amount: 40},
+		{From: a, To: c, Amount: 60}, // would go below the minimum balance
+		{From: b, To: c, Amount: -5},
+		{From: b, To: a, Amount: 90},
+		{From: c, To: nil, Amount: 1},
+		{From: a, To: a, Amount: 10},
+	})
+	if len(results) != 6 {
+		t.Fatalf("Expected 6 results, got %d", len(results))
+	}
+
+	var insufficient *InsufficientFundsError
+	var negative *NegativeAmountError
+	var accountErr *AccountError
+	checks := []struct {
+		ok  bool
+		err error
+	}{
+		{results[0].Err == nil, results[0].Err},
+		{errors.As(results[1].Err, &insufficient), results[1].Err},
+		{errors.As(results[2].Err, &negative), results[2].Err},
+		{results[3].Err == nil, results[3].Err},
+		{errors.As(results[4].Err, &accountErr), results[4].Err},
+		{results[5].Err == nil, results[5].Err},
+	}
+	for i, c := range checks {
+		if !c.ok {
+			t.Errorf("Transfer %d: unexpected result %v", i, c.err)
+		}
+	}
+	if results[3].Spec.Amount != 90 {
+		t.Errorf("Expected the result to carry its spec, got %+v", results[3].Spec)
+	}
+	if a.Balance != 150 || b.Balance != 0 || c.Balance != 0 {
+		t.Errorf("Unexpected balances: A=%.2f B=%.2f C=%.2f", a.Balance, b.Balance, c.Balance)
+	}
+}
+
+func TestBatchTransferConcurrent(t *testing.T) {
+	const numAccounts, workers, batches = 6, 16, 200
+	accounts := make([]*BankAccount, numAccounts)
+	for i := range accounts {
+		accounts[i], _ = NewBankAccount(fmt.Sprintf("ACC%d", i), "Owner", 1000.0, 0.0)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for n := 0; n < batches; n++ {
+				// Overlapping batches listing the accounts in random orders
+				specs := make([]TransferSpec, 1+rng.Intn(4))
+				for i := range specs {
+					specs[i] = TransferSpec{
+						From:   accounts[rng.Intn(numAccounts)],
+						To:     accounts[rng.Intn(numAccounts)],
+						Amount: float64(rng.Intn(400)),
+					}
+				}
+				BatchTransfer(specs)
+				// Single transfers interleave with the batches
+				accounts[rng.Intn(numAccounts)].Transfer(1, accounts[rng.Intn(numAccounts)])
+			}
+		}(int64(w))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Batch transfers deadlocked")
+	}
+
+	total := 0.0
+	for _, a := range accounts {
+		if a.Balance < a.MinBalance {
+			t.Errorf("Account %s below its minimum balance: %.2f", a.ID, a.Balance)
+		}
+		total += a.Balance
+	}
+	if total != numAccounts*1000.0 {
+		t.Errorf("Expected total balance %.2f, got %.2f", numAccounts*1000.0, total)
+	}
+}
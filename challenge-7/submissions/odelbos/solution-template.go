@@ -3,18 +3,45 @@ package challenge7
 
 import (
 	"sync"
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
     "fmt"
+    "slices"
+    "sort"
+    "strconv"
+    "time"
 )
 
 // BankAccount represents a bank account with balance management and minimum balance requirements.
 type BankAccount struct {
 	ID         string
 	Owner      string
+	Owners     []string // Owners allowed to withdraw, Owner is the first one
 	Balance    float64
 	MinBalance float64
+	Ledger     []Transaction // Chronological list of balance changes
+	ownerLimit map[string]float64 // Per-owner withdrawal limit
 	mu         sync.Mutex // For thread safety
 }
 
+// Transaction is an entry of the account ledger, Amount is positive for
+// credits and negative for debits.
+type Transaction struct {
+	Time   time.Time
+	Type   string
+	Amount float64
+}
+
+// Ledger transaction types
+const (
+	TxOpen        = "open"
+	TxDeposit     = "deposit"
+	TxWithdrawal  = "withdrawal"
+	TxTransferIn  = "transfer_in"
+	TxTransferOut = "transfer_out"
+)
+
 // Constants for account operations
 const (
 	MaxTransactionAmount = 10000.0 // Example limit for deposits/withdrawals
@@ -95,9 +122,93 @@ func NewBankAccount(id, owner string, initialBalance, minBalance float64) (*Bank
         Owner:      owner,
         Balance:    initialBalance,
         MinBalance: minBalance,
+        Owners:     []string{owner},
+        Ledger:     []Transaction{{time.Now(), TxOpen, initialBalance}},
+        ownerLimit: map[string]float64{owner: MaxTransactionAmount},
     }, nil
 }
 
+// NewJointAccount creates a bank account shared by several owners.
+// Each owner starts with a withdrawal limit of MaxTransactionAmount.
+func NewJointAccount(id string, owners []string, initialBalance, minBalance float64) (*BankAccount, error) {
+    if len(owners) == 0 {
+        return nil, &AccountError{id, "create", "cannot create account without owners"}
+    }
+    a, err := NewBankAccount(id, owners[0], initialBalance, minBalance)
+    if err != nil {
+        return nil, err
+    }
+    for _, owner := range(owners[1:]) {
+        if owner == "" {
+            return nil, &AccountError{id, "create", "cannot create account without valid owner"}
+        }
+        if _, ok := a.ownerLimit[owner]; ok {
+            return nil, &AccountError{id, "create", fmt.Sprintf("duplicate owner: %s", owner)}
+        }
+        a.Owners = append(a.Owners, owner)
+        a.ownerLimit[owner] = MaxTransactionAmount
+    }
+    return a, nil
+}
+
+// AuthorizeOwner adds an owner to the account, or updates its withdrawal limit.
+func (a *BankAccount) AuthorizeOwner(owner string, limit float64) error {
+    if owner == "" {
+        return &AccountError{a.ID, "authorize", "invalid owner"}
+    }
+    if limit < 0 {
+        return &NegativeAmountError{a.ID, "authorize", limit, "limit cannot be negative"}
+    }
+
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.initOwners()
+    if _, ok := a.ownerLimit[owner]; ! ok {
+        a.Owners = append(a.Owners, owner)
+    }
+    a.ownerLimit[owner] = limit
+    return nil
+}
+
+// initOwners sets up the owner limits of an account not built by
+// NewBankAccount, the known owners get the default limit. a.mu must be held.
+func (a *BankAccount) initOwners() {
+    if a.ownerLimit != nil {
+        return
+    }
+    a.ownerLimit = make(map[string]float64)
+    if a.Owner != "" && ! slices.Contains(a.Owners, a.Owner) {
+        a.Owners = append([]string{a.Owner}, a.Owners...)
+    }
+    for _, owner := range(a.Owners) {
+        a.ownerLimit[owner] = MaxTransactionAmount
+    }
+}
+
+// RemoveOwner revokes an owner, the last owner of an account cannot be removed.
+func (a *BankAccount) RemoveOwner(owner string) error {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.initOwners()
+    if _, ok := a.ownerLimit[owner]; ! ok {
+        return &AccountError{a.ID, "remove owner", fmt.Sprintf("not an owner: %s", owner)}
+    }
+    if len(a.Owners) == 1 {
+        return &AccountError{a.ID, "remove owner", "cannot remove the last owner"}
+    }
+
+    delete(a.ownerLimit, owner)
+    owners := a.Owners[:0]
+    for _, o := range(a.Owners) {
+        if o != owner {
+            owners = append(owners, o)
+        }
+    }
+    a.Owners = owners
+    a.Owner = a.Owners[0]
+    return nil
+}
+
 // Deposit adds the specified amount to the account balance.
 // It returns an error if the amount is invalid or exceeds the transaction limit.
 func (a *BankAccount) Deposit(amount float64) error {
@@ -108,42 +219,232 @@ func (a *BankAccount) Deposit(amount float64) error {
         return &NegativeAmountError{a.ID, "deposit", amount, "amount cannot be negative"}
     }
 
-    a.mu.Lock()
-    a.Balance += amount
-    a.mu.Unlock()
+    a.credit(amount, TxDeposit)
     return nil
 }
 
-// Withdraw removes the specified amount from the account balance.
+// Withdraw removes the specified amount from the account balance on behalf
+// of the primary owner, whose limit applies.
 // It returns an error if the amount is invalid, exceeds the transaction limit,
 // or would bring the balance below the minimum required balance.
 func (a *BankAccount) Withdraw(amount float64) error {
+    return a.debit("", amount, TxWithdrawal)
+}
+
+// WithdrawAs withdraws the specified amount on behalf of one of the owners.
+// In addition to the Withdraw checks, the owner must be authorized on the
+// account and the amount must not exceed the owner's limit.
+func (a *BankAccount) WithdrawAs(owner string, amount float64) error {
+    return a.debit(owner, amount, TxWithdrawal)
+}
+
+// debit removes amount from the balance on behalf of owner, the primary
+// owner if empty, and enforces the owner's limit.
+func (a *BankAccount) debit(owner string, amount float64, txType string) error {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return a.debitLocked(owner, amount, txType)
+}
+
+// debitLocked is debit with a.mu held
+func (a *BankAccount) debitLocked(owner string, amount float64, txType string) error {
     if amount > MaxTransactionAmount {
-        return &ExceedsLimitError{a.ID, "deposit", amount, fmt.Sprintf("exceed the limit of: %f", MaxTransactionAmount)}
+        return &ExceedsLimitError{a.ID, txType, amount, fmt.Sprintf("exceed the limit of: %f", MaxTransactionAmount)}
     }
     if amount < 0 {
-        return &NegativeAmountError{a.ID, "deposit", amount, "amount cannot be negative"}
+        return &NegativeAmountError{a.ID, txType, amount, "amount cannot be negative"}
     }
 
-    a.mu.Lock()
+    a.initOwners()
+    if owner == "" {
+        owner = a.Owner
+    }
+    limit, ok := a.ownerLimit[owner]
+    if ! ok {
+        return &AccountError{a.ID, txType, fmt.Sprintf("owner not authorized: %s", owner)}
+    }
+    if amount > limit {
+        return &ExceedsLimitError{a.ID, txType, amount, fmt.Sprintf("exceed the owner limit of: %f", limit)}
+    }
     if (a.Balance - amount < a.MinBalance) {
-        return &InsufficientFundsError{a.ID, "create", amount, "balance - amount < minimum balance"}
+        return &InsufficientFundsError{a.ID, txType, amount, "balance - amount < minimum balance"}
     }
     a.Balance -= amount
-    a.mu.Unlock()
+    a.Ledger = append(a.Ledger, Transaction{time.Now(), txType, -amount})
     return nil
 }
 
-// Transfer moves the specified amount from this account to the target account.
+func (a *BankAccount) credit(amount float64, txType string) {
+    a.mu.Lock()
+    a.creditLocked(amount, txType)
+    a.mu.Unlock()
+}
+
+// creditLocked is credit with a.mu held
+func (a *BankAccount) creditLocked(amount float64, txType string) {
+    a.Balance += amount
+    a.Ledger = append(a.Ledger, Transaction{time.Now(), txType, amount})
+}
+
+// Transfer moves the specified amount from this account to the target account
+// on behalf of the primary owner, whose limit applies.
 // It returns an error if the amount is invalid, exceeds the transaction limit,
 // or would bring the balance below the minimum required balance.
 func (a *BankAccount) Transfer(amount float64, target *BankAccount) error {
-    err := a.Withdraw(amount)
+    return a.TransferAs("", amount, target)
+}
+
+// TransferAs moves the specified amount to the target account on behalf of
+// one of the owners, with the same checks as WithdrawAs.
+func (a *BankAccount) TransferAs(owner string, amount float64, target *BankAccount) error {
+    err := a.debit(owner, amount, TxTransferOut)
     if err != nil {
         return err
     }
-    target.mu.Lock()
-    target.Balance += amount
-    target.mu.Unlock()
+    target.credit(amount, TxTransferIn)
     return nil
+}
+
+// TransferSpec is a transfer of a batch, Owner is optional as in TransferAs
+type TransferSpec struct {
+    From   *BankAccount
+    To     *BankAccount
+    Amount float64
+    Owner  string
+}
+
+// TransferResult is the outcome of the transfer of a batch at the same index
+type TransferResult struct {
+    Spec TransferSpec
+    Err  error // nil if the transfer was made
+}
+
+// BatchTransfer makes the transfers in order, a failed transfer does not
+// abort the others. All the accounts involved are locked once, by ascending
+// ID, so concurrent batches cannot deadlock. Account IDs must be unique.
+func BatchTransfer(transfers []TransferSpec) []TransferResult {
+    seen := make(map[*BankAccount]bool)
+    var accounts []*BankAccount
+    for _, t := range(transfers) {
+        for _, a := range([]*BankAccount{t.From, t.To}) {
+            if a != nil && ! seen[a] {
+                seen[a] = true
+                accounts = append(accounts, a)
+            }
+        }
+    }
+    sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+    for _, a := range(accounts) {
+        a.mu.Lock()
+    }
+    defer func() {
+        for i := len(accounts) - 1; i >= 0; i-- {
+            accounts[i].mu.Unlock()
+        }
+    }()
+
+    results := make([]TransferResult, len(transfers))
+    for i, t := range(transfers) {
+        results[i].Spec = t
+        if t.From == nil || t.To == nil {
+            results[i].Err = &AccountError{"", "transfer", "missing source or target account"}
+            continue
+        }
+        if err := t.From.debitLocked(t.Owner, t.Amount, TxTransferOut); err != nil {
+            results[i].Err = err
+            continue
+        }
+        t.To.creditLocked(t.Amount, TxTransferIn)
+    }
+    return results
+}
+
+// Money is an amount rendered with two decimals
+type Money float64
+
+func (m Money) String() string {
+    return strconv.FormatFloat(float64(m), 'f', 2, 64)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+    return []byte(m.String()), nil
+}
+
+// StatementLine is a ledger entry of a statement with the balance after it
+type StatementLine struct {
+    Date    time.Time `json:"date"`
+    Type    string    `json:"type"`
+    Amount  Money     `json:"amount"`
+    Balance Money     `json:"balance"`
+}
+
+// Statement is the ledger of an account over the [From, To) window
+type Statement struct {
+    AccountID      string          `json:"account_id"`
+    From           time.Time       `json:"from"`
+    To             time.Time       `json:"to"`
+    OpeningBalance Money           `json:"opening_balance"`
+    ClosingBalance Money           `json:"closing_balance"`
+    Lines          []StatementLine `json:"transactions"`
+}
+
+// Statement builds the statement of the transactions made in [from, to).
+func (a *BankAccount) Statement(from, to time.Time) Statement {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    st := Statement{AccountID: a.ID, From: from, To: to, Lines: []StatementLine{}}
+    var balance float64
+    for _, tx := range(a.Ledger) {
+        if ! tx.Time.Before(to) {
+            break
+        }
+        if tx.Time.Before(from) {
+            balance += tx.Amount
+            continue
+        }
+        if len(st.Lines) == 0 {
+            st.OpeningBalance = Money(balance)
+        }
+        balance += tx.Amount
+        st.Lines = append(st.Lines, StatementLine{tx.Time, tx.Type, Money(tx.Amount), Money(balance)})
+    }
+    if len(st.Lines) == 0 {
+        st.OpeningBalance = Money(balance)
+    }
+    st.ClosingBalance = Money(balance)
+    return st
+}
+
+// ExportStatement renders the statement of [from, to) as "csv" or "json".
+// The CSV rows are the opening balance, the transactions and the closing
+// balance, an empty window only renders the header.
+func (a *BankAccount) ExportStatement(from, to time.Time, format string) ([]byte, error) {
+    if to.Before(from) {
+        return nil, &AccountError{a.ID, "export", "statement end is before its start"}
+    }
+    st := a.Statement(from, to)
+
+    switch format {
+    case "json":
+        return json.Marshal(st)
+    case "csv":
+        var buf bytes.Buffer
+        w := csv.NewWriter(&buf)
+        w.Write([]string{"date", "type", "amount", "balance"})
+        if len(st.Lines) > 0 {
+            w.Write([]string{from.Format(time.RFC3339), "opening_balance", "", st.OpeningBalance.String()})
+            for _, line := range(st.Lines) {
+                w.Write([]string{line.Date.Format(time.RFC3339), line.Type, line.Amount.String(), line.Balance.String()})
+            }
+            w.Write([]string{to.Format(time.RFC3339), "closing_balance", "", st.ClosingBalance.String()})
+        }
+        w.Flush()
+        if err := w.Error(); err != nil {
+            return nil, err
+        }
+        return buf.Bytes(), nil
+    default:
+        return nil, &AccountError{a.ID, "export", fmt.Sprintf("unsupported format: %s", format)}
+    }
 } 
\ No newline at end of file
@@ -1,3 +1,5 @@
 module challenge8
 
-go 1.19 
\ No newline at end of file
+go 1.19
+
+require github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,443 @@
+package challenge8
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func receiveWithin(t *testing.T, c *Client, d time.Duration) (Message, bool) {
+	t.Helper()
+	done := make(chan Message, 1)
+	go func() {
+		if msg, ok := c.ReceiveMessage(); ok {
+			done <- msg
+		}
+	}()
+	select {
+	case msg := <-done:
+		return msg, true
+	case <-time.After(d):
+		return Message{}, false
+	}
+}
+
+func TestBroadcastSkipsSenderByDefault(t *testing.T) {
+	server := NewChatServer()
+	alice, _ := server.Connect("alice")
+	bob, _ := server.Connect("bob")
+	defer server.Disconnect(alice)
+	defer server.Disconnect(bob)
+
+	server.Broadcast(alice, "hello")
+
+	if msg, ok := receiveWithin(t, bob, time.Second); !ok || msg.Text != "alice: hello" {
+		t.Errorf("Expected bob to receive the broadcast, got %+v", msg)
+	}
+	if msg, ok := receiveWithin(t, alice, 50*time.Millisecond); ok {
+		t.Errorf("Expected no echo to the sender, got %+v", msg)
+	}
+}
+
+func TestBroadcastSenderEcho(t *testing.T) {
+	server := NewChatServer(WithSenderEcho())
+	alice, _ := server.Connect("alice")
+	bob, _ := server.Connect("bob")
+	defer server.Disconnect(alice)
+	defer server.Disconnect(bob)
+
+	server.Broadcast(alice, "hello")
+
+	echo, ok := receiveWithin(t, alice, time.Second)
+	if !ok || echo.Text != "alice: hello" {
+		t.Fatalf("Expected the sender to receive its own broadcast, got %+v", echo)
+	}
+	msg, ok := receiveWithin(t, bob, time.Second)
+	if !ok || msg.ID != echo.ID {
+		t.Errorf("Expected the same message ID %d for every recipient, got %+v", echo.ID, msg)
+	}
+}
+
+func TestMessageIDsIncrease(t *testing.T) {
+	server := NewChatServer()
+	receiver, _ := server.Connect("receiver")
+	defer server.Disconnect(receiver)
+
+	const numSenders, perSender = 10, 5
+	senders := make([]*Client, numSenders)
+	for i := range senders {
+		senders[i], _ = server.Connect(fmt.Sprintf("user%d", i))
+		defer server.Disconnect(senders[i])
+	}
+
+	var wg sync.WaitGroup
+	for _, sender := range senders {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				if j%2 == 0 {
+					server.Broadcast(c, "broadcast")
+				} else {
+					_ = server.PrivateMessage(c, "receiver", "private")
+				}
+			}
+		}(sender)
+	}
+	wg.Wait()
+
+	var last uint64
+	for i := 0; i < numSenders*perSender; i++ {
+		msg, ok := receiveWithin(t, receiver, time.Second)
+		if !ok {
+			t.Fatalf("Expected %d messages, got %d", numSenders*perSender, i)
+		}
+		if msg.ID <= last {
+			t.Fatalf("Expected increasing IDs, got %d after %d", msg.ID, last)
+		}
+		last = msg.ID
+	}
+
+	// IDs are per server
+	other := NewChatServer()
+	c, _ := other.Connect("receiver")
+	defer other.Disconnect(c)
+	c.Send("hello")
+	if msg, _ := receiveWithin(t, c, time.Second); msg.ID != 1 {
+		t.Errorf("Expected a new server to start at ID 1, got %d", msg.ID)
+	}
+}
+
+func TestMessagesSinceCatchUp(t *testing.T) {
+	server := NewChatServer()
+	alice, _ := server.Connect("alice")
+	bob, _ := server.Connect("bob")
+	defer server.Disconnect(alice)
+
+	server.Broadcast(alice, "before")
+	seen, ok := receiveWithin(t, bob, time.Second)
+	if !ok {
+		t.Fatal("Expected bob to receive the first message")
+	}
+
+	// Bob drops and misses several messages, a private message to alice is
+	// not part of the room history
+	server.Disconnect(bob)
+	for i := 1; i <= 3; i++ {
+		server.Broadcast(alice, fmt.Sprintf("missed %d", i))
+	}
+	alice.Send("private")
+
+	bob, err := server.Connect("bob")
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	defer server.Disconnect(bob)
+
+	got := server.MessagesSince(seen.ID)
+	want := []string{"alice: missed 1", "alice: missed 2", "alice: missed 3"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+
+	// Messages after the catch-up arrive live and continue the sequence
+	history := server.HistorySince(seen.ID)
+	last := history[len(history)-1].ID
+	server.Broadcast(alice, "live")
+	msg, ok := receiveWithin(t, bob, time.Second)
+	if !ok || msg.Text != "alice: live" || msg.ID <= last {
+		t.Errorf("Expected the live message after ID %d, got %+v", last, msg)
+	}
+	if got := server.MessagesSince(msg.ID); len(got) != 0 {
+		t.Errorf("Expected nothing after the latest message, got %v", got)
+	}
+}
+
+func TestMessagesSinceBounded(t *testing.T) {
+	server := NewChatServer(WithHistoryLimit(3))
+	alice, _ := server.Connect("alice")
+	defer server.Disconnect(alice)
+
+	for i := 1; i <= 5; i++ {
+		server.Broadcast(alice, fmt.Sprintf("m%d", i))
+	}
+
+	// A sequence older than the history replays from the oldest retained
+	want := "alice: m3|alice: m4|alice: m5"
+	if got := strings.Join(server.MessagesSince(0), "|"); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := strings.Join(server.MessagesSince(1), "|"); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := strings.Join(server.MessagesSince(4), "|"); got != "alice: m5" {
+		t.Errorf("Expected only m5, got %q", got)
+	}
+
+	disabled := NewChatServer(WithHistoryLimit(0))
+	c, _ := disabled.Connect("alice")
+	defer disabled.Disconnect(c)
+	disabled.Broadcast(c, "hello")
+	if got := disabled.MessagesSince(0); len(got) != 0 {
+		t.Errorf("Expected no history, got %v", got)
+	}
+}
+
+func dialChat(t *testing.T, ts *httptest.Server, username string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?username=" + username
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial as %s: %v", username, err)
+	}
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) WSOutbound {
+	t.Helper()
+	var frame WSOutbound
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	return frame
+}
+
+func TestWebSocketExchange(t *testing.T) {
+	server := NewChatServer()
+	ts := httptest.NewServer(NewWebSocketHandler(server))
+	defer ts.Close()
+
+	alice := dialChat(t, ts, "alice")
+	defer alice.Close()
+	bob := dialChat(t, ts, "bob")
+	defer bob.Close()
+
+	if err := alice.WriteJSON(WSInbound{Text: "hello"}); err != nil {
+		t.Fatalf("Failed to send broadcast: %v", err)
+	}
+	if frame := readFrame(t, bob); frame.Text != "alice: hello" || frame.ID == 0 {
+		t.Errorf("Expected bob to receive the broadcast, got %+v", frame)
+	}
+
+	if err := bob.WriteJSON(WSInbound{To: "alice", Text: "hi"}); err != nil {
+		t.Fatalf("Failed to send private message: %v", err)
+	}
+	if frame := readFrame(t, alice); frame.Text != "(pm) bob: hi" {
+		t.Errorf("Expected alice to receive the private message, got %+v", frame)
+	}
+
+	if err := bob.WriteJSON(WSInbound{To: "carol", Text: "hi"}); err != nil {
+		t.Fatalf("Failed to send private message: %v", err)
+	}
+	if frame := readFrame(t, bob); frame.Text != "error: "+ErrRecipientNotFound.Error() {
+		t.Errorf("Expected an error frame for an unknown recipient, got %+v", frame)
+	}
+}
+
+func TestWebSocketDisconnect(t *testing.T) {
+	server := NewChatServer()
+	ts := httptest.NewServer(NewWebSocketHandler(server))
+	defer ts.Close()
+
+	alice := dialChat(t, ts, "alice")
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?username=alice"
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 for a taken username, got %v", err)
+	}
+
+	// Closing the socket disconnects the client and frees the username
+	alice.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	alice.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		server.mu.RLock()
+		_, connected := server.clients["alice"]
+		server.mu.RUnlock()
+		if !connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected alice to be disconnected after closing the socket")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	again := dialChat(t, ts, "alice")
+	again.Close()
+}
+
+// fullClient connects a client with the given policy and fills its queue
+// with the messages "0" to "99"
+func fullClient(t *testing.T, policy OverflowPolicy, timeout time.Duration) *Client {
+	t.Helper()
+	server := NewChatServer(WithOverflowPolicy(policy, timeout))
+	client, err := server.Connect("alice")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	for i := 0; i < ClientQueueSize; i++ {
+		client.Send(fmt.Sprint(i))
+	}
+	if client.Dropped() != 0 {
+		t.Fatalf("Expected no drop while filling the queue, got %d", client.Dropped())
+	}
+	return client
+}
+
+// drain returns the texts of the queued messages
+func drain(c *Client) []string {
+	var texts []string
+	for len(c.incoming) > 0 {
+		texts = append(texts, c.Receive())
+	}
+	return texts
+}
+
+func TestOverflowDrop(t *testing.T) {
+	client := fullClient(t, OverflowDrop, 0)
+	client.Send("new")
+	client.Send("newer")
+
+	if client.Dropped() != 2 {
+		t.Errorf("Expected 2 dropped messages, got %d", client.Dropped())
+	}
+	texts := drain(client)
+	if len(texts) != ClientQueueSize || texts[0] != "0" || texts[len(texts)-1] != "99" {
+		t.Errorf("Expected the queue untouched, got %d messages from %s to %s", len(texts), texts[0], texts[len(texts)-1])
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	client := fullClient(t, OverflowDropOldest, 0)
+	client.Send("new")
+	client.Send("newer")
+
+	if client.Dropped() != 2 {
+		t.Errorf("Expected 2 dropped messages, got %d", client.Dropped())
+	}
+	texts := drain(client)
+	if len(texts) != ClientQueueSize || texts[0] != "2" || texts[len(texts)-2] != "new" || texts[len(texts)-1] != "newer" {
+		t.Errorf("Expected the oldest messages evicted, got %d messages from %s to %s", len(texts), texts[0], texts[len(texts)-1])
+	}
+}
+
+// waitDropped waits for the client to count n dropped messages
+func waitDropped(t *testing.T, c *Client, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for c.Dropped() < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c.Dropped() != n {
+		t.Fatalf("Expected %d dropped messages, got %d", n, c.Dropped())
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	client := fullClient(t, OverflowBlock, 50*time.Millisecond)
+
+	// Nobody reads, the message is dropped once the timeout elapsed, but
+	// Send does not wait for it
+	start := time.Now()
+	client.Send("timed out")
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("Expected Send to return at once, returned after %v", elapsed)
+	}
+	waitDropped(t, client, 1)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the message to wait 50ms, dropped after %v", elapsed)
+	}
+
+	// A reader making room within the timeout lets the messages through in
+	// order
+	client.Send("delivered")
+	client.Send("after")
+	if got := client.Receive(); got != "0" {
+		t.Errorf("Expected the oldest message first, got %s", got)
+	}
+	if got := client.Receive(); got != "1" {
+		t.Errorf("Expected the queue order kept, got %s", got)
+	}
+	var texts []string
+	for i := 0; i < ClientQueueSize; i++ {
+		texts = append(texts, client.Receive())
+	}
+	if client.Dropped() != 1 {
+		t.Errorf("Expected the messages to be delivered, got %d dropped", client.Dropped())
+	}
+	if texts[len(texts)-2] != "delivered" || texts[len(texts)-1] != "after" {
+		t.Errorf("Expected the blocked messages last in order, got %v", texts[len(texts)-2:])
+	}
+}
+
+func TestOverflowBlockDoesNotHoldOthers(t *testing.T) {
+	server := NewChatServer(WithOverflowPolicy(OverflowBlock, time.Second))
+	sender, _ := server.Connect("sender")
+	stalled, _ := server.Connect("stalled")
+	other, _ := server.Connect("other")
+	defer server.Disconnect(stalled)
+
+	for i := 0; i < ClientQueueSize; i++ {
+		stalled.Send(fmt.Sprint(i))
+	}
+
+	// The stalled client waits for room, the broadcast and the other
+	// clients do not
+	start := time.Now()
+	server.Broadcast(sender, "hello")
+	if _, err := server.Connect("late"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	other.Send("direct")
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected the deliveries not to wait for the stalled client, took %v", elapsed)
+	}
+	if texts := drain(other); len(texts) != 2 || texts[0] != "sender: hello" || texts[1] != "direct" {
+		t.Errorf("Expected the other client to get both messages, got %v", texts)
+	}
+}
+
+func TestOverflowBlockDisconnect(t *testing.T) {
+	server := NewChatServer(WithOverflowPolicy(OverflowBlock, time.Second))
+	client, _ := server.Connect("alice")
+	for i := 0; i <= ClientQueueSize; i++ {
+		client.Send(fmt.Sprint(i))
+	}
+
+	// The waiting message does not hold the disconnection
+	start := time.Now()
+	server.Disconnect(client)
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected the disconnection not to wait for room, took %v", elapsed)
+	}
+	client.Send("after")
+	n := 0
+	for range client.incoming {
+		n++
+	}
+	if n != ClientQueueSize {
+		t.Errorf("Expected %d queued messages, got %d", ClientQueueSize, n)
+	}
+}
+
+func TestSetOverflowPolicy(t *testing.T) {
+	client := fullClient(t, OverflowDrop, 0)
+	client.SetOverflowPolicy(OverflowDropOldest, 0)
+	client.Send("new")
+
+	if client.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", client.Dropped())
+	}
+	if texts := drain(client); texts[0] != "1" || texts[len(texts)-1] != "new" {
+		t.Errorf("Expected the client policy to apply, got %s to %s", texts[0], texts[len(texts)-1])
+	}
+}
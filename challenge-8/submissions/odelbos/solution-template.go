@@ -3,7 +3,13 @@ package challenge8
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // Common errors that can be returned by the Chat Server
@@ -13,38 +19,184 @@ var (
 	ErrClientDisconnected   = errors.New("client disconnected")
 )
 
+// Message is a chat message as delivered to a client. IDs are assigned by
+// the server in delivery order, they are unique and increasing per server.
+type Message struct {
+	ID   uint64
+	Text string
+}
+
+// OverflowPolicy decides what happens to a message delivered to a client
+// whose queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the new message
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits for room up to the block timeout, then drops the
+	// new message. The wait happens on a goroutine of the client, the later
+	// messages to the client wait behind it to keep their order.
+	OverflowBlock
+	// OverflowDropOldest drops the oldest queued message to make room
+	OverflowDropOldest
+)
+
+// Client queue settings
+const (
+	ClientQueueSize     = 100
+	DefaultBlockTimeout = 100 * time.Millisecond
+)
+
 // Client represents a connected chat client
 type Client struct {
 	username     string
-	incoming     chan string
+	server       *ChatServer
+	incoming     chan Message
 	outgoing     chan string
 	disconnect   chan struct{}
 	disconnected bool
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+	dropped      atomic.Uint64
 	mu           sync.RWMutex
+
+	// Messages waiting for room under OverflowBlock, oldest first
+	backlog  []blockedMessage
+	flushing bool // A flush goroutine is running
+	flushed  sync.WaitGroup
+	backMu   sync.Mutex
+}
+
+// blockedMessage is a message waiting for room in the queue until deadline
+type blockedMessage struct {
+	msg      Message
+	deadline time.Time
 }
 
-// Send sends a message to the client (non-blocking)
+// Send sends a message to the client, a full queue is handled according to
+// the overflow policy of the client
 func (c *Client) Send(message string) {
-	if c.disconnected {
-		return
+	c.server.deliverMu.Lock()
+	defer c.server.deliverMu.Unlock()
+
+	c.deliver(c.server.newMessage(message))
+}
+
+// SetOverflowPolicy sets how a full queue is handled, timeout is the longest
+// wait of OverflowBlock (DefaultBlockTimeout if zero or less)
+func (c *Client) SetOverflowPolicy(policy OverflowPolicy, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if timeout <= 0 {
+		timeout = DefaultBlockTimeout
 	}
+	c.policy, c.blockTimeout = policy, timeout
+}
+
+// Dropped returns the number of messages the client lost to a full queue
+func (c *Client) Dropped() uint64 {
+	return c.dropped.Load()
+}
 
+// deliver queues a message, deliverMu must be held so that a single
+// delivery at a time makes room in the queue. It never waits for room, see
+// flush.
+func (c *Client) deliver(message Message) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	if c.disconnected {
+		return
+	}
+
+	// Messages behind a blocked one wait too
+	c.backMu.Lock()
+	if c.flushing {
+		c.backlog = append(c.backlog, blockedMessage{message, time.Now().Add(c.blockTimeout)})
+		c.backMu.Unlock()
+		return
+	}
+	c.backMu.Unlock()
+
 	select {
 	case c.incoming <- message:
+		return
 	default:
-		// Do not block
+	}
+
+	switch c.policy {
+	case OverflowBlock:
+		c.backMu.Lock()
+		c.backlog = append(c.backlog, blockedMessage{message, time.Now().Add(c.blockTimeout)})
+		c.flushing = true
+		c.flushed.Add(1)
+		c.backMu.Unlock()
+		go c.flush()
+		return
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-c.incoming:
+				c.dropped.Add(1)
+			default:
+				// Drained meanwhile by the receiver
+			}
+			select {
+			case c.incoming <- message:
+				return
+			default:
+			}
+		}
+	}
+	c.dropped.Add(1)
+}
+
+// flush moves the backlog to the queue as room is made, a message still
+// waiting at its deadline is dropped. It returns once the backlog is empty
+// or the client is disconnected, without holding any lock while waiting.
+func (c *Client) flush() {
+	defer c.flushed.Done()
+
+	for {
+		c.backMu.Lock()
+		if len(c.backlog) == 0 {
+			c.flushing = false
+			c.backMu.Unlock()
+			return
+		}
+		next := c.backlog[0]
+		c.backMu.Unlock()
+
+		timer := time.NewTimer(time.Until(next.deadline))
+		select {
+		case c.incoming <- next.msg:
+		case <-timer.C:
+			c.dropped.Add(1)
+		case <-c.disconnect:
+			// incoming is closed once flush returned
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+
+		c.backMu.Lock()
+		c.backlog = c.backlog[1:]
+		c.backMu.Unlock()
 	}
 }
 
 // Receive returns the next message for the client (blocking)
 func (c *Client) Receive() string {
-	if msg, ok := <-c.incoming; ok {
-		return msg
-	}
-	return ""
+	msg, _ := c.ReceiveMessage()
+	return msg.Text
+}
+
+// ReceiveMessage returns the next message for the client with its ID (blocking).
+// It returns false once the client is disconnected.
+func (c *Client) ReceiveMessage() (Message, bool) {
+	msg, ok := <-c.incoming
+	return msg, ok
 }
 
 func (c *Client) do_disconnect() {
@@ -55,20 +207,114 @@ func (c *Client) do_disconnect() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	close(c.incoming)
 	close(c.disconnect)
+	c.flushed.Wait()
+	close(c.incoming)
 	c.disconnected = true
 }
 
+// DefaultHistoryLimit is the number of broadcasts retained for replay
+const DefaultHistoryLimit = 256
+
 // ChatServer manages client connections and message routing
 type ChatServer struct {
-	clients map[string]*Client
-	mu      sync.RWMutex
+	clients      map[string]*Client
+	echo         bool
+	nextID       uint64
+	history      []Message // Retained broadcasts, oldest first
+	historyLimit int
+	policy       OverflowPolicy // Overflow policy of the new clients
+	blockTimeout time.Duration
+	deliverMu    sync.Mutex // Serializes IDs assignment, delivery and history
+	mu           sync.RWMutex
+}
+
+// WithOverflowPolicy sets the overflow policy of the clients, see
+// Client.SetOverflowPolicy
+func WithOverflowPolicy(policy OverflowPolicy, timeout time.Duration) ServerOption {
+	return func(s *ChatServer) {
+		if timeout <= 0 {
+			timeout = DefaultBlockTimeout
+		}
+		s.policy, s.blockTimeout = policy, timeout
+	}
+}
+
+// ServerOption configures a ChatServer
+type ServerOption func(*ChatServer)
+
+// WithSenderEcho makes broadcasts also delivered to their sender
+func WithSenderEcho() ServerOption {
+	return func(s *ChatServer) {
+		s.echo = true
+	}
+}
+
+// WithHistoryLimit sets how many broadcasts are retained for replay,
+// a limit of zero or less disables the history
+func WithHistoryLimit(n int) ServerOption {
+	return func(s *ChatServer) {
+		s.historyLimit = n
+	}
 }
 
 // NewChatServer creates a new chat server instance
-func NewChatServer() *ChatServer {
-	return &ChatServer{clients: make(map[string]*Client)}
+func NewChatServer(opts ...ServerOption) *ChatServer {
+	s := &ChatServer{
+		clients:      make(map[string]*Client),
+		historyLimit: DefaultHistoryLimit,
+		blockTimeout: DefaultBlockTimeout,
+	}
+	for _, opt := range(opts) {
+		opt(s)
+	}
+	return s
+}
+
+// newMessage assigns the next ID, deliverMu must be held
+func (s *ChatServer) newMessage(text string) Message {
+	s.nextID++
+	return Message{ID: s.nextID, Text: text}
+}
+
+// record appends a broadcast to the history, dropping the oldest entries
+// past the limit. deliverMu must be held.
+func (s *ChatServer) record(msg Message) {
+	if s.historyLimit <= 0 {
+		return
+	}
+	if len(s.history) >= s.historyLimit {
+		n := copy(s.history, s.history[len(s.history)-s.historyLimit+1:])
+		s.history = s.history[:n]
+	}
+	s.history = append(s.history, msg)
+}
+
+// MessagesSince returns the retained broadcasts with an ID greater than seq,
+// oldest first. A reconnecting client passes the ID of the last message it
+// has seen; if that is older than the history, the replay starts from the
+// oldest message still retained.
+func (s *ChatServer) MessagesSince(seq uint64) []string {
+	messages := s.HistorySince(seq)
+	texts := make([]string, len(messages))
+	for i, msg := range(messages) {
+		texts[i] = msg.Text
+	}
+	return texts
+}
+
+// HistorySince is like MessagesSince but keeps the message IDs, so the
+// caller can track the last sequence it has seen
+func (s *ChatServer) HistorySince(seq uint64) []Message {
+	s.deliverMu.Lock()
+	defer s.deliverMu.Unlock()
+
+	i := sort.Search(len(s.history), func(i int) bool {
+		return s.history[i].ID > seq
+	})
+	messages := make([]Message, len(s.history)-i)
+	copy(messages, s.history[i:])
+	return messages
 }
 
 // Connect adds a new client to the chat server
@@ -81,10 +327,13 @@ func (s *ChatServer) Connect(username string) (*Client, error) {
 	}
 
 	client := &Client{
-		username:   username,
-		incoming:   make(chan string, 100),
-		outgoing:   make(chan string, 100),
-		disconnect: make(chan struct{}),
+		username:     username,
+		server:       s,
+		incoming:     make(chan Message, ClientQueueSize),
+		outgoing:     make(chan string, 100),
+		disconnect:   make(chan struct{}),
+		policy:       s.policy,
+		blockTimeout: s.blockTimeout,
 	}
 	s.clients[username] = client
 
@@ -106,15 +355,20 @@ func (s *ChatServer) Disconnect(client *Client) {
 	delete(s.clients, client.username)
 }
 
-// Broadcast sends a message to all connected clients
+// Broadcast sends a message to all connected clients and records it in the
+// history
 func (s *ChatServer) Broadcast(sender *Client, message string) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	msg := fmt.Sprintf("%s: %s", sender.username, message)
+	s.deliverMu.Lock()
+	defer s.deliverMu.Unlock()
+
+	msg := s.newMessage(fmt.Sprintf("%s: %s", sender.username, message))
+	s.record(msg)
 	for _, client := range(s.clients) {
-		if client.username != sender.username {
-			client.Send(msg)
+		if s.echo || client.username != sender.username {
+			client.deliver(msg)
 		}
 	}
 }
@@ -136,8 +390,7 @@ func (s *ChatServer) PrivateMessage(sender *Client, recipient string, message st
 		return ErrClientDisconnected
 	}
 
-	msg := fmt.Sprintf("(pm) %s: %s", sender.username, message)
-	target.Send(msg)
+	target.Send(fmt.Sprintf("(pm) %s: %s", sender.username, message))
 	return nil
 }
 
@@ -153,3 +406,112 @@ func (s *ChatServer) handleClient(client *Client) {
 		}
 	}
 }
+
+// WebSocket keepalive settings
+const (
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingPeriod   = wsPongWait * 9 / 10
+	wsMaxFrameSize = 4096
+)
+
+// WSInbound is a frame sent by a WebSocket client. A frame with a recipient
+// is sent as a private message, otherwise it is broadcast.
+type WSInbound struct {
+	To   string `json:"to,omitempty"`
+	Text string `json:"text"`
+}
+
+// WSOutbound is a frame delivered to a WebSocket client
+type WSOutbound struct {
+	ID   uint64 `json:"id"`
+	Text string `json:"text"`
+}
+
+// WebSocketHandler exposes a ChatServer over WebSocket, the username is
+// given by the "username" query parameter.
+type WebSocketHandler struct {
+	server   *ChatServer
+	upgrader websocket.Upgrader
+}
+
+// NewWebSocketHandler creates a WebSocket transport for the chat server
+func NewWebSocketHandler(server *ChatServer) *WebSocketHandler {
+	return &WebSocketHandler{server: server}
+}
+
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "missing username", http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.server.Connect(username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an HTTP error
+		h.server.Disconnect(client)
+		return
+	}
+
+	go h.writePump(conn, client)
+	h.readPump(conn, client)
+}
+
+// readPump forwards the socket frames to the chat server until the socket
+// is closed or stops answering pings, then disconnects the client.
+func (h *WebSocketHandler) readPump(conn *websocket.Conn, client *Client) {
+	defer h.server.Disconnect(client)
+
+	conn.SetReadLimit(wsMaxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var frame WSInbound
+		if err := conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		if frame.To == "" {
+			h.server.Broadcast(client, frame.Text)
+		} else if err := h.server.PrivateMessage(client, frame.To, frame.Text); err != nil {
+			client.Send(fmt.Sprintf("error: %v", err))
+		}
+	}
+}
+
+// writePump is the only writer of the socket, it sends the client messages
+// and the keepalive pings. It closes the socket once the client is disconnected.
+func (h *WebSocketHandler) writePump(conn *websocket.Conn, client *Client) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-client.incoming:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if ! ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteJSON(WSOutbound{ID: msg.ID, Text: msg.Text}); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
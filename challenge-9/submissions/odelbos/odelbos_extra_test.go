@@ -0,0 +1,1659 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func setupHealthServer(checks map[string]HealthCheck) *httptest.Server {
+	health := NewHealthHandler(checks)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health.HandleHealthz)
+	mux.HandleFunc("/readyz", health.HandleReadyz)
+	return httptest.NewServer(mux)
+}
+
+func getHealth(t *testing.T, url string) (int, HealthResponse) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestHealthz(t *testing.T) {
+	server := setupHealthServer(nil)
+	defer server.Close()
+
+	status, body := getHealth(t, server.URL+"/healthz")
+	if status != http.StatusOK {
+		t.Errorf("Expected status 200; got %d", status)
+	}
+	if body.Status != "ok" {
+		t.Errorf("Expected status 'ok'; got %q", body.Status)
+	}
+}
+
+func TestReadyzHealthy(t *testing.T) {
+	repo := NewInMemoryBookRepository()
+	server := setupHealthServer(map[string]HealthCheck{"store": repo.Ping})
+	defer server.Close()
+
+	status, body := getHealth(t, server.URL+"/readyz")
+	if status != http.StatusOK {
+		t.Errorf("Expected status 200; got %d", status)
+	}
+	if body.Status != "ok" || body.Checks["store"] != "ok" {
+		t.Errorf("Expected store check 'ok'; got %+v", body)
+	}
+}
+
+func TestReadyzUnavailable(t *testing.T) {
+	repo := NewInMemoryBookRepository()
+	server := setupHealthServer(map[string]HealthCheck{
+		"store": repo.Ping,
+		"db":    func() error { return errors.New("connection refused") },
+	})
+	defer server.Close()
+
+	status, body := getHealth(t, server.URL+"/readyz")
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503; got %d", status)
+	}
+	if body.Status != "unavailable" {
+		t.Errorf("Expected status 'unavailable'; got %q", body.Status)
+	}
+	if body.Checks["store"] != "ok" {
+		t.Errorf("Expected store check 'ok'; got %q", body.Checks["store"])
+	}
+	if body.Checks["db"] != "connection refused" {
+		t.Errorf("Expected db check error; got %q", body.Checks["db"])
+	}
+}
+
+func TestRepositoryPingUninitialized(t *testing.T) {
+	repo := &InMemoryBookRepository{}
+	if err := repo.Ping(); err == nil {
+		t.Error("Expected error for an uninitialized store")
+	}
+}
+
+// seedRepository returns a repository holding the given books
+func seedRepository(t *testing.T, books ...*Book) *InMemoryBookRepository {
+	t.Helper()
+	repo := NewInMemoryBookRepository()
+	if err := repo.Seed(books...); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	return repo
+}
+
+// setupBookServer serves the book API over a repository seeded with the
+// given books. The server is closed when the test ends, its handler is
+// Config.Handler.
+func setupBookServer(t *testing.T, books ...*Book) (*httptest.Server, BookService) {
+	t.Helper()
+	service := NewBookService(seedRepository(t, books...))
+	handler := NewBookHandler(service)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/books", handler.HandleBooks)
+	mux.HandleFunc("/api/books/", handler.HandleBooks)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, service
+}
+
+// numberedBooks returns count books whose titles collide by three, on
+// purpose to exercise the ID tie-breaker
+func numberedBooks(count int) []*Book {
+	books := make([]*Book, count)
+	for i := range books {
+		books[i] = &Book{
+			ID:     fmt.Sprintf("%03d", i),
+			Title:  fmt.Sprintf("Book %02d", i/3),
+			Author: fmt.Sprintf("Author %d", i%4),
+			ISBN:   fmt.Sprintf("978-%010d", i),
+		}
+	}
+	return books
+}
+
+// goBooks returns titles and authors sharing prefixes and differing by case
+func goBooks() []*Book {
+	return []*Book{
+		{ID: "1", Title: "The Go Programming Language", Author: "Alan Donovan", ISBN: "1"},
+		{ID: "2", Title: "Go in Action", Author: "William Kennedy", ISBN: "2"},
+		{ID: "3", Title: "go in action", Author: "Brian Ketelsen", ISBN: "3"},
+		{ID: "4", Title: "Gophers", Author: "alan donovan", ISBN: "4"},
+		{ID: "5", Title: "Rust in Action", Author: "Tim McNamara", ISBN: "5"},
+		{ID: "6", Title: "Go Web Programming", Author: "Sau Sheong Chang", ISBN: "6"},
+	}
+}
+
+func getPage(t *testing.T, url string) (int, BookPage) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var page BookPage
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+	}
+	return resp.StatusCode, page
+}
+
+func TestCursorPaginationWalksCatalog(t *testing.T) {
+	server, _ := setupBookServer(t, numberedBooks(25)...)
+
+	seen := make(map[string]bool)
+	var walked []*Book
+	cursor, pages := "", 0
+	for {
+		status, page := getPage(t, fmt.Sprintf("%s/api/books?limit=7&cursor=%s", server.URL, cursor))
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200; got %d", status)
+		}
+		pages++
+		for _, book := range page.Items {
+			if seen[book.ID] {
+				t.Fatalf("Book %s returned twice", book.ID)
+			}
+			seen[book.ID] = true
+		}
+		walked = append(walked, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(walked) != 25 {
+		t.Errorf("Expected 25 books; got %d", len(walked))
+	}
+	if pages != 4 {
+		t.Errorf("Expected 4 pages; got %d", pages)
+	}
+	sorted := sort.SliceIsSorted(walked, func(i, j int) bool {
+		if walked[i].Title != walked[j].Title {
+			return walked[i].Title < walked[j].Title
+		}
+		return walked[i].ID < walked[j].ID
+	})
+	if !sorted {
+		t.Error("Expected books sorted by title then ID")
+	}
+}
+
+func TestGetAfterFollowsUpdates(t *testing.T) {
+	repo := seedRepository(t, numberedBooks(10)...)
+	ctx := context.Background()
+	repo.Update(ctx, "004", &Book{Title: "A first book", ISBN: "978-0000000004"})
+	repo.Update(ctx, "000", &Book{Title: "Book 09", ISBN: "978-0000000000"})
+	repo.Delete(ctx, "007")
+	repo.Create(ctx, &Book{ID: "010", Title: "Book 02", ISBN: "978-0000000010"})
+
+	var walked []string
+	for cursor := ""; ; {
+		books, next, err := repo.GetAfter(ctx, cursor, 3)
+		if err != nil {
+			t.Fatalf("GetAfter failed: %v", err)
+		}
+		for _, book := range books {
+			walked = append(walked, book.Title+"/"+book.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	expected := []string{
+		"A first book/004", "Book 00/001", "Book 00/002", "Book 01/003", "Book 01/005",
+		"Book 02/006", "Book 02/008", "Book 02/010", "Book 03/009", "Book 09/000",
+	}
+	if fmt.Sprint(walked) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v; got %v", expected, walked)
+	}
+}
+
+func TestCursorPaginationEndOfData(t *testing.T) {
+	server, service := setupBookServer(t, numberedBooks(3)...)
+
+	books, _ := service.GetAllBooks(context.Background())
+	last := books[0]
+	for _, book := range books {
+		if book.Title > last.Title || (book.Title == last.Title && book.ID > last.ID) {
+			last = book
+		}
+	}
+
+	status, page := getPage(t, fmt.Sprintf("%s/api/books?cursor=%s", server.URL, encodeCursor(last)))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200; got %d", status)
+	}
+	if len(page.Items) != 0 || page.NextCursor != "" {
+		t.Errorf("Expected an empty last page; got %d items and cursor %q", len(page.Items), page.NextCursor)
+	}
+}
+
+func TestCursorPaginationInvalidInput(t *testing.T) {
+	server, _ := setupBookServer(t, numberedBooks(3)...)
+
+	for _, query := range []string{"cursor=not-a-cursor!", "cursor=e30", "limit=0", "limit=abc", "limit=1000"} {
+		status, _ := getPage(t, fmt.Sprintf("%s/api/books?%s", server.URL, query))
+		if status != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q; got %d", query, status)
+		}
+	}
+}
+
+func TestGetAllBooksWithoutPagination(t *testing.T) {
+	server, _ := setupBookServer(t, numberedBooks(3)...)
+
+	resp, err := http.Get(server.URL + "/api/books")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var books []*Book
+	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+		t.Fatalf("Expected a plain array without pagination params: %v", err)
+	}
+	if len(books) != 3 {
+		t.Errorf("Expected 3 books; got %d", len(books))
+	}
+}
+
+func autocomplete(t *testing.T, server *httptest.Server, query string) (int, []string) {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/api/books/autocomplete?" + query)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var results []string
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+	}
+	return resp.StatusCode, results
+}
+
+func TestAutocomplete(t *testing.T) {
+	server, _ := setupBookServer(t, goBooks()...)
+
+	status, titles := autocomplete(t, server, "field=title&prefix=GO")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200; got %d", status)
+	}
+	expected := []string{"Go in Action", "Go Web Programming", "Gophers"}
+	if fmt.Sprint(titles) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v; got %v", expected, titles)
+	}
+
+	_, authors := autocomplete(t, server, "field=author&prefix=al")
+	if len(authors) != 1 || authors[0] != "Alan Donovan" {
+		t.Errorf("Expected distinct [Alan Donovan]; got %v", authors)
+	}
+
+	_, limited := autocomplete(t, server, "field=title&prefix=g&limit=2")
+	if len(limited) != 2 {
+		t.Errorf("Expected 2 results with limit=2; got %v", limited)
+	}
+}
+
+func TestAutocompleteFollowsUpdates(t *testing.T) {
+	server, service := setupBookServer(t, goBooks()...)
+
+	books, _ := service.SearchBooksByTitle(context.Background(), "Rust")
+	rust := books[0]
+	if err := service.UpdateBook(context.Background(), rust.ID, &Book{Title: "Gleam in Action", Author: rust.Author, ISBN: rust.ISBN}); err != nil {
+		t.Fatalf("Failed to update book: %v", err)
+	}
+	if _, titles := autocomplete(t, server, "field=title&prefix=rust"); len(titles) != 0 {
+		t.Errorf("Expected the old title to be unindexed; got %v", titles)
+	}
+	if _, titles := autocomplete(t, server, "field=title&prefix=gl"); len(titles) != 1 {
+		t.Errorf("Expected the new title to be indexed; got %v", titles)
+	}
+
+	books, _ = service.SearchBooksByTitle(context.Background(), "Gophers")
+	if err := service.DeleteBook(context.Background(), books[0].ID); err != nil {
+		t.Fatalf("Failed to delete book: %v", err)
+	}
+	// "alan donovan" is still the author of another book
+	if _, authors := autocomplete(t, server, "field=author&prefix=alan"); len(authors) != 1 {
+		t.Errorf("Expected the shared author to stay indexed; got %v", authors)
+	}
+	if _, titles := autocomplete(t, server, "field=title&prefix=gophers"); len(titles) != 0 {
+		t.Errorf("Expected the deleted title to be unindexed; got %v", titles)
+	}
+}
+
+func TestAutocompleteInvalidInput(t *testing.T) {
+	server, _ := setupBookServer(t, goBooks()...)
+
+	for _, query := range []string{"field=title", "field=isbn&prefix=g", "field=title&prefix=g&limit=0", "prefix=g"} {
+		if status, _ := autocomplete(t, server, query); status != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q; got %d", query, status)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("x", 40) + "Go" + strings.Repeat("y", 40)
+
+	tests := []struct {
+		name     string
+		text     string
+		query    string
+		expected string
+	}{
+		{"case insensitive", "The Go Programming Language", "go", "The <mark>Go</mark> Programming Language"},
+		{"all matches", "go go GO", "go", "<mark>go</mark> <mark>go</mark> <mark>GO</mark>"},
+		{"overlapping", "aaaa", "aa", "<mark>aa</mark><mark>aa</mark>"},
+		{"overlapping odd", "aaa", "aa", "<mark>aa</mark>a"},
+		{"escaped", "Tom & <Jerry>", "jerry", "Tom &amp; &lt;<mark>Jerry</mark>&gt;"},
+		{"unicode", "Les Misérables", "MISÉ", "Les <mark>Misé</mark>rables"},
+		{"snippet", long, "go", "…" + strings.Repeat("x", 30) + "<mark>Go</mark>" + strings.Repeat("y", 30) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippet, ok := highlight(tt.text, tt.query)
+			if !ok || snippet != tt.expected {
+				t.Errorf("Expected %q; got %q (%v)", tt.expected, snippet, ok)
+			}
+		})
+	}
+
+	if _, ok := highlight("Gophers", "rust"); ok {
+		t.Error("Expected no snippet without a match")
+	}
+}
+
+func TestSearchHighlight(t *testing.T) {
+	server, _ := setupBookServer(t, goBooks()...)
+
+	resp, err := http.Get(server.URL + "/api/books/search?title=Action&highlight=true")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var hits []SearchHit
+	if err := json.NewDecoder(resp.Body).Decode(&hits); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	// The search itself is case-sensitive, "go in action" is not a hit
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 hits; got %d", len(hits))
+	}
+	for _, hit := range hits {
+		if hit.Field != "title" || hit.Book == nil {
+			t.Errorf("Unexpected hit: %+v", hit)
+			continue
+		}
+		expected := strings.Replace(hit.Book.Title, "Action", "<mark>Action</mark>", 1)
+		if hit.Snippet != expected {
+			t.Errorf("Expected snippet %q; got %q", expected, hit.Snippet)
+		}
+	}
+
+	// Without highlight the response is still a plain list of books
+	resp, err = http.Get(server.URL + "/api/books/search?author=Alan")
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+	var books []*Book
+	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil || len(books) != 1 || books[0].Author != "Alan Donovan" {
+		t.Errorf("Expected the plain book list; got %v (%v)", books, err)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	server, service := setupBookServer(t, numberedBooks(1)...)
+	books, _ := service.GetAllBooks(context.Background())
+	id := books[0].ID
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"unknown endpoint", http.MethodPatch, "/api/books", "", http.StatusNotFound, "not_found", ""},
+		{"get unknown", http.MethodGet, "/api/books/missing", "", http.StatusNotFound, "not_found", ""},
+		{"update unknown", http.MethodPut, "/api/books/missing", `{"title":"T","author":"A","isbn":"1"}`, http.StatusNotFound, "not_found", ""},
+		{"delete unknown", http.MethodDelete, "/api/books/missing", "", http.StatusNotFound, "not_found", ""},
+		{"create invalid JSON", http.MethodPost, "/api/books", "{", http.StatusBadRequest, "bad_request", ""},
+		{"create missing title", http.MethodPost, "/api/books", `{"author":"A","isbn":"1"}`, http.StatusBadRequest, "validation_error", "title"},
+		{"update missing author", http.MethodPut, "/api/books/" + id, `{"title":"T","isbn":"1"}`, http.StatusBadRequest, "validation_error", "author"},
+		{"update invalid JSON", http.MethodPut, "/api/books/" + id, "{", http.StatusBadRequest, "bad_request", ""},
+		{"search without params", http.MethodGet, "/api/books/search", "", http.StatusBadRequest, "bad_request", ""},
+		{"invalid cursor", http.MethodGet, "/api/books?cursor=e30", "", http.StatusBadRequest, "bad_request", ""},
+		{"invalid limit", http.MethodGet, "/api/books?limit=abc", "", http.StatusBadRequest, "bad_request", ""},
+		{"autocomplete empty prefix", http.MethodGet, "/api/books/autocomplete?field=title", "", http.StatusBadRequest, "bad_request", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d; got %d", tt.status, resp.StatusCode)
+			}
+			var apiErr APIError
+			if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+				t.Fatalf("Failed to decode error envelope: %v", err)
+			}
+			if apiErr.Code != tt.code || apiErr.Message == "" {
+				t.Errorf("Expected code %q with a message; got %+v", tt.code, apiErr)
+			}
+			if apiErr.Details["field"] != tt.field {
+				t.Errorf("Expected field %q in details; got %v", tt.field, apiErr.Details)
+			}
+		})
+	}
+}
+
+func TestMapErrorToStatusCode(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{ErrBookNotFound, http.StatusNotFound},
+		{fmt.Errorf("get: %w", ErrBookNotFound), http.StatusNotFound},
+		{ErrBookExists, http.StatusConflict},
+		{ErrUnsupportedMedia, http.StatusUnsupportedMediaType},
+		{&ValidationError{Field: "title", Message: "title is required"}, http.StatusBadRequest},
+		{ErrStoreUninitialized, http.StatusInternalServerError},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if status := mapErrorToStatusCode(tt.err); status != tt.status {
+			t.Errorf("Expected status %d for %v; got %d", tt.status, tt.err, status)
+		}
+	}
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, errors.New("database password is hunter2"))
+
+	var apiErr APIError
+	json.NewDecoder(w.Body).Decode(&apiErr)
+	if w.Code != http.StatusInternalServerError || apiErr.Code != "internal_error" || apiErr.Message != "internal server error" {
+		t.Errorf("Expected a generic internal error; got %d %+v", w.Code, apiErr)
+	}
+}
+
+// scanSearch is the reference full-scan substring search
+func scanSearch(books []*Book, field func(*Book) string, query string) []string {
+	var ids []string
+	for _, book := range books {
+		if strings.Contains(field(book), query) {
+			ids = append(ids, book.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func bookIDs(books []*Book) []string {
+	ids := make([]string, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestIndexedSearchMatchesScan(t *testing.T) {
+	repo := NewInMemoryBookRepository()
+	titles := []string{"The Go Programming Language", "Go in Action", "go in action", "Gophers", "Rust in Action", "Ação e Reação", "aaaa"}
+	authors := []string{"Alan Donovan", "William Kennedy", "Brian Ketelsen", "alan donovan", "Tim McNamara", "José Saramago", "Ann Aa"}
+	for i := range titles {
+		repo.Create(context.Background(), &Book{ID: fmt.Sprint(i), Title: titles[i], Author: authors[i], ISBN: fmt.Sprint(i)})
+	}
+
+	// Updates and deletes keep the index in sync
+	books, _ := repo.SearchByTitle(context.Background(), "Rust")
+	repo.Update(context.Background(), books[0].ID, &Book{Title: "Gleam in Action", Author: "Louis Pilfold", ISBN: "9"})
+	books, _ = repo.SearchByTitle(context.Background(), "Gophers")
+	repo.Delete(context.Background(), books[0].ID)
+
+	all, _ := repo.GetAll(context.Background())
+	queries := []string{"Go", "go", "Action", "in Action", "ction", "Rust", "Gleam", "Ação", "ção", "aaa", "aa", "Alan", "an", "Don", "Kennedy", "x", "zzzz", "Gophers"}
+	for _, q := range queries {
+		byTitle, _ := repo.SearchByTitle(context.Background(), q)
+		if got, want := bookIDs(byTitle), scanSearch(all, func(b *Book) string { return b.Title }, q); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Title search %q: expected %v; got %v", q, want, got)
+		}
+		byAuthor, _ := repo.SearchByAuthor(context.Background(), q)
+		if got, want := bookIDs(byAuthor), scanSearch(all, func(b *Book) string { return b.Author }, q); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Author search %q: expected %v; got %v", q, want, got)
+		}
+	}
+}
+
+func largeRepository(b *testing.B) (*InMemoryBookRepository, []*Book) {
+	b.Helper()
+	repo := NewInMemoryBookRepository()
+	for i := 0; i < 50000; i++ {
+		repo.Create(context.Background(), &Book{
+			ID:     fmt.Sprint(i),
+			Title:  fmt.Sprintf("Volume %d of the collected works", i),
+			Author: fmt.Sprintf("Author %d", i%1000),
+			ISBN:   fmt.Sprint(i),
+		})
+	}
+	all, _ := repo.GetAll(context.Background())
+	return repo, all
+}
+
+func BenchmarkSearchByTitleIndexed(b *testing.B) {
+	repo, _ := largeRepository(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.SearchByTitle(context.Background(), "Volume 4242 ")
+	}
+}
+
+func BenchmarkSearchByTitleScan(b *testing.B) {
+	_, all := largeRepository(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanSearch(all, func(b *Book) string { return b.Title }, "Volume 4242 ")
+	}
+}
+
+func TestNewBookRepository(t *testing.T) {
+	for _, backend := range []string{"", BackendMemory} {
+		repo, err := NewBookRepository(backend)
+		if err != nil {
+			t.Fatalf("Unexpected error for backend %q: %v", backend, err)
+		}
+		if _, ok := repo.(*InMemoryBookRepository); !ok {
+			t.Errorf("Expected an in-memory repository for backend %q, got %T", backend, repo)
+		}
+	}
+
+	if _, err := NewBookRepository(BackendSQLite); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("Expected ErrBackendUnavailable for sqlite, got %v", err)
+	}
+	for _, backend := range []string{"postgres", "Memory", " memory"} {
+		repo, err := NewBookRepository(backend)
+		if !errors.Is(err, ErrUnknownBackend) || repo != nil {
+			t.Errorf("Expected ErrUnknownBackend for %q, got %v, %v", backend, repo, err)
+		}
+	}
+}
+
+func TestInMemoryRepositorySeed(t *testing.T) {
+	repo := NewInMemoryBookRepository()
+	if err := repo.Create(context.Background(), &Book{ID: "old", Title: "Old Book", Author: "Someone"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	err := repo.Seed(
+		&Book{ID: "1", Title: "Dune", Author: "Frank Herbert"},
+		&Book{ID: "2", Title: "Emma", Author: "Jane Austen"},
+	)
+	if err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+	books, _ := repo.GetAll(context.Background())
+	if len(books) != 2 {
+		t.Fatalf("Expected 2 seeded books, got %d", len(books))
+	}
+	if _, err := repo.GetByID(context.Background(), "old"); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("Expected the previous book to be cleared, got %v", err)
+	}
+	if hits, _ := repo.SearchByTitle(context.Background(), "Old"); len(hits) != 0 {
+		t.Errorf("Expected the search index to be reset, got %d hits", len(hits))
+	}
+	if hits, _ := repo.SearchByAuthor(context.Background(), "Austen"); len(hits) != 1 || hits[0].ID != "2" {
+		t.Errorf("Expected the seeded books to be indexed, got %v", hits)
+	}
+
+	// A failed seed leaves the store unchanged
+	err = repo.Seed(&Book{ID: "3", Title: "A"}, &Book{ID: "3", Title: "B"})
+	if !errors.Is(err, ErrBookExists) {
+		t.Errorf("Expected ErrBookExists for duplicate IDs, got %v", err)
+	}
+	if books, _ := repo.GetAll(context.Background()); len(books) != 2 {
+		t.Errorf("Expected the 2 seeded books to remain, got %d", len(books))
+	}
+}
+
+// fakeDB records whether it was closed
+type fakeDB struct {
+	closed atomic.Bool
+}
+
+func (db *fakeDB) Close() error {
+	db.closed.Store(true)
+	return nil
+}
+
+// freeAddr returns a local address that is free to listen on
+func freeAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func TestRunGracefulShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	// No keep-alive: a pooled connection that never sent a request would
+	// delay the shutdown
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	db := &fakeDB{}
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, addr, mux, db)
+	}()
+
+	// Wait for the server to accept requests
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := client.Get("http://" + addr + "/ping")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// An in-flight request completes during the shutdown
+	slow := make(chan int, 1)
+	go func() {
+		resp, err := client.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if db.closed.Load() {
+		t.Error("Expected the DB to stay open while requests are in flight")
+	}
+	close(release)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after cancellation")
+	}
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("Expected the in-flight request to complete, got status %d", code)
+	}
+	if !db.closed.Load() {
+		t.Error("Expected the DB to be closed after shutdown")
+	}
+	if _, err := client.Get("http://" + addr + "/ping"); err == nil {
+		t.Error("Expected the server to stop accepting requests")
+	}
+}
+
+func TestRunListenError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+
+	db := &fakeDB{}
+	if err := run(context.Background(), lis.Addr().String(), http.NewServeMux(), db); err == nil {
+		t.Error("Expected an error when the address is in use")
+	}
+	if !db.closed.Load() {
+		t.Error("Expected the DB to be closed when the server fails to start")
+	}
+}
+
+// slowRepository is a repository whose GetAll takes a second unless cancelled
+type slowRepository struct {
+	*InMemoryBookRepository
+}
+
+func (r slowRepository) GetAll(ctx context.Context) ([]*Book, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Second):
+		return r.InMemoryBookRepository.GetAll(ctx)
+	}
+}
+
+func TestRepositoryHonorsContext(t *testing.T) {
+	repo := NewInMemoryBookRepository()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := repo.Create(ctx, &Book{ID: "1", Title: "Dune"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Create to fail with context.Canceled, got %v", err)
+	}
+	if books, _ := repo.GetAll(context.Background()); len(books) != 0 {
+		t.Errorf("Expected the cancelled Create to store nothing, got %d books", len(books))
+	}
+	if _, err := repo.GetAll(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetAll to fail with context.Canceled, got %v", err)
+	}
+	if _, _, err := repo.GetAfter(ctx, "", 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetAfter to fail with context.Canceled, got %v", err)
+	}
+}
+
+func TestHandlerRequestTimeout(t *testing.T) {
+	handler := NewBookHandler(NewBookService(slowRepository{NewInMemoryBookRepository()}))
+	handler.Timeout = 20 * time.Millisecond
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.HandleBooks(w, httptest.NewRequest(http.MethodGet, "/api/books", nil))
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the request to be aborted at the deadline, took %v", elapsed)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	var apiErr APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || apiErr.Code != "timeout" {
+		t.Errorf("Expected a timeout error envelope, got %s", w.Body.String())
+	}
+}
+
+func TestHandlerClientCancellation(t *testing.T) {
+	handler := NewBookHandler(NewBookService(slowRepository{NewInMemoryBookRepository()}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/books", nil).WithContext(ctx)
+	handler.HandleBooks(w, req)
+
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("Expected status %d, got %d", statusClientClosedRequest, w.Code)
+	}
+	var apiErr APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || apiErr.Code != "request_cancelled" {
+		t.Errorf("Expected a request_cancelled error envelope, got %s", w.Body.String())
+	}
+}
+
+// compressBookServer adds a plain text page to the book server handler and
+// compresses both
+func compressBookServer(server *httptest.Server) http.Handler {
+	mux := server.Config.Handler.(*http.ServeMux)
+	mux.HandleFunc("/readme", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("plain text ", 500)))
+	})
+	return Compress(mux, DefaultCompressionConfig())
+}
+
+func getEncoded(h http.Handler, path, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCompressLargeList(t *testing.T) {
+	server, _ := setupBookServer(t, numberedBooks(50)...)
+	h := compressBookServer(server)
+
+	rec := getEncoded(h, "/api/books", "br, gzip;q=0.8")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %q", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip body: %v", err)
+	}
+	var books []*Book
+	if err := json.NewDecoder(zr).Decode(&books); err != nil {
+		t.Fatalf("Invalid JSON body: %v", err)
+	}
+	if len(books) != 50 {
+		t.Errorf("Expected 50 books, got %d", len(books))
+	}
+
+	// Without gzip in Accept-Encoding the list is sent as is
+	for _, accept := range []string{"", "identity", "gzip;q=0"} {
+		rec = getEncoded(h, "/api/books", accept)
+		if got := rec.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("Accept-Encoding %q: expected no encoding, got %q", accept, got)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&books); err != nil {
+			t.Errorf("Accept-Encoding %q: invalid JSON body: %v", accept, err)
+		}
+	}
+}
+
+func TestCompressSkipsSmallErrors(t *testing.T) {
+	server, _ := setupBookServer(t, numberedBooks(50)...)
+	h := compressBookServer(server)
+
+	rec := getEncoded(h, "/api/books/missing", "gzip")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected no encoding, got %q", got)
+	}
+	var apiErr APIError
+	if err := json.NewDecoder(rec.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Invalid JSON body: %v", err)
+	}
+	if apiErr.Code != "not_found" {
+		t.Errorf("Expected code not_found, got %q", apiErr.Code)
+	}
+}
+
+func TestCompressSkipsOtherContentTypes(t *testing.T) {
+	server, _ := setupBookServer(t)
+	h := compressBookServer(server)
+
+	rec := getEncoded(h, "/readme", "gzip")
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected no encoding, got %q", got)
+	}
+	if rec.Body.Len() != len("plain text ")*500 {
+		t.Errorf("Expected the body untouched, got %d bytes", rec.Body.Len())
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                false,
+		"gzip":            true,
+		"GZIP":            true,
+		"deflate, gzip":   true,
+		"gzip;q=0":        false,
+		"gzip; q=0.5":     true,
+		"*":               true,
+		"br, identity":    false,
+		"gzip;q=0, *;q=1": true,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+// createBook posts a book, with the Idempotent header if idempotent is set
+func createBook(t *testing.T, url, isbn, title string, idempotent bool) (int, Book) {
+	t.Helper()
+	body := fmt.Sprintf(`{"title":%q,"author":"Author","published_year":2020,"isbn":%q}`, title, isbn)
+	req, _ := http.NewRequest("POST", url+"/api/books", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if idempotent {
+		req.Header.Set("Idempotent", "true")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make POST request: %v", err)
+	}
+	defer resp.Body.Close()
+	var book Book
+	json.NewDecoder(resp.Body).Decode(&book)
+	return resp.StatusCode, book
+}
+
+func TestCreateBookIdempotent(t *testing.T) {
+	server, service := setupBookServer(t)
+
+	status, created := createBook(t, server.URL, "978-0000000001", "Original", true)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	// A resent create returns the stored book, the new fields are ignored
+	status, existing := createBook(t, server.URL, "978-0000000001", "Resent", true)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if existing.ID != created.ID || existing.Title != "Original" {
+		t.Errorf("Expected the existing book %+v, got %+v", created, existing)
+	}
+
+	// Without the header a duplicate ISBN is a conflict
+	if status, _ := createBook(t, server.URL, "978-0000000001", "Resent", false); status != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", status)
+	}
+
+	books, _ := service.GetAllBooks(context.Background())
+	if len(books) != 1 {
+		t.Errorf("Expected 1 book stored, got %d", len(books))
+	}
+}
+
+func TestUpdateBookDuplicateISBN(t *testing.T) {
+	first := &Book{ID: "1", Title: "First", ISBN: "978-1"}
+	second := &Book{ID: "2", Title: "Second", ISBN: "978-2"}
+	repo := seedRepository(t, first, second)
+	ctx := context.Background()
+
+	err := repo.Update(ctx, "2", &Book{Title: "Second", ISBN: "978-1"})
+	var dup *DuplicateISBNError
+	if !errors.As(err, &dup) || dup.Existing != first || !errors.Is(err, ErrBookExists) {
+		t.Fatalf("Expected a duplicate ISBN error, got %v", err)
+	}
+
+	// Keeping its own ISBN or releasing one is allowed
+	if err := repo.Update(ctx, "2", &Book{Title: "Renamed", ISBN: "978-2"}); err != nil {
+		t.Errorf("Expected the update to succeed: %v", err)
+	}
+	if err := repo.Update(ctx, "1", &Book{Title: "First", ISBN: "978-3"}); err != nil {
+		t.Fatalf("Expected the update to succeed: %v", err)
+	}
+	if err := repo.Create(ctx, &Book{ID: "3", ISBN: "978-1"}); err != nil {
+		t.Errorf("Expected the released ISBN to be available: %v", err)
+	}
+}
+
+func TestUpdateIf(t *testing.T) {
+	repo := seedRepository(t, &Book{ID: "1", Title: "Dune", Author: "Frank Herbert", ISBN: "978-1"})
+	ctx := context.Background()
+
+	// The expected book is compared by content, its ID may be left out
+	expected := &Book{Title: "Dune", Author: "Frank Herbert", ISBN: "978-1"}
+	ok, err := repo.UpdateIf(ctx, "1", expected, &Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "978-1"})
+	if err != nil || !ok {
+		t.Fatalf("Expected the update to be applied, got %v, %v", ok, err)
+	}
+	book, _ := repo.GetByID(ctx, "1")
+	if book.Title != "Dune Messiah" || book.ID != "1" {
+		t.Errorf("Expected the updated book, got %+v", book)
+	}
+	if titles, _ := repo.Autocomplete(ctx, "title", "dune", 10); len(titles) != 1 || titles[0] != "Dune Messiah" {
+		t.Errorf("Expected the indexes to follow the update, got %v", titles)
+	}
+}
+
+func TestUpdateIfMismatch(t *testing.T) {
+	stored := &Book{ID: "1", Title: "Dune", Author: "Frank Herbert", ISBN: "978-1"}
+	repo := seedRepository(t, stored)
+	ctx := context.Background()
+
+	// A stale read, the book changed since
+	stale := &Book{ID: "1", Title: "Dune", Author: "F. Herbert", ISBN: "978-1"}
+	ok, err := repo.UpdateIf(ctx, "1", stale, &Book{Title: "Lost update", ISBN: "978-1"})
+	if err != nil || ok {
+		t.Fatalf("Expected the update to be refused, got %v, %v", ok, err)
+	}
+	if book, _ := repo.GetByID(ctx, "1"); book != stored {
+		t.Errorf("Expected the stored book to be unchanged, got %+v", book)
+	}
+	if ok, err := repo.UpdateIf(ctx, "1", nil, &Book{Title: "Lost update", ISBN: "978-1"}); err != nil || ok {
+		t.Errorf("Expected a nil expected book to be refused, got %v, %v", ok, err)
+	}
+}
+
+func TestUpdateIfMissing(t *testing.T) {
+	repo := NewInMemoryBookRepository()
+	ok, err := repo.UpdateIf(context.Background(), "missing", &Book{}, &Book{Title: "New"})
+	if ok || !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("Expected ErrBookNotFound, got %v, %v", ok, err)
+	}
+	if books, _ := repo.GetAll(context.Background()); len(books) != 0 {
+		t.Errorf("Expected nothing stored, got %d books", len(books))
+	}
+}
+
+func TestUpdateIfConcurrent(t *testing.T) {
+	original := &Book{ID: "1", Title: "Dune", ISBN: "978-1"}
+	repo := seedRepository(t, original)
+	ctx := context.Background()
+
+	var applied atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			update := &Book{Title: fmt.Sprintf("Dune %d", i), ISBN: "978-1"}
+			if ok, _ := repo.UpdateIf(ctx, "1", original, update); ok {
+				applied.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := applied.Load(); got != 1 {
+		t.Errorf("Expected exactly one update to win, got %d", got)
+	}
+}
+
+// sequenceIDs generates the IDs book-1, book-2, ...
+type sequenceIDs struct {
+	next atomic.Int64
+}
+
+func (g *sequenceIDs) NewID() string {
+	return fmt.Sprintf("book-%d", g.next.Add(1))
+}
+
+func TestCreateBookIDGenerator(t *testing.T) {
+	service := NewBookServiceWithIDGenerator(NewInMemoryBookRepository(), &sequenceIDs{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/books", NewBookHandler(service).HandleBooks)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for i, want := range []string{"book-1", "book-2"} {
+		status, book := createBook(t, server.URL, fmt.Sprintf("978-000000000%d", i), "Title", false)
+		if status != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", status)
+		}
+		if book.ID != want {
+			t.Errorf("Expected ID %q, got %q", want, book.ID)
+		}
+	}
+
+	// A rejected book does not consume an ID
+	if err := service.CreateBook(context.Background(), &Book{}); err == nil {
+		t.Fatal("Expected a validation error")
+	}
+	book := &Book{Title: "Third", Author: "Author", PublishedYear: 2020, ISBN: "978-0000000009"}
+	if err := service.CreateBook(context.Background(), book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	if book.ID != "book-3" {
+		t.Errorf("Expected ID book-3, got %q", book.ID)
+	}
+	if got, err := service.GetBookByID(context.Background(), "book-2"); err != nil || got.ISBN != "978-0000000001" {
+		t.Errorf("Expected book-2 to be stored, got %+v, %v", got, err)
+	}
+}
+
+func TestNewBookServiceDefaultIDs(t *testing.T) {
+	for _, service := range []*DefaultBookService{
+		NewBookService(NewInMemoryBookRepository()),
+		NewBookServiceWithIDGenerator(NewInMemoryBookRepository(), nil),
+	} {
+		book := &Book{Title: "Title", Author: "Author", PublishedYear: 2020, ISBN: "978-0000000001"}
+		if err := service.CreateBook(context.Background(), book); err != nil {
+			t.Fatalf("Failed to create book: %v", err)
+		}
+		if _, err := uuid.Parse(book.ID); err != nil {
+			t.Errorf("Expected a UUID, got %q", book.ID)
+		}
+	}
+}
+
+// sendBook sends a book with the given Content-Type header, none if empty
+func sendBook(t *testing.T, handler http.Handler, method, path, contentType string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := `{"title":"Title","author":"Author","published_year":2020,"isbn":"978-0000000001"}`
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestStrictContentType(t *testing.T) {
+	handler := NewBookHandler(NewBookServiceWithIDGenerator(NewInMemoryBookRepository(), &sequenceIDs{}))
+	handler.StrictContentType = true
+	books := http.HandlerFunc(handler.HandleBooks)
+
+	for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+		w := sendBook(t, books, "POST", "/api/books", contentType)
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected status 415 for %q, got %d", contentType, w.Code)
+		}
+		var apiErr APIError
+		json.NewDecoder(w.Body).Decode(&apiErr)
+		if apiErr.Code != "unsupported_media_type" {
+			t.Errorf("Expected code unsupported_media_type, got %q", apiErr.Code)
+		}
+	}
+	if w := sendBook(t, books, "PUT", "/api/books/book-1", "text/plain"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for PUT, got %d", w.Code)
+	}
+
+	if w := sendBook(t, books, "POST", "/api/books", "application/json; charset=utf-8"); w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", w.Code)
+	}
+	if w := sendBook(t, books, "PUT", "/api/books/book-1", "application/json"); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for PUT, got %d", w.Code)
+	}
+	// Requests without a body are not concerned
+	req := httptest.NewRequest("GET", "/api/books/book-1", nil)
+	w := httptest.NewRecorder()
+	books.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for GET, got %d", w.Code)
+	}
+}
+
+func TestLenientContentType(t *testing.T) {
+	books := http.HandlerFunc(NewBookHandler(NewBookService(NewInMemoryBookRepository())).HandleBooks)
+
+	if w := sendBook(t, books, "POST", "/api/books", ""); w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 without Content-Type, got %d", w.Code)
+	}
+}
+
+// getSorted lists the books with the given sort parameter, returning their IDs
+func getSorted(t *testing.T, serverURL, sort string) (int, []string) {
+	t.Helper()
+	resp, err := http.Get(serverURL + "/api/books?sort=" + url.QueryEscape(sort))
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var books []*Book
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+	}
+	ids := make([]string, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+	return resp.StatusCode, ids
+}
+
+// novels returns books of two authors, with a tie on the published year
+func novels() []*Book {
+	return []*Book{
+		{ID: "1", Title: "Dune", Author: "Herbert", PublishedYear: 1965, ISBN: "978-1"},
+		{ID: "2", Title: "Emma", Author: "Austen", PublishedYear: 1815, ISBN: "978-2"},
+		{ID: "3", Title: "Persuasion", Author: "Austen", PublishedYear: 1817, ISBN: "978-3"},
+		{ID: "4", Title: "Children of Dune", Author: "Herbert", PublishedYear: 1976, ISBN: "978-4"},
+		{ID: "5", Title: "Mansfield Park", Author: "Austen", PublishedYear: 1814, ISBN: "978-5"},
+		{ID: "6", Title: "Dune Messiah", Author: "Herbert", PublishedYear: 1969, ISBN: "978-6"},
+		{ID: "7", Title: "Sanditon", Author: "Austen", PublishedYear: 1817, ISBN: "978-7"},
+	}
+}
+
+func TestGetAllBooksSorted(t *testing.T) {
+	server, _ := setupBookServer(t, novels()...)
+
+	tests := []struct {
+		sort string
+		ids  []string
+	}{
+		// Ties on the author are ordered by year, then by ID
+		{"author,published_year", []string{"5", "2", "3", "7", "1", "6", "4"}},
+		{"author,-published_year", []string{"3", "7", "2", "5", "4", "6", "1"}},
+		{"-author,title", []string{"4", "1", "6", "2", "5", "3", "7"}},
+		{"-published_year", []string{"4", "6", "1", "3", "7", "2", "5"}},
+		{" title , -id ", []string{"4", "1", "6", "2", "5", "3", "7"}},
+	}
+	for _, tt := range tests {
+		status, ids := getSorted(t, server.URL, tt.sort)
+		if status != http.StatusOK {
+			t.Errorf("Expected status 200 for %q; got %d", tt.sort, status)
+			continue
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+			t.Errorf("Expected %v for %q; got %v", tt.ids, tt.sort, ids)
+		}
+	}
+}
+
+func TestGetAllBooksInvalidSort(t *testing.T) {
+	server, _ := setupBookServer(t, novels()...)
+
+	for _, sort := range []string{"price", "author,-author", "title,title", "", "author,", "--title"} {
+		if status, _ := getSorted(t, server.URL, sort); status != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q; got %d", sort, status)
+		}
+	}
+	if status, _ := getPage(t, server.URL+"/api/books?limit=2&sort=author"); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for sort with pagination; got %d", status)
+	}
+}
+
+// getExport requests the CSV export with the given headers
+func getExport(handler http.Handler, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/books/export.csv", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// setupExportHandler serves the books of exportCSV
+func setupExportHandler(t *testing.T) http.Handler {
+	t.Helper()
+	server, _ := setupBookServer(t,
+		&Book{ID: "2", Title: "Emma", Author: "Austen", PublishedYear: 1815, ISBN: "978-2"},
+		&Book{ID: "1", Title: "Dune", Author: "Herbert", PublishedYear: 1965, ISBN: "978-1", Description: "Spice, \"sand\""},
+	)
+	return server.Config.Handler
+}
+
+const exportCSV = "id,title,author,published_year,isbn,description\n" +
+	"1,Dune,Herbert,1965,978-1,\"Spice, \"\"sand\"\"\"\n" +
+	"2,Emma,Austen,1815,978-2,\n"
+
+func TestExportCSV(t *testing.T) {
+	w := getExport(setupExportHandler(t), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if got := w.Body.String(); got != exportCSV {
+		t.Errorf("Expected body %q, got %q", exportCSV, got)
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Expected Accept-Ranges bytes, got %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Errorf("Expected a CSV Content-Type, got %q", got)
+	}
+	if w.Header().Get("ETag") == "" {
+		t.Error("Expected an ETag")
+	}
+}
+
+func TestExportCSVRange(t *testing.T) {
+	handler := setupExportHandler(t)
+	etag := getExport(handler, nil).Header().Get("ETag")
+
+	w := getExport(handler, map[string]string{"Range": "bytes=49-"})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status 206, got %d", w.Code)
+	}
+	if got := w.Body.String(); got != exportCSV[49:] {
+		t.Errorf("Expected body %q, got %q", exportCSV[49:], got)
+	}
+	want := fmt.Sprintf("bytes 49-%d/%d", len(exportCSV)-1, len(exportCSV))
+	if got := w.Header().Get("Content-Range"); got != want {
+		t.Errorf("Expected Content-Range %q, got %q", want, got)
+	}
+
+	// Resuming with the ETag of an earlier download
+	w = getExport(handler, map[string]string{"Range": "bytes=0-1", "If-Range": etag})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "id" {
+		t.Errorf("Expected the range matching the ETag, got %d %q", w.Code, w.Body.String())
+	}
+	w = getExport(handler, map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`})
+	if w.Code != http.StatusOK || w.Body.String() != exportCSV {
+		t.Errorf("Expected the full export for a stale ETag, got %d", w.Code)
+	}
+}
+
+func TestExportCSVUnsatisfiableRange(t *testing.T) {
+	w := getExport(setupExportHandler(t), map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(exportCSV))})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("Expected status 416, got %d", w.Code)
+	}
+	want := fmt.Sprintf("bytes */%d", len(exportCSV))
+	if got := w.Header().Get("Content-Range"); got != want {
+		t.Errorf("Expected Content-Range %q, got %q", want, got)
+	}
+}
+
+func TestExportCSVRangeNotCompressed(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.MinSize = 1
+	handler := Compress(setupExportHandler(t), cfg)
+
+	w := getExport(handler, map[string]string{"Range": "bytes=0-1", "Accept-Encoding": "gzip"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "id" {
+		t.Errorf("Expected an identity partial body, got %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected no Content-Encoding, got %q", got)
+	}
+	if w := getExport(handler, map[string]string{"Accept-Encoding": "gzip"}); w.Header().Get("Content-Encoding") != "gzip" {
+		t.Error("Expected the full export to be compressed")
+	}
+}
+
+func TestExportCSVCompressedETag(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.MinSize = 1
+	handler := Compress(setupExportHandler(t), cfg)
+	identity := getExport(handler, nil).Header().Get("ETag")
+
+	w := getExport(handler, map[string]string{"Accept-Encoding": "gzip"})
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("Expected the full export to be compressed")
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "" {
+		t.Errorf("Expected no Accept-Ranges on a gzip body, got %q", got)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag == identity {
+		t.Fatalf("Expected a gzip ETag distinct from %s, got %q", identity, etag)
+	}
+
+	// Resuming with the gzip ETag must not splice identity bytes into it
+	w = getExport(handler, map[string]string{"Range": "bytes=10-", "If-Range": etag, "Accept-Encoding": "gzip"})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the full compressed export, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
+
+// searchTitles runs a search query, returning the status and the sorted titles
+func searchTitles(t *testing.T, handler http.Handler, query string) (int, []string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/books/search?"+query, nil))
+	var books []*Book
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&books); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+	}
+	titles := make([]string, 0, len(books))
+	for _, book := range books {
+		titles = append(titles, book.Title)
+	}
+	sort.Strings(titles)
+	return w.Code, titles
+}
+
+// tolkienBooks returns three books of one author and one of another
+func tolkienBooks() []*Book {
+	return []*Book{
+		{ID: "1", Title: "The Hobbit", Author: "J.R.R. Tolkien", ISBN: "978-1"},
+		{ID: "2", Title: "The Silmarillion", Author: "J.R.R. Tolkien", ISBN: "978-2"},
+		{ID: "3", Title: "Dune", Author: "Frank Herbert", ISBN: "978-3"},
+		{ID: "4", Title: "The Lord of the Rings", Author: "J.R.R. Tolkien", ISBN: "978-4"},
+	}
+}
+
+func TestSearchMinLength(t *testing.T) {
+	handler := NewBookHandler(NewBookService(seedRepository(t, tolkienBooks()...)))
+	books := http.HandlerFunc(handler.HandleBooks)
+
+	for _, query := range []string{"title=e", "author=T&fuzzy=true", "title=%20e%20"} {
+		if status, _ := searchTitles(t, books, query); status != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q; got %d", query, status)
+		}
+	}
+	if status, titles := searchTitles(t, books, "title=Du"); status != http.StatusOK || len(titles) != 1 {
+		t.Errorf("Expected Dune for a 2 character query; got %d %v", status, titles)
+	}
+
+	handler.MinSearchLength = 1
+	if status, titles := searchTitles(t, books, "title=e"); status != http.StatusOK || len(titles) != 4 {
+		t.Errorf("Expected every book with a lower minimum; got %d %v", status, titles)
+	}
+}
+
+func TestSearchExactByDefault(t *testing.T) {
+	server, _ := setupBookServer(t, tolkienBooks()...)
+	books := server.Config.Handler
+
+	if _, titles := searchTitles(t, books, "author=Tolkien"); len(titles) != 3 {
+		t.Errorf("Expected the 3 Tolkien books; got %v", titles)
+	}
+	if _, titles := searchTitles(t, books, "author=Tolkein"); len(titles) != 0 {
+		t.Errorf("Expected no match for a typo without fuzzy; got %v", titles)
+	}
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	server, _ := setupBookServer(t, tolkienBooks()...)
+	books := server.Config.Handler
+
+	tests := []struct {
+		query  string
+		titles []string
+	}{
+		{"author=Tolkein&fuzzy=true", []string{"The Hobbit", "The Lord of the Rings", "The Silmarillion"}},
+		{"author=frank%20herbret&fuzzy=true", []string{"Dune"}},
+		{"title=Hobit&fuzzy=true", []string{"The Hobbit"}},
+		{"title=Lord&fuzzy=true", []string{"The Lord of the Rings"}},
+		// Beyond the distance threshold
+		{"author=Tlkn&fuzzy=true", []string{}},
+		{"title=Dunkirk&fuzzy=true", []string{}},
+	}
+	for _, tt := range tests {
+		status, titles := searchTitles(t, books, tt.query)
+		if status != http.StatusOK {
+			t.Errorf("Expected status 200 for %q; got %d", tt.query, status)
+			continue
+		}
+		if strings.Join(titles, "|") != strings.Join(tt.titles, "|") {
+			t.Errorf("Expected %v for %q; got %v", tt.titles, tt.query, titles)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"tolkein", "tolkien", 2},
+		{"kitten", "sitting", 3},
+		{"héllo", "hello", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d; want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// countingRepository counts the GetByID calls reaching the repository
+type countingRepository struct {
+	*InMemoryBookRepository
+	gets atomic.Int32
+}
+
+func (r *countingRepository) GetByID(ctx context.Context, id string) (*Book, error) {
+	r.gets.Add(1)
+	return r.InMemoryBookRepository.GetByID(ctx, id)
+}
+
+// setupCachingRepository returns a cache of size books over a counting
+// repository holding three books
+func setupCachingRepository(t *testing.T, size int) (*CachingRepository, *countingRepository) {
+	t.Helper()
+	backend := &countingRepository{InMemoryBookRepository: seedRepository(t,
+		&Book{ID: "1", Title: "Dune", Author: "Frank Herbert", ISBN: "isbn-1"},
+		&Book{ID: "2", Title: "Emma", Author: "Jane Austen", ISBN: "isbn-2"},
+		&Book{ID: "3", Title: "Ulysses", Author: "James Joyce", ISBN: "isbn-3"},
+	)}
+	return NewCachingRepository(backend, size), backend
+}
+
+func TestCachingRepositoryGetByID(t *testing.T) {
+	repo, backend := setupCachingRepository(t, 10)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		book, err := repo.GetByID(ctx, "1")
+		if err != nil || book.Title != "Dune" {
+			t.Fatalf("Expected Dune, got %v, %v", book, err)
+		}
+	}
+	if got := backend.gets.Load(); got != 1 {
+		t.Errorf("Expected the later reads to be served from the cache, got %d backend reads", got)
+	}
+
+	// Missing books are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := repo.GetByID(ctx, "missing"); !errors.Is(err, ErrBookNotFound) {
+			t.Errorf("Expected ErrBookNotFound, got %v", err)
+		}
+	}
+	if got := backend.gets.Load(); got != 3 {
+		t.Errorf("Expected 3 backend reads, got %d", got)
+	}
+}
+
+func TestCachingRepositoryInvalidation(t *testing.T) {
+	repo, backend := setupCachingRepository(t, 10)
+	ctx := context.Background()
+	repo.GetByID(ctx, "1")
+
+	if err := repo.Update(ctx, "1", &Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "isbn-1"}); err != nil {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	book, err := repo.GetByID(ctx, "1")
+	if err != nil || book.Title != "Dune Messiah" {
+		t.Errorf("Expected the updated title, got %v, %v", book, err)
+	}
+	if got := backend.gets.Load(); got != 2 {
+		t.Errorf("Expected the update to force a backend read, got %d backend reads", got)
+	}
+
+	if err := repo.Delete(ctx, "1"); err != nil {
+		t.Fatalf("Unexpected delete error: %v", err)
+	}
+	if _, err := repo.GetByID(ctx, "1"); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("Expected the deleted book to be gone, got %v", err)
+	}
+}
+
+func TestCachingRepositoryUpdateIf(t *testing.T) {
+	repo, _ := setupCachingRepository(t, 10)
+	ctx := context.Background()
+	cached, _ := repo.GetByID(ctx, "1")
+
+	ok, err := repo.UpdateIf(ctx, "1", cached, &Book{Title: "Dune Messiah", Author: "Frank Herbert", ISBN: "isbn-1"})
+	if err != nil || !ok {
+		t.Fatalf("Expected the update to be applied, got %v, %v", ok, err)
+	}
+	book, err := repo.GetByID(ctx, "1")
+	if err != nil || book.Title != "Dune Messiah" {
+		t.Errorf("Expected the updated title, got %v, %v", book, err)
+	}
+}
+
+func TestCachingRepositoryEviction(t *testing.T) {
+	repo, backend := setupCachingRepository(t, 2)
+	ctx := context.Background()
+
+	// Reading 1 again makes 2 the least recently used
+	for _, id := range []string{"1", "2", "1", "3"} {
+		repo.GetByID(ctx, id)
+	}
+	if repo.books.Len() != 2 {
+		t.Errorf("Expected the cache to hold 2 books, got %d", repo.books.Len())
+	}
+	backend.gets.Store(0)
+	repo.GetByID(ctx, "1")
+	repo.GetByID(ctx, "3")
+	if got := backend.gets.Load(); got != 0 {
+		t.Errorf("Expected 1 and 3 to be cached, got %d backend reads", got)
+	}
+	repo.GetByID(ctx, "2")
+	if got := backend.gets.Load(); got != 1 {
+		t.Errorf("Expected 2 to be evicted, got %d backend reads", got)
+	}
+}
+
+// racingRepository updates the book while the cache is reading it
+type racingRepository struct {
+	*InMemoryBookRepository
+	cache *CachingRepository
+}
+
+func (r *racingRepository) GetByID(ctx context.Context, id string) (*Book, error) {
+	book, err := r.InMemoryBookRepository.GetByID(ctx, id)
+	if r.cache != nil {
+		cache := r.cache
+		r.cache = nil
+		cache.Update(ctx, id, &Book{Title: "Updated", ISBN: book.ISBN})
+	}
+	return book, err
+}
+
+func TestCachingRepositoryStaleRead(t *testing.T) {
+	backend := &racingRepository{InMemoryBookRepository: seedRepository(t, &Book{ID: "1", Title: "Original", ISBN: "isbn-1"})}
+	repo := NewCachingRepository(backend, 10)
+	backend.cache = repo
+
+	// The read that raced the update returns the old book but does not cache it
+	if book, _ := repo.GetByID(context.Background(), "1"); book.Title != "Original" {
+		t.Errorf("Expected the racing read to see the original, got %q", book.Title)
+	}
+	if book, _ := repo.GetByID(context.Background(), "1"); book.Title != "Updated" {
+		t.Errorf("Expected the next read to see the update, got %q", book.Title)
+	}
+}
@@ -2,12 +2,32 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"html"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -24,30 +44,419 @@ type Book struct {
 
 // BookRepository defines the operations for book data access
 type BookRepository interface {
-	GetAll() ([]*Book, error)
-	GetByID(id string) (*Book, error)
-	Create(book *Book) error
-	Update(id string, book *Book) error
-	Delete(id string) error
-	SearchByAuthor(author string) ([]*Book, error)
-	SearchByTitle(title string) ([]*Book, error)
+	GetAll(ctx context.Context) ([]*Book, error)
+	GetByID(ctx context.Context, id string) (*Book, error)
+	Create(ctx context.Context, book *Book) error
+	Update(ctx context.Context, id string, book *Book) error
+	// UpdateIf applies the update only if the stored book has the content of
+	// expected, it returns false otherwise
+	UpdateIf(ctx context.Context, id string, expected, book *Book) (bool, error)
+	Delete(ctx context.Context, id string) error
+	SearchByAuthor(ctx context.Context, author string) ([]*Book, error)
+	SearchByTitle(ctx context.Context, title string) ([]*Book, error)
+	GetAfter(ctx context.Context, cursor string, limit int) ([]*Book, string, error)
+	Autocomplete(ctx context.Context, field, prefix string, limit int) ([]string, error)
+}
+
+// Book errors
+var (
+	ErrBookNotFound       = errors.New("book not found")
+	ErrBookExists         = errors.New("book already exists")
+	ErrInvalidJSON        = errors.New("invalid JSON")
+	ErrMissingSearch      = errors.New("missing search parameters")
+	ErrEndpointNotFound   = errors.New("endpoint not found")
+	ErrStoreUninitialized = errors.New("store not initialized")
+	ErrUnsupportedMedia   = errors.New("content type must be application/json")
+)
+
+// ValidationError reports an invalid field of a request
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// DuplicateISBNError reports a book whose ISBN is already stored, it is an
+// ErrBookExists
+type DuplicateISBNError struct {
+	Existing *Book
+}
+
+func (e *DuplicateISBNError) Error() string {
+	return fmt.Sprintf("%v: isbn %s", ErrBookExists, e.Existing.ISBN)
+}
+
+func (e *DuplicateISBNError) Unwrap() error {
+	return ErrBookExists
+}
+
+// Pagination errors
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+// ErrInvalidSort reports an unknown or repeated sort key
+var ErrInvalidSort = errors.New("invalid sort")
+
+// Autocomplete errors
+var (
+	ErrInvalidField = errors.New("field must be title or author")
+	ErrEmptyPrefix  = errors.New("prefix cannot be empty")
+)
+
+// Pagination limits
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Autocomplete defaults
+const defaultAutocompleteLimit = 10
+
+// snippetRadius is the number of runes kept around the first match of a snippet
+const snippetRadius = 30
+
+// SearchHit is a search result with the matched field highlighted
+type SearchHit struct {
+	Book    *Book  `json:"book"`
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+}
+
+// highlight returns a snippet of text around the first case-insensitive
+// occurrence of query, every occurrence in the snippet being wrapped in
+// <mark>. Occurrences are matched left to right and do not overlap, the
+// text is HTML escaped.
+func highlight(text, query string) (string, bool) {
+	t, q := []rune(text), []rune(query)
+	if len(q) == 0 {
+		return "", false
+	}
+
+	var matches []int
+	for i := 0; i+len(q) <= len(t); {
+		if runesEqualFold(t[i:i+len(q)], q) {
+			matches = append(matches, i)
+			i += len(q)
+		} else {
+			i++
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	start, end := matches[0]-snippetRadius, matches[0]+len(q)+snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(t) {
+		end = len(t)
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		if m+len(q) > end {
+			break
+		}
+		sb.WriteString(html.EscapeString(string(t[pos:m])))
+		sb.WriteString("<mark>" + html.EscapeString(string(t[m:m+len(q)])) + "</mark>")
+		pos = m + len(q)
+	}
+	sb.WriteString(html.EscapeString(string(t[pos:end])))
+	if end < len(t) {
+		sb.WriteString("…")
+	}
+	return sb.String(), true
+}
+
+func runesEqualFold(a, b []rune) bool {
+	for i := range a {
+		if unicode.ToLower(a[i]) != unicode.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// BookPage represents a page of books returned by cursor pagination
+type BookPage struct {
+	Items      []*Book `json:"items"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// bookCursor is the position of a book in the (title, id) sort order
+type bookCursor struct {
+	Title string `json:"t"`
+	ID    string `json:"id"`
+}
+
+// positionOf returns the position of the book in the sort order
+func positionOf(book *Book) bookCursor {
+	return bookCursor{Title: book.Title, ID: book.ID}
+}
+
+func encodeCursor(book *Book) string {
+	data, _ := json.Marshal(positionOf(book))
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (bookCursor, error) {
+	var c bookCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.ID == "" {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// before reports whether the cursor position sorts before the other one
+func (c bookCursor) before(other bookCursor) bool {
+	if c.Title != other.Title {
+		return c.Title < other.Title
+	}
+	return c.ID < other.ID
 }
 
 // InMemoryBookRepository implements BookRepository using in-memory storage
 type InMemoryBookRepository struct {
-	books map[string]*Book
-	mu    sync.RWMutex
+	books       map[string]*Book
+	isbns       map[string]*Book
+	titles      *prefixIndex
+	authors     *prefixIndex
+	titleGrams  *trigramIndex
+	authorGrams *trigramIndex
+	order       []bookCursor // Positions of the books, sorted for GetAfter
+	mu          sync.RWMutex
 }
 
 // NewInMemoryBookRepository creates a new in-memory book repository
 func NewInMemoryBookRepository() *InMemoryBookRepository {
 	return &InMemoryBookRepository{
-		books: make(map[string]*Book),
+		books:       make(map[string]*Book),
+		isbns:       make(map[string]*Book),
+		titles:      newPrefixIndex(),
+		authors:     newPrefixIndex(),
+		titleGrams:  newTrigramIndex(),
+		authorGrams: newTrigramIndex(),
+	}
+}
+
+// Store backends, selected by the STORE_BACKEND environment variable
+const (
+	BackendMemory = "memory"
+	BackendSQLite = "sqlite"
+)
+
+// Store backend errors
+var (
+	ErrUnknownBackend     = errors.New("unknown store backend")
+	ErrBackendUnavailable = errors.New("store backend not available")
+)
+
+// NewBookRepository returns the repository of the given backend, the
+// in-memory one if backend is empty
+func NewBookRepository(backend string) (BookRepository, error) {
+	switch backend {
+	case "", BackendMemory:
+		return NewInMemoryBookRepository(), nil
+	case BackendSQLite:
+		// No SQLite repository exists yet, fail fast rather than silently
+		// falling back to memory
+		return nil, fmt.Errorf("%w: %s", ErrBackendUnavailable, backend)
+	default:
+		return nil, fmt.Errorf("%w: %q (expected %s or %s)", ErrUnknownBackend, backend, BackendMemory, BackendSQLite)
+	}
+}
+
+// prefixIndex is a case-insensitive index of distinct values supporting
+// prefix lookups with a binary search over the sorted keys
+type prefixIndex struct {
+	keys   []string          // sorted lowercased values
+	counts map[string]int    // lowercased value -> number of books
+	values map[string]string // lowercased value -> value as first seen
+}
+
+func newPrefixIndex() *prefixIndex {
+	return &prefixIndex{counts: make(map[string]int), values: make(map[string]string)}
+}
+
+func (idx *prefixIndex) add(value string) {
+	key := strings.ToLower(value)
+	if idx.counts[key] == 0 {
+		i := sort.SearchStrings(idx.keys, key)
+		idx.keys = append(idx.keys, "")
+		copy(idx.keys[i+1:], idx.keys[i:])
+		idx.keys[i] = key
+		idx.values[key] = value
+	}
+	idx.counts[key]++
+}
+
+func (idx *prefixIndex) remove(value string) {
+	key := strings.ToLower(value)
+	if idx.counts[key] == 0 {
+		return
+	}
+	idx.counts[key]--
+	if idx.counts[key] == 0 {
+		i := sort.SearchStrings(idx.keys, key)
+		idx.keys = append(idx.keys[:i], idx.keys[i+1:]...)
+		delete(idx.counts, key)
+		delete(idx.values, key)
 	}
 }
 
+// match returns up to limit distinct values starting with prefix, in lexicographic order
+func (idx *prefixIndex) match(prefix string, limit int) []string {
+	prefix = strings.ToLower(prefix)
+	results := make([]string, 0)
+	for i := sort.SearchStrings(idx.keys, prefix); i < len(idx.keys) && len(results) < limit; i++ {
+		if ! strings.HasPrefix(idx.keys[i], prefix) {
+			break
+		}
+		results = append(results, idx.values[idx.keys[i]])
+	}
+	return results
+}
+
+// trigramIndex maps each 3-byte substring of the indexed values to the IDs
+// of the books containing it. A value containing a query contains all of
+// its trigrams, so intersecting their posting sets gives the candidates of
+// a case-sensitive substring search without scanning every book.
+type trigramIndex struct {
+	postings map[string]map[string]struct{}
+}
+
+func newTrigramIndex() *trigramIndex {
+	return &trigramIndex{postings: make(map[string]map[string]struct{})}
+}
+
+func (idx *trigramIndex) add(id, value string) {
+	for i := 0; i+3 <= len(value); i++ {
+		gram := value[i : i+3]
+		ids, ok := idx.postings[gram]
+		if ! ok {
+			ids = make(map[string]struct{})
+			idx.postings[gram] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+func (idx *trigramIndex) remove(id, value string) {
+	for i := 0; i+3 <= len(value); i++ {
+		gram := value[i : i+3]
+		delete(idx.postings[gram], id)
+		if len(idx.postings[gram]) == 0 {
+			delete(idx.postings, gram)
+		}
+	}
+}
+
+// candidates returns the IDs of the values that may contain query, false
+// when the query is too short to use the index
+func (idx *trigramIndex) candidates(query string) (map[string]struct{}, bool) {
+	if len(query) < 3 {
+		return nil, false
+	}
+
+	// Start from the smallest posting set to keep the intersection cheap
+	smallest := ""
+	for i := 0; i+3 <= len(query); i++ {
+		gram := query[i : i+3]
+		if len(idx.postings[gram]) == 0 {
+			return nil, true
+		}
+		if smallest == "" || len(idx.postings[gram]) < len(idx.postings[smallest]) {
+			smallest = gram
+		}
+	}
+
+	result := make(map[string]struct{})
+	for id := range idx.postings[smallest] {
+		result[id] = struct{}{}
+	}
+	for i := 0; i+3 <= len(query) && len(result) > 0; i++ {
+		ids := idx.postings[query[i:i+3]]
+		for id := range result {
+			if _, ok := ids[id]; ! ok {
+				delete(result, id)
+			}
+		}
+	}
+	return result, true
+}
+
+// index adds the book to the lookup indexes, books without an ISBN are not
+// subject to its uniqueness
+func (r *InMemoryBookRepository) index(book *Book) {
+	if book.ISBN != "" {
+		r.isbns[book.ISBN] = book
+	}
+	r.titles.add(book.Title)
+	r.authors.add(book.Author)
+	r.titleGrams.add(book.ID, book.Title)
+	r.authorGrams.add(book.ID, book.Author)
+
+	pos := positionOf(book)
+	i := sort.Search(len(r.order), func(i int) bool { return pos.before(r.order[i]) })
+	r.order = append(r.order, bookCursor{})
+	copy(r.order[i+1:], r.order[i:])
+	r.order[i] = pos
+}
+
+func (r *InMemoryBookRepository) unindex(book *Book) {
+	if r.isbns[book.ISBN] == book {
+		delete(r.isbns, book.ISBN)
+	}
+	r.titles.remove(book.Title)
+	r.authors.remove(book.Author)
+	r.titleGrams.remove(book.ID, book.Title)
+	r.authorGrams.remove(book.ID, book.Author)
+
+	pos := positionOf(book)
+	i := sort.Search(len(r.order), func(i int) bool { return ! r.order[i].before(pos) })
+	if i < len(r.order) && r.order[i] == pos {
+		r.order = append(r.order[:i], r.order[i+1:]...)
+	}
+}
+
+// search returns the books whose field contains query, using the trigram
+// index when the query is long enough and a full scan otherwise
+func (r *InMemoryBookRepository) search(idx *trigramIndex, field func(*Book) string, query string) []*Book {
+	var results []*Book
+	ids, ok := idx.candidates(query)
+	if ! ok {
+		for _, book := range r.books {
+			if strings.Contains(field(book), query) {
+				results = append(results, book)
+			}
+		}
+		return results
+	}
+	for id := range ids {
+		// Trigrams may match at different places, verify the candidate
+		if book := r.books[id]; strings.Contains(field(book), query) {
+			results = append(results, book)
+		}
+	}
+	return results
+}
+
 // Implement BookRepository methods for InMemoryBookRepository
-func (r *InMemoryBookRepository) GetAll() ([]*Book, error) {
+func (r *InMemoryBookRepository) GetAll(ctx context.Context) ([]*Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	books := make([]*Book, 0, len(r.books))
@@ -57,163 +466,666 @@ func (r *InMemoryBookRepository) GetAll() ([]*Book, error) {
 	return books, nil
 }
 
-func (r *InMemoryBookRepository) GetByID(id string) (*Book, error) {
+func (r *InMemoryBookRepository) GetByID(ctx context.Context, id string) (*Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	if book, ok := r.books[id]; ok {
 		return book, nil
 	}
-	return nil, errors.New("book not found")
+	return nil, ErrBookNotFound
 }
 
-func (r *InMemoryBookRepository) Create(book *Book) error {
+func (r *InMemoryBookRepository) Create(ctx context.Context, book *Book) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if _, ok := r.books[book.ID]; ok {
-		return errors.New("book already exists")
+		return ErrBookExists
+	}
+	if existing, ok := r.isbns[book.ISBN]; ok {
+		return &DuplicateISBNError{Existing: existing}
 	}
 	r.books[book.ID] = book
+	r.index(book)
+	return nil
+}
+
+// Seed replaces the stored books with the given fixtures, the store is left
+// unchanged if two of them share an ID or an ISBN
+func (r *InMemoryBookRepository) Seed(books ...*Book) error {
+	seeded := NewInMemoryBookRepository()
+	for _, book := range books {
+		if _, ok := seeded.books[book.ID]; ok {
+			return ErrBookExists
+		}
+		if existing, ok := seeded.isbns[book.ISBN]; ok {
+			return &DuplicateISBNError{Existing: existing}
+		}
+		seeded.books[book.ID] = book
+		seeded.index(book)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.books, r.isbns = seeded.books, seeded.isbns
+	r.titles, r.authors = seeded.titles, seeded.authors
+	r.titleGrams, r.authorGrams = seeded.titleGrams, seeded.authorGrams
+	r.order = seeded.order
 	return nil
 }
 
-func (r *InMemoryBookRepository) Update(id string, book *Book) error {
+func (r *InMemoryBookRepository) Update(ctx context.Context, id string, book *Book) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, ok := r.books[id]; ! ok {
-		return errors.New("book not found")
+	old, ok := r.books[id]
+	if ! ok {
+		return ErrBookNotFound
 	}
-	book.ID = id
-	r.books[id] = book
+	return r.replace(old, book)
+}
+
+// UpdateIf is a compare-and-swap Update: the book is replaced only if its
+// stored fields, the ID aside, equal those of expected
+func (r *InMemoryBookRepository) UpdateIf(ctx context.Context, id string, expected, book *Book) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.books[id]
+	if ! ok {
+		return false, ErrBookNotFound
+	}
+	if ! sameContent(old, expected) {
+		return false, nil
+	}
+	if err := r.replace(old, book); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// replace stores book in place of old, r.mu must be held
+func (r *InMemoryBookRepository) replace(old, book *Book) error {
+	if existing, ok := r.isbns[book.ISBN]; ok && existing.ID != old.ID {
+		return &DuplicateISBNError{Existing: existing}
+	}
+	book.ID = old.ID
+	r.unindex(old)
+	r.books[book.ID] = book
+	r.index(book)
 	return nil
 }
 
-func (r *InMemoryBookRepository) Delete(id string) error {
+// sameContent reports whether two books have the same fields, the ID aside
+func sameContent(a, b *Book) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	x, y := *a, *b
+	x.ID, y.ID = "", ""
+	return x == y
+}
+
+func (r *InMemoryBookRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, ok := r.books[id]; ! ok {
-		return errors.New("book not found")
+	book, ok := r.books[id]
+	if ! ok {
+		return ErrBookNotFound
 	}
 	delete(r.books, id)
+	r.unindex(book)
 	return nil
 }
 
-func (r *InMemoryBookRepository) SearchByAuthor(author string) ([]*Book, error) {
+// Ping reports whether the underlying store is ready to serve requests
+func (r *InMemoryBookRepository) Ping() error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	var results []*Book
-	for _, book := range r.books {
-		if strings.Contains(book.Author,  author) {
-			results = append(results, book)
+	if r.books == nil {
+		return ErrStoreUninitialized
+	}
+	return nil
+}
+
+// GetAfter returns up to limit books following the cursor, sorted by title
+// then ID so that pages never overlap nor skip books. The returned cursor is
+// empty when there is no more data.
+func (r *InMemoryBookRepository) GetAfter(ctx context.Context, cursor string, limit int) ([]*Book, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	var after *bookCursor
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
 		}
+		after = &c
 	}
-	return results, nil
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(r.order), func(i int) bool { return after.before(r.order[i]) })
+	}
+	end := start + limit
+	if end > len(r.order) {
+		end = len(r.order)
+	}
+	books := make([]*Book, 0, end-start)
+	for _, pos := range r.order[start:end] {
+		books = append(books, r.books[pos.ID])
+	}
+	if end == len(r.order) {
+		return books, "", nil
+	}
+	return books, encodeCursor(books[len(books)-1]), nil
 }
 
-func (r *InMemoryBookRepository) SearchByTitle(title string) ([]*Book, error) {
+// Autocomplete returns up to limit distinct titles or authors starting with
+// prefix (case-insensitive), in lexicographic order
+func (r *InMemoryBookRepository) Autocomplete(ctx context.Context, field, prefix string, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	var results []*Book
-	for _, book := range r.books {
-		if strings.Contains(book.Title, title) {
-			results = append(results, book)
-		}
+	switch field {
+	case "title":
+		return r.titles.match(prefix, limit), nil
+	case "author":
+		return r.authors.match(prefix, limit), nil
 	}
-	return results, nil
+	return nil, ErrInvalidField
+}
+
+func (r *InMemoryBookRepository) SearchByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.search(r.authorGrams, func(b *Book) string { return b.Author }, author), nil
+}
+
+func (r *InMemoryBookRepository) SearchByTitle(ctx context.Context, title string) ([]*Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.search(r.titleGrams, func(b *Book) string { return b.Title }, title), nil
+}
+
+// lruCache is a bounded map evicting its least recently used entry, it is
+// not safe for concurrent use
+type lruCache[K comparable, V any] struct {
+	capacity int
+	order    *list.List // of *lruEntry, most recently used first
+	entries  map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](capacity int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value of key and marks it as the most recently used
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	elem, ok := c.entries[key]
+	if ! ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+// Put stores the value of key, evicting the least recently used entry when
+// the cache is full
+func (c *lruCache[K, V]) Put(key K, value V) {
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key, value})
+}
+
+// Remove deletes key from the cache
+func (c *lruCache[K, V]) Remove(key K) {
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// Len returns the number of cached entries
+func (c *lruCache[K, V]) Len() int {
+	return c.order.Len()
+}
+
+// DefaultBookCacheSize is the number of books a CachingRepository keeps
+// when no size is given
+const DefaultBookCacheSize = 1024
+
+// CachingRepository is a read-through BookRepository decorator caching the
+// GetByID results of the wrapped repository in an LRU. The writes go to the
+// wrapped repository then invalidate the book, the other reads (listings,
+// searches, autocomplete) are never cached.
+type CachingRepository struct {
+	BookRepository
+	mu    sync.Mutex
+	books *lruCache[string, *Book]
+	// gen changes with every write, a lookup started before a write does
+	// not cache its possibly stale result
+	gen uint64
+}
+
+// NewCachingRepository wraps repo with a cache of up to size books, the
+// DefaultBookCacheSize if size is zero or less
+func NewCachingRepository(repo BookRepository, size int) *CachingRepository {
+	if size <= 0 {
+		size = DefaultBookCacheSize
+	}
+	return &CachingRepository{BookRepository: repo, books: newLRUCache[string, *Book](size)}
+}
+
+func (r *CachingRepository) GetByID(ctx context.Context, id string) (*Book, error) {
+	r.mu.Lock()
+	book, ok := r.books.Get(id)
+	gen := r.gen
+	r.mu.Unlock()
+	if ok {
+		return book, nil
+	}
+
+	book, err := r.BookRepository.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.gen == gen {
+		r.books.Put(id, book)
+	}
+	r.mu.Unlock()
+	return book, nil
+}
+
+func (r *CachingRepository) Create(ctx context.Context, book *Book) error {
+	defer r.invalidate(book.ID)
+	return r.BookRepository.Create(ctx, book)
+}
+
+func (r *CachingRepository) Update(ctx context.Context, id string, book *Book) error {
+	defer r.invalidate(id)
+	return r.BookRepository.Update(ctx, id, book)
+}
+
+func (r *CachingRepository) UpdateIf(ctx context.Context, id string, expected, book *Book) (bool, error) {
+	defer r.invalidate(id)
+	return r.BookRepository.UpdateIf(ctx, id, expected, book)
+}
+
+func (r *CachingRepository) Delete(ctx context.Context, id string) error {
+	defer r.invalidate(id)
+	return r.BookRepository.Delete(ctx, id)
+}
+
+// invalidate drops the cached book once its write is done, whether it
+// succeeded or not
+func (r *CachingRepository) invalidate(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.books.Remove(id)
+	r.gen++
 }
 
 // BookService defines the business logic for book operations
 type BookService interface {
-	GetAllBooks() ([]*Book, error)
-	GetBookByID(id string) (*Book, error)
-	CreateBook(book *Book) error
-	UpdateBook(id string, book *Book) error
-	DeleteBook(id string) error
-	SearchBooksByAuthor(author string) ([]*Book, error)
-	SearchBooksByTitle(title string) ([]*Book, error)
+	GetAllBooks(ctx context.Context) ([]*Book, error)
+	GetBookByID(ctx context.Context, id string) (*Book, error)
+	CreateBook(ctx context.Context, book *Book) error
+	UpdateBook(ctx context.Context, id string, book *Book) error
+	DeleteBook(ctx context.Context, id string) error
+	SearchBooksByAuthor(ctx context.Context, author string) ([]*Book, error)
+	SearchBooksByTitle(ctx context.Context, title string) ([]*Book, error)
+	FuzzySearchBooks(ctx context.Context, field, query string, maxDistance int) ([]*Book, error)
+	GetBooksPage(ctx context.Context, cursor string, limit int) (*BookPage, error)
+	AutocompleteBooks(ctx context.Context, field, prefix string, limit int) ([]string, error)
+}
+
+// IDGenerator generates the IDs of the created books
+type IDGenerator interface {
+	NewID() string
+}
+
+// uuidGenerator is the default IDGenerator, it returns random UUIDs
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string {
+	return uuid.New().String()
 }
 
 // DefaultBookService implements BookService
 type DefaultBookService struct {
 	repo BookRepository
+	ids  IDGenerator
 }
 
 // NewBookService creates a new book service
 func NewBookService(repo BookRepository) *DefaultBookService {
-	return &DefaultBookService{repo: repo}
+	return NewBookServiceWithIDGenerator(repo, uuidGenerator{})
+}
+
+// NewBookServiceWithIDGenerator creates a book service using the given ID
+// generator, a nil one is replaced by the UUID generator
+func NewBookServiceWithIDGenerator(repo BookRepository, ids IDGenerator) *DefaultBookService {
+	if ids == nil {
+		ids = uuidGenerator{}
+	}
+	return &DefaultBookService{repo: repo, ids: ids}
 }
 
 // Implement BookService methods for DefaultBookService
-func (s *DefaultBookService) GetAllBooks() ([]*Book, error) {
-	return s.repo.GetAll()
+func (s *DefaultBookService) GetAllBooks(ctx context.Context) ([]*Book, error) {
+	return s.repo.GetAll(ctx)
+}
+
+func (s *DefaultBookService) GetBooksPage(ctx context.Context, cursor string, limit int) (*BookPage, error) {
+	if limit < 1 || limit > maxPageLimit {
+		return nil, ErrInvalidLimit
+	}
+	books, next, err := s.repo.GetAfter(ctx, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &BookPage{Items: books, NextCursor: next}, nil
+}
+
+// AutocompleteBooks suggests titles or authors, an empty prefix is rejected
+// rather than returning an arbitrary top-N
+func (s *DefaultBookService) AutocompleteBooks(ctx context.Context, field, prefix string, limit int) ([]string, error) {
+	if field != "title" && field != "author" {
+		return nil, ErrInvalidField
+	}
+	if strings.TrimSpace(prefix) == "" {
+		return nil, ErrEmptyPrefix
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return nil, ErrInvalidLimit
+	}
+	return s.repo.Autocomplete(ctx, field, prefix, limit)
 }
 
-func (s *DefaultBookService) GetBookByID(id string) (*Book, error) {
-	return s.repo.GetByID(id)
+func (s *DefaultBookService) GetBookByID(ctx context.Context, id string) (*Book, error) {
+	return s.repo.GetByID(ctx, id)
 }
 
-func (s *DefaultBookService) CreateBook(book *Book) error {
+func (s *DefaultBookService) CreateBook(ctx context.Context, book *Book) error {
 	if err := validateBook(book); err != nil {
 		return err
 	}
-	book.ID = uuid.New().String()
-	return s.repo.Create(book)
+	book.ID = s.ids.NewID()
+	return s.repo.Create(ctx, book)
 }
 
-func (s *DefaultBookService) UpdateBook(id string, book *Book) error {
+func (s *DefaultBookService) UpdateBook(ctx context.Context, id string, book *Book) error {
 	if err := validateBook(book); err != nil {
 		return err
 	}
-	return s.repo.Update(id, book)
+	return s.repo.Update(ctx, id, book)
 }
 
-func (s *DefaultBookService) DeleteBook(id string) error {
-	return s.repo.Delete(id)
+func (s *DefaultBookService) DeleteBook(ctx context.Context, id string) error {
+	return s.repo.Delete(ctx, id)
 }
 
-func (s *DefaultBookService) SearchBooksByAuthor(author string) ([]*Book, error) {
+func (s *DefaultBookService) SearchBooksByAuthor(ctx context.Context, author string) ([]*Book, error) {
 	if author == "" {
-		return nil, errors.New("author cannot be empty")
+		return nil, &ValidationError{Field: "author", Message: "author cannot be empty"}
 	}
-	return s.repo.SearchByAuthor(author)
+	return s.repo.SearchByAuthor(ctx, author)
 }
 
-func (s *DefaultBookService) SearchBooksByTitle(title string) ([]*Book, error) {
+func (s *DefaultBookService) SearchBooksByTitle(ctx context.Context, title string) ([]*Book, error) {
 	if title == "" {
-		return nil, errors.New("title cannot be empty")
+		return nil, &ValidationError{Field: "title", Message: "title cannot be empty"}
+	}
+	return s.repo.SearchByTitle(ctx, title)
+}
+
+// FuzzySearchBooks returns the books whose field, "title" or "author",
+// matches query within maxDistance edits (see fuzzyMatch). It scans the
+// catalog, the trigram index only serves exact substrings.
+func (s *DefaultBookService) FuzzySearchBooks(ctx context.Context, field, query string, maxDistance int) ([]*Book, error) {
+	var value func(*Book) string
+	switch field {
+	case "title":
+		value = func(b *Book) string { return b.Title }
+	case "author":
+		value = func(b *Book) string { return b.Author }
+	default:
+		return nil, ErrInvalidField
+	}
+	if query == "" {
+		return nil, &ValidationError{Field: field, Message: field + " cannot be empty"}
+	}
+	books, err := s.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []*Book
+	for _, book := range books {
+		if fuzzyMatch(value(book), query, maxDistance) {
+			results = append(results, book)
+		}
+	}
+	return results, nil
+}
+
+// fuzzyMatch reports whether value contains query, or has a run of as many
+// words as query within maxDistance edits of it, ignoring case and
+// punctuation: "Tolkein" matches "J.R.R. Tolkien".
+func fuzzyMatch(value, query string, maxDistance int) bool {
+	value, query = strings.ToLower(value), strings.ToLower(query)
+	if strings.Contains(value, query) {
+		return true
+	}
+	words := strings.FieldsFunc(value, notWordRune)
+	queryWords := strings.FieldsFunc(query, notWordRune)
+	if len(queryWords) == 0 {
+		return false
+	}
+	q := strings.Join(queryWords, " ")
+	for i := 0; i+len(queryWords) <= len(words); i++ {
+		if levenshtein(strings.Join(words[i:i+len(queryWords)], " "), q) <= maxDistance {
+			return true
+		}
 	}
-	return s.repo.SearchByTitle(title)
+	return false
+}
+
+func notWordRune(r rune) bool {
+	return ! unicode.IsLetter(r) && ! unicode.IsDigit(r)
+}
+
+// levenshtein returns the number of rune insertions, deletions and
+// substitutions turning a into b
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+// minInt returns the smallest of its arguments, go 1.19 has no min builtin
+func minInt(first int, rest ...int) int {
+	for _, v := range rest {
+		if v < first {
+			first = v
+		}
+	}
+	return first
 }
 
 func validateBook(book *Book) error {
 	if book.Title == "" {
-		return errors.New("title is required")
+		return &ValidationError{Field: "title", Message: "title is required"}
 	}
 	if book.Author == "" {
-		return errors.New("author is required")
+		return &ValidationError{Field: "author", Message: "author is required"}
 	}
 	if book.ISBN == "" {
-		return errors.New("invalid ISBN format")
+		return &ValidationError{Field: "isbn", Message: "invalid ISBN format"}
 	}
 	return nil
 }
 
+// bookSortKeys are the allowed keys of the sort parameter of GET /api/books
+var bookSortKeys = map[string]func(a, b *Book) int{
+	"title":          func(a, b *Book) int { return strings.Compare(a.Title, b.Title) },
+	"author":         func(a, b *Book) int { return strings.Compare(a.Author, b.Author) },
+	"published_year": func(a, b *Book) int { return a.PublishedYear - b.PublishedYear },
+	"isbn":           func(a, b *Book) int { return strings.Compare(a.ISBN, b.ISBN) },
+	"id":             func(a, b *Book) int { return strings.Compare(a.ID, b.ID) },
+}
+
+// bookSortKey is a parsed key of the sort parameter
+type bookSortKey struct {
+	compare func(a, b *Book) int
+	desc    bool
+}
+
+// bookOrder compares books on a list of sort keys
+type bookOrder []bookSortKey
+
+// parseBookSort parses a comma-separated list of sort keys, each one being
+// descending if prefixed by '-'. Unknown, empty and repeated keys are rejected.
+func parseBookSort(param string) (bookOrder, error) {
+	var order bookOrder
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(param, ",") {
+		key = strings.TrimSpace(key)
+		desc := strings.HasPrefix(key, "-")
+		key = strings.TrimPrefix(key, "-")
+		compare, ok := bookSortKeys[key]
+		if ! ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidSort, key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%w: duplicate key %q", ErrInvalidSort, key)
+		}
+		seen[key] = true
+		order = append(order, bookSortKey{compare: compare, desc: desc})
+	}
+	return order, nil
+}
+
+// sort sorts the books on the keys in turn, the ID breaking the remaining
+// ties so that the order is deterministic
+func (o bookOrder) sort(books []*Book) {
+	sort.SliceStable(books, func(i, j int) bool {
+		for _, key := range o {
+			if c := key.compare(books[i], books[j]); c != 0 {
+				return (c < 0) != key.desc
+			}
+		}
+		return books[i].ID < books[j].ID
+	})
+}
+
+// defaultRequestTimeout is the deadline of the service calls of a request
+const defaultRequestTimeout = 5 * time.Second
+
+// Search defaults
+const (
+	defaultMinSearchLength  = 2
+	defaultFuzzyMaxDistance = 2
+)
+
 // BookHandler handles HTTP requests for book operations
 type BookHandler struct {
-	Service BookService
+	Service           BookService
+	Timeout           time.Duration // deadline of each request, none if 0
+	StrictContentType bool          // POST and PUT bodies must be declared as JSON
+	MinSearchLength   int           // shorter search queries are rejected
+	FuzzyMaxDistance  int           // edits allowed by the fuzzy search
 }
 
 // NewBookHandler creates a new book handler
 func NewBookHandler(service BookService) *BookHandler {
-	return &BookHandler{Service: service}
+	return &BookHandler{
+		Service:          service,
+		Timeout:          defaultRequestTimeout,
+		MinSearchLength:  defaultMinSearchLength,
+		FuzzyMaxDistance: defaultFuzzyMaxDistance,
+	}
 }
 
-// HandleBooks processes the book-related endpoints
+// HandleBooks processes the book-related endpoints. The service calls use the
+// request context, they are cancelled if the client disconnects or Timeout elapses.
 func (h *BookHandler) HandleBooks(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if h.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), h.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
 	path, method := r.URL.Path, r.Method
+	if h.StrictContentType && (method == http.MethodPost || method == http.MethodPut) && ! isJSONContent(r) {
+		writeError(w, ErrUnsupportedMedia)
+		return
+	}
 	switch {
 	case strings.HasPrefix(path, "/api/books/search") && method == http.MethodGet:
 		h.handleSearch(w, r)
+	case path == "/api/books/autocomplete" && method == http.MethodGet:
+		h.handleAutocomplete(w, r)
+	case path == "/api/books/export.csv" && (method == http.MethodGet || method == http.MethodHead):
+		h.handleExportCSV(w, r)
 	case path == "/api/books" && method == http.MethodGet:
 		h.handleGetAll(w, r)
 	case path == "/api/books" && method == http.MethodPost:
@@ -225,37 +1137,150 @@ func (h *BookHandler) HandleBooks(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "/api/books/") && method == http.MethodDelete:
 		h.handleDelete(w, r)
 	default:
-		writeError(w, http.StatusNotFound, "endpoint not found")
+		writeError(w, ErrEndpointNotFound)
 	}
 }
 
+// isJSONContent reports whether the request body is declared as JSON, a
+// missing Content-Type is not
+func isJSONContent(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// handleGetAll serves GET /api/books, sorted by the keys of the sort
+// parameter if any. Pages are always in (title, id) order.
 func (h *BookHandler) handleGetAll(w http.ResponseWriter, r *http.Request) {
-	books, err := h.Service.GetAllBooks()
+	query := r.URL.Query()
+	if query.Has("cursor") || query.Has("limit") {
+		if query.Has("sort") {
+			writeError(w, fmt.Errorf("%w: not supported with pagination", ErrInvalidSort))
+			return
+		}
+		h.handleGetPage(w, r)
+		return
+	}
+	var order bookOrder
+	if query.Has("sort") {
+		var err error
+		if order, err = parseBookSort(query.Get("sort")); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	books, err := h.Service.GetAllBooks(r.Context())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeError(w, err)
 		return
 	}
+	if order != nil {
+		order.sort(books)
+	}
 	writeJSON(w, http.StatusOK, books)
 }
 
+// csvHeader is the header row of the CSV export
+var csvHeader = []string{"id", "title", "author", "published_year", "isbn", "description"}
+
+// handleExportCSV serves GET /api/books/export.csv, the catalog in (title, id)
+// order. The CSV is materialized so that byte ranges can be served, with an
+// ETag for the clients resuming a download to check it did not change.
+func (h *BookHandler) handleExportCSV(w http.ResponseWriter, r *http.Request) {
+	books, err := h.Service.GetAllBooks(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	bookOrder{{compare: bookSortKeys["title"]}}.sort(books)
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(csvHeader)
+	for _, b := range books {
+		cw.Write([]string{b.ID, b.Title, b.Author, strconv.Itoa(b.PublishedYear), b.ISBN, b.Description})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="books.csv"`)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	// ServeContent advertises Accept-Ranges and answers Range and If-Range
+	http.ServeContent(w, r, "books.csv", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// handleGetPage serves GET /api/books?cursor=&limit= using cursor pagination
+func (h *BookHandler) handleGetPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultPageLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, ErrInvalidLimit)
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.Service.GetBooksPage(r.Context(), query.Get("cursor"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleAutocomplete serves GET /api/books/autocomplete?field=title|author&prefix=&limit=
+func (h *BookHandler) handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultAutocompleteLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, ErrInvalidLimit)
+			return
+		}
+		limit = n
+	}
+
+	results, err := h.Service.AutocompleteBooks(r.Context(), query.Get("field"), query.Get("prefix"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
 func (h *BookHandler) handleGetByID(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/books/")
-	book, err := h.Service.GetBookByID(id)
+	book, err := h.Service.GetBookByID(r.Context(), id)
 	if err != nil {
-		writeError(w, http.StatusNotFound, err.Error())
+		writeError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, book)
 }
 
+// handleCreate serves POST /api/books. With the "Idempotent: true" header
+// the ISBN is the idempotency key, a book already stored with the same ISBN
+// is returned with a 200 rather than a 409 so that retries are safe.
 func (h *BookHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
 	var book Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeError(w, ErrInvalidJSON)
 		return
 	}
-	if err := h.Service.CreateBook(&book); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+	if err := h.Service.CreateBook(r.Context(), &book); err != nil {
+		var dup *DuplicateISBNError
+		if errors.As(err, &dup) && strings.EqualFold(r.Header.Get("Idempotent"), "true") {
+			writeJSON(w, http.StatusOK, dup.Existing)
+			return
+		}
+		writeError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusCreated, book)
@@ -265,15 +1290,11 @@ func (h *BookHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/books/")
 	var book Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeError(w, ErrInvalidJSON)
 		return
 	}
-	if err := h.Service.UpdateBook(id, &book); err != nil {
-		if err.Error() == "book not found" {
-			writeError(w, http.StatusNotFound, err.Error())
-		} else {
-			writeError(w, http.StatusBadRequest, err.Error())
-		}
+	if err := h.Service.UpdateBook(r.Context(), id, &book); err != nil {
+		writeError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, book)
@@ -281,32 +1302,160 @@ func (h *BookHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
 
 func (h *BookHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/books/")
-	if err := h.Service.DeleteBook(id); err != nil {
-		writeError(w, http.StatusNotFound, err.Error())
+	if err := h.Service.DeleteBook(r.Context(), id); err != nil {
+		writeError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"message": "book deleted"})
 }
 
+// handleSearch serves GET /api/books/search?author= or ?title=, matching
+// substrings unless fuzzy=true. Queries shorter than MinSearchLength runes
+// are rejected. With highlight=true each book is returned with a snippet of
+// the matched field.
 func (h *BookHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
+	var field, term string
 	if author := query.Get("author"); author != "" {
-		results, _ := h.Service.SearchBooksByAuthor(author)
-		writeJSON(w, http.StatusOK, results)
+		field, term = "author", author
+	} else if title := query.Get("title"); title != "" {
+		field, term = "title", title
+	} else {
+		writeError(w, ErrMissingSearch)
+		return
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(term)) < h.MinSearchLength {
+		writeError(w, &ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("%s must be at least %d characters", field, h.MinSearchLength),
+		})
+		return
+	}
+
+	var results []*Book
+	var err error
+	switch {
+	case query.Get("fuzzy") == "true":
+		results, err = h.Service.FuzzySearchBooks(r.Context(), field, term, h.FuzzyMaxDistance)
+	case field == "author":
+		results, err = h.Service.SearchBooksByAuthor(r.Context(), term)
+	default:
+		results, err = h.Service.SearchBooksByTitle(r.Context(), term)
+	}
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	if title := query.Get("title"); title != "" {
-		results, _ := h.Service.SearchBooksByTitle(title)
+
+	if query.Get("highlight") != "true" {
 		writeJSON(w, http.StatusOK, results)
 		return
 	}
-	writeError(w, http.StatusBadRequest, "missing search parameters")
+	hits := make([]SearchHit, 0, len(results))
+	for _, book := range results {
+		value := book.Author
+		if field == "title" {
+			value = book.Title
+		}
+		snippet, found := highlight(value, term)
+		if ! found {
+			// A fuzzy match has no exact occurrence to mark
+			snippet = html.EscapeString(value)
+		}
+		hits = append(hits, SearchHit{Book: book, Field: field, Snippet: snippet})
+	}
+	writeJSON(w, http.StatusOK, hits)
 }
 
-// ErrorResponse represents an error response
-type ErrorResponse struct {
-	StatusCode int    `json:"-"`
-	Error      string `json:"error"`
+// HealthCheck reports the state of a single dependency, nil meaning ready
+type HealthCheck func() error
+
+// HealthResponse represents a liveness or readiness response
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// HealthHandler serves the liveness and readiness endpoints
+type HealthHandler struct {
+	Checks map[string]HealthCheck
+}
+
+// NewHealthHandler creates a new health handler running the given readiness checks
+func NewHealthHandler(checks map[string]HealthCheck) *HealthHandler {
+	return &HealthHandler{Checks: checks}
+}
+
+// HandleHealthz reports that the process is alive
+func (h *HealthHandler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// HandleReadyz reports whether every dependency is ready, 503 otherwise
+func (h *HealthHandler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	status, resp := http.StatusOK, HealthResponse{Status: "ok", Checks: map[string]string{}}
+	for name, check := range h.Checks {
+		if err := check(); err != nil {
+			status, resp.Status = http.StatusServiceUnavailable, "unavailable"
+			resp.Checks[name] = err.Error()
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+	writeJSON(w, status, resp)
+}
+
+// APIError is the envelope of every error response
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// statusClientClosedRequest is the non-standard status of a request cancelled
+// by the client (as used by nginx), it is mostly seen in logs
+const statusClientClosedRequest = 499
+
+// errorCodes are the APIError codes of the statuses returned by mapErrorToStatusCode
+var errorCodes = map[int]string{
+	http.StatusBadRequest:           "bad_request",
+	http.StatusNotFound:             "not_found",
+	http.StatusConflict:             "conflict",
+	http.StatusUnsupportedMediaType: "unsupported_media_type",
+	statusClientClosedRequest:       "request_cancelled",
+	http.StatusInternalServerError:  "internal_error",
+	http.StatusServiceUnavailable:   "timeout",
+}
+
+// mapErrorToStatusCode maps domain errors to HTTP statuses, unknown errors
+// being internal errors
+func mapErrorToStatusCode(err error) int {
+	var ve *ValidationError
+	switch {
+	case errors.As(err, &ve),
+		errors.Is(err, ErrInvalidJSON),
+		errors.Is(err, ErrMissingSearch),
+		errors.Is(err, ErrInvalidCursor),
+		errors.Is(err, ErrInvalidLimit),
+		errors.Is(err, ErrInvalidSort),
+		errors.Is(err, ErrInvalidField),
+		errors.Is(err, ErrEmptyPrefix):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrBookNotFound), errors.Is(err, ErrEndpointNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrBookExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrUnsupportedMedia):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 // Helper functions
@@ -315,26 +1464,234 @@ func writeJSON(w http.ResponseWriter, status int, data any) {
 	json.NewEncoder(w).Encode(data)
 }
 
-func writeError(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, ErrorResponse{
-		StatusCode: status,
-		Error: msg,
+func writeError(w http.ResponseWriter, err error) {
+	status := mapErrorToStatusCode(err)
+	apiErr := APIError{Code: errorCodes[status], Message: err.Error()}
+
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		apiErr.Code = "validation_error"
+		apiErr.Details = map[string]string{"field": ve.Field}
+	}
+	if status == http.StatusInternalServerError {
+		// Do not leak internal details to clients
+		log.Printf("internal error: %v", err)
+		apiErr.Message = "internal server error"
+	}
+	writeJSON(w, status, apiErr)
+}
+
+// CompressionConfig controls which responses Compress gzips
+type CompressionConfig struct {
+	MinSize      int      // smaller bodies are sent as is
+	ContentTypes []string // media types worth compressing
+}
+
+// DefaultCompressionConfig compresses JSON and CSV bodies of 1 KiB or more
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		MinSize:      1024,
+		ContentTypes: []string{"application/json", "text/csv"},
+	}
+}
+
+// compressible reports whether a Content-Type header is in the allowlist
+func (c CompressionConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.ContentTypes {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compress gzips the responses of next for clients accepting it, when the
+// content type is allowlisted and the body reaches cfg.MinSize. The body is
+// buffered up to MinSize to decide, so small error bodies go out as is. A
+// compressed response gets its own ETag and does not advertise ranges.
+func Compress(next http.Handler, cfg CompressionConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || ! acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, cfg: cfg, status: http.StatusOK}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
 	})
 }
 
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if ! strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		if name, q, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(q), 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressWriter holds the status and the start of the body back until it
+// knows whether to compress
+type compressWriter struct {
+	http.ResponseWriter
+	cfg     CompressionConfig
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if ! cw.decided {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if ! cw.decided {
+		cw.buf.Write(p)
+		if cw.buf.Len() < cw.cfg.MinSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressing if large is set and the response
+// qualifies, then the buffered body
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+	// A partial body is sent as is, its Content-Range counts identity bytes
+	if large && cw.status != http.StatusPartialContent && h.Get("Content-Encoding") == "" && cw.cfg.compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		// Ranges count identity bytes, the gzip body must neither offer them
+		// nor share the ETag a client resuming a download sends in If-Range
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); strings.HasSuffix(etag, `"`) {
+			h.Set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+		}
+		cw.gz = gzip.NewWriter(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// Close sends a body that stayed under the threshold, or ends the gzip stream
+func (cw *compressWriter) Close() error {
+	if ! cw.decided {
+		return cw.decide(false)
+	}
+	if cw.gz != nil {
+		return cw.gz.Close()
+	}
+	return nil
+}
+
+// shutdownTimeout bounds the time given to in-flight requests on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// run serves handler on addr until ctx is cancelled, then shuts the server
+// down gracefully and closes the closers (e.g. the store)
+func run(ctx context.Context, addr string, handler http.Handler, closers ...io.Closer) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		// The server failed to start
+	case <-ctx.Done():
+		log.Println("Server shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+		if serr := <-serveErr; err == nil && ! errors.Is(serr, http.ErrServerClosed) {
+			err = serr
+		}
+	}
+
+	for _, c := range closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 func main() {
 	// Initialize the repository, service, and handler
-	repo := NewInMemoryBookRepository()
-	service := NewBookService(repo)
+	repo, err := NewBookRepository(os.Getenv("STORE_BACKEND"))
+	if err != nil {
+		log.Fatalf("Failed to create the store: %v", err)
+	}
+	// BOOK_CACHE_SIZE > 0 caches the books read by ID, see CachingRepository
+	cached := repo
+	if size, err := strconv.Atoi(os.Getenv("BOOK_CACHE_SIZE")); err == nil && size > 0 {
+		cached = NewCachingRepository(repo, size)
+	}
+	service := NewBookService(cached)
 	handler := NewBookHandler(service)
+	handler.StrictContentType = os.Getenv("STRICT_CONTENT_TYPE") == "true"
+	checks := map[string]HealthCheck{}
+	if p, ok := repo.(interface{ Ping() error }); ok {
+		checks["store"] = p.Ping
+	}
+	health := NewHealthHandler(checks)
 
 	// Create a new router and register endpoints
-	http.HandleFunc("/api/books", handler.HandleBooks)
-	http.HandleFunc("/api/books/", handler.HandleBooks)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/books", handler.HandleBooks)
+	mux.HandleFunc("/api/books/", handler.HandleBooks)
+	mux.HandleFunc("/healthz", health.HandleHealthz)
+	mux.HandleFunc("/readyz", health.HandleReadyz)
 
-	// Start the server
+	// The store is closed after shutdown if it holds resources (e.g. a DB)
+	var closers []io.Closer
+	if c, ok := repo.(io.Closer); ok {
+		closers = append(closers, c)
+	}
+
+	// Start the server, until SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	log.Println("Server starting on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	if err := run(ctx, ":8080", Compress(mux, DefaultCompressionConfig()), closers...); err != nil {
+		log.Fatalf("Server error: %v", err)
 	}
-} 
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -157,10 +159,23 @@ func getProductsHandler(c fiber.Ctx) error {
 		"search":    c.Query("search"),
 	}
 
+	var order []productSortKey
+	if c.Request().URI().QueryArgs().Has("sort") {
+		var sortErr *ValidationError
+		if order, sortErr = parseProductSort(c.Query("sort")); sortErr != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Success: false,
+				Error:   "Invalid sort",
+				Details: []ValidationError{*sortErr},
+			})
+		}
+	}
+
 	productsMu.RLock()
 	defer productsMu.RUnlock()
 
 	filteredProducts := filterProducts(products, filters)
+	sortProducts(filteredProducts, order)
 	return c.JSON(filteredProducts)
 }
 
@@ -360,3 +375,70 @@ func filterProducts(products []Product, filters map[string]string) []Product {
 	}
 	return results
 }
+
+// -------------------------------------------------------------------
+// Sorting
+// -------------------------------------------------------------------
+
+// productSortKeys are the allowed keys of the sort query parameter
+var productSortKeys = map[string]func(a, b Product) int{
+	"id":       func(a, b Product) int { return cmp.Compare(a.ID, b.ID) },
+	"name":     func(a, b Product) int { return cmp.Compare(a.Name, b.Name) },
+	"price":    func(a, b Product) int { return cmp.Compare(a.Price, b.Price) },
+	"category": func(a, b Product) int { return cmp.Compare(a.Category, b.Category) },
+	"sku":      func(a, b Product) int { return cmp.Compare(a.SKU, b.SKU) },
+	"in_stock": func(a, b Product) int {
+		if a.InStock == b.InStock {
+			return 0
+		}
+		if a.InStock {
+			return 1
+		}
+		return -1
+	},
+}
+
+type productSortKey struct {
+	compare func(a, b Product) int
+	desc    bool
+}
+
+// parseProductSort parses comma-separated sort keys such as
+// "category,-price", a '-' prefix sorting descending. Unknown, empty and
+// repeated keys are rejected.
+func parseProductSort(param string) ([]productSortKey, *ValidationError) {
+	var order []productSortKey
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(param, ",") {
+		key = strings.TrimSpace(key)
+		name := strings.TrimPrefix(key, "-")
+		compare, ok := productSortKeys[name]
+		if !ok {
+			return nil, &ValidationError{Field: "sort", Tag: "sort", Value: key, Message: fmt.Sprintf("unknown sort key %q", key)}
+		}
+		if seen[name] {
+			return nil, &ValidationError{Field: "sort", Tag: "sort", Value: key, Message: fmt.Sprintf("duplicate sort key %q", name)}
+		}
+		seen[name] = true
+		order = append(order, productSortKey{compare: compare, desc: key != name})
+	}
+	return order, nil
+}
+
+// sortProducts sorts on the keys in turn, ties left are ordered by ID
+func sortProducts(products []Product, order []productSortKey) {
+	if len(order) == 0 {
+		return
+	}
+	slices.SortStableFunc(products, func(a, b Product) int {
+		for _, key := range order {
+			if c := key.compare(a, b); c != 0 {
+				if key.desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupSortApp() *fiber.App {
+	products = []Product{
+		{ID: 1, Name: "Laptop", Price: 999.99, Category: "electronics", SKU: "PROD-00001", InStock: true},
+		{ID: 2, Name: "T-Shirt", Price: 29.99, Category: "clothing", SKU: "PROD-00002", InStock: true},
+		{ID: 3, Name: "Phone", Price: 599.99, Category: "electronics", SKU: "PROD-00003", InStock: false},
+		{ID: 4, Name: "Novel", Price: 14.99, Category: "books", SKU: "PROD-00004", InStock: true},
+		{ID: 5, Name: "Jeans", Price: 59.99, Category: "clothing", SKU: "PROD-00005", InStock: false},
+		{ID: 6, Name: "Tablet", Price: 599.99, Category: "electronics", SKU: "PROD-00006", InStock: true},
+	}
+	nextID = 7
+	setupCustomValidator()
+
+	app := fiber.New()
+	app.Get("/products", getProductsHandler)
+	return app
+}
+
+// getSorted lists the products with the given sort parameter, returning
+// their IDs
+func getSorted(t *testing.T, app *fiber.App, sort string) (int, []int) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", "/products?sort="+url.QueryEscape(sort), nil))
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	var listed []Product
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	ids := make([]int, 0, len(listed))
+	for _, p := range listed {
+		ids = append(ids, p.ID)
+	}
+	return resp.StatusCode, ids
+}
+
+func TestGetProductsSorted(t *testing.T) {
+	app := setupSortApp()
+
+	tests := []struct {
+		sort string
+		ids  []int
+	}{
+		// Ties on the category are ordered by price, then by ID
+		{"category,price", []int{4, 2, 5, 3, 6, 1}},
+		{"category,-price", []int{4, 5, 2, 1, 3, 6}},
+		{"-price", []int{1, 3, 6, 5, 2, 4}},
+		{"-in_stock,name", []int{1, 4, 2, 6, 5, 3}},
+		{"price,-id", []int{4, 2, 5, 6, 3, 1}},
+	}
+	for _, tt := range tests {
+		status, ids := getSorted(t, app, tt.sort)
+		assert.Equal(t, http.StatusOK, status, tt.sort)
+		assert.Equal(t, tt.ids, ids, tt.sort)
+	}
+}
+
+func TestGetProductsSortedAndFiltered(t *testing.T) {
+	app := setupSortApp()
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/products?category=electronics&sort=-price,name", nil))
+	assert.NoError(t, err)
+	var listed []Product
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	if assert.Len(t, listed, 3) {
+		assert.Equal(t, []string{"Laptop", "Phone", "Tablet"}, []string{listed[0].Name, listed[1].Name, listed[2].Name})
+	}
+}
+
+func TestGetProductsInvalidSort(t *testing.T) {
+	app := setupSortApp()
+
+	for _, sort := range []string{"weight", "price,-price", "name,name", "", "price,", "--price"} {
+		status, _ := getSorted(t, app, sort)
+		assert.Equal(t, http.StatusBadRequest, status, sort)
+	}
+
+	// Without the parameter the stored order is kept
+	resp, err := app.Test(httptest.NewRequest("GET", "/products", nil))
+	assert.NoError(t, err)
+	var listed []Product
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	assert.Len(t, listed, 6)
+	assert.Equal(t, 1, listed[0].ID)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"regexp"
 	"strconv"
@@ -82,7 +83,10 @@ func getUserByID(c *gin.Context) {
 		})
 		return
 	}
-	if id < 1 || id > int64(len(users)) {
+	// IDs are not positions: after a deletion the largest ID is greater
+	// than the number of users, so only a lookup can tell if it exists
+	user, _ := findUserByID(int(id))
+	if user == nil {
 		c.JSON(404, Response{
 			Success: false,
 			Error:   "User not found",
@@ -90,18 +94,9 @@ func getUserByID(c *gin.Context) {
 		})
 		return
 	}
-	for i := 0; i < len(users); i++ {
-		if users[i].ID == int(id) {
-			c.JSON(200, Response{
-				Success: true,
-				Data:    users[i],
-			})
-			return
-		}
-	}
-	c.JSON(404, Response{
+	c.JSON(200, Response{
 		Success: true,
-		Data:    nil,
+		Data:    *user,
 	})
 	// Handle invalid ID format
 	// Return 404 if user not found
@@ -126,8 +121,18 @@ func createUser(c *gin.Context) {
 		})
 		return
 	}
-	user.ID = nextID
-	users = append(users, user)
+	// A client supplied ID is ignored, the server always assigns it
+	user.ID = newUserID()
+	created := append(users[:len(users):len(users)], user)
+	if err := checkUserIDs(created); err != nil {
+		c.JSON(500, Response{
+			Success: false,
+			Error:   err.Error(),
+			Code:    500,
+		})
+		return
+	}
+	users = created
 	c.JSON(201, Response{
 		Success: true,
 		Data:    user,
@@ -153,7 +158,8 @@ func updateUser(c *gin.Context) {
 
 	// Parse JSON request body
 	var updatedUser User
-	if id < 1 || id > int64(len(users)) {
+	_, index := findUserByID(int(id))
+	if index < 0 {
 		c.JSON(404, Response{
 			Success: false,
 			Error:   "User not found",
@@ -169,7 +175,7 @@ func updateUser(c *gin.Context) {
 		return
 	}
 	updatedUser.ID = int(id)
-	users[id] = updatedUser
+	users[index] = updatedUser
 	c.JSON(200, Response{
 		Success: true,
 		Data:    updatedUser,
@@ -191,13 +197,6 @@ func deleteUser(c *gin.Context) {
 		})
 		return
 	}
-	if id < 1 || id > int64(len(users)) {
-		c.JSON(404, Response{
-			Success: false,
-			Error:   "User not found",
-			Code:    404,
-		})
-	}
 	for i := 0; i < len(users); i++ {
 		if users[i].ID == int(id) {
 			users = append(users[:i], users[i+1:]...)
@@ -266,6 +265,35 @@ func findUserByID(id int) (*User, int) {
 	return nil, -1
 }
 
+// newUserID returns the next ID. IDs are never reused, and nextID is moved
+// past the largest ID in the store so it cannot hand out a duplicate.
+func newUserID() int {
+	for _, user := range users {
+		if user.ID >= nextID {
+			nextID = user.ID + 1
+		}
+	}
+	id := nextID
+	nextID++
+	return id
+}
+
+// checkUserIDs verifies the store invariant: IDs are positive, unique and
+// lower than nextID
+func checkUserIDs(list []User) error {
+	seen := make(map[int]bool, len(list))
+	for _, user := range list {
+		if user.ID < 1 || user.ID >= nextID {
+			return fmt.Errorf("user ID %d is out of range", user.ID)
+		}
+		if seen[user.ID] {
+			return fmt.Errorf("duplicate user ID %d", user.ID)
+		}
+		seen[user.ID] = true
+	}
+	return nil
+}
+
 // Helper function to validate user data
 func validateUser(user User) error {
 	// 1. 检查必填字段
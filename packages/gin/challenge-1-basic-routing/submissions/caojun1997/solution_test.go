@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupUsersRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	users = []User{
+		{ID: 1, Name: "John Doe", Email: "john@example.com", Age: 30},
+		{ID: 2, Name: "Jane Smith", Email: "jane@example.com", Age: 25},
+		{ID: 3, Name: "Bob Wilson", Email: "bob@example.com", Age: 35},
+	}
+	nextID = 4
+
+	router := gin.New()
+	router.GET("/users/:id", getUserByID)
+	router.POST("/users", createUser)
+	router.PUT("/users/:id", updateUser)
+	router.DELETE("/users/:id", deleteUser)
+	return router
+}
+
+func request(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// createdID creates a user and returns the assigned ID
+func createdID(t *testing.T, router *gin.Engine, body string) int {
+	t.Helper()
+	w := request(router, "POST", "/users", body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Data User `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp.Data.ID
+}
+
+func TestCreateUserIgnoresClientID(t *testing.T) {
+	router := setupUsersRouter()
+
+	first := createdID(t, router, `{"id": 1, "name": "Eve", "email": "eve@example.com", "age": 28}`)
+	second := createdID(t, router, `{"id": 4, "name": "Mallory", "email": "mallory@example.com", "age": 40}`)
+	assert.Equal(t, 4, first)
+	assert.Equal(t, 5, second)
+	assert.NoError(t, checkUserIDs(users))
+
+	// The user with ID 1 was not overwritten
+	user, _ := findUserByID(1)
+	assert.Equal(t, "John Doe", user.Name)
+}
+
+func TestCreateUserAfterDeletion(t *testing.T) {
+	router := setupUsersRouter()
+
+	assert.Equal(t, http.StatusOK, request(router, "DELETE", "/users/3", "").Code)
+	assert.Equal(t, http.StatusOK, request(router, "DELETE", "/users/1", "").Code)
+
+	// Deleted IDs are not reused
+	id := createdID(t, router, `{"name": "Eve", "email": "eve@example.com", "age": 28}`)
+	assert.Equal(t, 4, id)
+	assert.Equal(t, http.StatusNotFound, request(router, "GET", "/users/3", "").Code)
+
+	// A nextID that fell behind the store is moved past the largest ID
+	nextID = 2
+	id = createdID(t, router, `{"name": "Trent", "email": "trent@example.com", "age": 50}`)
+	assert.Equal(t, 5, id)
+	assert.NoError(t, checkUserIDs(users))
+}
+
+func TestGetUserByIDBeyondLength(t *testing.T) {
+	router := setupUsersRouter()
+	request(router, "DELETE", "/users/1", "")
+	request(router, "DELETE", "/users/2", "")
+	id := createdID(t, router, `{"name": "Eve", "email": "eve@example.com", "age": 28}`)
+
+	// Two users are left, IDs 3 and 4 are both found
+	for _, want := range []int{3, id} {
+		w := request(router, "GET", "/users/"+strconv.Itoa(want), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Data User `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, want, resp.Data.ID)
+	}
+
+	w := request(router, "GET", "/users/2", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"success":false`)
+
+	// Updates go to the user with that ID, not to that position
+	w = request(router, "PUT", "/users/4", `{"name": "Eve Updated", "email": "eve@example.com", "age": 29}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	user, _ := findUserByID(4)
+	assert.Equal(t, "Eve Updated", user.Name)
+	assert.Equal(t, 2, len(users))
+}
+
+func TestCheckUserIDs(t *testing.T) {
+	nextID = 4
+	assert.NoError(t, checkUserIDs([]User{{ID: 1}, {ID: 3}}))
+	assert.Error(t, checkUserIDs([]User{{ID: 1}, {ID: 1}}))
+	assert.Error(t, checkUserIDs([]User{{ID: 4}}))
+	assert.Error(t, checkUserIDs([]User{{ID: 0}}))
+}
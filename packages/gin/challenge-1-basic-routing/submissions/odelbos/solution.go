@@ -28,6 +28,12 @@ type Response struct {
 	Code    int     `json:"code,omitempty"`
 }
 
+// HealthResponse represents a liveness or readiness response
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
 // In-memory storage
 var users = []User{
 	{ID: 1, Name: "John Doe", Email: "john@example.com", Age: 30},
@@ -43,6 +49,8 @@ var nextID = 4
 
 func main() {
 	r := gin.Default()
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz)
 	r.GET("/users/search", searchUsers)
 	r.GET("/users", getAllUsers)
 	r.GET("/users/:id", getUserByID)
@@ -192,6 +200,23 @@ func searchUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, Response{Success: true, Data: results})
 }
 
+// healthz handles GET /healthz - liveness probe
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyz handles GET /readyz - readiness probe, 503 until the user store is set
+func readyz(c *gin.Context) {
+	if users == nil {
+		c.JSON(http.StatusServiceUnavailable, HealthResponse{
+			Status: "unavailable",
+			Checks: map[string]string{"users": "user store not initialized"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok", Checks: map[string]string{"users": "ok"}})
+}
+
 // ---------------------------------------------------------------
 // Helpers
 // ---------------------------------------------------------------
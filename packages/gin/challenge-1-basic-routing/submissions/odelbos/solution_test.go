@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func getHealth(path string) (int, HealthResponse) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	router.ServeHTTP(w, req)
+	var resp HealthResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	code, resp := getHealth("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+}
+
+func TestReadyzFollowsUserStore(t *testing.T) {
+	code, resp := getHealth("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"users": "ok"}, resp.Checks)
+
+	saved := users
+	defer func() { users = saved }()
+	users = nil
+	code, resp = getHealth("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", resp.Status)
+	assert.Equal(t, "user store not initialized", resp.Checks["users"])
+
+	// Liveness does not depend on the store
+	code, _ = getHealth("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -20,6 +20,9 @@ const (
 	RequestIDKey       = "request_id"
 	UserRoleKey        = "user_role"
 	RequestIDHeaderKey = "X-Request-ID"
+
+	// limiterIdleTTL is how long the limiter of an IP that stops sending is kept
+	limiterIdleTTL = time.Minute
 )
 
 // Article represents a blog article
@@ -41,62 +44,79 @@ type APIResponse struct {
 	RequestID string      `json:"request_id,omitempty"`
 }
 
-// LRUCache implements a thread-safe LRU cache for rate limiters
-type LRUCache struct {
-	mu      sync.RWMutex
-	cache   map[string]*lruNode
-	head    *lruNode
-	tail    *lruNode
+// LRUCache implements a thread-safe LRU cache whose entries also expire
+// after ttl without being accessed. Entries are ordered by last access, so
+// the expired ones are always at the tail.
+type LRUCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	cache   map[K]*lruNode[K, V]
+	head    *lruNode[K, V]
+	tail    *lruNode[K, V]
 	maxSize int
+	ttl     time.Duration    // idle lifetime of an entry, none if 0
+	now     func() time.Time // clock, replaced in tests
 }
 
 // lruNode represents a node in the doubly-linked list
-type lruNode struct {
-	key   string
-	value *rate.Limiter
-	next  *lruNode
-	prev  *lruNode
+type lruNode[K comparable, V any] struct {
+	key      K
+	value    V
+	lastUsed time.Time
+	next     *lruNode[K, V]
+	prev     *lruNode[K, V]
 }
 
-// NewLRUCache creates a new LRU cache with the given maximum size
-func NewLRUCache(maxSize int) *LRUCache {
-	return &LRUCache{
-		cache:   make(map[string]*lruNode),
+// NewLRUCache creates a new LRU cache with the given maximum size whose
+// entries expire after ttl without access (never if ttl is 0)
+func NewLRUCache[K comparable, V any](maxSize int, ttl time.Duration) *LRUCache[K, V] {
+	return &LRUCache[K, V]{
+		cache:   make(map[K]*lruNode[K, V]),
 		maxSize: maxSize,
+		ttl:     ttl,
+		now:     time.Now,
 	}
 }
 
-// Get retrieves a value from the cache
-func (c *LRUCache) Get(key string) (*rate.Limiter, bool) {
+// Get retrieves a value from the cache, refreshing its lifetime
+func (c *LRUCache[K, V]) Get(key K) (V, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	now := c.now()
+	c.evictExpired(now)
 	node, exists := c.cache[key]
 	if !exists {
-		return nil, false
+		var zero V
+		return zero, false
 	}
 
 	// Move to front (most recently used)
+	node.lastUsed = now
 	c.moveToFront(node)
 	return node.value, true
 }
 
 // Put adds a value to the cache
-func (c *LRUCache) Put(key string, value *rate.Limiter) {
+func (c *LRUCache[K, V]) Put(key K, value V) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	now := c.now()
+	c.evictExpired(now)
+
 	// If key already exists, update it
 	if node, exists := c.cache[key]; exists {
 		node.value = value
+		node.lastUsed = now
 		c.moveToFront(node)
 		return
 	}
 
 	// Create new node
-	newNode := &lruNode{
-		key:   key,
-		value: value,
+	newNode := &lruNode[K, V]{
+		key:      key,
+		value:    value,
+		lastUsed: now,
 	}
 
 	// Add to front
@@ -110,7 +130,7 @@ func (c *LRUCache) Put(key string, value *rate.Limiter) {
 }
 
 // Remove removes a key from the cache
-func (c *LRUCache) Remove(key string) {
+func (c *LRUCache[K, V]) Remove(key K) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -120,8 +140,16 @@ func (c *LRUCache) Remove(key string) {
 	}
 }
 
+// Len returns the number of entries, expired ones included until the next
+// Get or Put
+func (c *LRUCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.cache)
+}
+
 // moveToFront moves a node to the front of the list
-func (c *LRUCache) moveToFront(node *lruNode) {
+func (c *LRUCache[K, V]) moveToFront(node *lruNode[K, V]) {
 	if c.head == node {
 		return
 	}
@@ -131,7 +159,9 @@ func (c *LRUCache) moveToFront(node *lruNode) {
 }
 
 // addToFront adds a node to the front of the list
-func (c *LRUCache) addToFront(node *lruNode) {
+func (c *LRUCache[K, V]) addToFront(node *lruNode[K, V]) {
+	node.prev = nil
+	node.next = nil
 	if c.head == nil {
 		c.head = node
 		c.tail = node
@@ -143,7 +173,7 @@ func (c *LRUCache) addToFront(node *lruNode) {
 }
 
 // removeNode removes a node from the list
-func (c *LRUCache) removeNode(node *lruNode) {
+func (c *LRUCache[K, V]) removeNode(node *lruNode[K, V]) {
 	if node.prev != nil {
 		node.prev.next = node.next
 	} else {
@@ -158,7 +188,7 @@ func (c *LRUCache) removeNode(node *lruNode) {
 }
 
 // evict removes the least recently used item
-func (c *LRUCache) evict() {
+func (c *LRUCache[K, V]) evict() {
 	if c.tail == nil {
 		return
 	}
@@ -177,6 +207,16 @@ func (c *LRUCache) evict() {
 	}
 }
 
+// evictExpired removes the items idle for ttl or more, from the tail
+func (c *LRUCache[K, V]) evictExpired(now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	for c.tail != nil && now.Sub(c.tail.lastUsed) >= c.ttl {
+		c.evict()
+	}
+}
+
 // In-memory storage
 var (
 	articlesMutex sync.RWMutex
@@ -186,7 +226,8 @@ var (
 	}
 	nextID = 3
 
-	ipLimiters = NewLRUCache(1000)
+	// A limiter idle for a minute is refilled, dropping it loses nothing
+	ipLimiters = NewLRUCache[string, *rate.Limiter](1000, limiterIdleTTL)
 
 	keys = map[string]string{
 		"admin-key-123": "admin",
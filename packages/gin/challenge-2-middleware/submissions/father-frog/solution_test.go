@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// fakeClock is a settable clock for the cache
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func newTestCache[V any](maxSize int, ttl time.Duration) (*LRUCache[string, V], *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cache := NewLRUCache[string, V](maxSize, ttl)
+	cache.now = clock.Now
+	return cache, clock
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	cache, clock := newTestCache[int](10, time.Minute)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+
+	clock.Advance(30 * time.Second)
+	v, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	// b was idle for a minute, a was accessed 30s ago
+	clock.Advance(30 * time.Second)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, cache.Len())
+
+	// Expired entries are dropped by any Put, without reading them
+	cache.Put("c", 3)
+	clock.Advance(2 * time.Minute)
+	cache.Put("d", 4)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestLRUCacheCapacity(t *testing.T) {
+	cache, _ := newTestCache[int](2, 0)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Get("a")
+	cache.Put("c", 3)
+
+	_, ok := cache.Get("b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c"} {
+		_, ok := cache.Get(key)
+		assert.True(t, ok, key)
+	}
+
+	cache.Remove("a")
+	cache.Put("d", 4)
+	assert.Equal(t, 2, cache.Len())
+	_, ok = cache.Get("c")
+	assert.True(t, ok)
+}
+
+func TestRateLimitIdleBucketEvicted(t *testing.T) {
+	cache, clock := newTestCache[*rate.Limiter](1000, limiterIdleTTL)
+	saved := ipLimiters
+	ipLimiters = cache
+	defer func() { ipLimiters = saved }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RateLimitMiddleware())
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	send := func(ip string) {
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.RemoteAddr = ip + ":1234"
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("192.0.2.1")
+	send("192.0.2.2")
+	assert.Equal(t, 2, cache.Len())
+
+	// The first IP keeps sending, the second one stops
+	for i := 0; i < 4; i++ {
+		clock.Advance(limiterIdleTTL / 3)
+		send("192.0.2.1")
+	}
+	assert.Contains(t, cache.cache, "192.0.2.1")
+	assert.NotContains(t, cache.cache, "192.0.2.2")
+	assert.Equal(t, 1, cache.Len())
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"time"
+	"encoding/xml"
 	"net/http"
+	"net/netip"
 	"log"
+	"os"
+	"reflect"
+	"regexp"
 	"strconv"
 	"fmt"
 	"strings"
@@ -12,6 +17,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/html"
 	"golang.org/x/time/rate"
 )
 
@@ -38,6 +46,40 @@ type APIResponse struct {
 	RequestID string      `json:"request_id,omitempty"`
 }
 
+// xmlError is the XML envelope of an error APIResponse
+type xmlError struct {
+	XMLName   xml.Name `xml:"error"`
+	Status    int      `xml:"status,attr"`
+	Error     string   `xml:"message"`
+	Detail    string   `xml:"detail,omitempty"`
+	RequestID string   `xml:"request_id,omitempty"`
+}
+
+// HealthResponse represents a liveness or readiness response
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Page is a page of a list response
+type Page[T any] struct {
+	Items      []T  `json:"items"`
+	Page       int  `json:"page"`
+	Size       int  `json:"size"`
+	Total      int  `json:"total"`
+	TotalPages int  `json:"total_pages"`
+	HasNext    bool `json:"has_next"`
+}
+
+// ListOptions configures the page and size query parameters of a listing
+type ListOptions struct {
+	DefaultSize int // size used when the query has none
+	MaxSize     int // larger sizes are clamped to it
+}
+
+// articlesListOptions configures GET /articles
+var articlesListOptions = ListOptions{DefaultSize: 20, MaxSize: 100}
+
 // In-memory storage
 var articles = []Article{
 	{ID: 1, Title: "Getting Started with Go", Content: "Go is a programming language...", Author: "John Doe", CreatedAt: time.Now(), UpdatedAt: time.Now()},
@@ -45,24 +87,113 @@ var articles = []Article{
 }
 
 var nextID = 3
+var articlesMutex sync.RWMutex
 
 var (
 	rateLimiters = make(map[string]*rate.Limiter)
 	rateLimitMutex sync.Mutex
 )
 
+// RateLimitConfig lists the clients RateLimitMiddleware never limits
+type RateLimitConfig struct {
+	Allowlist []string `env:"RATE_LIMIT_ALLOWLIST"` // IPv4 or IPv6 addresses or CIDRs
+	APIKeys   []string `env:"RATE_LIMIT_API_KEYS"`  // X-API-Key values
+}
+
+// Validate checks the allowlist entries
+func (c RateLimitConfig) Validate() error {
+	_, err := ParseAllowlist(c.Allowlist)
+	return err
+}
+
+var rateLimitConfig = RateLimitConfig{}
+
+// Maximum number of requests handled concurrently
+var maxConcurrentRequests = 100
+
+// CORSConfig configures CORSMiddleware
+type CORSConfig struct {
+	AllowOrigin  string        `env:"CORS_ALLOW_ORIGIN" default:"http://localhost:3000"`
+	AllowHeaders string        `env:"CORS_ALLOW_HEADERS" default:"Content-Type,X-API-Key,X-Request-ID,X-Correlation-ID,If-Match"`
+	MaxAge       time.Duration `env:"CORS_MAX_AGE" default:"10m"` // preflight cache duration, none if 0
+}
+
+var corsConfig = CORSConfig{
+	AllowOrigin:  "http://localhost:3000",
+	AllowHeaders: "Content-Type,X-API-Key,X-Request-ID,X-Correlation-ID,If-Match",
+	MaxAge:       10 * time.Minute,
+}
+
+// requireIfMatch rejects with 428 the article updates without an If-Match
+// header, when false they overwrite unconditionally
+var requireIfMatch = false
+
+// HTML handling of the article content
+const (
+	HTMLKeep   = "keep"   // the text is stored as sent
+	HTMLStrip  = "strip"  // tags are removed, script and style with their content
+	HTMLEscape = "escape" // tags are escaped so they display as text
+)
+
+// HTMLPolicy says how the article content treats HTML. The AllowedTags
+// (lowercase names, e.g. "b", "em") survive either mode without attributes,
+// so they cannot carry event handlers or URLs: stripping drops the
+// attributes, escaping only restores the bare tags.
+type HTMLPolicy struct {
+	Mode        string   `env:"HTML_POLICY" default:"keep"`
+	AllowedTags []string `env:"HTML_ALLOWED_TAGS"`
+}
+
+// Validate checks the mode
+func (p HTMLPolicy) Validate() error {
+	if p.Mode != HTMLKeep && p.Mode != HTMLStrip && p.Mode != HTMLEscape {
+		return fmt.Errorf("unknown mode %q (expected %s, %s or %s)", p.Mode, HTMLKeep, HTMLStrip, HTMLEscape)
+	}
+	return nil
+}
+
+var htmlPolicy = HTMLPolicy{Mode: HTMLKeep}
+
+// ServerConfig is the configuration main loads from the environment, its
+// defaults are the ones of the variables above
+type ServerConfig struct {
+	Addr                  string `env:"ADDR" default:":8080"`
+	MaxConcurrentRequests int    `env:"MAX_CONCURRENT_REQUESTS" default:"100"`
+	RequireIfMatch        bool   `env:"REQUIRE_IF_MATCH" default:"false"`
+	CORS                  CORSConfig
+	RateLimit             RateLimitConfig
+	HTML                  HTMLPolicy
+}
+
+// Health check routes, never throttled
+var healthCheckPaths = []string{"/ping", "/healthz", "/readyz"}
+
+// Route label used for requests that match no registered route
+const unmatchedRoute = "unmatched"
+
 // ----------------------------------------------------------------
 // Main
 // ----------------------------------------------------------------
 
 func main() {
+	cfg, err := LoadConfig[ServerConfig]()
+	if err != nil {
+		log.Fatal(err)
+	}
+	corsConfig, rateLimitConfig, htmlPolicy = cfg.CORS, cfg.RateLimit, cfg.HTML
+	maxConcurrentRequests, requireIfMatch = cfg.MaxConcurrentRequests, cfg.RequireIfMatch
+
 	r := gin.New()
+	registry := prometheus.NewRegistry()
 
 	r.Use(
+		ResponseRecorderMiddleware(),
 		RequestIDMiddleware(),
+		MetricsMiddleware(registry),
 		ErrorHandlerMiddleware(),
+		ConcurrencyLimitMiddleware(maxConcurrentRequests),
 		LoggingMiddleware(),
-		CORSMiddleware(),
+		CORSMiddleware(r),
 		RateLimitMiddleware(),
 		ContentTypeMiddleware(),
 	)
@@ -70,6 +201,10 @@ func main() {
 	public := r.Group("/")
 	{
 		public.GET("/ping", ping)
+		public.GET("/healthz", healthz)
+		public.GET("/readyz", readyz)
+		public.GET("/metrics", MetricsHandler(registry))
+		public.GET("/articles/search", searchArticles)
 		public.GET("/articles/:id", getArticle)
 		public.GET("/articles", getArticles)
 	}
@@ -82,33 +217,225 @@ func main() {
 		private.GET("/admin/stats", getStats)
 	}
 
-	r.Run(":8080")
+	r.Run(cfg.Addr)
+}
+
+// ----------------------------------------------------------------
+// Configuration
+// ----------------------------------------------------------------
+
+// ConfigError lists every missing or invalid setting found by LoadConfig
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// LoadConfig builds a T from the environment. A field tagged env:"NAME" is
+// read from the NAME variable, else from its default:"..." tag, and is
+// missing if tagged required:"true" and neither is set. Untagged struct
+// fields are loaded the same way, and a struct with a Validate() error
+// method is validated once its fields are read. Every problem is reported
+// at once in a *ConfigError.
+func LoadConfig[T any]() (T, error) {
+	var cfg T
+	var problems []string
+	loadConfigStruct(reflect.ValueOf(&cfg).Elem(), &problems)
+	if len(problems) > 0 {
+		return cfg, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// loadConfigStruct sets the fields of the struct v, appending the problems
+func loadConfigStruct(v reflect.Value, problems *[]string) {
+	before := len(*problems)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if ! field.IsExported() {
+			continue
+		}
+		name, tagged := field.Tag.Lookup("env")
+		if ! tagged {
+			if field.Type.Kind() == reflect.Struct {
+				loadConfigStruct(v.Field(i), problems)
+			}
+			continue
+		}
+
+		raw := os.Getenv(name)
+		if raw == "" {
+			raw = field.Tag.Get("default")
+		}
+		if raw == "" {
+			if field.Tag.Get("required") == "true" {
+				*problems = append(*problems, name+" is required")
+			}
+			continue
+		}
+		if err := setConfigField(v.Field(i), raw); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	checker, ok := v.Addr().Interface().(interface{ Validate() error })
+	if ok && len(*problems) == before {
+		if err := checker.Validate(); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %v", v.Type().Name(), err))
+		}
+	}
+}
+
+// setConfigField parses raw into the field. Durations use the
+// time.ParseDuration format and string slices are comma separated.
+func setConfigField(f reflect.Value, raw string) error {
+	if f.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		f.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(raw, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		f.SetFloat(x)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", f.Type())
+		}
+		var items []string
+		for _, item := range(strings.Split(raw, ",")) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items).Convert(f.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
 }
 
 // ----------------------------------------------------------------
 // Middlewares
 // ----------------------------------------------------------------
 
-// RequestIDMiddleware generates a unique request ID for each request
+// RequestIDMiddleware assigns a request ID to each request, reusing a valid
+// inbound X-Request-ID (UUID) for cross-service correlation. The inbound
+// X-Correlation-ID is always propagated, it defaults to the request ID.
 func RequestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := uuid.New().String()
+		id := c.GetHeader("X-Request-ID")
+		if ! isValidRequestID(id) {
+			id = uuid.New().String()
+		}
+		correlationID := c.GetHeader("X-Correlation-ID")
+		if ! isValidCorrelationID(correlationID) {
+			correlationID = id
+		}
 		c.Set("request_id", id)
+		c.Set("correlation_id", correlationID)
 		c.Writer.Header().Set("X-Request-ID", id)
+		c.Writer.Header().Set("X-Correlation-ID", correlationID)
 		c.Next()
 	}
 }
 
+// responseRecorder wraps the response writer to record the final status
+// and the number of body bytes written. Flush, Hijack and the other
+// ResponseWriter methods are delegated, so streaming keeps working.
+type responseRecorder struct {
+	gin.ResponseWriter
+	status int
+	size   int
+}
+
+// WriteHeader records the code, non positive codes are ignored as done by
+// gin (SSEvent renders with -1)
+func (w *responseRecorder) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.size += n
+	return n, err
+}
+
+// ResponseRecorderMiddleware records the status and size of the response,
+// read by the later middlewares with recordedResponse. Install it first so
+// every write goes through the recorder.
+func ResponseRecorderMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rec := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Set("response_recorder", rec)
+		c.Next()
+	}
+}
+
+// recordedResponse returns the status and body size recorded so far, ok is
+// false without ResponseRecorderMiddleware
+func recordedResponse(c *gin.Context) (status, size int, ok bool) {
+	value, exists := c.Get("response_recorder")
+	rec, _ := value.(*responseRecorder)
+	if ! exists || rec == nil {
+		return 0, 0, false
+	}
+	status = rec.status
+	if status == 0 {
+		status = rec.ResponseWriter.Status()
+	}
+	return status, rec.size, true
+}
+
 // LoggingMiddleware logs all requests with timing information
 func LoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		start := time.Now()
 		c.Next()
-		log.Printf("[%s] %s %s %d %s %s %s",
+		status, size, ok := recordedResponse(c)
+		if ! ok {
+			status, size = c.Writer.Status(), max(c.Writer.Size(), 0)
+		}
+		log.Printf("[%s] %s %s %d %dB %s %s %s",
 			c.GetString("request_id"),
 			c.Request.Method,
 			c.Request.URL.Path,
-			c.Writer.Status(),
-			time.Since(time.Now()),
+			status,
+			size,
+			time.Since(start),
 			c.ClientIP(),
 			c.Request.UserAgent(),
 		)
@@ -135,13 +462,28 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// CORSMiddleware handles cross-origin requests
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware handles cross-origin requests as set by corsConfig. Given
+// the router, the allowed methods are the ones registered for the requested
+// path and unknown paths get no CORS headers (and a 404), else a static list
+// is advertised.
+func CORSMiddleware(router ...*gin.Engine) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "http://localhost:3000")
-		c.Header("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type,X-API-Key,X-Request-ID")
+		methods := "GET, POST, PUT, DELETE, OPTIONS"
+		if len(router) > 0 {
+			allowed := allowedMethods(router[0].Routes(), c.Request.URL.Path)
+			if len(allowed) == 0 {
+				c.Next()
+				return
+			}
+			methods = strings.Join(append(allowed, http.MethodOptions), ", ")
+		}
+		c.Header("Access-Control-Allow-Origin", corsConfig.AllowOrigin)
+		c.Header("Access-Control-Allow-Methods", methods)
+		c.Header("Access-Control-Allow-Headers", corsConfig.AllowHeaders)
 		if c.Request.Method == "OPTIONS" {
+			if corsConfig.MaxAge > 0 {
+				c.Header("Access-Control-Max-Age", strconv.Itoa(int(corsConfig.MaxAge.Seconds())))
+			}
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
@@ -150,6 +492,9 @@ func CORSMiddleware() gin.HandlerFunc {
 }
 
 // RateLimitMiddleware implements rate limiting per IP
+// The clients of rateLimitConfig bypass the limiter without consuming a
+// token, they get X-RateLimit-Exempt instead of the rate limit headers. It
+// panics if the allowlist has an invalid entry.
 func RateLimitMiddleware() gin.HandlerFunc {
 	// TODO: Implement rate limiting
 	// Limit: 100 requests per IP per minute
@@ -157,8 +502,21 @@ func RateLimitMiddleware() gin.HandlerFunc {
 	// Set headers: X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset
 	// Return 429 if rate limit exceeded
 
+	allowlist, err := ParseAllowlist(rateLimitConfig.Allowlist)
+	if err != nil {
+		panic(fmt.Sprintf("rate limit allowlist: %v", err))
+	}
+	apiKeys := slices.Clone(rateLimitConfig.APIKeys)
+
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
+		key := c.GetHeader("X-API-Key")
+		if isAllowlisted(allowlist, ip) || (key != "" && slices.Contains(apiKeys, key)) {
+			c.Writer.Header().Set("X-RateLimit-Exempt", "true")
+			c.Next()
+			return
+		}
+
 		rateLimitMutex.Lock()
 		limiter, ok := rateLimiters[ip]
 		if ! ok {
@@ -184,6 +542,66 @@ func RateLimitMiddleware() gin.HandlerFunc {
 
 }
 
+// ParseAllowlist parses IP addresses and CIDRs, an address is a prefix
+// matching only itself
+func ParseAllowlist(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range(entries) {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// isAllowlisted reports whether ip is in one of the prefixes, IPv4-mapped
+// IPv6 addresses match the IPv4 prefixes
+func isAllowlisted(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range(prefixes) {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConcurrencyLimitMiddleware rejects requests with 503 when max requests are
+// already being handled, health checks are never rejected
+func ConcurrencyLimitMiddleware(max int) gin.HandlerFunc {
+	slots := make(chan struct{}, max)
+	return func(c *gin.Context) {
+		if slices.Contains(healthCheckPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			errResponse(c, http.StatusServiceUnavailable, "Server is busy")
+			c.Abort()
+		}
+	}
+}
+
 // ContentTypeMiddleware validates content type for POST/PUT requests
 func ContentTypeMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -198,14 +616,42 @@ func ContentTypeMiddleware() gin.HandlerFunc {
 	}
 }
 
+// MetricsMiddleware records request counts and latencies in reg, labelled by
+// method, route pattern (c.FullPath) and status. It must run before the
+// recovery middleware so that recovered panics are counted as 500.
+func MetricsMiddleware(reg prometheus.Registerer) gin.HandlerFunc {
+	labels := []string{"method", "route", "status"}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests.",
+	}, labels)
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, labels)
+	reg.MustRegister(requests, latency)
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		latency.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
 // ErrorHandlerMiddleware handles panics and errors
 func ErrorHandlerMiddleware() gin.HandlerFunc {
 	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success:   false,
-			Error:     "Internal server error",
-			Message:   fmt.Sprintf("%v", recovered),
-			RequestID: c.GetString("request_id"),
+		respond(c, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal server error",
+			Message: fmt.Sprintf("%v", recovered),
 		})
 		c.Abort()
 	})
@@ -220,9 +666,45 @@ func ping(c *gin.Context) {
 	okResponse(c, http.StatusOK, "pong", nil)
 }
 
+// healthz handles GET /healthz - liveness probe
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyz handles GET /readyz - readiness probe, 503 until the article store is set
+func readyz(c *gin.Context) {
+	if err := checkArticleStore(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, HealthResponse{
+			Status: "unavailable",
+			Checks: map[string]string{"articles": err.Error()},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok", Checks: map[string]string{"articles": "ok"}})
+}
+
+// MetricsHandler serves GET /metrics - metrics of g in the Prometheus text format
+func MetricsHandler(g prometheus.Gatherer) gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+}
+
 // getArticles handles GET /articles - get all articles with pagination
+// The data is a Page when page or size is given, else the whole list
 func getArticles(c *gin.Context) {
-	okResponse(c, http.StatusOK, "Articles", articles)
+	articlesMutex.RLock()
+	list := slices.Clone(articles)
+	articlesMutex.RUnlock()
+
+	if c.Query("page") == "" && c.Query("size") == "" {
+		okResponse(c, http.StatusOK, "Articles", list)
+		return
+	}
+	page, size, err := articlesListOptions.Parse(c)
+	if err != nil {
+		errResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	okResponse(c, http.StatusOK, "Articles", Paginate(list, page, size))
 }
 
 // getArticle handles GET /articles/:id - get article by ID
@@ -232,14 +714,43 @@ func getArticle(c *gin.Context) {
 		errResponse(c, http.StatusBadRequest, "Invalid ID")
 		return
 	}
+	articlesMutex.RLock()
 	article, _ := findArticleByID(id)
+	articlesMutex.RUnlock()
 	if article == nil {
 		errResponse(c, http.StatusNotFound, "Not found")
 		return
 	}
+	c.Header("ETag", articleETag(*article))
 	okResponse(c, http.StatusOK, "Article", article)
 }
 
+// searchArticles handles GET /articles/search - filter articles by author
+// and/or by a case-insensitive text contained in the title or content
+func searchArticles(c *gin.Context) {
+	author := strings.ToLower(strings.TrimSpace(c.Query("author")))
+	q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if author == "" && q == "" {
+		errResponse(c, http.StatusBadRequest, "author or q is required")
+		return
+	}
+
+	articlesMutex.RLock()
+	result := []Article{}
+	for _, article := range(articles) {
+		if author != "" && ! strings.Contains(strings.ToLower(article.Author), author) {
+			continue
+		}
+		if q != "" && ! strings.Contains(strings.ToLower(article.Title), q) &&
+			! strings.Contains(strings.ToLower(article.Content), q) {
+			continue
+		}
+		result = append(result, article)
+	}
+	articlesMutex.RUnlock()
+	okResponse(c, http.StatusOK, "Articles", result)
+}
+
 // createArticle handles POST /articles - create new article (protected)
 func createArticle(c *gin.Context) {
 	var article Article
@@ -247,20 +758,25 @@ func createArticle(c *gin.Context) {
 		errResponse(c, http.StatusBadRequest, "Invalid request")
 		return
 	}
+	article.Content = htmlPolicy.Sanitize(article.Content)
 	if err := validateArticle(article); err != nil {
 		errResponse(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	articlesMutex.Lock()
 	article.ID = nextID
 	article.CreatedAt = time.Now()
 	article.UpdatedAt = article.CreatedAt
 	articles = append(articles, article)
 	nextID++
+	articlesMutex.Unlock()
 	okResponse(c, http.StatusCreated, "Article created", article)
 }
 
 // updateArticle handles PUT /articles/:id - update article (protected)
+// With an If-Match header the update only applies if the article still has
+// that ETag, a stale one gets 412 so concurrent edits are not lost.
 func updateArticle(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -273,21 +789,37 @@ func updateArticle(c *gin.Context) {
 		errResponse(c, http.StatusBadRequest, "Invalid request")
 		return
 	}
+	articleData.Content = htmlPolicy.Sanitize(articleData.Content)
 	if err := validateArticle(articleData); err != nil {
 		errResponse(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" && requireIfMatch {
+		errResponse(c, http.StatusPreconditionRequired, "If-Match header required")
+		return
+	}
+
+	articlesMutex.Lock()
 	article, index := findArticleByID(id)
 	if article == nil {
+		articlesMutex.Unlock()
 		errResponse(c, http.StatusNotFound, "Not found")
 		return
 	}
+	if ifMatch != "" && ! etagMatches(ifMatch, articleETag(*article)) {
+		articlesMutex.Unlock()
+		errResponse(c, http.StatusPreconditionFailed, "Article was modified")
+		return
+	}
 
 	articleData.ID = id
 	articleData.CreatedAt = article.CreatedAt
 	articleData.UpdatedAt = time.Now()
 	articles[index] = articleData
+	articlesMutex.Unlock()
+	c.Header("ETag", articleETag(articleData))
 	okResponse(c, http.StatusOK, "Article updated", articleData)
 }
 
@@ -299,13 +831,16 @@ func deleteArticle(c *gin.Context) {
 		return
 	}
 
+	articlesMutex.Lock()
 	_, index := findArticleByID(id)
 	if index == -1 {
+		articlesMutex.Unlock()
 		errResponse(c, http.StatusNotFound, "Not found")
 		return
 	}
 
 	articles = slices.Delete(articles, index, index + 1)
+	articlesMutex.Unlock()
 	okResponse(c, http.StatusOK, "Article deleted", nil)
 }
 
@@ -316,8 +851,12 @@ func getStats(c *gin.Context) {
 		return
 	}
 
+	articlesMutex.RLock()
+	total := len(articles)
+	articlesMutex.RUnlock()
+
 	stats := map[string]interface{}{
-		"total_articles": len(articles),
+		"total_articles": total,
 		"total_requests": 0,
 		"uptime":         time.Since(time.Now().Add(-24 * time.Hour)).String(),
 	}
@@ -328,7 +867,7 @@ func getStats(c *gin.Context) {
 // Helpers
 // ----------------------------------------------------------------
 
-// findArticleByID finds an article by ID
+// findArticleByID finds an article by ID, articlesMutex must be held
 func findArticleByID(id int) (*Article, int) {
 	for i, a := range(articles) {
 		if a.ID == id {
@@ -338,6 +877,233 @@ func findArticleByID(id int) (*Article, int) {
 	return nil, -1
 }
 
+// articleETag is the entity tag of an article version, it changes with
+// every update
+func articleETag(article Article) string {
+	return fmt.Sprintf(`"%d-%d"`, article.ID, article.UpdatedAt.UnixNano())
+}
+
+// etagMatches reports whether an If-Match header, a list of ETags or "*",
+// matches etag. Weak ETags never match, If-Match uses strong comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range(strings.Split(header, ",")) {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Paginate returns the given 1-based page of items, a page beyond the end
+// has no items. Page and size below 1 are raised to 1, use ListOptions to
+// parse and bound them. Items share the backing array of the input slice.
+func Paginate[T any](items []T, page, size int) Page[T] {
+	page, size = max(page, 1), max(size, 1)
+
+	total := len(items)
+	start := total
+	if page-1 <= total/size { // avoids overflowing on huge pages
+		start = min((page-1)*size, total)
+	}
+	end := min(start+size, total)
+	pageItems := items[start:end]
+	if pageItems == nil {
+		pageItems = []T{}
+	}
+	return Page[T]{
+		Items:      pageItems,
+		Page:       page,
+		Size:       size,
+		Total:      total,
+		TotalPages: (total + size - 1) / size,
+		HasNext:    end < total,
+	}
+}
+
+// Parse reads the optional page (default 1) and size query parameters.
+// Non-numeric, zero or negative values are rejected, a size above MaxSize
+// is clamped.
+func (o ListOptions) Parse(c *gin.Context) (page, size int, err error) {
+	page, size = 1, o.DefaultSize
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page: %q, must be a positive integer", v)
+		}
+	}
+	if v := c.Query("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 {
+			return 0, 0, fmt.Errorf("invalid size: %q, must be a positive integer", v)
+		}
+	}
+	return page, min(size, o.MaxSize), nil
+}
+
+// allowedMethods returns the sorted methods of the routes matching path,
+// OPTIONS excepted
+func allowedMethods(routes gin.RoutesInfo, path string) []string {
+	var methods []string
+	for _, route := range(routes) {
+		if route.Method == http.MethodOptions || slices.Contains(methods, route.Method) {
+			continue
+		}
+		if matchRoute(route.Path, path) {
+			methods = append(methods, route.Method)
+		}
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+// matchRoute reports whether path matches a gin route pattern, where a
+// :param matches one segment and a *catchAll the rest of the path
+func matchRoute(pattern, path string) bool {
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range(parts) {
+		if strings.HasPrefix(part, "*") {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if strings.HasPrefix(part, ":") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if part != segments[i] {
+			return false
+		}
+	}
+	return len(parts) == len(segments)
+}
+
+// isValidRequestID reports whether an inbound request ID is a canonical UUID
+func isValidRequestID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+// isValidCorrelationID reports whether an inbound correlation ID is safe to
+// propagate: 1 to 128 printable ASCII characters without spaces
+func isValidCorrelationID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// SeedArticles replaces the stored articles with the given fixtures, IDs are
+// reassigned from 1 in order and missing timestamps set to now
+func SeedArticles(seed ...Article) {
+	articlesMutex.Lock()
+	defer articlesMutex.Unlock()
+	now := time.Now()
+	articles = make([]Article, 0, len(seed))
+	for i, article := range seed {
+		article.ID = i + 1
+		if article.CreatedAt.IsZero() {
+			article.CreatedAt = now
+		}
+		if article.UpdatedAt.IsZero() {
+			article.UpdatedAt = article.CreatedAt
+		}
+		articles = append(articles, article)
+	}
+	nextID = len(seed) + 1
+}
+
+// checkArticleStore reports whether the article store is initialized
+func checkArticleStore() error {
+	articlesMutex.RLock()
+	defer articlesMutex.RUnlock()
+	if articles == nil {
+		return fmt.Errorf("article store not initialized")
+	}
+	return nil
+}
+
+// htmlEscapedTagRegexp matches a bare tag once escaped, e.g. &lt;/b&gt;
+var htmlEscapedTagRegexp = regexp.MustCompile(`&lt;(/?)([a-zA-Z][a-zA-Z0-9]*)&gt;`)
+
+// Sanitize applies the policy to s, text without tags is returned unchanged
+func (p HTMLPolicy) Sanitize(s string) string {
+	if (p.Mode != HTMLStrip && p.Mode != HTMLEscape) || ! strings.Contains(s, "<") {
+		return s
+	}
+	if p.Mode == HTMLStrip {
+		return p.strip(s)
+	}
+	return htmlEscapedTagRegexp.ReplaceAllStringFunc(html.EscapeString(s), func(tag string) string {
+		m := htmlEscapedTagRegexp.FindStringSubmatch(tag)
+		name := strings.ToLower(m[2])
+		if slices.Contains(p.AllowedTags, name) {
+			return "<" + m[1] + name + ">"
+		}
+		return tag
+	})
+}
+
+// strip tokenizes s the way a browser does, so a malformed tag cannot slip
+// through. Script and style go with their content, comments and other tags
+// are dropped and the text is kept.
+func (p HTMLPolicy) strip(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	rawTag := "" // set while reading the content of e.g. <script> or <title>
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			switch rawTag {
+			case "":
+				// A trailing < would open a tag with whatever follows
+				text := string(z.Raw())
+				if strings.HasSuffix(text, "<") {
+					text = strings.TrimSuffix(text, "<") + "&lt;"
+				}
+				b.WriteString(text)
+			case "script", "style":
+			default:
+				b.WriteString(html.EscapeString(string(z.Text())))
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if slices.Contains(p.AllowedTags, tag) {
+				if tt == html.EndTagToken {
+					b.WriteString("</" + tag + ">")
+				} else {
+					b.WriteString("<" + tag + ">")
+				}
+			}
+			if tt == html.StartTagToken && htmlRawTextTags[tag] {
+				rawTag = tag
+				continue
+			}
+		}
+		rawTag = ""
+	}
+}
+
+// htmlRawTextTags are the elements whose content the tokenizer reads as text
+var htmlRawTextTags = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+}
+
 // validateArticle validates article data
 func validateArticle(article Article) error {
 	if strings.TrimSpace(article.Title) == "" {
@@ -352,19 +1118,43 @@ func validateArticle(article Article) error {
 	return nil
 }
 
+// respond writes payload with the request ID of c. Errors are negotiated
+// from the Accept header: text/plain gets "key: value" lines, XML an
+// <error> element and anything else JSON. Successful responses carry
+// arbitrary data and are always JSON.
+func respond(c *gin.Context, status int, payload APIResponse) {
+	payload.RequestID = c.GetString("request_id")
+	if payload.Success {
+		c.JSON(status, payload)
+		return
+	}
+	switch c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain, gin.MIMEXML, gin.MIMEXML2) {
+	case gin.MIMEPlain:
+		var b strings.Builder
+		fmt.Fprintf(&b, "error: %s\n", payload.Error)
+		if payload.Message != "" {
+			fmt.Fprintf(&b, "detail: %s\n", payload.Message)
+		}
+		if payload.RequestID != "" {
+			fmt.Fprintf(&b, "request_id: %s\n", payload.RequestID)
+		}
+		c.String(status, b.String())
+	case gin.MIMEXML, gin.MIMEXML2:
+		c.XML(status, xmlError{
+			Status:    status,
+			Error:     payload.Error,
+			Detail:    payload.Message,
+			RequestID: payload.RequestID,
+		})
+	default:
+		c.JSON(status, payload)
+	}
+}
+
 func okResponse(c *gin.Context, status int, message string, data interface{}) {
-	c.JSON(status, APIResponse{
-		Success:   true,
-		Data:      data,
-		Message:   message,
-		RequestID: c.GetString("request_id"),
-	})
+	respond(c, status, APIResponse{Success: true, Data: data, Message: message})
 }
 
 func errResponse(c *gin.Context, status int, msg string) {
-	c.JSON(status, APIResponse{
-		Success:   false,
-		Error:     msg,
-		RequestID: c.GetString("request_id"),
-	})
+	respond(c, status, APIResponse{Success: false, Error: msg})
 }
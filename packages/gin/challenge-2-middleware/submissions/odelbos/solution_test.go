@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthRoutesSkipConcurrencyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// No slot at all, only the health routes get through
+	router.Use(ConcurrencyLimitMiddleware(0))
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
+	router.GET("/articles", getArticles)
+
+	get := func(path string) (int, HealthResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		var resp HealthResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, _ := get("/articles")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	code, resp := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+	code, resp = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"articles": "ok"}, resp.Checks)
+
+	// Readiness follows the article store
+	articlesMutex.Lock()
+	saved := articles
+	articles = nil
+	articlesMutex.Unlock()
+	defer func() {
+		articlesMutex.Lock()
+		articles = saved
+		articlesMutex.Unlock()
+	}()
+	code, resp = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", resp.Status)
+	assert.Equal(t, "article store not initialized", resp.Checks["articles"])
+}
+
+func setupRequestIDRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.GET("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"request_id":     c.GetString("request_id"),
+			"correlation_id": c.GetString("correlation_id"),
+		})
+	})
+	return router
+}
+
+func pingWithHeaders(router *gin.Engine, headers map[string]string) (*httptest.ResponseRecorder, map[string]string) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	router.ServeHTTP(w, req)
+
+	var body map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body
+}
+
+func TestRequestIDPreservesValidInboundID(t *testing.T) {
+	router := setupRequestIDRouter()
+	inbound := "3f1c8a52-7d4e-4b9a-9c2f-0e5d6a7b8c9d"
+
+	w, body := pingWithHeaders(router, map[string]string{"X-Request-ID": inbound})
+
+	assert.Equal(t, inbound, w.Header().Get("X-Request-ID"))
+	assert.Equal(t, inbound, body["request_id"])
+	assert.Equal(t, inbound, body["correlation_id"])
+}
+
+func TestRequestIDReplacesMalformedInboundID(t *testing.T) {
+	router := setupRequestIDRouter()
+
+	for _, inbound := range []string{"garbage", "{3f1c8a52-7d4e-4b9a-9c2f-0e5d6a7b8c9d}", "3f1c8a527d4e4b9a9c2f0e5d6a7b8c9d"} {
+		w, body := pingWithHeaders(router, map[string]string{"X-Request-ID": inbound})
+
+		id := w.Header().Get("X-Request-ID")
+		assert.NotEqual(t, inbound, id)
+		assert.True(t, isValidRequestID(id))
+		assert.Equal(t, id, body["request_id"])
+	}
+}
+
+func TestRequestIDGeneratedWhenAbsent(t *testing.T) {
+	router := setupRequestIDRouter()
+
+	w1, _ := pingWithHeaders(router, nil)
+	w2, _ := pingWithHeaders(router, nil)
+
+	assert.True(t, isValidRequestID(w1.Header().Get("X-Request-ID")))
+	assert.NotEqual(t, w1.Header().Get("X-Request-ID"), w2.Header().Get("X-Request-ID"))
+}
+
+func TestCorrelationIDPreserved(t *testing.T) {
+	router := setupRequestIDRouter()
+
+	w, body := pingWithHeaders(router, map[string]string{"X-Correlation-ID": "checkout-flow-42"})
+
+	assert.Equal(t, "checkout-flow-42", w.Header().Get("X-Correlation-ID"))
+	assert.Equal(t, "checkout-flow-42", body["correlation_id"])
+	assert.NotEqual(t, body["request_id"], body["correlation_id"])
+
+	w, _ = pingWithHeaders(router, map[string]string{"X-Correlation-ID": "has spaces"})
+	assert.Equal(t, w.Header().Get("X-Request-ID"), w.Header().Get("X-Correlation-ID"))
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const limit = 3
+
+	started := make(chan struct{}, limit)
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(RequestIDMiddleware(), ConcurrencyLimitMiddleware(limit))
+	router.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		okResponse(c, http.StatusOK, "done", nil)
+	})
+	router.GET("/healthz", healthz)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Saturate the limit
+	var wg sync.WaitGroup
+	inFlight := make([]*httptest.ResponseRecorder, limit)
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			inFlight[i] = serve("/slow")
+		}(i)
+	}
+	for i := 0; i < limit; i++ {
+		<-started
+	}
+
+	// Overflow requests are rejected with the standard envelope
+	for i := 0; i < 5; i++ {
+		w := serve("/slow")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		var resp APIResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.False(t, resp.Success)
+		assert.NotEmpty(t, resp.RequestID)
+	}
+
+	// Health checks bypass the limit
+	assert.Equal(t, http.StatusOK, serve("/healthz").Code)
+
+	close(release)
+	wg.Wait()
+	for _, w := range inFlight {
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Slots are released once the in-flight requests complete
+	started = make(chan struct{}, 1)
+	assert.Equal(t, http.StatusOK, serve("/slow").Code)
+}
+
+func setupMetricsRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	registry := prometheus.NewRegistry()
+	router := gin.New()
+	router.Use(MetricsMiddleware(registry), ErrorHandlerMiddleware())
+	router.GET("/metrics", MetricsHandler(registry))
+	router.GET("/articles/:id", getArticle)
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	return router
+}
+
+func scrapeMetrics(t *testing.T, router *gin.Engine) string {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	return w.Body.String()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	router := setupMetricsRouter()
+	for _, path := range []string{"/articles/1", "/articles/2", "/articles/999", "/nope", "/panic"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+	}
+
+	body := scrapeMetrics(t, router)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/articles/:id",status="200"} 2`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/articles/:id",status="404"} 1`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/panic",status="500"} 1`)
+	assert.Contains(t, body, `http_request_duration_seconds_bucket{method="GET",route="/articles/:id",status="200",le="+Inf"} 2`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/articles/:id",status="200"} 2`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`)
+	assert.NotContains(t, body, `route="/articles/1"`)
+}
+
+func TestMetricsCountsScrapes(t *testing.T) {
+	router := setupMetricsRouter()
+	scrapeMetrics(t, router)
+
+	body := scrapeMetrics(t, router)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/metrics",status="200"} 1`)
+}
+
+func setupArticlesRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/articles", getArticles)
+	router.POST("/articles", createArticle)
+	return router
+}
+
+func listArticles(t *testing.T, router *gin.Engine) []Article {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/articles", nil)
+	router.ServeHTTP(w, req)
+	var resp struct {
+		Data []Article `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Data
+}
+
+func postArticle(t *testing.T, router *gin.Engine, title string) Article {
+	w := httptest.NewRecorder()
+	body := `{"title": "` + title + `", "content": "Content", "author": "Author"}`
+	req, _ := http.NewRequest("POST", "/articles", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Data Article `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Data
+}
+
+func TestSeedArticles(t *testing.T) {
+	saved := articles
+	defer func() { SeedArticles(saved...) }()
+	router := setupArticlesRouter()
+
+	SeedArticles(
+		Article{ID: 10, Title: "First", Content: "A", Author: "Ann"},
+		Article{ID: 20, Title: "Second", Content: "B", Author: "Bob"},
+	)
+	list := listArticles(t, router)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, 1, list[0].ID)
+		assert.Equal(t, "Second", list[1].Title)
+		assert.Equal(t, 2, list[1].ID)
+		assert.False(t, list[0].CreatedAt.IsZero())
+	}
+	assert.Equal(t, 3, postArticle(t, router, "Third").ID)
+
+	// Re-seeding clears the previous articles and restarts the IDs
+	SeedArticles(Article{Title: "Only", Content: "C", Author: "Cid"})
+	list = listArticles(t, router)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "Only", list[0].Title)
+	}
+	assert.Equal(t, 2, postArticle(t, router, "Next").ID)
+}
+
+func TestSeedArticlesConcurrent(t *testing.T) {
+	saved := articles
+	defer func() { SeedArticles(saved...) }()
+	router := setupArticlesRouter()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SeedArticles(Article{Title: "Seed", Content: "S", Author: "Sam"})
+		}()
+		go func() {
+			defer wg.Done()
+			listArticles(t, router)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, articles, 1)
+}
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+
+	p := Paginate(items, 2, 2)
+	assert.Equal(t, []int{3, 4}, p.Items)
+	assert.Equal(t, 3, p.TotalPages)
+	assert.True(t, p.HasNext)
+
+	p = Paginate(items, 3, 2)
+	assert.Equal(t, []int{5}, p.Items)
+	assert.False(t, p.HasNext)
+
+	p = Paginate(items, 4, 2)
+	assert.Equal(t, []int{}, p.Items)
+	assert.Equal(t, 4, p.Page)
+	assert.False(t, p.HasNext)
+
+	p = Paginate(items, -1, 1000)
+	assert.Equal(t, 1, p.Page)
+	assert.Equal(t, 1000, p.Size)
+	assert.Equal(t, 1, p.TotalPages)
+
+	p = Paginate(items, 1, 0)
+	assert.Equal(t, 1, p.Size)
+	assert.Equal(t, []int{1}, p.Items)
+}
+
+func TestListOptionsParse(t *testing.T) {
+	opts := ListOptions{DefaultSize: 10, MaxSize: 50}
+	parse := func(query string) (int, int, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?"+query, nil)
+		return opts.Parse(c)
+	}
+
+	page, size, err := parse("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, page)
+	assert.Equal(t, 10, size)
+
+	page, size, err = parse("page=2&size=500")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, page)
+	assert.Equal(t, 50, size)
+
+	for _, query := range []string{"size=0", "size=-3", "page=0", "page=-1", "size=x"} {
+		_, _, err = parse(query)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestGetArticlesPagination(t *testing.T) {
+	saved := articles
+	defer func() { SeedArticles(saved...) }()
+	SeedArticles(
+		Article{Title: "A", Content: "A", Author: "Ann"},
+		Article{Title: "B", Content: "B", Author: "Bob"},
+		Article{Title: "C", Content: "C", Author: "Cid"},
+	)
+	router := setupArticlesRouter()
+
+	// Without pagination parameters the whole list is returned
+	assert.Len(t, listArticles(t, router), 3)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/articles?page=2&size=2", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data Page[Article] `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Data.Items, 1) {
+		assert.Equal(t, "C", resp.Data.Items[0].Title)
+	}
+	assert.Equal(t, 3, resp.Data.Total)
+	assert.Equal(t, 2, resp.Data.TotalPages)
+	assert.False(t, resp.Data.HasNext)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/articles?size=x", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/articles?size=0", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestErrorContentNegotiation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.GET("/articles/:id", getArticle)
+	requestID := "123e4567-e89b-12d3-a456-426614174000"
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/articles/999", nil)
+		req.Header.Set("X-Request-ID", requestID)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		return w
+	}
+
+	for _, accept := range []string{"", "application/json", "*/*", "image/png"} {
+		w := get(accept)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json", accept)
+		var resp APIResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), accept)
+		assert.False(t, resp.Success)
+		assert.NotEmpty(t, resp.Error)
+		assert.Equal(t, requestID, resp.RequestID)
+	}
+
+	w := get("text/plain")
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	fields := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		assert.True(t, ok, line)
+		fields[key] = value
+	}
+	assert.NotEmpty(t, fields["error"])
+	assert.Equal(t, requestID, fields["request_id"])
+
+	for _, accept := range []string{"application/xml", "text/xml"} {
+		w = get(accept)
+		assert.Contains(t, w.Header().Get("Content-Type"), "xml", accept)
+		var resp xmlError
+		assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &resp), accept)
+		assert.Equal(t, http.StatusNotFound, resp.Status)
+		assert.NotEmpty(t, resp.Error)
+		assert.Equal(t, requestID, resp.RequestID)
+	}
+
+	// Successful responses stay JSON whatever the Accept header
+	router.GET("/articles", getArticles)
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/articles", nil)
+	req.Header.Set("Accept", "text/plain")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+}
+
+func setupCORSRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORSMiddleware(router))
+	router.GET("/ping", ping)
+	router.GET("/articles", getArticles)
+	router.POST("/articles", createArticle)
+	router.GET("/articles/:id", getArticle)
+	router.PUT("/articles/:id", updateArticle)
+	router.DELETE("/articles/:id", deleteArticle)
+	return router
+}
+
+func preflight(router *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", path, nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSAllowedMethodsPerRoute(t *testing.T) {
+	router := setupCORSRouter()
+
+	tests := []struct {
+		path    string
+		methods string
+	}{
+		{"/ping", "GET, OPTIONS"},
+		{"/articles", "GET, POST, OPTIONS"},
+		{"/articles/1", "DELETE, GET, PUT, OPTIONS"},
+	}
+	for _, tt := range tests {
+		w := preflight(router, tt.path)
+		assert.Equal(t, http.StatusNoContent, w.Code, tt.path)
+		assert.Equal(t, tt.methods, w.Header().Get("Access-Control-Allow-Methods"), tt.path)
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"), tt.path)
+	}
+
+	// Actual requests carry the same list but no max-age
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "GET, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Empty(t, w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestCORSUnknownRoute(t *testing.T) {
+	router := setupCORSRouter()
+
+	for _, path := range []string{"/nope", "/articles/1/comments"} {
+		w := preflight(router, path)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), path)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"), path)
+	}
+}
+
+func TestCORSMaxAgeConfig(t *testing.T) {
+	saved := corsConfig
+	defer func() { corsConfig = saved }()
+	router := setupCORSRouter()
+
+	corsConfig.MaxAge = 90 * time.Second
+	assert.Equal(t, "90", preflight(router, "/ping").Header().Get("Access-Control-Max-Age"))
+
+	corsConfig.MaxAge = 0
+	assert.Empty(t, preflight(router, "/ping").Header().Get("Access-Control-Max-Age"))
+}
+
+func TestMatchRoute(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/", true},
+		{"/articles", "/articles", true},
+		{"/articles", "/articles/", true},
+		{"/articles/:id", "/articles/42", true},
+		{"/articles/:id", "/articles", false},
+		{"/articles/:id", "/articles/42/x", false},
+		{"/static/*path", "/static/css/site.css", true},
+		{"/ping", "/pong", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchRoute(tt.pattern, tt.path), tt.pattern+" "+tt.path)
+	}
+}
+
+func searchArticlesQuery(t *testing.T, router *gin.Engine, query string) (int, []Article) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/articles/search?"+query, nil)
+	router.ServeHTTP(w, req)
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	var data struct {
+		Data []Article `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+	assert.NotNil(t, data.Data)
+	return w.Code, data.Data
+}
+
+func titles(list []Article) []string {
+	result := []string{}
+	for _, article := range list {
+		result = append(result, article.Title)
+	}
+	return result
+}
+
+func TestSearchArticles(t *testing.T) {
+	saved := articles
+	defer func() { SeedArticles(saved...) }()
+	SeedArticles(
+		Article{Title: "Getting Started with Go", Content: "Go is a programming language", Author: "John Doe"},
+		Article{Title: "Web Development with Gin", Content: "Gin is a web framework for Go", Author: "Jane Smith"},
+		Article{Title: "Testing", Content: "Table driven tests", Author: "John Smith"},
+	)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.GET("/articles/search", searchArticles)
+	router.GET("/articles/:id", getArticle)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"author=Jane%20Smith", []string{"Web Development with Gin"}},
+		{"author=smith", []string{"Web Development with Gin", "Testing"}},
+		{"q=GO", []string{"Getting Started with Go", "Web Development with Gin"}},
+		{"q=table", []string{"Testing"}},
+		{"author=john&q=go", []string{"Getting Started with Go"}},
+		{"author=jane&q=table", []string{}},
+		{"author=nobody", []string{}},
+	}
+	for _, tt := range tests {
+		status, list := searchArticlesQuery(t, router, tt.query)
+		assert.Equal(t, http.StatusOK, status, tt.query)
+		assert.Equal(t, tt.expected, titles(list), tt.query)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/articles/search?author=john", nil)
+	router.ServeHTTP(w, req)
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp.RequestID)
+	assert.Equal(t, w.Header().Get("X-Request-ID"), resp.RequestID)
+
+	for _, query := range []string{"", "author=&q=", "q=%20"} {
+		status, _ := searchArticlesQuery(t, router, query)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+func setupETagRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/articles/:id", getArticle)
+	router.PUT("/articles/:id", updateArticle)
+	return router
+}
+
+func fetchETag(t *testing.T, router *gin.Engine, path string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	return w.Header().Get("ETag")
+}
+
+func putArticle(router *gin.Engine, path, ifMatch, title string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	body := `{"title": "` + title + `", "content": "Content", "author": "Author"}`
+	req, _ := http.NewRequest("PUT", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateArticleIfMatch(t *testing.T) {
+	saved := articles
+	defer func() { SeedArticles(saved...) }()
+	SeedArticles(Article{Title: "Draft", Content: "C", Author: "Ann"})
+	router := setupETagRouter()
+
+	// Two editors load the same version
+	etag := fetchETag(t, router, "/articles/1")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, etag, fetchETag(t, router, "/articles/1"))
+
+	first := putArticle(router, "/articles/1", etag, "First edit")
+	assert.Equal(t, http.StatusOK, first.Code)
+	updated := first.Header().Get("ETag")
+	assert.NotEqual(t, etag, updated)
+	assert.Equal(t, updated, fetchETag(t, router, "/articles/1"))
+
+	// The second editor's version is stale
+	second := putArticle(router, "/articles/1", etag, "Second edit")
+	assert.Equal(t, http.StatusPreconditionFailed, second.Code)
+	articlesMutex.RLock()
+	assert.Equal(t, "First edit", articles[0].Title)
+	articlesMutex.RUnlock()
+
+	// After reloading, the update succeeds
+	assert.Equal(t, http.StatusOK, putArticle(router, "/articles/1", updated, "Second edit").Code)
+	assert.Equal(t, http.StatusOK, putArticle(router, "/articles/1", `"other", *`, "Third edit").Code)
+	assert.Equal(t, http.StatusPreconditionFailed, putArticle(router, "/articles/1", "W/"+updated, "Weak").Code)
+}
+
+func TestUpdateArticleConcurrentEditors(t *testing.T) {
+	saved := articles
+	defer func() { SeedArticles(saved...) }()
+	SeedArticles(Article{Title: "Draft", Content: "C", Author: "Ann"})
+	router := setupETagRouter()
+	etag := fetchETag(t, router, "/articles/1")
+
+	const editors = 10
+	codes := make(chan int, editors)
+	var wg sync.WaitGroup
+	for i := 0; i < editors; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- putArticle(router, "/articles/1", etag, "Edit").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusPreconditionFailed: editors - 1}, counts)
+}
+
+func TestUpdateArticleRequireIfMatch(t *testing.T) {
+	saved := articles
+	defer func() { SeedArticles(saved...) }()
+	SeedArticles(Article{Title: "Draft", Content: "C", Author: "Ann"})
+	router := setupETagRouter()
+
+	assert.Equal(t, http.StatusOK, putArticle(router, "/articles/1", "", "Unconditional").Code)
+
+	requireIfMatch = true
+	defer func() { requireIfMatch = false }()
+	assert.Equal(t, http.StatusPreconditionRequired, putArticle(router, "/articles/1", "", "Rejected").Code)
+	etag := fetchETag(t, router, "/articles/1")
+	assert.Equal(t, http.StatusOK, putArticle(router, "/articles/1", etag, "Conditional").Code)
+}
+
+func setupRateLimitRouter(t *testing.T, cfg RateLimitConfig) *gin.Engine {
+	saved := rateLimitConfig
+	t.Cleanup(func() { rateLimitConfig = saved })
+	rateLimitConfig = cfg
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RateLimitMiddleware())
+	router.GET("/ping", ping)
+	return router
+}
+
+// burst sends n requests from addr and returns how many were limited
+func burst(router *gin.Engine, addr, apiKey string, n int) (limited int, last *httptest.ResponseRecorder) {
+	for i := 0; i < n; i++ {
+		last = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		req.RemoteAddr = addr
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		router.ServeHTTP(last, req)
+		if last.Code == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	return limited, last
+}
+
+func TestRateLimitAllowlist(t *testing.T) {
+	router := setupRateLimitRouter(t, RateLimitConfig{
+		Allowlist: []string{"10.20.0.0/16", "2001:db8::/32", "192.0.2.7"},
+		APIKeys:   []string{"monitoring-key"},
+	})
+
+	for _, addr := range []string{"10.20.3.4:1000", "[2001:db8::1]:1000", "192.0.2.7:1000", "[::ffff:10.20.9.9]:1000"} {
+		limited, last := burst(router, addr, "", 150)
+		assert.Zero(t, limited, addr)
+		assert.Equal(t, "true", last.Header().Get("X-RateLimit-Exempt"), addr)
+		assert.Empty(t, last.Header().Get("X-RateLimit-Remaining"), addr)
+	}
+
+	limited, _ := burst(router, "198.51.100.20:1000", "monitoring-key", 150)
+	assert.Zero(t, limited)
+
+	// Outside the allowlist, and with another key, the limit applies
+	for _, addr := range []string{"10.21.0.1:1000", "192.0.2.8:1000", "[2001:db9::1]:1000"} {
+		limited, last := burst(router, addr, "other-key", 150)
+		assert.NotZero(t, limited, addr)
+		assert.Empty(t, last.Header().Get("X-RateLimit-Exempt"), addr)
+		assert.Equal(t, "0", last.Header().Get("X-RateLimit-Remaining"), addr)
+	}
+}
+
+func TestParseAllowlist(t *testing.T) {
+	prefixes, err := ParseAllowlist([]string{" 10.0.0.1/8 ", "::1", "::ffff:192.0.2.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "::1/128", "192.0.2.1/32"}, []string{
+		prefixes[0].String(), prefixes[1].String(), prefixes[2].String(),
+	})
+
+	for _, entry := range []string{"10.0.0.0/33", "not-an-ip", ""} {
+		_, err := ParseAllowlist([]string{entry})
+		assert.Error(t, err, entry)
+	}
+	assert.Panics(t, func() { setupRateLimitRouter(t, RateLimitConfig{Allowlist: []string{"bad"}}) })
+}
+
+type recorded struct {
+	status, size int
+}
+
+// setupRecorderRouter records, after each request, what the response
+// recorder saw
+func setupRecorderRouter(seen *recorded) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ResponseRecorderMiddleware(), func(c *gin.Context) {
+		c.Next()
+		seen.status, seen.size, _ = recordedResponse(c)
+	})
+	router.GET("/articles", getArticles)
+	router.GET("/articles/:id", getArticle)
+	router.DELETE("/articles/:id", AuthMiddleware(), deleteArticle)
+	router.GET("/stream", func(c *gin.Context) {
+		for i := 0; i < 3; i++ {
+			c.SSEvent("tick", i)
+			c.Writer.Flush()
+		}
+	})
+	router.GET("/empty", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusNoContent)
+	})
+	return router
+}
+
+func TestResponseRecorder(t *testing.T) {
+	var seen recorded
+	router := setupRecorderRouter(&seen)
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/articles", http.StatusOK},
+		{"GET", "/articles/999", http.StatusNotFound},
+		{"DELETE", "/articles/1", http.StatusUnauthorized},
+		{"GET", "/stream", http.StatusOK},
+		{"GET", "/empty", http.StatusNoContent},
+	} {
+		seen = recorded{}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		assert.Equal(t, tc.status, w.Code, tc.path)
+		assert.Equal(t, w.Code, seen.status, tc.path)
+		assert.Equal(t, w.Body.Len(), seen.size, tc.path)
+	}
+
+	// Streaming still reaches the client in chunks
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, 3, strings.Count(w.Body.String(), "event:tick"))
+}
+
+func TestRecordedResponseWithoutRecorder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ok := true
+	router.GET("/", func(c *gin.Context) {
+		_, _, ok = recordedResponse(c)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.False(t, ok)
+}
+
+// serverEnv are the variables read by LoadConfig[ServerConfig]
+var serverEnv = []string{
+	"ADDR", "MAX_CONCURRENT_REQUESTS", "REQUIRE_IF_MATCH", "CORS_ALLOW_ORIGIN", "CORS_ALLOW_HEADERS",
+	"CORS_MAX_AGE", "RATE_LIMIT_ALLOWLIST", "RATE_LIMIT_API_KEYS", "HTML_POLICY", "HTML_ALLOWED_TAGS",
+}
+
+// setEnv clears the server variables, then sets the given ones
+func setEnv(t *testing.T, env map[string]string) {
+	for _, name := range serverEnv {
+		t.Setenv(name, "")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setEnv(t, nil)
+	cfg, err := LoadConfig[ServerConfig]()
+	assert.NoError(t, err)
+	assert.Equal(t, ServerConfig{
+		Addr:                  ":8080",
+		MaxConcurrentRequests: maxConcurrentRequests,
+		RequireIfMatch:        requireIfMatch,
+		CORS:                  corsConfig,
+		RateLimit:             rateLimitConfig,
+		HTML:                  htmlPolicy,
+	}, cfg)
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	setEnv(t, map[string]string{
+		"ADDR":                    ":9090",
+		"MAX_CONCURRENT_REQUESTS": "8",
+		"REQUIRE_IF_MATCH":        "true",
+		"CORS_MAX_AGE":            "30s",
+		"RATE_LIMIT_ALLOWLIST":    " 10.0.0.0/8, ,::1 ",
+		"HTML_POLICY":             "strip",
+		"HTML_ALLOWED_TAGS":       "b,em",
+	})
+	cfg, err := LoadConfig[ServerConfig]()
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", cfg.Addr)
+	assert.Equal(t, 8, cfg.MaxConcurrentRequests)
+	assert.True(t, cfg.RequireIfMatch)
+	assert.Equal(t, 30*time.Second, cfg.CORS.MaxAge)
+	assert.Equal(t, corsConfig.AllowOrigin, cfg.CORS.AllowOrigin)
+	assert.Equal(t, []string{"10.0.0.0/8", "::1"}, cfg.RateLimit.Allowlist)
+	assert.Empty(t, cfg.RateLimit.APIKeys)
+	assert.Equal(t, HTMLPolicy{Mode: HTMLStrip, AllowedTags: []string{"b", "em"}}, cfg.HTML)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	// Every invalid setting is reported at once
+	setEnv(t, map[string]string{
+		"MAX_CONCURRENT_REQUESTS": "lots",
+		"REQUIRE_IF_MATCH":        "maybe",
+		"CORS_MAX_AGE":            "soon",
+		"RATE_LIMIT_ALLOWLIST":    "10.0.0.0/33",
+		"HTML_POLICY":             "sanitize",
+	})
+	_, err := LoadConfig[ServerConfig]()
+	var configErr *ConfigError
+	if assert.ErrorAs(t, err, &configErr) {
+		assert.Len(t, configErr.Problems, 5)
+		for _, name := range []string{"MAX_CONCURRENT_REQUESTS", "REQUIRE_IF_MATCH", "CORS_MAX_AGE", "RateLimitConfig", "HTMLPolicy"} {
+			assert.Contains(t, err.Error(), name)
+		}
+	}
+
+	type secretConfig struct {
+		Secret string        `env:"TEST_SECRET" required:"true"`
+		TTL    time.Duration `env:"TEST_TTL" required:"true" default:"1m"`
+		Port   int           `env:"TEST_PORT"`
+	}
+	t.Setenv("TEST_SECRET", "")
+	t.Setenv("TEST_TTL", "")
+	t.Setenv("TEST_PORT", "")
+	_, err = LoadConfig[secretConfig]()
+	assert.EqualError(t, err, "invalid configuration: TEST_SECRET is required")
+
+	t.Setenv("TEST_SECRET", "s3cret")
+	cfg, err := LoadConfig[secretConfig]()
+	assert.NoError(t, err)
+	assert.Equal(t, secretConfig{Secret: "s3cret", TTL: time.Minute}, cfg)
+}
+
+func TestHTMLPolicySanitize(t *testing.T) {
+	strip := HTMLPolicy{Mode: HTMLStrip, AllowedTags: []string{"b", "em"}}
+	escape := HTMLPolicy{Mode: HTMLEscape, AllowedTags: []string{"b"}}
+	tests := []struct {
+		name   string
+		policy HTMLPolicy
+		in     string
+		want   string
+	}{
+		{"Plain text unchanged", strip, "Go & Gin, 2 < 3", "Go & Gin, 2 < 3"},
+		{"Script removed", strip, `Intro<script>alert("x")</script> text`, "Intro text"},
+		{"Unclosed script tag removed", strip, "<script src=//evil>", ""},
+		{"Allowed tags kept bare", strip, `<EM style="x">it</EM> <b>bold</b>`, "<em>it</em> <b>bold</b>"},
+		{"Other tags stripped", strip, `<iframe src="x"></iframe><p>para</p>`, "para"},
+		{"Script escaped", escape, "<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"Escape keeps bare allowed tags", escape, `<b>bold</b> <B>loud</B>`, "<b>bold</b> <b>loud</b>"},
+		{"Escape keeps attributes escaped", escape, `<b onclick="x()">bold</b>`, "&lt;b onclick=&#34;x()&#34;&gt;bold</b>"},
+		{"Unterminated tag stripped", strip, `<img src=x onerror=alert(1)<b>bold</b>`, "bold</b>"},
+		{"Unterminated tag escaped", escape, `<img src=x onerror=alert(1)<b>bold</b>`, "&lt;img src=x onerror=alert(1)<b>bold</b>"},
+		{"Tag at the end stripped", strip, "Hi <img src=x onerror=y", "Hi "},
+		{"Stray < cannot join a tag", strip, "<<p>script>", "&lt;script>"},
+		{"Title content escaped", strip, "<title><img src=x onerror=y></title>", "&lt;img src=x onerror=y&gt;"},
+		{"Keep mode", HTMLPolicy{Mode: HTMLKeep}, "<script>x</script>", "<script>x</script>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Sanitize(tt.in))
+		})
+	}
+}
+
+func TestArticleContentSanitized(t *testing.T) {
+	savedArticles, savedPolicy := articles, htmlPolicy
+	defer func() { SeedArticles(savedArticles...); htmlPolicy = savedPolicy }()
+	htmlPolicy = HTMLPolicy{Mode: HTMLEscape, AllowedTags: []string{"em"}}
+	router := gin.New()
+	router.POST("/articles", createArticle)
+	router.PUT("/articles/:id", updateArticle)
+
+	send := func(method, path, content string) (int, Article) {
+		body, _ := json.Marshal(Article{Title: "Title", Content: content, Author: "Author"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		var resp struct {
+			Data Article `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Data
+	}
+
+	code, created := send("POST", "/articles", "<em>Hi</em><script>x()</script>")
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "<em>Hi</em>&lt;script&gt;x()&lt;/script&gt;", created.Content)
+
+	code, updated := send("PUT", "/articles/"+strconv.Itoa(created.ID), "<img src=x onerror=y()>Bye")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "&lt;img src=x onerror=y()&gt;Bye", updated.Content)
+
+	// Content reduced to nothing by stripping is missing
+	htmlPolicy.Mode = HTMLStrip
+	code, _ = send("POST", "/articles", "<script>x()</script>")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
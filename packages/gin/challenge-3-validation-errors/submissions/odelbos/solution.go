@@ -1,15 +1,31 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
+	"reflect"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
 	validator "github.com/go-playground/validator/v10"
 )
 
@@ -76,6 +92,46 @@ type APIResponse struct {
 	RequestID string            `json:"request_id,omitempty"`
 }
 
+// xmlError is the XML envelope of an error APIResponse
+type xmlError struct {
+	XMLName   xml.Name        `xml:"error"`
+	Status    int             `xml:"status,attr"`
+	Code      string          `xml:"code,attr,omitempty"`
+	Message   string          `xml:"message"`
+	Fields    []xmlFieldError `xml:"field"`
+	RequestID string          `xml:"request_id,omitempty"`
+}
+
+// xmlFieldError is a ValidationError within an xmlError
+type xmlFieldError struct {
+	Name    string `xml:"name,attr"`
+	Tag     string `xml:"tag,attr"`
+	Message string `xml:",chardata"`
+}
+
+// Reservation is a hold on product inventory, released unless confirmed
+// before ExpiresAt
+type Reservation struct {
+	ID        string    `json:"id"`
+	ProductID int       `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Reservation statuses
+const (
+	ReservationHeld      = "held"
+	ReservationConfirmed = "confirmed"
+	ReservationExpired   = "expired"
+)
+
+// HealthResponse represents a liveness or readiness response
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
 // Global data stores (in a real app, these would be databases)
 var products = []Product{}
 var categories = []Category{
@@ -87,11 +143,74 @@ var categories = []Category{
 var validCurrencies = []string{"USD", "EUR", "GBP", "JPY", "CAD", "AUD"}
 var validWarehouses = []string{"WH001", "WH002", "WH003", "WH004", "WH005"}
 var nextProductID = 1
+var productsMutex sync.RWMutex
+var reservations = map[string]*Reservation{} // guarded by productsMutex
+
+// Reservations
+var (
+	reservationTTL       = 15 * time.Minute // unconfirmed holds are released after it
+	reservationRetention = time.Hour        // records are kept as long after expiry
+	reservationSweep     = time.Minute      // sweeper period
+)
+
+// JSON body limits of the product endpoints, checked before decoding
+var (
+	maxBodyBytes int64 = 1 << 20 // larger bodies get 413
+	maxJSONDepth       = 16      // nesting of objects and arrays
+	maxArrayLen        = 1000    // elements of any single array
+)
+
+// readinessChecks are the dependency checks reported by GET /readyz
+var readinessChecks = map[string]func() error{
+	"products":   checkProductStore,
+	"categories": checkCategoryStore,
+}
+
+func checkProductStore() error {
+	productsMutex.RLock()
+	defer productsMutex.RUnlock()
+	if products == nil {
+		return errors.New("product store not initialized")
+	}
+	return nil
+}
+
+func checkCategoryStore() error {
+	if len(categories) == 0 {
+		return errors.New("no categories loaded")
+	}
+	return nil
+}
+
+// Patterns shared by the validators and the JSON schema export
+const (
+	skuPattern  = `^[A-Z]{3}-\d{3}-[A-Z]{3}$`
+	slugPattern = `^[a-z0-9]+(?:-[a-z0-9]+)*$`
+)
+
+var (
+	skuRegexp  = regexp.MustCompile(skuPattern)
+	slugRegexp = regexp.MustCompile(slugPattern)
+)
+
+// asciiIdentifier returns the NFC form of s and whether it is pure ASCII.
+// SKUs and slugs are ASCII-only: lookalikes such as full-width digits,
+// Cyrillic letters or combining accents are rejected, never folded.
+func asciiIdentifier(s string) (string, bool) {
+	s = norm.NFC.String(s)
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return "", false
+		}
+	}
+	return s, true
+}
 
 // SKU format: ABC-123-XYZ (3 letters, 3 numbers, 3 letters)
-// The SKU should match the pattern: ^[A-Z]{3}-\d{3}-[A-Z]{3}$
+// The SKU should match the pattern: ^[A-Z]{3}-\d{3}-[A-Z]{3}$ (ASCII only)
 func isValidSKU(sku string) bool {
-	return regexp.MustCompile(`^[A-Z]{3}-\d{3}-[A-Z]{3}$`).MatchString(sku)
+	sku, ok := asciiIdentifier(sku)
+	return ok && skuRegexp.MatchString(sku)
 }
 
 func isValidCurrency(currency string) bool {
@@ -107,9 +226,10 @@ func isValidCategory(categoryName string) bool {
 	return false
 }
 
-// Slug should match: ^[a-z0-9]+(?:-[a-z0-9]+)*$
+// Slug should match: ^[a-z0-9]+(?:-[a-z0-9]+)*$ (ASCII only)
 func isValidSlug(slug string) bool {
-	return regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`).MatchString(slug)
+	slug, ok := asciiIdentifier(slug)
+	return ok && slugRegexp.MatchString(slug)
 }
 
 // Format should be WH### (e.g., WH001, WH002)
@@ -118,6 +238,16 @@ func isValidWarehouseCode(code string) bool {
 }
 
 func validateProduct(product *Product) []ValidationError {
+	errors := validateProductFields(product)
+	if skuExists(product.SKU) {
+		errors = append(errors, ValidationError{Field: "sku", Value: product.SKU, Tag: "sku_unique", Message: "SKU already exists"})
+	}
+	return errors
+}
+
+// validateProductFields runs the custom validations except the SKU
+// uniqueness, which depends on the product being created or updated
+func validateProductFields(product *Product) []ValidationError {
 	var errors []ValidationError
 
 	// Add custom validation logic:
@@ -129,35 +259,253 @@ func validateProduct(product *Product) []ValidationError {
 	// - Cross-field validations (reserved <= quantity, etc.)
 
 	if ! isValidSKU(product.SKU) {
-		errors = append(errors, ValidationError{Field: "sku", Message: "Invalid SKU"})
+		errors = append(errors, ValidationError{Field: "sku", Value: product.SKU, Tag: "sku_format", Message: "Invalid SKU"})
 	}
 	if ! isValidCurrency(product.Currency) {
-		errors = append(errors, ValidationError{Field: "currency", Message: "Invalid currency"})
+		errors = append(errors, ValidationError{Field: "currency", Value: product.Currency, Tag: "currency_valid", Message: "Invalid currency"})
 	}
 	if ! isValidCategory(product.Category.Name) {
-		errors = append(errors, ValidationError{Field: "category.name", Message: "Category does not exist"})
+		errors = append(errors, ValidationError{Field: "category.name", Value: product.Category.Name, Tag: "category_exists", Message: "Category does not exist"})
 	}
 	if ! isValidSlug(product.Category.Slug) {
-		errors = append(errors, ValidationError{Field: "category.slug", Message: "Invalid slug"})
+		errors = append(errors, ValidationError{Field: "category.slug", Value: product.Category.Slug, Tag: "slug_format", Message: "Invalid slug"})
 	}
 	if ! isValidWarehouseCode(product.Inventory.Location) {
-		errors = append(errors, ValidationError{Field: "inventory.location", Message: "Invalid warehouse code"})
+		errors = append(errors, ValidationError{Field: "inventory.location", Value: product.Inventory.Location, Tag: "warehouse_code", Message: "Invalid warehouse code"})
 	}
 	if product.Inventory.Reserved > product.Inventory.Quantity {
-		errors = append(errors, ValidationError{Field: "inventory.reserved", Message: "Reserved > quantity"})
+		errors = append(errors, ValidationError{
+			Field:   "inventory.reserved",
+			Value:   product.Inventory.Reserved,
+			Tag:     "reserved_less_than_quantity",
+			Message: "Reserved > quantity",
+			Param:   strconv.Itoa(product.Inventory.Quantity),
+		})
 	}
-	for _, p := range(products) {
-		if p.SKU == product.SKU {
-			errors = append(errors, ValidationError{Field: "sku", Message: "SKU already exists"})
-			break
+	return errors
+}
+
+// skuExists reports whether a stored product has the given SKU
+func skuExists(sku string) bool {
+	productsMutex.RLock()
+	defer productsMutex.RUnlock()
+	return findProductBySKU(sku) != nil
+}
+
+// findProductBySKU returns the stored product with the given SKU,
+// productsMutex must be held
+func findProductBySKU(sku string) *Product {
+	for i := range(products) {
+		if products[i].SKU == sku {
+			return &products[i]
 		}
 	}
-	return errors
+	return nil
+}
+
+// storeProduct assigns the next ID to the product and stores it
+func storeProduct(product *Product) {
+	productsMutex.Lock()
+	defer productsMutex.Unlock()
+	product.ID = nextProductID
+	nextProductID++
+	products = append(products, *product)
+}
+
+// SeedProducts replaces the stored products with the given fixtures and
+// drops the reservations, IDs are reassigned from 1 in order
+func SeedProducts(seed ...Product) {
+	productsMutex.Lock()
+	defer productsMutex.Unlock()
+	reservations = map[string]*Reservation{}
+	products = make([]Product, 0, len(seed))
+	for i, product := range seed {
+		product.ID = i + 1
+		products = append(products, product)
+	}
+	nextProductID = len(seed) + 1
+}
+
+// findProduct returns the stored product with the given ID, productsMutex
+// must be held
+func findProduct(id int) *Product {
+	for i := range(products) {
+		if products[i].ID == id {
+			return &products[i]
+		}
+	}
+	return nil
+}
+
+// releaseExpiredReservations returns the inventory of the holds expired at
+// now and forgets the records past retention. It returns the released count.
+func releaseExpiredReservations(now time.Time) int {
+	productsMutex.Lock()
+	defer productsMutex.Unlock()
+	released := 0
+	for id, r := range(reservations) {
+		if r.Status == ReservationHeld && ! now.Before(r.ExpiresAt) {
+			releaseReservation(r, now)
+			released++
+		}
+		if now.After(r.ExpiresAt.Add(reservationRetention)) {
+			delete(reservations, id)
+		}
+	}
+	return released
+}
+
+// releaseReservation puts a held quantity back into Available, productsMutex
+// must be held
+func releaseReservation(r *Reservation, now time.Time) {
+	r.Status = ReservationExpired
+	if p := findProduct(r.ProductID); p != nil {
+		p.Inventory.Reserved -= r.Quantity
+		p.Inventory.Available += r.Quantity
+		p.Inventory.LastUpdated = now
+	}
+}
+
+// StartReservationSweeper releases expired holds every interval until ctx
+// is cancelled
+func StartReservationSweeper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				releaseExpiredReservations(now)
+			}
+		}
+	}()
+}
+
+// newReservationID returns a random reservation ID
+func newReservationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validateAvailable rejects an available inventory contradicting quantity -
+// reserved. It is only used in strict mode (?strict=true), by default
+// sanitizeProduct silently recomputes it.
+func validateAvailable(product *Product) []ValidationError {
+	inv := product.Inventory
+	if inv.Available == inv.Quantity-inv.Reserved {
+		return nil
+	}
+	return []ValidationError{{
+		Field:   "inventory.available",
+		Value:   inv.Available,
+		Tag:     "consistency",
+		Message: fmt.Sprintf("Available must be quantity - reserved (%d)", inv.Quantity-inv.Reserved),
+	}}
+}
+
+func isStrict(c *gin.Context) bool {
+	return c.Query("strict") == "true"
+}
+
+// HTML handling of the free-text fields
+const (
+	HTMLKeep   = "keep"   // the text is stored as sent
+	HTMLStrip  = "strip"  // tags are removed, script and style with their content
+	HTMLEscape = "escape" // tags are escaped so they display as text
+)
+
+// HTMLPolicy says how the free-text fields treat HTML. The AllowedTags
+// (lowercase names, e.g. "b", "em") survive either mode without attributes,
+// so they cannot carry event handlers or URLs: stripping drops the
+// attributes, escaping only restores the bare tags.
+type HTMLPolicy struct {
+	Mode        string
+	AllowedTags []string
+}
+
+// htmlPolicy applies to Product.Description, HTML is kept by default
+var htmlPolicy = HTMLPolicy{Mode: HTMLKeep}
+
+// htmlEscapedTagRegexp matches a bare tag once escaped, e.g. &lt;/b&gt;
+var htmlEscapedTagRegexp = regexp.MustCompile(`&lt;(/?)([a-zA-Z][a-zA-Z0-9]*)&gt;`)
+
+// Sanitize applies the policy to s, text without tags is returned unchanged
+func (p HTMLPolicy) Sanitize(s string) string {
+	if (p.Mode != HTMLStrip && p.Mode != HTMLEscape) || ! strings.Contains(s, "<") {
+		return s
+	}
+	if p.Mode == HTMLStrip {
+		return p.strip(s)
+	}
+	return htmlEscapedTagRegexp.ReplaceAllStringFunc(html.EscapeString(s), func(tag string) string {
+		m := htmlEscapedTagRegexp.FindStringSubmatch(tag)
+		name := strings.ToLower(m[2])
+		if slices.Contains(p.AllowedTags, name) {
+			return "<" + m[1] + name + ">"
+		}
+		return tag
+	})
+}
+
+// strip tokenizes s the way a browser does, so a malformed tag cannot slip
+// through. Script and style go with their content, comments and other tags
+// are dropped and the text is kept.
+func (p HTMLPolicy) strip(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	rawTag := "" // set while reading the content of e.g. <script> or <title>
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			switch rawTag {
+			case "":
+				// A trailing < would open a tag with whatever follows
+				text := string(z.Raw())
+				if strings.HasSuffix(text, "<") {
+					text = strings.TrimSuffix(text, "<") + "&lt;"
+				}
+				b.WriteString(text)
+			case "script", "style":
+			default:
+				b.WriteString(html.EscapeString(string(z.Text())))
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if slices.Contains(p.AllowedTags, tag) {
+				if tt == html.EndTagToken {
+					b.WriteString("</" + tag + ">")
+				} else {
+					b.WriteString("<" + tag + ">")
+				}
+			}
+			if tt == html.StartTagToken && htmlRawTextTags[tag] {
+				rawTag = tag
+				continue
+			}
+		}
+		rawTag = ""
+	}
+}
+
+// htmlRawTextTags are the elements whose content the tokenizer reads as text
+var htmlRawTextTags = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
 }
 
 func sanitizeProduct(product *Product) {
 	// Sanitize input data:
 	// - Trim whitespace from strings
+	// - Apply the HTML policy to the description
 	// - Convert currency to uppercase
 	// - Convert slug to lowercase
 	// - Calculate available inventory (quantity - reserved)
@@ -165,7 +513,7 @@ func sanitizeProduct(product *Product) {
 
 	product.SKU = strings.TrimSpace(product.SKU)
 	product.Name = strings.TrimSpace(product.Name)
-	product.Description = strings.TrimSpace(product.Description)
+	product.Description = strings.TrimSpace(htmlPolicy.Sanitize(product.Description))
 	product.Currency = strings.ToUpper(strings.TrimSpace(product.Currency))
 	product.Category.Slug = strings.ToLower(strings.TrimSpace(product.Category.Slug))
 	product.Inventory.Available = product.Inventory.Quantity - product.Inventory.Reserved
@@ -193,11 +541,133 @@ func formatBindingErrors(err error) []ValidationError {
 	return result
 }
 
+// respond writes payload with the X-Request-ID of the request, if any.
+// Errors are negotiated from the Accept header: text/plain gets "key: value"
+// lines, one per failed field, XML an <error> element and anything else
+// JSON. Successful responses carry arbitrary data and are always JSON.
+func respond(c *gin.Context, status int, payload APIResponse) {
+	payload.RequestID = c.GetHeader("X-Request-ID")
+	if payload.Success {
+		c.JSON(status, payload)
+		return
+	}
+	switch c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain, gin.MIMEXML, gin.MIMEXML2) {
+	case gin.MIMEPlain:
+		var b strings.Builder
+		fmt.Fprintf(&b, "error: %s\n", payload.Message)
+		if payload.ErrorCode != "" {
+			fmt.Fprintf(&b, "code: %s\n", payload.ErrorCode)
+		}
+		for _, fe := range(payload.Errors) {
+			fmt.Fprintf(&b, "field %s: %s\n", fe.Field, fe.Message)
+		}
+		if payload.RequestID != "" {
+			fmt.Fprintf(&b, "request_id: %s\n", payload.RequestID)
+		}
+		c.String(status, b.String())
+	case gin.MIMEXML, gin.MIMEXML2:
+		resp := xmlError{
+			Status:    status,
+			Code:      payload.ErrorCode,
+			Message:   payload.Message,
+			RequestID: payload.RequestID,
+		}
+		for _, fe := range(payload.Errors) {
+			resp.Fields = append(resp.Fields, xmlFieldError{Name: fe.Field, Tag: fe.Tag, Message: fe.Message})
+		}
+		c.XML(status, resp)
+	default:
+		c.JSON(status, payload)
+	}
+}
+
+// readGuardedBody reads the request body within maxBodyBytes and checks its
+// JSON shape. On failure the error response is sent and ok is false.
+func readGuardedBody(c *gin.Context) (body []byte, ok bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respond(c, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Message: "Request body too large",
+			Errors: []ValidationError{{
+				Field:   "body",
+				Tag:     "max_bytes",
+				Message: fmt.Sprintf("Body must not exceed %d bytes", maxBodyBytes),
+				Param:   strconv.FormatInt(maxBodyBytes, 10),
+			}},
+		})
+		return nil, false
+	}
+	if err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{Success: false, Message: "Cannot read request body"})
+		return nil, false
+	}
+	if shapeErr := checkJSONShape(body); shapeErr != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "JSON body too complex",
+			Errors:  []ValidationError{*shapeErr},
+		})
+		return nil, false
+	}
+	return body, true
+}
+
+// checkJSONShape streams the JSON tokens and reports the first object or
+// array nested deeper than maxJSONDepth or array longer than maxArrayLen.
+// Syntax errors are left to the binder.
+func checkJSONShape(body []byte) *ValidationError {
+	type frame struct {
+		array bool
+		len   int
+	}
+	var stack []frame
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		delim, isDelim := tok.(json.Delim)
+		if delim == '}' || delim == ']' {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if n := len(stack); n > 0 && stack[n-1].array {
+			stack[n-1].len++
+			if stack[n-1].len > maxArrayLen {
+				return &ValidationError{
+					Field:   "body",
+					Tag:     "max_array_len",
+					Message: fmt.Sprintf("Arrays must not have more than %d elements", maxArrayLen),
+					Param:   strconv.Itoa(maxArrayLen),
+				}
+			}
+		}
+		if isDelim {
+			stack = append(stack, frame{array: delim == '['})
+			if len(stack) > maxJSONDepth {
+				return &ValidationError{
+					Field:   "body",
+					Tag:     "max_depth",
+					Message: fmt.Sprintf("JSON must not be nested deeper than %d levels", maxJSONDepth),
+					Param:   strconv.Itoa(maxJSONDepth),
+				}
+			}
+		}
+	}
+}
+
 // POST /products - Create single product
 func createProduct(c *gin.Context) {
+	body, ok := readGuardedBody(c)
+	if ! ok {
+		return
+	}
 	var product Product
-	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, APIResponse{
+	if err := binding.JSON.BindBody(body, &product); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Message: "Invalid JSON or basic validation failed",
 			Errors:  formatBindingErrors(err),
@@ -205,12 +675,18 @@ func createProduct(c *gin.Context) {
 		return
 	}
 
+	// Strict checks must be done before sanitization overwrites the input
+	var validationErrors []ValidationError
+	if isStrict(c) {
+		validationErrors = validateAvailable(&product)
+	}
+
 	// Sanitization must be done before validation
 	sanitizeProduct(&product)
 
-	validationErrors := validateProduct(&product)
+	validationErrors = append(validationErrors, validateProduct(&product)...)
 	if len(validationErrors) > 0 {
-		c.JSON(http.StatusBadRequest, APIResponse{
+		respond(c, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Message: "Validation failed",
 			Errors:  validationErrors,
@@ -218,40 +694,151 @@ func createProduct(c *gin.Context) {
 		return
 	}
 
-	product.ID = nextProductID
-	nextProductID++
-	products = append(products, product)
+	storeProduct(&product)
 
-	c.JSON(http.StatusCreated, APIResponse{
+	respond(c, http.StatusCreated, APIResponse{
 		Success: true,
 		Data:    product,
 		Message: "Product created successfully",
 	})
 }
 
+// PATCH /products/:id - Update a product with a partial JSON document
+//
+// The document is merged into the stored product, which is then validated
+// as a whole: lowering the quantity below the reserved one is rejected.
+// ID, reserved and available inventory and creation time are not writable,
+// reserved is managed by the reservations and available is recomputed. The
+// stored description is already sanitized, the HTML policy only applies to
+// a description set by the document.
+func updateProduct(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid product ID"})
+		return
+	}
+	body, ok := readGuardedBody(c)
+	if ! ok {
+		return
+	}
+
+	productsMutex.Lock()
+	defer productsMutex.Unlock()
+	stored := findProduct(productID)
+	if stored == nil {
+		respond(c, http.StatusNotFound, APIResponse{Success: false, Message: "Product not found"})
+		return
+	}
+
+	// The decoder reuses slices and maps, they must not be the stored ones
+	merged := *stored
+	merged.Tags = slices.Clone(stored.Tags)
+	merged.Images = slices.Clone(stored.Images)
+	merged.Attributes = maps.Clone(stored.Attributes)
+	if err := json.Unmarshal(body, &merged); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid JSON"})
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid JSON"})
+		return
+	}
+	_, setsDescription := fields["description"]
+	merged.ID = stored.ID
+	merged.CreatedAt = stored.CreatedAt
+	merged.Inventory.Reserved = stored.Inventory.Reserved
+	if err := binding.Validator.ValidateStruct(&merged); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Basic validation failed",
+			Errors:  formatBindingErrors(err),
+		})
+		return
+	}
+
+	// Sanitizing recomputes Available on the copy, the stored product is
+	// only replaced once the update is known to be valid
+	sanitizeProduct(&merged)
+	merged.CreatedAt = stored.CreatedAt
+	if ! setsDescription {
+		merged.Description = stored.Description
+	}
+
+	validationErrors := validateProductFields(&merged)
+	if p := findProductBySKU(merged.SKU); p != nil && p.ID != productID {
+		validationErrors = append(validationErrors, ValidationError{Field: "sku", Value: merged.SKU, Tag: "sku_unique", Message: "SKU already exists"})
+	}
+	if len(validationErrors) > 0 {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Validation failed",
+			Errors:  validationErrors,
+		})
+		return
+	}
+
+	*stored = merged
+
+	respond(c, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    merged,
+		Message: "Product updated successfully",
+	})
+}
+
 // POST /products/bulk - Create multiple products
 func createProductsBulk(c *gin.Context) {
+	body, ok := readGuardedBody(c)
+	if ! ok {
+		return
+	}
 	var inputProducts []Product
-
-	if err := c.ShouldBindJSON(&inputProducts); err != nil {
-		c.JSON(http.StatusBadRequest, APIResponse{
+	if err := binding.JSON.BindBody(body, &inputProducts); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Message: "Invalid JSON format",
 		})
 		return
 	}
 
-	type BulkResult struct {
-		Index   int               `json:"index"`
-		Success bool              `json:"success"`
-		Product *Product          `json:"product,omitempty"`
-		Errors  []ValidationError `json:"errors,omitempty"`
+	var strictErrors [][]ValidationError
+	if isStrict(c) {
+		for i := range inputProducts {
+			strictErrors = append(strictErrors, validateAvailable(&inputProducts[i]))
+		}
+	}
+	for i := range inputProducts {
+		sanitizeProduct(&inputProducts[i])
 	}
 
+	bulkResponse(c, bulkCreate(inputProducts, strictErrors))
+}
+
+// BulkResult represents the outcome of a single product of a bulk operation
+type BulkResult struct {
+	Index   int               `json:"index"`
+	Success bool              `json:"success"`
+	Product *Product          `json:"product,omitempty"`
+	Errors  []ValidationError `json:"errors,omitempty"`
+}
+
+// bulkCreate validates and stores each product, already sanitized. preErrors
+// holds the errors found before validation (decoding, strict checks), such an
+// item is rejected as is.
+func bulkCreate(inputProducts []Product, preErrors [][]ValidationError) []BulkResult {
 	var results []BulkResult
-	var successCount int
 
 	for i, product := range inputProducts {
+		product := product
+		if i < len(preErrors) && len(preErrors[i]) > 0 {
+			results = append(results, BulkResult{
+				Index:   i,
+				Success: false,
+				Errors:  preErrors[i],
+			})
+			continue
+		}
 		validationErrors := validateProduct(&product)
 		if len(validationErrors) > 0 {
 			results = append(results, BulkResult{
@@ -260,38 +847,190 @@ func createProductsBulk(c *gin.Context) {
 				Errors:  validationErrors,
 			})
 		} else {
-			sanitizeProduct(&product)
-			product.ID = nextProductID
-			nextProductID++
-			products = append(products, product)
+			storeProduct(&product)
 
 			results = append(results, BulkResult{
 				Index:   i,
 				Success: true,
 				Product: &product,
 			})
+		}
+	}
+	return results
+}
+
+func bulkResponse(c *gin.Context, results []BulkResult) {
+	successCount := 0
+	for _, r := range results {
+		if r.Success {
 			successCount++
 		}
 	}
 
-	c.JSON(http.StatusOK, APIResponse{
-		Success: successCount == len(inputProducts),
+	respond(c, http.StatusOK, APIResponse{
+		Success: successCount == len(results),
 		Data: map[string]interface{}{
 			"results":    results,
-			"total":      len(inputProducts),
+			"total":      len(results),
 			"successful": successCount,
-			"failed":     len(inputProducts) - successCount,
+			"failed":     len(results) - successCount,
 		},
 		Message: "Bulk operation completed",
 	})
 }
 
+// productCSVColumns maps the CSV header names to the product field they set
+var productCSVColumns = map[string]func(p *Product, value string) error{
+	"sku":           func(p *Product, v string) error { p.SKU = v; return nil },
+	"name":          func(p *Product, v string) error { p.Name = v; return nil },
+	"description":   func(p *Product, v string) error { p.Description = v; return nil },
+	"currency":      func(p *Product, v string) error { p.Currency = v; return nil },
+	"category_name": func(p *Product, v string) error { p.Category.Name = v; return nil },
+	"category_slug": func(p *Product, v string) error { p.Category.Slug = v; return nil },
+	"location":      func(p *Product, v string) error { p.Inventory.Location = v; return nil },
+	"price": func(p *Product, v string) (err error) {
+		p.Price, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return err
+	},
+	"category_id": func(p *Product, v string) (err error) {
+		p.Category.ID, err = strconv.Atoi(strings.TrimSpace(v))
+		return err
+	},
+	"quantity": func(p *Product, v string) (err error) {
+		p.Inventory.Quantity, err = strconv.Atoi(strings.TrimSpace(v))
+		return err
+	},
+	"reserved": func(p *Product, v string) (err error) {
+		p.Inventory.Reserved, err = strconv.Atoi(strings.TrimSpace(v))
+		return err
+	},
+	"tags": func(p *Product, v string) error {
+		if v != "" {
+			p.Tags = strings.Split(v, "|")
+		}
+		return nil
+	},
+}
+
+var requiredCSVColumns = []string{"sku", "name", "price", "currency", "category_name", "category_slug", "quantity", "location"}
+
+// POST /products/import - Create multiple products from a CSV file
+// The first row is a header naming the columns, tags are separated by '|'.
+func importProductsCSV(c *gin.Context) {
+	if c.ContentType() != "text/csv" {
+		respond(c, http.StatusUnsupportedMediaType, APIResponse{
+			Success: false,
+			Message: "Content-Type must be text/csv",
+		})
+		return
+	}
+
+	reader := csv.NewReader(c.Request.Body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Missing CSV header row",
+		})
+		return
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	var missing []ValidationError
+	for _, col := range requiredCSVColumns {
+		if ! slices.Contains(header, col) {
+			missing = append(missing, ValidationError{Field: col, Tag: "required", Message: "Missing column"})
+		}
+	}
+	if len(missing) > 0 {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Missing required CSV columns",
+			Errors:  missing,
+		})
+		return
+	}
+
+	var inputProducts []Product
+	var decodeErrors [][]ValidationError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && ! errors.Is(err, csv.ErrFieldCount) {
+			respond(c, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Invalid CSV: %v", err),
+			})
+			return
+		}
+		product, rowErrors := parseProductRecord(header, record)
+		inputProducts = append(inputProducts, product)
+		decodeErrors = append(decodeErrors, rowErrors)
+	}
+
+	bulkResponse(c, bulkCreate(inputProducts, decodeErrors))
+}
+
+// parseProductRecord converts a CSV record into a sanitized product and runs
+// the binding validation usually done by ShouldBindJSON
+func parseProductRecord(header, record []string) (Product, []ValidationError) {
+	var product Product
+	var rowErrors []ValidationError
+
+	if len(record) != len(header) {
+		rowErrors = append(rowErrors, ValidationError{
+			Field:   "row",
+			Tag:     "columns",
+			Message: fmt.Sprintf("Expected %d columns, got %d", len(header), len(record)),
+		})
+		return product, rowErrors
+	}
+
+	for i, col := range header {
+		set, ok := productCSVColumns[col]
+		if ! ok {
+			continue
+		}
+		if err := set(&product, record[i]); err != nil {
+			rowErrors = append(rowErrors, ValidationError{
+				Field:   col,
+				Value:   record[i],
+				Tag:     "type",
+				Message: fmt.Sprintf("Invalid value for column '%s'", col),
+			})
+		}
+	}
+	if len(rowErrors) > 0 {
+		return product, rowErrors
+	}
+
+	if product.Category.ID == 0 {
+		for _, cat := range categories {
+			if cat.Name == product.Category.Name {
+				product.Category.ID = cat.ID
+				break
+			}
+		}
+	}
+
+	sanitizeProduct(&product)
+	if err := binding.Validator.ValidateStruct(&product); err != nil {
+		rowErrors = formatBindingErrors(err)
+	}
+	return product, rowErrors
+}
+
 // POST /categories - Create category
 func createCategory(c *gin.Context) {
 	var category Category
 
 	if err := c.ShouldBindJSON(&category); err != nil {
-		c.JSON(400, APIResponse{
+		respond(c, 400, APIResponse{
 			Success: false,
 			Message: "Invalid JSON or validation failed",
 		})
@@ -303,7 +1042,7 @@ func createCategory(c *gin.Context) {
 	// - Check parent category exists if specified
 	// - Ensure category name is unique
 	if ! isValidSlug(category.Slug) {
-		c.JSON(http.StatusBadRequest, APIResponse{
+		respond(c, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Message: "Invalid slug",
 		})
@@ -312,7 +1051,7 @@ func createCategory(c *gin.Context) {
 
 	for _, cat := range(categories) {
 		if cat.Name == category.Name {
-			c.JSON(http.StatusBadRequest, APIResponse{
+			respond(c, http.StatusBadRequest, APIResponse{
 				Success:   false,
 				Message:   "Category already exists",
 			})
@@ -329,7 +1068,7 @@ func createCategory(c *gin.Context) {
 			}
 		}
 		if ! exists {
-			c.JSON(http.StatusBadRequest, APIResponse{
+			respond(c, http.StatusBadRequest, APIResponse{
 				Success:   false,
 				Message:   "Parent category does not exists",
 			})
@@ -339,13 +1078,134 @@ func createCategory(c *gin.Context) {
 
 	categories = append(categories, category)
 
-	c.JSON(201, APIResponse{
+	respond(c, 201, APIResponse{
 		Success: true,
 		Data:    category,
 		Message: "Category created successfully",
 	})
 }
 
+// POST /products/:id/reserve - Hold inventory for reservationTTL
+func reserveProduct(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid product ID"})
+		return
+	}
+	var request struct {
+		Quantity int `json:"quantity" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid JSON or validation failed",
+			Errors:  formatBindingErrors(err),
+		})
+		return
+	}
+	id, err := newReservationID()
+	if err != nil {
+		respond(c, http.StatusInternalServerError, APIResponse{Success: false, Message: "Internal server error"})
+		return
+	}
+
+	productsMutex.Lock()
+	defer productsMutex.Unlock()
+	product := findProduct(productID)
+	if product == nil {
+		respond(c, http.StatusNotFound, APIResponse{Success: false, Message: "Product not found"})
+		return
+	}
+	if product.Inventory.Available < request.Quantity {
+		respond(c, http.StatusConflict, APIResponse{
+			Success:   false,
+			Message:   fmt.Sprintf("Only %d available", product.Inventory.Available),
+			ErrorCode: "INSUFFICIENT_INVENTORY",
+		})
+		return
+	}
+	now := time.Now()
+	product.Inventory.Reserved += request.Quantity
+	product.Inventory.Available -= request.Quantity
+	product.Inventory.LastUpdated = now
+	reservation := &Reservation{
+		ID:        id,
+		ProductID: productID,
+		Quantity:  request.Quantity,
+		Status:    ReservationHeld,
+		ExpiresAt: now.Add(reservationTTL),
+	}
+	reservations[id] = reservation
+
+	respond(c, http.StatusCreated, APIResponse{
+		Success: true,
+		Data:    *reservation,
+		Message: "Inventory reserved",
+	})
+}
+
+// POST /products/:id/confirm - Make a held reservation permanent, the
+// reserved quantity leaves the inventory
+func confirmReservation(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid product ID"})
+		return
+	}
+	var request struct {
+		ReservationID string `json:"reservation_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid JSON or validation failed",
+			Errors:  formatBindingErrors(err),
+		})
+		return
+	}
+
+	productsMutex.Lock()
+	defer productsMutex.Unlock()
+	reservation, ok := reservations[request.ReservationID]
+	if ! ok || reservation.ProductID != productID {
+		respond(c, http.StatusNotFound, APIResponse{Success: false, Message: "Reservation not found"})
+		return
+	}
+	now := time.Now()
+	// The sweeper may not have run yet
+	if reservation.Status == ReservationHeld && ! now.Before(reservation.ExpiresAt) {
+		releaseReservation(reservation, now)
+	}
+	switch reservation.Status {
+	case ReservationConfirmed:
+		respond(c, http.StatusConflict, APIResponse{
+			Success:   false,
+			Message:   "Reservation already confirmed",
+			ErrorCode: "RESERVATION_CONFIRMED",
+		})
+		return
+	case ReservationExpired:
+		respond(c, http.StatusConflict, APIResponse{
+			Success:   false,
+			Message:   "Reservation expired",
+			ErrorCode: "RESERVATION_EXPIRED",
+		})
+		return
+	}
+
+	reservation.Status = ReservationConfirmed
+	if product := findProduct(productID); product != nil {
+		product.Inventory.Quantity -= reservation.Quantity
+		product.Inventory.Reserved -= reservation.Quantity
+		product.Inventory.LastUpdated = now
+	}
+	respond(c, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    *reservation,
+		Message: "Reservation confirmed",
+	})
+}
+
 // POST /validate/sku - Validate SKU format and uniqueness
 func validateSKUEndpoint(c *gin.Context) {
 	var request struct {
@@ -353,7 +1213,7 @@ func validateSKUEndpoint(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, APIResponse{
+		respond(c, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Message: "SKU is required",
 		})
@@ -361,24 +1221,22 @@ func validateSKUEndpoint(c *gin.Context) {
 	}
 
 	if ! isValidSKU(request.SKU) {
-		c.JSON(http.StatusOK, APIResponse{
+		respond(c, http.StatusOK, APIResponse{
 			Success: false,
 			Message: "Invalid SKU format",
 		})
 		return
 	}
 
-	for _, p := range(products) {
-		if p.SKU == request.SKU {
-			c.JSON(http.StatusOK, APIResponse{
-				Success: false,
-				Message: "SKU already exists",
-			})
-			return
-		}
+	if skuExists(request.SKU) {
+		respond(c, http.StatusOK, APIResponse{
+			Success: false,
+			Message: "SKU already exists",
+		})
+		return
 	}
 
-	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "SKU is valid"})
+	respond(c, http.StatusOK, APIResponse{Success: true, Message: "SKU is valid"})
 }
 
 // POST /validate/product - Validate product without saving
@@ -386,16 +1244,20 @@ func validateProductEndpoint(c *gin.Context) {
 	var product Product
 
 	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, APIResponse{
+		respond(c, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Message: "Invalid JSON format",
 		})
 		return
 	}
 
-	validationErrors := validateProduct(&product)
+	var validationErrors []ValidationError
+	if isStrict(c) {
+		validationErrors = validateAvailable(&product)
+	}
+	validationErrors = append(validationErrors, validateProduct(&product)...)
 	if len(validationErrors) > 0 {
-		c.JSON(http.StatusBadRequest, APIResponse{
+		respond(c, http.StatusBadRequest, APIResponse{
 			Success: false,
 			Message: "Validation failed",
 			Errors:  validationErrors,
@@ -403,12 +1265,91 @@ func validateProductEndpoint(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, APIResponse{
+	respond(c, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Product data is valid",
 	})
 }
 
+// ValidationSummary aggregates the errors of a batch validation
+type ValidationSummary struct {
+	Total   int            `json:"total"`
+	Valid   int            `json:"valid"`
+	Invalid int            `json:"invalid"`
+	ByTag   map[string]int `json:"by_tag"`   // errors per tag, e.g. sku_format
+	ByField map[string]int `json:"by_field"` // errors per field
+}
+
+// POST /validate/products - Validate products without saving, reporting the
+// errors of each one and their counts per tag and field
+func validateProductsEndpoint(c *gin.Context) {
+	body, ok := readGuardedBody(c)
+	if ! ok {
+		return
+	}
+	// The binding rules are checked per item, so that an invalid item does
+	// not hide the errors of the others
+	var inputProducts []Product
+	if err := json.Unmarshal(body, &inputProducts); err != nil {
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid JSON format, expected an array of products",
+		})
+		return
+	}
+
+	summary := ValidationSummary{
+		Total:   len(inputProducts),
+		ByTag:   map[string]int{},
+		ByField: map[string]int{},
+	}
+	results := make([]BulkResult, 0, len(inputProducts))
+	firstBySKU := map[string]int{}
+	for i := range inputProducts {
+		product := inputProducts[i]
+		var validationErrors []ValidationError
+		if err := binding.Validator.ValidateStruct(&product); err != nil {
+			validationErrors = formatBindingErrors(err)
+		}
+		if isStrict(c) {
+			validationErrors = append(validationErrors, validateAvailable(&product)...)
+		}
+		// Sanitizing works on the decoded copy, nothing is stored
+		sanitizeProduct(&product)
+		validationErrors = append(validationErrors, validateProduct(&product)...)
+		if first, seen := firstBySKU[product.SKU]; seen {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:   "sku",
+				Value:   product.SKU,
+				Tag:     "sku_unique_in_batch",
+				Message: fmt.Sprintf("SKU already used by item %d", first),
+			})
+		} else {
+			firstBySKU[product.SKU] = i
+		}
+
+		for _, e := range validationErrors {
+			summary.ByTag[e.Tag]++
+			summary.ByField[e.Field]++
+		}
+		if len(validationErrors) == 0 {
+			summary.Valid++
+		} else {
+			summary.Invalid++
+		}
+		results = append(results, BulkResult{Index: i, Success: len(validationErrors) == 0, Errors: validationErrors})
+	}
+
+	respond(c, http.StatusOK, APIResponse{
+		Success: summary.Invalid == 0,
+		Data: map[string]interface{}{
+			"results": results,
+			"summary": summary,
+		},
+		Message: "Batch validation completed",
+	})
+}
+
 // GET /validation/rules - Get validation rules
 func getValidationRules(c *gin.Context) {
 	rules := map[string]interface{}{
@@ -432,20 +1373,165 @@ func getValidationRules(c *gin.Context) {
 		},
 	}
 
-	c.JSON(http.StatusOK, APIResponse{
+	respond(c, http.StatusOK, APIResponse{
 		Success: true,
 		Data:    rules,
 		Message: "Validation rules retrieved",
 	})
 }
 
+// GET /healthz - Liveness probe
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// GET /readyz - Readiness probe, 503 if any check fails
+func readyz(c *gin.Context) {
+	status, resp := http.StatusOK, HealthResponse{Status: "ok", Checks: map[string]string{}}
+	for name, check := range readinessChecks {
+		if err := check(); err != nil {
+			status, resp.Status = http.StatusServiceUnavailable, "unavailable"
+			resp.Checks[name] = err.Error()
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+	c.JSON(status, resp)
+}
+
+// GET /products/schema - JSON Schema of the Product model
+func getProductSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, productSchema())
+}
+
+// productSchema builds the JSON Schema of Product from the binding tags and
+// the custom validators configuration
+func productSchema() map[string]interface{} {
+	categoryNames := make([]string, 0, len(categories))
+	for _, cat := range categories {
+		categoryNames = append(categoryNames, cat.Name)
+	}
+
+	// Constraints enforced by validateProduct, keyed by JSON path
+	custom := map[string]map[string]interface{}{
+		"sku":                 {"pattern": skuPattern},
+		"currency":            {"enum": validCurrencies},
+		"category.name":       {"enum": categoryNames},
+		"category.slug":       {"pattern": slugPattern},
+		"inventory.location":  {"enum": validWarehouses},
+		"inventory.reserved":  {"description": "Must be less than or equal to inventory.quantity"},
+		"inventory.available": {"description": "Calculated as quantity - reserved", "readOnly": true},
+	}
+
+	schema := typeSchema(reflect.TypeOf(Product{}), "", custom)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Product"
+	return schema
+}
+
+// typeSchema returns the JSON Schema of a Go type, path is the JSON path of
+// the value used to look up custom constraints
+func typeSchema(t reflect.Type, path string, custom map[string]map[string]interface{}) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	schema := map[string]interface{}{}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		schema["type"] = "string"
+		schema["format"] = "date-time"
+	case t.Kind() == reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			prop := typeSchema(field.Type, fieldPath, custom)
+			if applyBindingRules(prop, field.Tag.Get("binding")) {
+				required = append(required, name)
+			}
+			for k, v := range custom[fieldPath] {
+				prop[k] = v
+			}
+			properties[name] = prop
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	case t.Kind() == reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = typeSchema(t.Elem(), path+"[]", custom)
+	case t.Kind() == reflect.Map:
+		schema["type"] = "object"
+	case t.Kind() == reflect.String:
+		schema["type"] = "string"
+	case t.Kind() == reflect.Bool:
+		schema["type"] = "boolean"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema["type"] = "number"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema["type"] = "integer"
+	}
+	return schema
+}
+
+// applyBindingRules translates the binding tag rules into schema keywords and
+// reports whether the field is required
+func applyBindingRules(prop map[string]interface{}, binding string) bool {
+	required := false
+	for _, rule := range strings.Split(binding, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "url":
+			prop["format"] = "uri"
+		case "email":
+			prop["format"] = "email"
+		case "min", "max":
+			value, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			keyword := map[string]map[string]string{
+				"string": {"min": "minLength", "max": "maxLength"},
+				"array":  {"min": "minItems", "max": "maxItems"},
+			}[fmt.Sprint(prop["type"])][name]
+			if keyword == "" {
+				keyword = map[string]string{"min": "minimum", "max": "maximum"}[name]
+			}
+			prop[keyword] = value
+		}
+	}
+	return required
+}
+
 // Setup router
 func setupRouter() *gin.Engine {
 	router := gin.Default()
 
+	// Health routes
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
+
 	// Product routes
 	router.POST("/products", createProduct)
 	router.POST("/products/bulk", createProductsBulk)
+	router.POST("/products/import", importProductsCSV)
+	router.GET("/products/schema", getProductSchema)
+	router.POST("/products/:id/reserve", reserveProduct)
+	router.POST("/products/:id/confirm", confirmReservation)
+	router.PATCH("/products/:id", updateProduct)
 
 	// Category routes
 	router.POST("/categories", createCategory)
@@ -453,12 +1539,14 @@ func setupRouter() *gin.Engine {
 	// Validation routes
 	router.POST("/validate/sku", validateSKUEndpoint)
 	router.POST("/validate/product", validateProductEndpoint)
+	router.POST("/validate/products", validateProductsEndpoint)
 	router.GET("/validation/rules", getValidationRules)
 
 	return router
 }
 
 func main() {
+	StartReservationSweeper(context.Background(), reservationSweep)
 	router := setupRouter()
 	router.Run(":8080")
 }
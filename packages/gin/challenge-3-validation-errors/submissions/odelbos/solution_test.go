@@ -0,0 +1,889 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestHealthz(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp HealthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "ok", resp.Status)
+}
+
+func TestReadyz(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp HealthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "ok", resp.Status)
+	assert.Equal(t, "ok", resp.Checks["products"])
+	assert.Equal(t, "ok", resp.Checks["categories"])
+}
+
+func TestReadyzWithoutCategories(t *testing.T) {
+	saved := categories
+	defer func() { categories = saved }()
+	categories = nil
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var resp HealthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "unavailable", resp.Status)
+	assert.Equal(t, "ok", resp.Checks["products"])
+	assert.Equal(t, "no categories loaded", resp.Checks["categories"])
+}
+
+func TestProductSchema(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/products/schema", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var schema struct {
+		Type       string   `json:"type"`
+		Required   []string `json:"required"`
+		Properties map[string]struct {
+			Type       string                     `json:"type"`
+			Pattern    string                     `json:"pattern"`
+			Enum       []string                   `json:"enum"`
+			MinLength  float64                    `json:"minLength"`
+			MaxLength  float64                    `json:"maxLength"`
+			Minimum    float64                    `json:"minimum"`
+			Properties map[string]json.RawMessage `json:"properties"`
+			Items      map[string]interface{}     `json:"items"`
+		} `json:"properties"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &schema))
+
+	assert.Equal(t, "object", schema.Type)
+	assert.Subset(t, schema.Required, []string{"sku", "name", "price", "currency", "category", "inventory"})
+
+	sku := schema.Properties["sku"]
+	assert.Equal(t, "string", sku.Type)
+	assert.Equal(t, skuPattern, sku.Pattern)
+
+	currency := schema.Properties["currency"]
+	assert.ElementsMatch(t, validCurrencies, currency.Enum)
+
+	name := schema.Properties["name"]
+	assert.Equal(t, 3.0, name.MinLength)
+	assert.Equal(t, 100.0, name.MaxLength)
+	assert.Equal(t, 0.01, schema.Properties["price"].Minimum)
+	assert.Equal(t, "array", schema.Properties["images"].Type)
+	assert.Equal(t, "object", schema.Properties["images"].Items["type"])
+
+	var location struct {
+		Enum []string `json:"enum"`
+	}
+	assert.NoError(t, json.Unmarshal(schema.Properties["inventory"].Properties["location"], &location))
+	assert.ElementsMatch(t, validWarehouses, location.Enum)
+
+	var slug struct {
+		Pattern string `json:"pattern"`
+	}
+	assert.NoError(t, json.Unmarshal(schema.Properties["category"].Properties["slug"], &slug))
+	assert.Equal(t, slugPattern, slug.Pattern)
+}
+
+func importCSV(router *gin.Engine, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/products/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestImportProductsCSV(t *testing.T) {
+	router := setupRouter()
+
+	body := `sku,name,description,price,currency,category_name,category_slug,tags,quantity,reserved,location
+ABC-123-XYZ,"Laptop, 15 inch","A ""fast"" laptop",999.99,usd,Electronics,electronics,tech|portable,10,2,WH001
+DEF-456-UVW,T-Shirt,,abc,EUR,Clothing,clothing,,5,0,WH002
+GHI-789-RST,Mystery,,9.99,XXX,Clothing,clothing,,5,0,WH002
+JKL-012-MNO,Novel,,12.50,GBP,Books,books,,seven,0,WH003
+`
+	w := importCSV(router, body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Results    []BulkResult `json:"results"`
+			Total      int          `json:"total"`
+			Successful int          `json:"successful"`
+			Failed     int          `json:"failed"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Success)
+	assert.Equal(t, 4, resp.Data.Total)
+	assert.Equal(t, 1, resp.Data.Successful)
+	assert.Equal(t, 3, resp.Data.Failed)
+
+	results := resp.Data.Results
+	assert.Len(t, results, 4)
+
+	assert.True(t, results[0].Success)
+	product := results[0].Product
+	if assert.NotNil(t, product) {
+		assert.Equal(t, "ABC-123-XYZ", product.SKU)
+		assert.Equal(t, "Laptop, 15 inch", product.Name)
+		assert.Equal(t, `A "fast" laptop`, product.Description)
+		assert.Equal(t, "USD", product.Currency)
+		assert.Equal(t, 1, product.Category.ID)
+		assert.Equal(t, []string{"tech", "portable"}, product.Tags)
+		assert.Equal(t, 8, product.Inventory.Available)
+	}
+
+	assert.False(t, results[1].Success)
+	if assert.Len(t, results[1].Errors, 1) {
+		assert.Equal(t, "price", results[1].Errors[0].Field)
+		assert.Equal(t, "type", results[1].Errors[0].Tag)
+	}
+
+	assert.False(t, results[2].Success)
+	if assert.NotEmpty(t, results[2].Errors) {
+		assert.Equal(t, "currency", results[2].Errors[0].Field)
+	}
+
+	assert.False(t, results[3].Success)
+	if assert.Len(t, results[3].Errors, 1) {
+		assert.Equal(t, "quantity", results[3].Errors[0].Field)
+	}
+}
+
+func TestImportProductsCSVMissingColumns(t *testing.T) {
+	router := setupRouter()
+
+	w := importCSV(router, "sku,name,price\nABC-123-XYZ,Laptop,10\n")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Success)
+	var fields []string
+	for _, e := range resp.Errors {
+		fields = append(fields, e.Field)
+	}
+	assert.Contains(t, fields, "currency")
+	assert.Contains(t, fields, "location")
+}
+
+func TestImportProductsCSVContentType(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/products/import", strings.NewReader("sku\n"))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func productJSON(sku string, quantity, reserved, available int) string {
+	return fmt.Sprintf(`{
+		"sku": %q, "name": "Laptop", "price": 999.99, "currency": "USD",
+		"category": {"id": 1, "name": "Electronics", "slug": "electronics"},
+		"inventory": {"quantity": %d, "reserved": %d, "available": %d, "location": "WH001"}
+	}`, sku, quantity, reserved, available)
+}
+
+func postJSON(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAvailableLenientMode(t *testing.T) {
+	router := setupRouter()
+
+	w := postJSON(router, "/products", productJSON("AVL-001-LEN", 10, 2, 5))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var resp struct {
+		Data Product `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 8, resp.Data.Inventory.Available)
+}
+
+func TestAvailableStrictMode(t *testing.T) {
+	router := setupRouter()
+
+	w := postJSON(router, "/products?strict=true", productJSON("AVL-002-STR", 10, 2, 5))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.NotEmpty(t, resp.Errors) {
+		assert.Equal(t, "inventory.available", resp.Errors[0].Field)
+		assert.Equal(t, "consistency", resp.Errors[0].Tag)
+	}
+
+	w = postJSON(router, "/products?strict=true", productJSON("AVL-002-STR", 10, 2, 8))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = postJSON(router, "/validate/product?strict=true", productJSON("AVL-003-STR", 10, 2, 10))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body := "[" + productJSON("AVL-004-STR", 5, 0, 5) + "," + productJSON("AVL-005-STR", 5, 1, 5) + "]"
+	w = postJSON(router, "/products/bulk?strict=true", body)
+	var bulk struct {
+		Data struct {
+			Results []BulkResult `json:"results"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &bulk))
+	if assert.Len(t, bulk.Data.Results, 2) {
+		assert.True(t, bulk.Data.Results[0].Success)
+		assert.False(t, bulk.Data.Results[1].Success)
+		assert.Equal(t, "inventory.available", bulk.Data.Results[1].Errors[0].Field)
+	}
+}
+
+func TestSeedProducts(t *testing.T) {
+	defer SeedProducts()
+	router := setupRouter()
+
+	SeedProducts(
+		Product{ID: 42, SKU: "SED-001-AAA", Name: "Laptop"},
+		Product{ID: 7, SKU: "SED-002-BBB", Name: "Phone"},
+	)
+	assert.Equal(t, []int{1, 2}, []int{products[0].ID, products[1].ID})
+
+	w := postJSON(router, "/products", productJSON("SED-001-AAA", 10, 0, 10))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON(router, "/products", productJSON("SED-003-CCC", 10, 0, 10))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Data Product `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Data.ID)
+
+	// Re-seeding clears the previous products and restarts the IDs
+	SeedProducts(Product{SKU: "SED-004-DDD", Name: "Tablet"})
+	assert.Len(t, products, 1)
+	w = postJSON(router, "/products", productJSON("SED-001-AAA", 10, 0, 10))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Data.ID)
+}
+
+func TestValidatorsRejectLookalikes(t *testing.T) {
+	assert.True(t, isValidSKU("ABC-123-XYZ"))
+	assert.True(t, isValidSlug("home-garden"))
+
+	for _, sku := range []string{
+		"ＡＢＣ-１２３-ＸＹＺ",       // full-width letters and digits
+		"ABC-١٢٣-XYZ",       // Arabic-Indic digits
+		"АВС-123-XYZ",       // Cyrillic A, V, S
+		"A\u0301BC-123-XYZ", // combining acute accent
+		"ABC-123-XYZ\u200b", // zero-width space
+		"ABC\u2010123-XYZ",  // Unicode hyphen
+		"ABC-123-XYZ\n",     // trailing newline
+		"ABC-123-XY\xff",    // invalid UTF-8
+	} {
+		assert.False(t, isValidSKU(sku), "SKU %q", sku)
+	}
+	for _, slug := range []string{"ｈｏｍｅ", "caf\u00e9", "cafe\u0301", "home\u2011garden"} {
+		assert.False(t, isValidSlug(slug), "slug %q", slug)
+	}
+}
+
+// isASCII reports whether s only holds ASCII bytes
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzValidateSKU(f *testing.F) {
+	for _, seed := range []string{
+		"ABC-123-XYZ", "abc-123-xyz", "ABC-123-XYZ-", "", "-",
+		"ＡＢＣ-１２３-ＸＹＺ", "ABC-١٢٣-XYZ", "АВС-123-ХУZ",
+		"A\u0301BC-123-XYZ", "ABC-123-XYZ\u200b", "\ufeffABC-123-XYZ",
+		"ABC-123-XYZ\n", "ABC-123-XY\xff", "\xc0\x80", "home-garden", "home--garden",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		sku, slug := isValidSKU(s), isValidSlug(s)
+		if (sku || slug) && !isASCII(s) {
+			t.Fatalf("Accepted non-ASCII input %q (sku=%v, slug=%v)", s, sku, slug)
+		}
+		if sku != skuRegexp.MatchString(s) && isASCII(s) {
+			t.Fatalf("SKU validator disagrees with the pattern on ASCII input %q", s)
+		}
+		if slug != slugRegexp.MatchString(s) && isASCII(s) {
+			t.Fatalf("Slug validator disagrees with the pattern on ASCII input %q", s)
+		}
+	})
+}
+
+func seedReservable(quantity int) {
+	SeedProducts(Product{
+		SKU:       "RES-001-AAA",
+		Name:      "Laptop",
+		Inventory: Inventory{Quantity: quantity, Available: quantity, Location: "WH001"},
+	})
+}
+
+func reserve(t *testing.T, router *gin.Engine, quantity int) (int, Reservation) {
+	t.Helper()
+	w := postJSON(router, "/products/1/reserve", fmt.Sprintf(`{"quantity": %d}`, quantity))
+	var resp struct {
+		Data Reservation `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w.Code, resp.Data
+}
+
+func confirm(router *gin.Engine, id string) *httptest.ResponseRecorder {
+	return postJSON(router, "/products/1/confirm", fmt.Sprintf(`{"reservation_id": %q}`, id))
+}
+
+// stock returns the quantities of an inventory
+func stock(p Product) Inventory {
+	inv := p.Inventory
+	return Inventory{Quantity: inv.Quantity, Reserved: inv.Reserved, Available: inv.Available}
+}
+
+func TestReserveAndConfirm(t *testing.T) {
+	defer SeedProducts()
+	seedReservable(10)
+	router := setupRouter()
+
+	code, res := reserve(t, router, 4)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.NotEmpty(t, res.ID)
+	assert.Equal(t, ReservationHeld, res.Status)
+	assert.Equal(t, Inventory{Quantity: 10, Reserved: 4, Available: 6}, stock(products[0]))
+
+	code, _ = reserve(t, router, 7)
+	assert.Equal(t, http.StatusConflict, code)
+
+	w := confirm(router, res.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Inventory{Quantity: 6, Reserved: 0, Available: 6}, stock(products[0]))
+
+	// Confirming twice is rejected and leaves the inventory alone
+	w = confirm(router, res.ID)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "RESERVATION_CONFIRMED")
+	assert.Equal(t, Inventory{Quantity: 6, Reserved: 0, Available: 6}, stock(products[0]))
+
+	w = confirm(router, "unknown")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = postJSON(router, "/products/99/reserve", `{"quantity": 1}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = postJSON(router, "/products/1/reserve", `{"quantity": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestConfirmExpiredReservation(t *testing.T) {
+	defer SeedProducts()
+	seedReservable(10)
+	router := setupRouter()
+
+	_, res := reserve(t, router, 3)
+	productsMutex.Lock()
+	reservations[res.ID].ExpiresAt = time.Now().Add(-time.Second)
+	productsMutex.Unlock()
+
+	w := confirm(router, res.ID)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "RESERVATION_EXPIRED")
+	assert.Equal(t, Inventory{Quantity: 10, Reserved: 0, Available: 10}, stock(products[0]))
+
+	// The sweeper does not release it twice
+	assert.Equal(t, 0, releaseExpiredReservations(time.Now()))
+	assert.Equal(t, 10, products[0].Inventory.Available)
+}
+
+func TestReservationSweeper(t *testing.T) {
+	defer SeedProducts()
+	defer func(ttl time.Duration) { reservationTTL = ttl }(reservationTTL)
+	reservationTTL = 20 * time.Millisecond
+	seedReservable(10)
+	router := setupRouter()
+
+	_, held := reserve(t, router, 2)
+	_, kept := reserve(t, router, 5)
+	assert.Equal(t, http.StatusOK, confirm(router, kept.ID).Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartReservationSweeper(ctx, 5*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		productsMutex.RLock()
+		defer productsMutex.RUnlock()
+		return reservations[held.ID].Status == ReservationExpired
+	}, time.Second, 5*time.Millisecond)
+
+	productsMutex.RLock()
+	defer productsMutex.RUnlock()
+	assert.Equal(t, Inventory{Quantity: 5, Reserved: 0, Available: 5}, stock(products[0]))
+	assert.Equal(t, ReservationConfirmed, reservations[kept.ID].Status)
+}
+
+func TestReservationRetention(t *testing.T) {
+	defer SeedProducts()
+	seedReservable(10)
+	router := setupRouter()
+
+	_, res := reserve(t, router, 1)
+	now := time.Now().Add(reservationTTL)
+	assert.Equal(t, 1, releaseExpiredReservations(now))
+	assert.Contains(t, reservations, res.ID)
+
+	releaseExpiredReservations(now.Add(reservationRetention + time.Second))
+	assert.NotContains(t, reservations, res.ID)
+	assert.Equal(t, http.StatusNotFound, confirm(router, res.ID).Code)
+}
+
+// productWithAttributes is a valid product with the given attributes JSON
+func productWithAttributes(sku, attributes string) string {
+	return fmt.Sprintf(`{
+		"sku": %q, "name": "Laptop", "price": 999.99, "currency": "USD",
+		"category": {"id": 1, "name": "Electronics", "slug": "electronics"},
+		"inventory": {"quantity": 10, "reserved": 0, "location": "WH001"},
+		"attributes": %s
+	}`, sku, attributes)
+}
+
+func guardError(t *testing.T, w *httptest.ResponseRecorder) ValidationError {
+	t.Helper()
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Success)
+	if !assert.Len(t, resp.Errors, 1) {
+		return ValidationError{}
+	}
+	return resp.Errors[0]
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	saved := maxBodyBytes
+	defer func() { maxBodyBytes = saved }()
+	maxBodyBytes = 1024
+	router := setupRouter()
+
+	big := productWithAttributes("BIG-001-ABC", `{"notes": "`+strings.Repeat("x", 2048)+`"}`)
+	for _, path := range []string{"/products", "/products/bulk"} {
+		body := big
+		if path == "/products/bulk" {
+			body = "[" + big + "]"
+		}
+		w := postJSON(router, path, body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, path)
+		assert.Equal(t, "max_bytes", guardError(t, w).Tag, path)
+	}
+
+	w := postJSON(router, "/products", productWithAttributes("SML-001-ABC", `{"color": "red"}`))
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestJSONDepthLimit(t *testing.T) {
+	router := setupRouter()
+
+	// The product object is level 1, attributes level 2
+	nested := strings.Repeat(`{"a": `, maxJSONDepth) + `1` + strings.Repeat(`}`, maxJSONDepth)
+	w := postJSON(router, "/products", productWithAttributes("DEP-001-ABC", nested))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	failure := guardError(t, w)
+	assert.Equal(t, "max_depth", failure.Tag)
+	assert.Equal(t, fmt.Sprint(maxJSONDepth), failure.Param)
+
+	// Arrays count as levels too
+	nested = strings.Repeat(`[`, maxJSONDepth) + strings.Repeat(`]`, maxJSONDepth)
+	w = postJSON(router, "/products", productWithAttributes("DEP-002-ABC", `{"a": `+nested+`}`))
+	assert.Equal(t, "max_depth", guardError(t, w).Tag)
+
+	// Right at the limit the product is accepted
+	nested = strings.Repeat(`{"a": `, maxJSONDepth-1) + `1` + strings.Repeat(`}`, maxJSONDepth-1)
+	w = postJSON(router, "/products", productWithAttributes("DEP-003-ABC", nested))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
+
+func TestJSONArrayLengthLimit(t *testing.T) {
+	saved := maxArrayLen
+	defer func() { maxArrayLen = saved }()
+	maxArrayLen = 3
+	router := setupRouter()
+
+	w := postJSON(router, "/products", productWithAttributes("ARR-001-ABC", `{"sizes": [1, 2, 3, 4]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "max_array_len", guardError(t, w).Tag)
+
+	// Nested arrays are counted separately
+	w = postJSON(router, "/products", productWithAttributes("ARR-002-ABC", `{"sizes": [[1, 2, 3], [4, 5, 6]]}`))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	items := strings.Repeat(productJSON("ARR-003-ABC", 1, 0, 0)+",", 3) + productJSON("ARR-004-ABC", 1, 0, 0)
+	w = postJSON(router, "/products/bulk", "["+items+"]")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "max_array_len", guardError(t, w).Tag)
+
+	// Malformed JSON is still reported by the binder
+	w = postJSON(router, "/products", `{"sku": `)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid JSON")
+}
+
+func patchJSON(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// seedUpdatable stores a valid product with 4 of its 10 items reserved
+func seedUpdatable() {
+	SeedProducts(Product{
+		SKU:       "UPD-001-AAA",
+		Name:      "Laptop",
+		Price:     999.99,
+		Currency:  "USD",
+		Category:  Category{ID: 1, Name: "Electronics", Slug: "electronics"},
+		Tags:      []string{"tech"},
+		Inventory: Inventory{Quantity: 10, Reserved: 4, Available: 6, Location: "WH001"},
+	})
+}
+
+func TestUpdateProductQuantityBelowReserved(t *testing.T) {
+	defer SeedProducts()
+	seedUpdatable()
+	router := setupRouter()
+
+	w := patchJSON(router, "/products/1", `{"inventory": {"quantity": 3}, "tags": ["changed"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, "inventory.reserved", resp.Errors[0].Field)
+		assert.Equal(t, "reserved_less_than_quantity", resp.Errors[0].Tag)
+	}
+	// The stored product is left untouched
+	assert.Equal(t, Inventory{Quantity: 10, Reserved: 4, Available: 6}, stock(products[0]))
+	assert.Equal(t, []string{"tech"}, products[0].Tags)
+}
+
+func TestUpdateProductQuantityToReserved(t *testing.T) {
+	defer SeedProducts()
+	seedUpdatable()
+	router := setupRouter()
+
+	w := patchJSON(router, "/products/1", `{"name": "Laptop Pro", "inventory": {"quantity": 4, "reserved": 0, "available": 9}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data Product `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Laptop Pro", resp.Data.Name)
+	assert.Equal(t, Inventory{Quantity: 4, Reserved: 4, Available: 0}, stock(resp.Data))
+	assert.Equal(t, Inventory{Quantity: 4, Reserved: 4, Available: 0}, stock(products[0]))
+	assert.Equal(t, "WH001", products[0].Inventory.Location)
+}
+
+func TestUpdateProductErrors(t *testing.T) {
+	defer SeedProducts()
+	seedUpdatable()
+	router := setupRouter()
+	w := postJSON(router, "/products", productJSON("UPD-002-BBB", 10, 0, 10))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = patchJSON(router, "/products/1", `{"sku": "UPD-002-BBB"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "SKU already exists")
+
+	// Keeping its own SKU is not a duplicate
+	w = patchJSON(router, "/products/1", `{"sku": "UPD-001-AAA"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = patchJSON(router, "/products/1", `{"price": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = patchJSON(router, "/products/1", `{"inventory": `)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = patchJSON(router, "/products/99", `{"name": "Phone"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = patchJSON(router, "/products/abc", `{"name": "Phone"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func batchItem(sku, currency, location string) string {
+	return fmt.Sprintf(`{
+		"sku": %q, "name": "Laptop", "price": 999.99, "currency": %q,
+		"category": {"id": 1, "name": "Electronics", "slug": "electronics"},
+		"inventory": {"quantity": 10, "location": %q}
+	}`, sku, currency, location)
+}
+
+// errorTags returns the tags of the errors of a batch result
+func errorTags(r BulkResult) []string {
+	tags := []string{}
+	for _, e := range r.Errors {
+		tags = append(tags, e.Tag)
+	}
+	return tags
+}
+
+func TestValidateProductsBatch(t *testing.T) {
+	defer SeedProducts()
+	SeedProducts(Product{SKU: "BAT-009-ZZZ", Name: "Stored"})
+	router := setupRouter()
+
+	body := "[" + strings.Join([]string{
+		batchItem("BAT-001-AAA", "usd", "WH001"),
+		batchItem("bad-sku", "XYZ", "WH001"),
+		batchItem("BAT-002-BBB", "ABC", "WH999"),
+		batchItem("BAT-001-AAA", "EUR", "WH002"),
+		batchItem("BAT-009-ZZZ", "GBP", "WH003"),
+		`{"sku": "BAT-003-CCC"}`,
+	}, ",") + "]"
+	w := postJSON(router, "/validate/products", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Results []BulkResult      `json:"results"`
+			Summary ValidationSummary `json:"summary"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Success)
+	if assert.Len(t, resp.Data.Results, 6) {
+		results := resp.Data.Results
+		assert.True(t, results[0].Success)
+		assert.Equal(t, []string{"sku_format", "currency_valid"}, errorTags(results[1]))
+		assert.Equal(t, []string{"currency_valid", "warehouse_code"}, errorTags(results[2]))
+		assert.Equal(t, []string{"sku_unique_in_batch"}, errorTags(results[3]))
+		assert.Equal(t, []string{"sku_unique"}, errorTags(results[4]))
+		assert.Contains(t, errorTags(results[5]), "required")
+		assert.Equal(t, 5, results[5].Index)
+	}
+
+	summary := resp.Data.Summary
+	assert.Equal(t, 6, summary.Total)
+	assert.Equal(t, 1, summary.Valid)
+	assert.Equal(t, 5, summary.Invalid)
+	assert.Equal(t, 1, summary.ByTag["sku_format"])
+	// The incomplete item also fails the custom checks
+	assert.Equal(t, 3, summary.ByTag["currency_valid"])
+	assert.Equal(t, 2, summary.ByTag["warehouse_code"])
+	assert.Equal(t, 1, summary.ByTag["sku_unique_in_batch"])
+	assert.Equal(t, 1, summary.ByTag["sku_unique"])
+	assert.Equal(t, 3, summary.ByField["sku"])
+
+	// Nothing is stored
+	assert.Len(t, products, 1)
+	assert.Equal(t, 2, nextProductID)
+}
+
+func TestValidateProductsBatchAllValid(t *testing.T) {
+	defer SeedProducts()
+	SeedProducts()
+	router := setupRouter()
+
+	body := "[" + batchItem("BAT-001-AAA", "USD", "WH001") + "," + batchItem("BAT-002-BBB", "EUR", "WH002") + "]"
+	w := postJSON(router, "/validate/products", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Success)
+
+	w = postJSON(router, "/validate/products", batchItem("BAT-001-AAA", "USD", "WH001"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHTMLPolicySanitize(t *testing.T) {
+	strip := HTMLPolicy{Mode: HTMLStrip, AllowedTags: []string{"b", "em"}}
+	escape := HTMLPolicy{Mode: HTMLEscape, AllowedTags: []string{"b"}}
+	tests := []struct {
+		name   string
+		policy HTMLPolicy
+		in     string
+		want   string
+	}{
+		{"Plain text unchanged", strip, "Fast & light, 2 < 3", "Fast & light, 2 < 3"},
+		{"Script removed", strip, `Nice<script>alert("x")</script> laptop`, "Nice laptop"},
+		{"Style and comments removed", strip, "<style>p{}</style>A<!-- note -->B", "AB"},
+		{"Allowed tags kept bare", strip, `<B onclick="x()">bold</B> <em>it</em>`, "<b>bold</b> <em>it</em>"},
+		{"Other tags stripped", strip, `<a href="javascript:x()">link</a><img src=x onerror=y>`, "link"},
+		{"Script escaped", escape, "<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"Escape keeps bare allowed tags", escape, `<b>bold</b><i>it</i>`, "<b>bold</b>&lt;i&gt;it&lt;/i&gt;"},
+		{"Escape keeps attributes escaped", escape, `<b class="x">bold</b>`, "&lt;b class=&#34;x&#34;&gt;bold</b>"},
+		{"Unterminated tag stripped", strip, `<img src=x onerror=alert(1)<b>bold</b>`, "bold</b>"},
+		{"Unterminated tag escaped", escape, `<img src=x onerror=alert(1)<b>bold</b>`, "&lt;img src=x onerror=alert(1)<b>bold</b>"},
+		{"Textarea content escaped", strip, "<textarea><img src=x onerror=y></textarea>", "&lt;img src=x onerror=y&gt;"},
+		{"Keep mode", HTMLPolicy{Mode: HTMLKeep}, "<script>x</script>", "<script>x</script>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Sanitize(tt.in))
+		})
+	}
+}
+
+func TestCreateProductSanitizesDescription(t *testing.T) {
+	saved := htmlPolicy
+	defer func() { htmlPolicy = saved }()
+	htmlPolicy = HTMLPolicy{Mode: HTMLStrip, AllowedTags: []string{"b"}}
+	router := setupRouter()
+
+	body := strings.Replace(productJSON("HTM-001-XSS", 10, 0, 10), `"name": "Laptop"`,
+		`"name": "Laptop", "description": " <b>Light</b> laptop<script>steal()</script> "`, 1)
+	w := postJSON(router, "/products", body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var resp struct{ Data Product }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "<b>Light</b> laptop", resp.Data.Description)
+}
+
+func TestSanitizeDescriptionOnce(t *testing.T) {
+	saved := htmlPolicy
+	defer func() { htmlPolicy = saved }()
+	htmlPolicy = HTMLPolicy{Mode: HTMLEscape, AllowedTags: []string{"b"}}
+	defer SeedProducts()
+	router := setupRouter()
+	const sanitized = "<b>x</b> &amp; &lt;i&gt;y&lt;/i&gt;"
+
+	body := strings.Replace(productJSON("HTM-002-ESC", 10, 0, 10), `"name": "Laptop"`,
+		`"name": "Laptop", "description": "<b>x</b> & <i>y</i>"`, 1)
+	w := postJSON(router, "/products", body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct{ Data Product }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, sanitized, created.Data.Description)
+
+	// A PATCH leaving the description out does not escape it again
+	path := fmt.Sprintf("/products/%d", created.Data.ID)
+	for i := 0; i < 3; i++ {
+		w = patchJSON(router, path, `{"price": 2}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, sanitized, findProduct(created.Data.ID).Description)
+	}
+	w = patchJSON(router, path, `{"description": "<i>a</i> & b"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "&lt;i&gt;a&lt;/i&gt; &amp; b", findProduct(created.Data.ID).Description)
+
+	// The CSV import sanitizes each row once
+	w = importCSV(router, `sku,name,description,price,currency,category_name,category_slug,tags,quantity,reserved,location
+CSV-001-ESC,Novel,<i>a</i> & b,12.50,GBP,Books,books,,7,0,WH003
+`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var imported struct {
+		Data struct {
+			Results []BulkResult `json:"results"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &imported))
+	if assert.Len(t, imported.Data.Results, 1) && assert.NotNil(t, imported.Data.Results[0].Product) {
+		assert.Equal(t, "&lt;i&gt;a&lt;/i&gt; &amp; b", imported.Data.Results[0].Product.Description)
+	}
+}
+
+func TestErrorContentNegotiation(t *testing.T) {
+	router := setupRouter()
+	requestID := "123e4567-e89b-12d3-a456-426614174000"
+
+	post := func(accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/products", strings.NewReader(`{"name": "Laptop"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", requestID)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		return w
+	}
+
+	for _, accept := range []string{"", "application/json", "*/*", "image/png"} {
+		w := post(accept)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json", accept)
+		var resp APIResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), accept)
+		assert.False(t, resp.Success)
+		assert.NotEmpty(t, resp.Errors)
+		assert.Equal(t, requestID, resp.RequestID)
+	}
+
+	w := post("text/plain")
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	fields := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		assert.True(t, ok, line)
+		fields[key] = value
+	}
+	assert.NotEmpty(t, fields["error"])
+	assert.NotEmpty(t, fields["field SKU"])
+	assert.Equal(t, requestID, fields["request_id"])
+
+	for _, accept := range []string{"application/xml", "text/xml"} {
+		w = post(accept)
+		assert.Contains(t, w.Header().Get("Content-Type"), "xml", accept)
+		var resp xmlError
+		assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &resp), accept)
+		assert.Equal(t, http.StatusBadRequest, resp.Status)
+		assert.NotEmpty(t, resp.Message)
+		assert.NotEmpty(t, resp.Fields)
+		assert.Equal(t, requestID, resp.RequestID)
+	}
+
+	// Successful responses stay JSON whatever the Accept header
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/validation/rules", nil)
+	req.Header.Set("Accept", "text/plain")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+}
@@ -437,7 +437,7 @@ func logout(c *gin.Context) {
 		return
 	}
 	if req.RefreshToken != "" {
-		delete(blacklistedTokens, req.RefreshToken)
+		delete(refreshTokens, req.RefreshToken)
 	}
 	c.JSON(200, APIResponse{
 		Success: true,
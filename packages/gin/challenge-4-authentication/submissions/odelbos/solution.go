@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
+	mathrand "math/rand"
 	"net/http"
+	"os"
+	"reflect"
 	"regexp"
 	"slices"
 	"strconv"
@@ -13,27 +25,34 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User represents a user in the system
 type User struct {
-	ID             int        `json:"id"`
-	Username       string     `json:"username" binding:"required,min=3,max=30"`
-	Email          string     `json:"email" binding:"required,email"`
-	Password       string     `json:"-"` // Never return in JSON
-	PasswordHash   string     `json:"-"`
-	FirstName      string     `json:"first_name" binding:"required,min=2,max=50"`
-	LastName       string     `json:"last_name" binding:"required,min=2,max=50"`
-	Role           string     `json:"role"`
-	IsActive       bool       `json:"is_active"`
-	EmailVerified  bool       `json:"email_verified"`
-	LastLogin      *time.Time `json:"last_login"`
-	FailedAttempts int        `json:"-"`
-	LockedUntil    *time.Time `json:"-"`
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
+	ID            int         `json:"id"`
+	Username      string      `json:"username" binding:"required,min=3,max=30"`
+	Email         string      `json:"email" binding:"required,email"`
+	Password      string      `json:"-"` // Never return in JSON
+	PasswordHash  string      `json:"-"`
+	FirstName     string      `json:"first_name" binding:"required,min=2,max=50"`
+	LastName      string      `json:"last_name" binding:"required,min=2,max=50"`
+	Role          string      `json:"role"`
+	Permissions   []string    `json:"permissions,omitempty"` // granted beyond the role
+	SuperAdmin    bool        `json:"super_admin"` // may impersonate admins
+	IsActive      bool        `json:"is_active"`
+	EmailVerified bool        `json:"email_verified"`
+	LastLogin     *time.Time  `json:"last_login"`
+	FailedAt      []time.Time `json:"-"` // failed logins within the failure window
+	LockoutCount  int         `json:"-"`
+	LockedUntil   *time.Time  `json:"-"`
+	TokenVersion  int         `json:"-"` // bumped to revoke every access token
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
 }
 
 // LoginRequest represents login credentials
@@ -55,7 +74,7 @@ type RegisterRequest struct {
 // TokenResponse represents JWT token response
 type TokenResponse struct {
 	AccessToken  string    `json:"access_token"`
-	RefreshToken string    `json:"refresh_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
 	TokenType    string    `json:"token_type"`
 	ExpiresIn    int64     `json:"expires_in"`
 	ExpiresAt    time.Time `json:"expires_at"`
@@ -65,36 +84,355 @@ type TokenResponse struct {
 type JWTClaims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
-	Role     string `json:"role"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"perms,omitempty"` // of the role and granted
+	Act         *Actor   `json:"act,omitempty"`   // set on impersonation tokens
+	Version     int      `json:"ver"`             // TokenVersion of the user at issuance
 	jwt.RegisteredClaims
 }
 
+// Actor is the admin acting on behalf of the token subject
+type Actor struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
 // APIResponse represents standard API response
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Message string      `json:"message,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success   bool         `json:"success"`
+	Data      interface{}  `json:"data,omitempty"`
+	Message   string       `json:"message,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// xmlError is the XML envelope of an error APIResponse
+type xmlError struct {
+	XMLName   xml.Name        `xml:"error"`
+	Status    int             `xml:"status,attr"`
+	Message   string          `xml:"message"`
+	Detail    string          `xml:"detail,omitempty"`
+	Fields    []xmlFieldError `xml:"field"`
+	RequestID string          `xml:"request_id,omitempty"`
+}
+
+// xmlFieldError is a FieldError within an xmlError
+type xmlFieldError struct {
+	Name    string `xml:"name,attr"`
+	Rule    string `xml:"rule,attr"`
+	Message string `xml:",chardata"`
+}
+
+// FieldError describes a request field that failed a validation rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// HealthResponse represents a liveness or readiness response
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// keptToken lets one access token survive the version bump that revoked
+// its siblings, until the token expires or the version moves on again
+type keptToken struct {
+	Version   int
+	ExpiresAt time.Time
 }
 
 // Global data stores (in a real app, these would be databases)
 var users = []User{}
 var usersMutex sync.RWMutex
 var blacklistedTokens = make(map[string]bool) // Token blacklist for logout
+var keptTokens = make(map[string]keptToken)  // Access token ID -> exemption from a version bump
 var blacklistMutex sync.RWMutex
-var refreshTokens = make(map[string]int)      // RefreshToken -> UserID mapping
+var refreshTokens = make(map[string]int)         // RefreshToken -> UserID mapping
+var refreshExpiries = make(map[string]time.Time) // RefreshToken -> expiry, see refreshExpiry
+var userSessions = make(map[int][]string)        // UserID -> RefreshTokens, oldest first
 var refreshMutex sync.RWMutex
 var nextUserID = 1
 
+// readinessChecks are the dependency checks reported by GET /readyz
+var readinessChecks = map[string]func() error{
+	"users":  checkUserStore,
+	"tokens": checkTokenStore,
+}
+
 // Configuration
 var (
-	jwtSecret         = []byte("your-super-secret-jwt-key")
-	accessTokenTTL    = 15 * time.Minute   // 15 minutes
-	refreshTokenTTL   = 7 * 24 * time.Hour // 7 days
-	maxFailedAttempts = 5
-	lockoutDuration   = 30 * time.Minute
+	jwtSecret       = []byte("your-super-secret-jwt-key")
+	accessTokenTTL  = 15 * time.Minute   // 15 minutes
+	refreshTokenTTL = 7 * 24 * time.Hour // 7 days
+	impersonateTTL  = 5 * time.Minute    // impersonation tokens are never refreshed
+	lockoutPolicy   = defaultLockoutPolicy()
+	authClock       = Clock(realClock{}) // clock of the lockout policy and refresh expiries
+
+	// refreshTokenJitter spreads the refresh token expiries over
+	// refreshTokenTTL ± the fraction, so a burst of logins does not expire at once
+	refreshTokenJitter = 0.0
+
+	// maxSessions caps the refresh tokens a user holds at once, 0 for no
+	// cap. A login beyond it is handled per sessionLimitMode.
+	maxSessions      = 0
+	sessionLimitMode = SessionEvictOldest
+
+	// logRequestBodies logs every request with its body, the values of the
+	// redactedFields and the bearer tokens being redacted
+	logRequestBodies = false
+	redactedFields   = []string{"password", "confirm_password", "current_password", "new_password", "access_token", "refresh_token"}
+)
+
+// AuthConfig is the configuration main loads from the environment, the JWT
+// secret has no default so that a deployment cannot run with a known one
+type AuthConfig struct {
+	Addr              string        `env:"ADDR" default:":8080"`
+	JWTSecret         string        `env:"JWT_SECRET" required:"true"`
+	AccessTokenTTL    time.Duration `env:"ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL   time.Duration `env:"REFRESH_TOKEN_TTL" default:"168h"`
+	RefreshJitter     float64       `env:"REFRESH_TOKEN_JITTER" default:"0"` // fraction of the TTL, in [0, 1)
+	ImpersonateTTL    time.Duration `env:"IMPERSONATE_TTL" default:"5m"`
+	LockoutPolicyFile string        `env:"LOCKOUT_POLICY_FILE"` // JSON, see LoadLockoutPolicy
+	LogRequestBodies  bool          `env:"LOG_REQUEST_BODIES" default:"false"`
+	PasswordHash      string        `env:"PASSWORD_HASH" default:"bcrypt"` // bcrypt or argon2id
+	BcryptCost        int           `env:"BCRYPT_COST" default:"12"`
+	MaxSessions       int           `env:"MAX_SESSIONS" default:"0"` // 0 for no cap
+	SessionLimitMode  string        `env:"SESSION_LIMIT_MODE" default:"evict_oldest"`
+}
+
+// What a login beyond maxSessions does
+const (
+	SessionEvictOldest = "evict_oldest" // the oldest session is logged out
+	SessionRejectNew   = "reject"       // the login is refused
 )
 
+// Validate checks the token lifetimes, the jitter, the session limit and the
+// password hasher
+func (c AuthConfig) Validate() error {
+	if c.AccessTokenTTL <= 0 || c.RefreshTokenTTL <= 0 || c.ImpersonateTTL <= 0 {
+		return errors.New("token lifetimes must be positive")
+	}
+	if c.RefreshJitter < 0 || c.RefreshJitter >= 1 {
+		return errors.New("refresh token jitter must be in [0, 1)")
+	}
+	if c.MaxSessions < 0 {
+		return errors.New("max sessions must not be negative")
+	}
+	if c.SessionLimitMode != SessionEvictOldest && c.SessionLimitMode != SessionRejectNew {
+		return fmt.Errorf("unknown session limit mode %q", c.SessionLimitMode)
+	}
+	_, err := newPasswordHasher(c.PasswordHash, c.BcryptCost)
+	return err
+}
+
+// Clock abstracts time so that lockouts and refresh tokens can expire in
+// tests without waiting
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a one-shot timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it returns false if it already fired
+	Stop() bool
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a Clock that only moves when advanced, a lockout ends as soon
+// as Advance goes past LockedUntil
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	at     time.Time
+	c      chan time.Time
+	active bool
+}
+
+// NewFakeClock returns a FakeClock reading start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1), active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock and fires the timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range(c.timers) {
+		if ! t.active {
+			continue
+		}
+		if c.now.Before(t.at) {
+			pending = append(pending, t)
+			continue
+		}
+		t.active = false
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+// LockoutPolicy controls how accounts are locked after repeated failed logins.
+// Only the failures of the last FailureWindow count toward MaxFailedAttempts,
+// a zero window counts every failure since the last successful login.
+// Consecutive lockouts escalate: LockoutDuration * BackoffMultiplier^n, capped
+// at MaxLockoutDuration.
+type LockoutPolicy struct {
+	MaxFailedAttempts  int
+	FailureWindow      time.Duration
+	LockoutDuration    time.Duration
+	BackoffMultiplier  float64
+	MaxLockoutDuration time.Duration
+}
+
+// lockoutPolicyConfig is the JSON form of a LockoutPolicy, durations use the
+// time.ParseDuration format (e.g. "30m", "2h")
+type lockoutPolicyConfig struct {
+	MaxFailedAttempts  int     `json:"max_failed_attempts"`
+	FailureWindow      string  `json:"failure_window"`
+	LockoutDuration    string  `json:"lockout_duration"`
+	BackoffMultiplier  float64 `json:"backoff_multiplier"`
+	MaxLockoutDuration string  `json:"max_lockout_duration"`
+}
+
+func defaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		MaxFailedAttempts:  5,
+		FailureWindow:      15 * time.Minute,
+		LockoutDuration:    30 * time.Minute,
+		BackoffMultiplier:  2,
+		MaxLockoutDuration: 24 * time.Hour,
+	}
+}
+
+// LoadLockoutPolicy reads a lockout policy from a JSON file, missing fields
+// keep their default value
+func LoadLockoutPolicy(path string) (LockoutPolicy, error) {
+	policy := defaultLockoutPolicy()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+
+	var cfg lockoutPolicyConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return policy, fmt.Errorf("invalid lockout policy: %w", err)
+	}
+	if cfg.MaxFailedAttempts != 0 {
+		policy.MaxFailedAttempts = cfg.MaxFailedAttempts
+	}
+	if cfg.BackoffMultiplier != 0 {
+		policy.BackoffMultiplier = cfg.BackoffMultiplier
+	}
+	if cfg.FailureWindow != "" {
+		if policy.FailureWindow, err = time.ParseDuration(cfg.FailureWindow); err != nil {
+			return policy, fmt.Errorf("invalid failure_window: %w", err)
+		}
+	}
+	if cfg.LockoutDuration != "" {
+		if policy.LockoutDuration, err = time.ParseDuration(cfg.LockoutDuration); err != nil {
+			return policy, fmt.Errorf("invalid lockout_duration: %w", err)
+		}
+	}
+	if cfg.MaxLockoutDuration != "" {
+		if policy.MaxLockoutDuration, err = time.ParseDuration(cfg.MaxLockoutDuration); err != nil {
+			return policy, fmt.Errorf("invalid max_lockout_duration: %w", err)
+		}
+	}
+	return policy, policy.Validate()
+}
+
+// Validate checks that the policy values are consistent
+func (p LockoutPolicy) Validate() error {
+	switch {
+	case p.MaxFailedAttempts < 1:
+		return fmt.Errorf("max_failed_attempts must be at least 1")
+	case p.FailureWindow < 0:
+		return fmt.Errorf("failure_window must not be negative")
+	case p.LockoutDuration <= 0:
+		return fmt.Errorf("lockout_duration must be positive")
+	case p.BackoffMultiplier < 1:
+		return fmt.Errorf("backoff_multiplier must be at least 1")
+	case p.MaxLockoutDuration < p.LockoutDuration:
+		return fmt.Errorf("max_lockout_duration must be greater than lockout_duration")
+	}
+	return nil
+}
+
+// LockoutFor returns how long the account is locked given the number of
+// lockouts that already happened
+func (p LockoutPolicy) LockoutFor(lockoutCount int) time.Duration {
+	d := float64(p.LockoutDuration) * math.Pow(p.BackoffMultiplier, float64(lockoutCount))
+	if d > float64(p.MaxLockoutDuration) {
+		return p.MaxLockoutDuration
+	}
+	return time.Duration(d)
+}
+
 // User roles
 const (
 	RoleUser      = "user"
@@ -102,6 +440,35 @@ const (
 	RoleModerator = "moderator"
 )
 
+// Permissions, held through the role or granted to a user
+const (
+	PermUsersRead        = "users:read"
+	PermUsersWrite       = "users:write"
+	PermUsersImpersonate = "users:impersonate"
+)
+
+// rolePermissions are the default permissions of each role, a permission
+// outside of them is only held when granted
+var rolePermissions = map[string][]string{
+	RoleAdmin:     {PermUsersRead, PermUsersWrite, PermUsersImpersonate},
+	RoleModerator: {},
+	RoleUser:      {},
+}
+
+// effectivePermissions returns the sorted permissions of the role and the
+// granted ones
+func effectivePermissions(role string, granted []string) []string {
+	perms := append(slices.Clone(rolePermissions[role]), granted...)
+	slices.Sort(perms)
+	return slices.Compact(perms)
+}
+
+// isValidPermission reports whether perm has the "resource:action" form
+func isValidPermission(perm string) bool {
+	resource, action, ok := strings.Cut(perm, ":")
+	return ok && resource != "" && action != "" && ! strings.ContainsAny(perm, " \t")
+}
+
 // ---------------------------------------------------------------
 // Password security
 // ---------------------------------------------------------------
@@ -119,33 +486,161 @@ func isStrongPassword(password string) bool {
 	return len(password) > 7 && upper && lower && number && special
 }
 
+// PasswordHasher hashes the passwords. Verify also reports whether the hash
+// should be replaced, because it was made with other parameters or by another
+// algorithm, so that login can upgrade it.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, hash string) (ok bool, needsRehash bool)
+}
+
+// passwordHasher hashes the new passwords, set from AuthConfig by main
+var passwordHasher PasswordHasher = BcryptHasher{Cost: 12}
+
 func hashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	return string(hash), err
+	return passwordHasher.Hash(password)
 }
 
 func verifyPassword(password, hash string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	ok, _ := passwordHasher.Verify(password, hash)
+	return ok
+}
+
+// newPasswordHasher returns the hasher of an algorithm, "bcrypt" or
+// "argon2id", bcryptCost being only used by bcrypt
+func newPasswordHasher(algorithm string, bcryptCost int) (PasswordHasher, error) {
+	switch algorithm {
+	case "bcrypt":
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return BcryptHasher{Cost: bcryptCost}, nil
+	case "argon2id":
+		return DefaultArgon2idHasher(), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+}
+
+// verifyOtherHash checks a hash made by another algorithm than the configured
+// one, it always needs a rehash
+func verifyOtherHash(password, hash string) (bool, bool) {
+	var ok bool
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		ok, _ = Argon2idHasher{}.Verify(password, hash)
+	case strings.HasPrefix(hash, "$2"):
+		ok, _ = BcryptHasher{}.Verify(password, hash)
+	}
+	return ok, true
+}
+
+// BcryptHasher hashes with bcrypt, the hashes of another cost need a rehash
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h BcryptHasher) Verify(password, hash string) (bool, bool) {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return verifyOtherHash(password, hash)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	return true, cost != h.Cost
+}
+
+// argon2idPrefix starts the argon2id hashes, encoded in the PHC string format
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<key>
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idHasher hashes with argon2id, the hashes of other parameters need a
+// rehash
+type Argon2idHasher struct {
+	Time    uint32 // passes over the memory
+	Memory  uint32 // KiB
+	Threads uint8
+	SaltLen int
+	KeyLen  uint32
+}
+
+// DefaultArgon2idHasher uses the parameters recommended by RFC 9106 for
+// memory-constrained environments
+func DefaultArgon2idHasher() Argon2idHasher {
+	return Argon2idHasher{Time: 3, Memory: 64 * 1024, Threads: 4, SaltLen: 16, KeyLen: 32}
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(password, hash string) (bool, bool) {
+	if ! strings.HasPrefix(hash, argon2idPrefix) {
+		return verifyOtherHash(password, hash)
+	}
+	var version int
+	var used Argon2idHasher
+	parts := strings.Split(strings.TrimPrefix(hash, argon2idPrefix), "$")
+	if len(parts) != 4 {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &used.Memory, &used.Time, &used.Threads); err != nil || used.Time < 1 || used.Threads < 1 {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return false, false
+	}
+	used.SaltLen, used.KeyLen = len(salt), uint32(len(key))
+
+	computed := argon2.IDKey([]byte(password), salt, used.Time, used.Memory, used.Threads, used.KeyLen)
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false
+	}
+	return true, used != h
 }
 
 // ---------------------------------------------------------------
 // JWT functions
 // ---------------------------------------------------------------
 
-func generateTokens(userID int, username, role string) (*TokenResponse, error) {
-	now := time.Now()
-	claims := JWTClaims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
-		},
-	}
+// generateTokens issues tokens at the current token version of the user,
+// holding the permissions of the role and the granted ones. usersMutex must
+// not be held.
+func generateTokens(userID int, username, role string, granted ...string) (*TokenResponse, error) {
+	version, _ := tokenVersion(userID)
+	return issueTokens(userID, username, role, granted, version)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessToken, err := token.SignedString(jwtSecret)
+// issueTokens issues an access and a refresh token at the given version
+func issueTokens(userID int, username, role string, granted []string, version int) (*TokenResponse, error) {
+	now := time.Now()
+	accessToken, err := signAccessToken(JWTClaims{
+		UserID:      userID,
+		Username:    username,
+		Role:        role,
+		Permissions: effectivePermissions(role, granted),
+		Version:     version,
+	}, now, accessTokenTTL)
 	if err != nil {
 		return nil, err
 	}
@@ -157,6 +652,8 @@ func generateTokens(userID int, username, role string) (*TokenResponse, error) {
 
 	refreshMutex.Lock()
 	refreshTokens[refreshToken] = userID
+	refreshExpiries[refreshToken] = refreshExpiry(authClock.Now())
+	userSessions[userID] = append(userSessions[userID], refreshToken)
 	refreshMutex.Unlock()
 
 	return &TokenResponse{
@@ -168,6 +665,123 @@ func generateTokens(userID int, username, role string) (*TokenResponse, error) {
 	}, nil
 }
 
+// refreshExpiry returns when a refresh token issued at now expires, the TTL
+// moved by a random amount of up to refreshTokenJitter of itself either way
+func refreshExpiry(now time.Time) time.Time {
+	ttl := refreshTokenTTL
+	if refreshTokenJitter > 0 {
+		offset := (2*mathrand.Float64() - 1) * refreshTokenJitter
+		ttl += time.Duration(float64(ttl) * offset)
+	}
+	return now.Add(ttl)
+}
+
+// deleteRefreshToken forgets a refresh token, refreshMutex must be held
+func deleteRefreshToken(token string) {
+	if userID, ok := refreshTokens[token]; ok {
+		sessions := slices.DeleteFunc(userSessions[userID], func(t string) bool { return t == token })
+		if len(sessions) == 0 {
+			delete(userSessions, userID)
+		} else {
+			userSessions[userID] = sessions
+		}
+	}
+	delete(refreshTokens, token)
+	delete(refreshExpiries, token)
+}
+
+// activeSessions returns the unexpired refresh tokens of the user, oldest
+// first. The expired ones are forgotten on the way. refreshMutex must be held.
+func activeSessions(userID int) []string {
+	now := authClock.Now()
+	var active []string
+	for _, token := range(slices.Clone(userSessions[userID])) {
+		expiry, ok := refreshExpiries[token]
+		if refreshTokens[token] != userID || ! ok {
+			continue
+		}
+		if ! now.Before(expiry) {
+			deleteRefreshToken(token)
+			continue
+		}
+		active = append(active, token)
+	}
+	if len(active) == 0 {
+		delete(userSessions, userID)
+	} else {
+		userSessions[userID] = active
+	}
+	return active
+}
+
+// ErrTooManySessions is returned by makeRoomForSession when the user holds
+// maxSessions sessions and the mode is SessionRejectNew
+var ErrTooManySessions = errors.New("maximum active sessions reached")
+
+// makeRoomForSession enforces maxSessions before a login issues a new
+// refresh token: the oldest sessions are logged out, or ErrTooManySessions
+// is returned, per sessionLimitMode. It is called with usersMutex held so
+// that two logins of the same user cannot both take the last slot.
+func makeRoomForSession(userID int) error {
+	if maxSessions <= 0 {
+		return nil
+	}
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+	active := activeSessions(userID)
+	excess := len(active) - maxSessions + 1
+	if excess <= 0 {
+		return nil
+	}
+	if sessionLimitMode == SessionRejectNew {
+		return ErrTooManySessions
+	}
+	for _, token := range(active[:excess]) {
+		deleteRefreshToken(token)
+	}
+	return nil
+}
+
+// generateImpersonationToken issues a short-lived access token for user on
+// behalf of act. No refresh token is issued, the session ends on expiry.
+func generateImpersonationToken(user, act *User) (*TokenResponse, error) {
+	now := time.Now()
+	accessToken, err := signAccessToken(JWTClaims{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Role:        user.Role,
+		Permissions: effectivePermissions(user.Role, user.Permissions),
+		Act:         &Actor{UserID: act.ID, Username: act.Username},
+		Version:     user.TokenVersion,
+	}, now, impersonateTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(impersonateTTL.Seconds()),
+		ExpiresAt:   now.Add(impersonateTTL),
+	}, nil
+}
+
+// signAccessToken signs claims issued at now and valid for ttl
+func signAccessToken(claims JWTClaims, now time.Time, ttl time.Duration) (string, error) {
+	// A unique token ID keeps tokens of concurrent sessions distinct, so
+	// blacklisting one does not log out the others
+	tokenID, err := generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
 func validateToken(tokenString string) (*JWTClaims, error) {
 	// Parse and validate JWT token
 	// Check if token is blacklisted
@@ -189,21 +803,91 @@ func validateToken(tokenString string) (*JWTClaims, error) {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*JWTClaims)
+	if ! ok || ! token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	// Tokens issued before the last version bump are revoked
+	if version, ok := tokenVersion(claims.UserID); ok && version != claims.Version && ! isKeptToken(claims.ID, version) {
+		return nil, fmt.Errorf("token revoked")
+	}
+	return claims, nil
+}
+
+// isKeptToken reports whether the access token was kept at the given version
+func isKeptToken(tokenID string, version int) bool {
+	blacklistMutex.RLock()
+	defer blacklistMutex.RUnlock()
+	kept, ok := keptTokens[tokenID]
+	return ok && kept.Version == version
+}
+
+// keepToken exempts the access token from the version bump that just
+// revoked the other sessions of its user. Expired exemptions are dropped.
+func keepToken(claims *JWTClaims) {
+	version, ok := tokenVersion(claims.UserID)
+	if ! ok || claims.ID == "" || claims.ExpiresAt == nil {
+		return
+	}
+	now := authClock.Now()
+	blacklistMutex.Lock()
+	defer blacklistMutex.Unlock()
+	for id, kept := range keptTokens {
+		if ! now.Before(kept.ExpiresAt) {
+			delete(keptTokens, id)
+		}
+	}
+	keptTokens[claims.ID] = keptToken{Version: version, ExpiresAt: claims.ExpiresAt.Time}
+}
+
+// tokenVersion returns the current TokenVersion of a user, false if unknown
+func tokenVersion(userID int) (int, bool) {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	for i := range(users) {
+		if users[i].ID == userID {
+			return users[i].TokenVersion, true
+		}
+	}
+	return 0, false
+}
+
+// revokeSessions invalidates every access and refresh token of the user
+func revokeSessions(userID int) int {
+	usersMutex.Lock()
+	for i := range(users) {
+		if users[i].ID == userID {
+			users[i].TokenVersion++
+			break
+		}
+	}
+	usersMutex.Unlock()
+	return revokeRefreshTokens(userID)
+}
+
+// revokePrivilegedSessions revokes the sessions of a user whose role or
+// permissions changed, the session making the change is kept as for a
+// password change
+func revokePrivilegedSessions(c *gin.Context, userID int) {
+	revokeSessions(userID)
+	if userID == c.GetInt("user_id") {
+		keepToken(c.MustGet("claims").(*JWTClaims))
 	}
-	return nil, fmt.Errorf("invalid token")
 }
 
 // ---------------------------------------------------------------
 // User functions
 // ---------------------------------------------------------------
 
+// The finders return a copy of the user, changes are written back with
+// updateUser
+
 func findUserByUsername(username string) *User {
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
-	for _, user := range(users) {
-		if user.Username == username {
+	for i := range(users) {
+		if users[i].Username == username {
+			user := users[i]
 			return &user
 		}
 	}
@@ -213,8 +897,9 @@ func findUserByUsername(username string) *User {
 func findUserByEmail(email string) *User {
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
-	for _, user := range(users) {
-		if user.Email == email {
+	for i := range(users) {
+		if users[i].Email == email {
+			user := users[i]
 			return &user
 		}
 	}
@@ -224,36 +909,88 @@ func findUserByEmail(email string) *User {
 func findUserByID(id int) *User {
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
-	for _, user := range(users) {
-		if user.ID == id {
+	for i := range(users) {
+		if users[i].ID == id {
+			user := users[i]
 			return &user
 		}
 	}
 	return nil
 }
 
+// userIndex returns the index of the user in users, -1 if unknown.
+// usersMutex must be held.
+func userIndex(id int) int {
+	return slices.IndexFunc(users, func(u User) bool { return u.ID == id })
+}
+
+// updateUser applies update to the stored user under usersMutex and returns
+// a copy of the result, false if the user no longer exists
+func updateUser(id int, update func(*User)) (User, bool) {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	i := userIndex(id)
+	if i < 0 {
+		return User{}, false
+	}
+	update(&users[i])
+	return users[i], true
+}
+
 func isAccountLocked(user *User) bool {
 	// Check if account is locked based on LockedUntil field
-	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+	return user.LockedUntil != nil && authClock.Now().Before(*user.LockedUntil)
 }
 
+// recordFailedAttempt locks the account once MaxFailedAttempts failures
+// happened within the failure window, older failures are forgotten. It is
+// meant for updateUser.
 func recordFailedAttempt(user *User) {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-	user.FailedAttempts++
-	if user.FailedAttempts >= maxFailedAttempts {
-		lockTime := time.Now().Add(lockoutDuration)
+	now := authClock.Now()
+	user.FailedAt = append(recentFailures(user.FailedAt, now), now)
+	if len(user.FailedAt) >= lockoutPolicy.MaxFailedAttempts {
+		lockTime := now.Add(lockoutPolicy.LockoutFor(user.LockoutCount))
 		user.LockedUntil = &lockTime
-		user.UpdatedAt = time.Now()
+		user.LockoutCount++
+		user.FailedAt = nil
+		user.UpdatedAt = now
+	}
+}
+
+// recentFailures drops the failures older than the failure window, the
+// timestamps are in chronological order
+func recentFailures(failures []time.Time, now time.Time) []time.Time {
+	if lockoutPolicy.FailureWindow <= 0 {
+		return failures
+	}
+	cutoff := now.Add(-lockoutPolicy.FailureWindow)
+	for len(failures) > 0 && ! failures[0].After(cutoff) {
+		failures = failures[1:]
 	}
+	return failures
 }
 
+// resetFailedAttempts unlocks the account, it is meant for updateUser
 func resetFailedAttempts(user *User) {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-	user.FailedAttempts = 0
+	user.FailedAt = nil
+	user.LockoutCount = 0
 	user.LockedUntil = nil
-	user.UpdatedAt = time.Now()
+	user.UpdatedAt = authClock.Now()
+}
+
+// revokeRefreshTokens deletes every refresh token owned by the user and
+// returns how many were deleted
+func revokeRefreshTokens(userID int) int {
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+	count := 0
+	for token, id := range refreshTokens {
+		if id == userID {
+			deleteRefreshToken(token)
+			count++
+		}
+	}
+	return count
 }
 
 func generateRandomToken() (string, error) {
@@ -265,6 +1002,73 @@ func generateRandomToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// ---------------------------------------------------------------
+// Pagination
+// ---------------------------------------------------------------
+
+// ListOptions configures the page and size query parameters of a listing
+type ListOptions struct {
+	DefaultSize int // size used when the query has none
+	MaxSize     int // larger sizes are clamped to it
+}
+
+// usersListOptions configures GET /admin/users
+var usersListOptions = ListOptions{DefaultSize: 20, MaxSize: 100}
+
+// Parse reads the optional page (default 1) and size query parameters.
+// Non-numeric, zero or negative values are rejected, a size above MaxSize
+// is clamped.
+func (o ListOptions) Parse(c *gin.Context) (page, size int, err error) {
+	page, size = 1, o.DefaultSize
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page: %q, must be a positive integer", v)
+		}
+	}
+	if v := c.Query("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 {
+			return 0, 0, fmt.Errorf("invalid size: %q, must be a positive integer", v)
+		}
+	}
+	return page, min(size, o.MaxSize), nil
+}
+
+// Page is a page of a list response
+type Page[T any] struct {
+	Items      []T  `json:"items"`
+	Page       int  `json:"page"`
+	Size       int  `json:"size"`
+	Total      int  `json:"total"`
+	TotalPages int  `json:"total_pages"`
+	HasNext    bool `json:"has_next"`
+}
+
+// Paginate returns the given 1-based page of items, a page beyond the end
+// has no items. Page and size below 1 are raised to 1, use ListOptions to
+// parse and bound them. Items share the backing array of the input slice.
+func Paginate[T any](items []T, page, size int) Page[T] {
+	page, size = max(page, 1), max(size, 1)
+
+	total := len(items)
+	start := total
+	if page-1 <= total/size { // avoids overflowing on huge pages
+		start = min((page-1)*size, total)
+	}
+	end := min(start+size, total)
+	pageItems := items[start:end]
+	if pageItems == nil {
+		pageItems = []T{}
+	}
+	return Page[T]{
+		Items:      pageItems,
+		Page:       page,
+		Size:       size,
+		Total:      total,
+		TotalPages: (total + size - 1) / size,
+		HasNext:    end < total,
+	}
+}
+
 // ---------------------------------------------------------------
 // Route handlers
 // ---------------------------------------------------------------
@@ -272,7 +1076,7 @@ func generateRandomToken() (string, error) {
 func register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		errResponse(c, http.StatusBadRequest, "Invalid request")
+		bindErrResponse(c, err)
 		return
 	}
 	if req.Password != req.ConfirmPassword {
@@ -324,7 +1128,7 @@ func register(c *gin.Context) {
 func login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		errResponse(c, http.StatusBadRequest, "Invalid request")
+		bindErrResponse(c, err)
 		return
 	}
 
@@ -336,24 +1140,53 @@ func login(c *gin.Context) {
 
 	if isAccountLocked(user) {
 		errResponse(c, http.StatusLocked, "Account is locked")
+		return
 	}
 
-	if ! verifyPassword(req.Password, user.PasswordHash) {
-		recordFailedAttempt(user)
+	verified := user.PasswordHash
+	ok, needsRehash := passwordHasher.Verify(req.Password, verified)
+	if ! ok {
+		updateUser(user.ID, recordFailedAttempt)
 		errResponse(c, http.StatusUnauthorized, "Invalid credentials")
+		return
 	}
 
-	resetFailedAttempts(user)
+	updateUser(user.ID, resetFailedAttempts)
+
+	// The hash is upgraded while the password is at hand. Hashing is slow so
+	// it is done before locking, the hash is only replaced if the password
+	// did not change meanwhile.
+	var rehashed string
+	if needsRehash {
+		var err error
+		if rehashed, err = passwordHasher.Hash(req.Password); err != nil {
+			log.Printf("Failed to rehash the password of user %d: %v", user.ID, err)
+		}
+	}
 
 	usersMutex.Lock()
 	defer usersMutex.Unlock()
 
+	i := userIndex(user.ID)
+	if i < 0 {
+		errResponse(c, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+	user = &users[i]
+	if rehashed != "" && user.PasswordHash == verified {
+		user.PasswordHash = rehashed
+	}
+	if err := makeRoomForSession(user.ID); err != nil {
+		errResponse(c, http.StatusConflict, "Maximum active sessions reached")
+		return
+	}
 	now := time.Now()
 	user.LastLogin = &now
 
-	tokens, err := generateTokens(user.ID, user.Username, user.Role)
+	tokens, err := issueTokens(user.ID, user.Username, user.Role, user.Permissions, user.TokenVersion)
 	if err != nil {
 		errResponse(c, http.StatusInternalServerError, "Internal server error")
+		return
 	}
 	okResponse(c, http.StatusOK, "Login successful", tokens)
 }
@@ -371,47 +1204,81 @@ func logout(c *gin.Context) {
 	}
 
 	tokenStr := strings.TrimPrefix(bearer, "Bearer ")
-	_, err := validateToken(tokenStr)
+	claims, err := validateToken(tokenStr)
 	if err != nil {
 		errResponse(c, http.StatusUnauthorized, "Invalid token")
 		c.Abort()
 		return
 	}
 
+	// The refresh token of the current session is optional, only the
+	// session it belongs to is logged out
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
 	blacklistMutex.Lock()
 	blacklistedTokens[tokenStr] = true
 	blacklistMutex.Unlock()
 
-	// NOTE: Should remove all refresh tokens owned by the user
+	if req.RefreshToken != "" {
+		refreshMutex.Lock()
+		if refreshTokens[req.RefreshToken] == claims.UserID {
+			deleteRefreshToken(req.RefreshToken)
+		}
+		refreshMutex.Unlock()
+	}
 
 	okResponse(c, http.StatusOK, "Logout successful", nil)
 }
 
+// POST /auth/logout-all - Log out of every device
+// Every refresh token of the user is revoked and bumping the token version
+// rejects outstanding access tokens, without blacklisting them.
+func logoutAll(c *gin.Context) {
+	userId, _ := c.Get("user_id")
+	revoked := revokeSessions(userId.(int))
+	okResponse(c, http.StatusOK, "Logged out from all devices", gin.H{"revoked_sessions": revoked})
+}
+
 func refreshToken(c *gin.Context) {
 	var req struct {
 		RefreshToken string `json:"refresh_token" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		errResponse(c, http.StatusBadRequest, "Invalid request")
+		bindErrResponse(c, err)
 		return
 	}
 
+	// A token without a stored expiry was not issued by issueTokens. The
+	// token is used up either way, the new one takes over its session.
 	refreshMutex.Lock()
 	userId, ok := refreshTokens[req.RefreshToken]
+	expiry, known := refreshExpiries[req.RefreshToken]
+	expired := ok && (! known || ! authClock.Now().Before(expiry))
+	if ok {
+		deleteRefreshToken(req.RefreshToken)
+	}
 	refreshMutex.Unlock()
 	if ! ok {
 		errResponse(c, http.StatusUnauthorized, "Invalid refresh token")
 		return
 	}
+	if expired {
+		errResponse(c, http.StatusUnauthorized, "Refresh token expired")
+		return
+	}
 	user := findUserByID(userId)
 	if user == nil {
 		errResponse(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 
-	tokens, err := generateTokens(user.ID, user.Username, user.Role)
+	tokens, err := generateTokens(user.ID, user.Username, user.Role, user.Permissions...)
 	if err != nil {
 		errResponse(c, http.StatusInternalServerError, "Internal server error")
+		return
 	}
 	okResponse(c, http.StatusOK, "Login successful", tokens)
 }
@@ -437,11 +1304,99 @@ func authMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		c.Set("claims", claims)
-		c.Set("user_id", claims.UserID)
-		c.Set("role", claims.Role)
-		c.Next()
+		c.Set("claims", claims)
+		c.Set("user_id", claims.UserID)
+		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
+		if claims.Act != nil {
+			c.Set("actor_id", claims.Act.UserID)
+			log.Printf("audit: admin %d (%s) as user %d (%s): %s %s",
+				claims.Act.UserID, claims.Act.Username, claims.UserID, claims.Username,
+				c.Request.Method, c.Request.URL.Path)
+		}
+		c.Next()
+	}
+}
+
+// redactedValue replaces the secrets in the logged requests
+const redactedValue = "[REDACTED]"
+
+// Middleware: Request logging, only when logRequestBodies is set. The body
+// is read for the log and restored, the handlers get it unchanged.
+func requestLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ! logRequestBodies || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// The handler gets the same read error after the bytes read
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		} else {
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		authorization := c.GetHeader("Authorization")
+		if authorization != "" {
+			authorization = redactBearer(authorization)
+		}
+		log.Printf("request: %s %s authorization=%q body=%s",
+			c.Request.Method, c.Request.URL.Path, authorization, redactBody(body, redactedFields))
+		c.Next()
+	}
+}
+
+// redactBody returns the JSON body with the values of fields redacted at any
+// depth. A body that is not JSON is not logged, it could hold anything.
+func redactBody(body []byte, fields []string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Sprintf("[%d bytes, not JSON]", len(body))
+	}
+	data, err := json.Marshal(redactJSON(value, fields))
+	if err != nil {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	return string(data)
+}
+
+// redactJSON redacts in place the values of the fields, matched case
+// insensitively, and the bearer tokens of a decoded JSON value
+func redactJSON(value interface{}, fields []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range(v) {
+			if slices.ContainsFunc(fields, func(field string) bool { return strings.EqualFold(field, key) }) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactJSON(item, fields)
+			}
+		}
+	case []interface{}:
+		for i, item := range(v) {
+			v[i] = redactJSON(item, fields)
+		}
+	case string:
+		return redactBearer(v)
+	}
+	return value
+}
+
+// redactBearer hides the token of a "Bearer <token>" value, other values are
+// returned as is
+func redactBearer(value string) string {
+	scheme, _, found := strings.Cut(strings.TrimSpace(value), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return scheme + " " + redactedValue
 	}
+	return value
 }
 
 // Middleware: Role-based authorization
@@ -459,6 +1414,20 @@ func requireRole(roles ...string) gin.HandlerFunc {
 	}
 }
 
+// Middleware: Permission-based authorization, the permission may come from
+// the role or be granted to the user
+func requirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perms, _ := c.Get("permissions")
+		if granted, ok := perms.([]string); ok && slices.Contains(granted, perm) {
+			c.Next()
+			return
+		}
+		errResponse(c, http.StatusForbidden, "Forbidden")
+		c.Abort()
+	}
+}
+
 // GET /user/profile - Get current user profile
 func getUserProfile(c *gin.Context) {
 	userId, _ := c.Get("user_id")
@@ -481,8 +1450,6 @@ func getUserProfile(c *gin.Context) {
 		UpdatedAt time.Time `json:"updated_at"`
 	}
 
-	usersMutex.RLock()
-	defer usersMutex.RUnlock()
 	result := safeUser{
 		ID:        user.ID,
 		Username:  user.Username,
@@ -504,7 +1471,7 @@ func updateUserProfile(c *gin.Context) {
 		Email     string `json:"email" binding:"required,email"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		errResponse(c, http.StatusBadRequest, "Invalid request")
+		bindErrResponse(c, err)
 		return
 	}
 
@@ -519,12 +1486,16 @@ func updateUserProfile(c *gin.Context) {
 		return
 	}
 
-	usersMutex.Lock()
-	user.FirstName = req.FirstName
-	user.LastName = req.LastName
-	user.Email = req.Email
-	user.UpdatedAt = time.Now()
-	usersMutex.Unlock()
+	_, ok := updateUser(user.ID, func(u *User) {
+		u.FirstName = req.FirstName
+		u.LastName = req.LastName
+		u.Email = req.Email
+		u.UpdatedAt = time.Now()
+	})
+	if ! ok {
+		errResponse(c, http.StatusNotFound, "Not found")
+		return
+	}
 	okResponse(c, http.StatusOK, "Profile updated successfully", nil)
 }
 
@@ -534,7 +1505,7 @@ func changePassword(c *gin.Context) {
 		NewPassword     string `json:"new_password" binding:"required,min=8"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		errResponse(c, http.StatusBadRequest, "Invalid request")
+		bindErrResponse(c, err)
 		return
 	}
 
@@ -555,14 +1526,21 @@ func changePassword(c *gin.Context) {
 	}
 	pwdHash, err := hashPassword(req.NewPassword)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to hash new password"})
+		respond(c, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to hash new password"})
 		return
 	}
 
-	usersMutex.Lock()
-	user.PasswordHash = pwdHash
-	user.UpdatedAt = time.Now()
-	usersMutex.Unlock()
+	_, ok := updateUser(user.ID, func(u *User) {
+		u.PasswordHash = pwdHash
+		u.UpdatedAt = time.Now()
+	})
+	if ! ok {
+		errResponse(c, http.StatusNotFound, "Not found")
+		return
+	}
+	// Sessions opened with the old password are closed, except this one
+	revokeSessions(user.ID)
+	keepToken(c.MustGet("claims").(*JWTClaims))
 	okResponse(c, http.StatusOK, "Password changed successfully", nil)
 }
 
@@ -579,10 +1557,16 @@ func listUsers(c *gin.Context) {
 		UpdatedAt time.Time `json:"updated_at"`
 	}
 
+	page, size, err := usersListOptions.Parse(c)
+	if err != nil {
+		errResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
 
-	var results []safeUser
+	results := make([]safeUser, 0, len(users))
 	for _, u := range(users) {
 		results = append(results, safeUser{
 			ID:        u.ID,
@@ -596,7 +1580,7 @@ func listUsers(c *gin.Context) {
 			UpdatedAt: u.UpdatedAt,
 		})
 	}
-	okResponse(c, http.StatusOK, "Users list", results)
+	okResponse(c, http.StatusOK, "Users list", Paginate(results, page, size))
 }
 
 func changeUserRole(c *gin.Context) {
@@ -611,7 +1595,7 @@ func changeUserRole(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		errResponse(c, http.StatusBadRequest, "Invalid request")
+		bindErrResponse(c, err)
 		return
 	}
 
@@ -626,17 +1610,250 @@ func changeUserRole(c *gin.Context) {
 		errResponse(c, http.StatusNotFound, "Not found")
 		return
 	}
+	if (req.Role == RoleAdmin || user.Role == RoleAdmin) && c.GetString("role") != RoleAdmin {
+		errResponse(c, http.StatusForbidden, "Only an admin can grant or revoke the admin role")
+		return
+	}
+
+	_, ok := updateUser(user.ID, func(u *User) {
+		u.Role = req.Role
+		u.UpdatedAt = time.Now()
+	})
+	if ! ok {
+		errResponse(c, http.StatusNotFound, "Not found")
+		return
+	}
+	// The tokens issued before embed the old role
+	if user.Role != req.Role {
+		revokePrivilegedSessions(c, user.ID)
+	}
+	okResponse(c, http.StatusOK, "User role updated successfully", nil)
+}
+
+// POST /admin/users/:id/impersonate - Issue a token acting as the user.
+// Impersonating an admin requires a super admin, and an impersonation token
+// cannot be used to impersonate again.
+func impersonateUser(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		errResponse(c, http.StatusBadRequest, "Invalid Id")
+		return
+	}
+	claims := c.MustGet("claims").(*JWTClaims)
+	if claims.Act != nil {
+		errResponse(c, http.StatusForbidden, "Nested impersonation is not allowed")
+		return
+	}
+
+	target := findUserByID(userId)
+	if target == nil {
+		errResponse(c, http.StatusNotFound, "Not found")
+		return
+	}
+	actor := findUserByID(claims.UserID)
+	if actor == nil {
+		errResponse(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	if target.Role == RoleAdmin && ! actor.SuperAdmin {
+		errResponse(c, http.StatusForbidden, "Impersonating an admin requires a super admin")
+		return
+	}
+
+	tokens, err := generateImpersonationToken(target, actor)
+	if err != nil {
+		errResponse(c, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	log.Printf("audit: admin %d (%s) started impersonating user %d (%s), expires at %s",
+		actor.ID, actor.Username, target.ID, target.Username, tokens.ExpiresAt.Format(time.RFC3339))
+	okResponse(c, http.StatusOK, "Impersonation token issued", tokens)
+}
+
+// GET /healthz - Liveness probe
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// GET /readyz - Readiness probe, 503 if any check fails
+func readyz(c *gin.Context) {
+	status, resp := http.StatusOK, HealthResponse{Status: "ok", Checks: map[string]string{}}
+	for name, check := range readinessChecks {
+		if err := check(); err != nil {
+			status, resp.Status = http.StatusServiceUnavailable, "unavailable"
+			resp.Checks[name] = err.Error()
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+	c.JSON(status, resp)
+}
+
+// RoleUpdate is a single item of a bulk role change
+type RoleUpdate struct {
+	ID   int    `json:"id"`
+	Role string `json:"role"`
+}
+
+// RoleUpdateResult reports the outcome of a single bulk role change item
+type RoleUpdateResult struct {
+	ID      int    `json:"id"`
+	Role    string `json:"role"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PUT /admin/users/roles - Change the role of several users at once
+// The whole batch is applied under a single lock. If the batch would leave no
+// admin at all, every admin demotion of the batch is rejected. The sessions
+// of the users whose role changed are revoked, except the caller's one.
+func changeUserRoles(c *gin.Context) {
+	var req []RoleUpdate
+	if err := c.ShouldBindJSON(&req); err != nil || len(req) == 0 {
+		errResponse(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	validRoles := []string{RoleUser, RoleAdmin, RoleModerator}
+	results := make([]RoleUpdateResult, len(req))
+	targets := make([]int, len(req)) // index in users, -1 when rejected
+	seen := make(map[int]bool)
+	isAdmin := c.GetString("role") == RoleAdmin
 
 	usersMutex.Lock()
-	user.Role = req.Role
-	user.UpdatedAt = time.Now()
+	admins := 0
+	for _, u := range users {
+		if u.Role == RoleAdmin {
+			admins++
+		}
+	}
+
+	for i, update := range req {
+		results[i] = RoleUpdateResult{ID: update.ID, Role: update.Role}
+		targets[i] = -1
+		switch {
+		case seen[update.ID]:
+			results[i].Error = "Duplicate user ID in batch"
+			continue
+		case ! slices.Contains(validRoles, update.Role):
+			results[i].Error = "Invalid role"
+		}
+		seen[update.ID] = true
+		if results[i].Error != "" {
+			continue
+		}
+
+		index := slices.IndexFunc(users, func(u User) bool { return u.ID == update.ID })
+		if index == -1 {
+			results[i].Error = "User not found"
+			continue
+		}
+		if (update.Role == RoleAdmin || users[index].Role == RoleAdmin) && ! isAdmin {
+			results[i].Error = "Only an admin can grant or revoke the admin role"
+			continue
+		}
+		targets[i] = index
+		if users[index].Role == RoleAdmin && update.Role != RoleAdmin {
+			admins--
+		} else if users[index].Role != RoleAdmin && update.Role == RoleAdmin {
+			admins++
+		}
+	}
+
+	now := time.Now()
+	successful := 0
+	var changed []int
+	for i, index := range targets {
+		if index == -1 {
+			continue
+		}
+		if admins < 1 && users[index].Role == RoleAdmin && req[i].Role != RoleAdmin {
+			results[i].Error = "Cannot demote the last admin"
+			continue
+		}
+		if users[index].Role != req[i].Role {
+			users[index].TokenVersion++
+			changed = append(changed, users[index].ID)
+		}
+		users[index].Role = req[i].Role
+		users[index].UpdatedAt = now
+		results[i].Success = true
+		successful++
+	}
 	usersMutex.Unlock()
-	okResponse(c, http.StatusOK, "User role updated successfully", nil)
+	for _, id := range changed {
+		revokeRefreshTokens(id)
+		if id == c.GetInt("user_id") {
+			keepToken(c.MustGet("claims").(*JWTClaims))
+		}
+	}
+
+	okResponse(c, http.StatusOK, "Bulk role update completed", gin.H{
+		"results":    results,
+		"total":      len(req),
+		"successful": successful,
+		"failed":     len(req) - successful,
+	})
+}
+
+// PUT /admin/users/:id/permissions - Replace the permissions granted to the
+// user beyond its role, they apply to the tokens issued afterwards. A change
+// revokes the other sessions of the user, whose tokens embed the old
+// permissions.
+func changeUserPermissions(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		errResponse(c, http.StatusBadRequest, "Invalid Id")
+		return
+	}
+
+	var req struct {
+		Permissions []string `json:"permissions" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bindErrResponse(c, err)
+		return
+	}
+	for _, perm := range(req.Permissions) {
+		if ! isValidPermission(perm) {
+			errResponse(c, http.StatusBadRequest, "Invalid permission: "+perm)
+			return
+		}
+	}
+
+	user := findUserByID(userId)
+	if user == nil {
+		errResponse(c, http.StatusNotFound, "Not found")
+		return
+	}
+
+	permissions := slices.Clone(req.Permissions)
+	slices.Sort(permissions)
+	permissions = slices.Compact(permissions)
+	updated, ok := updateUser(user.ID, func(u *User) {
+		u.Permissions = permissions
+		u.UpdatedAt = time.Now()
+	})
+	if ! ok {
+		errResponse(c, http.StatusNotFound, "Not found")
+		return
+	}
+	if ! slices.Equal(user.Permissions, permissions) {
+		revokePrivilegedSessions(c, user.ID)
+	}
+	okResponse(c, http.StatusOK, "User permissions updated successfully", gin.H{
+		"permissions": effectivePermissions(updated.Role, permissions),
+	})
 }
 
 // Setup router with authentication routes
 func setupRouter() *gin.Engine {
 	router := gin.Default()
+	router.Use(requestLogMiddleware())
+
+	// Health routes
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
 
 	// Public routes
 	auth := router.Group("/auth")
@@ -644,6 +1861,7 @@ func setupRouter() *gin.Engine {
 		auth.POST("/register", register)
 		auth.POST("/login", login)
 		auth.POST("/logout", logout)
+		auth.POST("/logout-all", authMiddleware(), logoutAll)
 		auth.POST("/refresh", refreshToken)
 	}
 
@@ -659,10 +1877,12 @@ func setupRouter() *gin.Engine {
 	// Admin routes
 	admin := router.Group("/admin")
 	admin.Use(authMiddleware())
-	admin.Use(requireRole(RoleAdmin))
 	{
-		admin.GET("/users", listUsers)
-		admin.PUT("/users/:id/role", changeUserRole)
+		admin.GET("/users", requirePermission(PermUsersRead), listUsers)
+		admin.PUT("/users/:id/role", requirePermission(PermUsersWrite), changeUserRole)
+		admin.PUT("/users/roles", requirePermission(PermUsersWrite), changeUserRoles)
+		admin.PUT("/users/:id/permissions", requireRole(RoleAdmin), changeUserPermissions)
+		admin.POST("/users/:id/impersonate", requirePermission(PermUsersImpersonate), impersonateUser)
 	}
 
 	return router
@@ -672,8 +1892,107 @@ func setupRouter() *gin.Engine {
 // Helper functions
 // ---------------------------------------------------------------
 
+// SeedUsers replaces the stored users with the given fixtures and clears the
+// token stores. IDs are reassigned from 1 in order, a plain Password is
+// hashed into PasswordHash and missing timestamps are set to now.
+func SeedUsers(seed ...User) error {
+	now := time.Now()
+	seeded := make([]User, 0, len(seed))
+	for i, user := range seed {
+		if user.Password != "" {
+			hash, err := hashPassword(user.Password)
+			if err != nil {
+				return fmt.Errorf("seed user %q: %w", user.Username, err)
+			}
+			user.PasswordHash, user.Password = hash, ""
+		}
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = now
+		}
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = user.CreatedAt
+		}
+		user.ID = i + 1
+		seeded = append(seeded, user)
+	}
+
+	usersMutex.Lock()
+	users, nextUserID = seeded, len(seeded)+1
+	usersMutex.Unlock()
+	blacklistMutex.Lock()
+	blacklistedTokens = make(map[string]bool)
+	keptTokens = make(map[string]keptToken)
+	blacklistMutex.Unlock()
+	refreshMutex.Lock()
+	refreshTokens = make(map[string]int)
+	refreshExpiries = make(map[string]time.Time)
+	userSessions = make(map[int][]string)
+	refreshMutex.Unlock()
+	return nil
+}
+
+func checkUserStore() error {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	if users == nil {
+		return fmt.Errorf("user store not initialized")
+	}
+	return nil
+}
+
+func checkTokenStore() error {
+	refreshMutex.RLock()
+	defer refreshMutex.RUnlock()
+	blacklistMutex.RLock()
+	defer blacklistMutex.RUnlock()
+	if refreshTokens == nil || refreshExpiries == nil || userSessions == nil || blacklistedTokens == nil || keptTokens == nil {
+		return fmt.Errorf("token store not initialized")
+	}
+	return nil
+}
+
+// respond writes payload with the X-Request-ID of the request, if any.
+// Errors are negotiated from the Accept header: text/plain gets "key: value"
+// lines, one per failed field, XML an <error> element and anything else
+// JSON. Successful responses carry arbitrary data and are always JSON.
+func respond(c *gin.Context, status int, payload APIResponse) {
+	payload.RequestID = c.GetHeader("X-Request-ID")
+	if payload.Success {
+		c.JSON(status, payload)
+		return
+	}
+	switch c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain, gin.MIMEXML, gin.MIMEXML2) {
+	case gin.MIMEPlain:
+		var b strings.Builder
+		fmt.Fprintf(&b, "error: %s\n", payload.Message)
+		if payload.Error != "" {
+			fmt.Fprintf(&b, "detail: %s\n", payload.Error)
+		}
+		for _, fe := range(payload.Errors) {
+			fmt.Fprintf(&b, "field %s: %s\n", fe.Field, fe.Message)
+		}
+		if payload.RequestID != "" {
+			fmt.Fprintf(&b, "request_id: %s\n", payload.RequestID)
+		}
+		c.String(status, b.String())
+	case gin.MIMEXML, gin.MIMEXML2:
+		resp := xmlError{
+			Status:    status,
+			Message:   payload.Message,
+			Detail:    payload.Error,
+			RequestID: payload.RequestID,
+		}
+		for _, fe := range(payload.Errors) {
+			resp.Fields = append(resp.Fields, xmlFieldError{Name: fe.Field, Rule: fe.Rule, Message: fe.Message})
+		}
+		c.XML(status, resp)
+	default:
+		c.JSON(status, payload)
+	}
+}
+
 func okResponse(c *gin.Context, status int, msg string, data interface{}) {
-	c.JSON(status, APIResponse{
+	respond(c, status, APIResponse{
 		Success: true,
 		Message: msg,
 		Data:    data,
@@ -681,33 +2000,246 @@ func okResponse(c *gin.Context, status int, msg string, data interface{}) {
 }
 
 func errResponse(c *gin.Context, status int, msg string) {
-	c.JSON(status, APIResponse{
+	respond(c, status, APIResponse{
 		Success: false,
 		Message: msg,
 	})
 }
 
+// bindErrResponse reports a ShouldBindJSON failure: the failed fields for a
+// validation error, or a malformed JSON error for a body that cannot be decoded
+func bindErrResponse(c *gin.Context, err error) {
+	var ve validator.ValidationErrors
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &ve):
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Validation failed",
+			Errors:  translateValidationErrors(ve),
+		})
+	case errors.As(err, &typeErr):
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Malformed JSON",
+			Error:   fmt.Sprintf("field %s must be a %s", typeErr.Field, typeErr.Type),
+		})
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		respond(c, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Malformed JSON",
+			Error:   err.Error(),
+		})
+	default:
+		errResponse(c, http.StatusBadRequest, "Invalid request")
+	}
+}
+
+// translateValidationErrors turns validator errors into field level messages,
+// the fields are named by their JSON name
+func translateValidationErrors(ve validator.ValidationErrors) []FieldError {
+	result := make([]FieldError, 0, len(ve))
+	for _, fe := range(ve) {
+		result = append(result, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: validationMessage(fe),
+		})
+	}
+	return result
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "email":
+		return fmt.Sprintf("%s must be a valid email address", fe.Field())
+	case "min":
+		return fmt.Sprintf("%s must be at least %s characters", fe.Field(), fe.Param())
+	case "max":
+		return fmt.Sprintf("%s must be at most %s characters", fe.Field(), fe.Param())
+	default:
+		return fmt.Sprintf("%s failed the %s rule", fe.Field(), fe.Tag())
+	}
+}
+
+// jsonFieldName names the validated fields after their JSON key
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+}
+
 // ---------------------------------------------------------------
 // Main
 // ---------------------------------------------------------------
 
 func main() {
-	adminHash, _ := hashPassword("admin123")
-	users = append(users, User{
-		ID:            nextUserID,
+	cfg, err := LoadConfig[AuthConfig]()
+	if err != nil {
+		log.Fatal(err)
+	}
+	jwtSecret = []byte(cfg.JWTSecret)
+	accessTokenTTL, refreshTokenTTL, impersonateTTL = cfg.AccessTokenTTL, cfg.RefreshTokenTTL, cfg.ImpersonateTTL
+	refreshTokenJitter = cfg.RefreshJitter
+	maxSessions, sessionLimitMode = cfg.MaxSessions, cfg.SessionLimitMode
+	logRequestBodies = cfg.LogRequestBodies
+	if passwordHasher, err = newPasswordHasher(cfg.PasswordHash, cfg.BcryptCost); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.LockoutPolicyFile != "" {
+		policy, err := LoadLockoutPolicy(cfg.LockoutPolicyFile)
+		if err != nil {
+			log.Fatalf("Failed to load lockout policy: %v", err)
+		}
+		lockoutPolicy = policy
+	}
+
+	err = SeedUsers(User{
 		Username:      "admin",
 		Email:         "admin@example.com",
-		PasswordHash:  adminHash,
+		Password:      "admin123",
 		FirstName:     "Admin",
 		LastName:      "User",
 		Role:          RoleAdmin,
 		IsActive:      true,
 		EmailVerified: true,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
 	})
-	nextUserID++
+	if err != nil {
+		log.Fatalf("Failed to seed users: %v", err)
+	}
 
 	router := setupRouter()
-	router.Run(":8080")
+	router.Run(cfg.Addr)
+}
+
+// ---------------------------------------------------------------
+// Configuration
+// ---------------------------------------------------------------
+
+// ConfigError lists every missing or invalid setting found by LoadConfig
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// LoadConfig builds a T from the environment. A field tagged env:"NAME" is
+// read from the NAME variable, else from its default:"..." tag, and is
+// missing if tagged required:"true" and neither is set. Untagged struct
+// fields are loaded the same way, and a struct with a Validate() error
+// method is validated once its fields are read. Every problem is reported
+// at once in a *ConfigError.
+func LoadConfig[T any]() (T, error) {
+	var cfg T
+	var problems []string
+	loadConfigStruct(reflect.ValueOf(&cfg).Elem(), &problems)
+	if len(problems) > 0 {
+		return cfg, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// loadConfigStruct sets the fields of the struct v, appending the problems
+func loadConfigStruct(v reflect.Value, problems *[]string) {
+	before := len(*problems)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if ! field.IsExported() {
+			continue
+		}
+		name, tagged := field.Tag.Lookup("env")
+		if ! tagged {
+			if field.Type.Kind() == reflect.Struct {
+				loadConfigStruct(v.Field(i), problems)
+			}
+			continue
+		}
+
+		raw := os.Getenv(name)
+		if raw == "" {
+			raw = field.Tag.Get("default")
+		}
+		if raw == "" {
+			if field.Tag.Get("required") == "true" {
+				*problems = append(*problems, name+" is required")
+			}
+			continue
+		}
+		if err := setConfigField(v.Field(i), raw); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	checker, ok := v.Addr().Interface().(interface{ Validate() error })
+	if ok && len(*problems) == before {
+		if err := checker.Validate(); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %v", v.Type().Name(), err))
+		}
+	}
+}
+
+// setConfigField parses raw into the field. Durations use the
+// time.ParseDuration format and string slices are comma separated.
+func setConfigField(f reflect.Value, raw string) error {
+	if f.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		f.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(raw, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		f.SetFloat(x)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", f.Type())
+		}
+		var items []string
+		for _, item := range(strings.Split(raw, ",")) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items).Convert(f.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
 }
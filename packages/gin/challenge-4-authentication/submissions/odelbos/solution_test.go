@@ -0,0 +1,1373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestHealthz(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp HealthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "ok", resp.Status)
+}
+
+func TestReadyz(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp HealthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "ok", resp.Status)
+	assert.Equal(t, "ok", resp.Checks["users"])
+	assert.Equal(t, "ok", resp.Checks["tokens"])
+}
+
+func TestReadyzWithoutTokenStore(t *testing.T) {
+	blacklistMutex.Lock()
+	saved := blacklistedTokens
+	blacklistedTokens = nil
+	blacklistMutex.Unlock()
+	defer func() {
+		blacklistMutex.Lock()
+		blacklistedTokens = saved
+		blacklistMutex.Unlock()
+	}()
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var resp HealthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "unavailable", resp.Status)
+	assert.Equal(t, "ok", resp.Checks["users"])
+	assert.Equal(t, "token store not initialized", resp.Checks["tokens"])
+}
+
+func TestLoadLockoutPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lockout.json")
+	data := `{"max_failed_attempts": 3, "failure_window": "10m", "lockout_duration": "30m", "backoff_multiplier": 2, "max_lockout_duration": "90m"}`
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	policy, err := LoadLockoutPolicy(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, policy.MaxFailedAttempts)
+	assert.Equal(t, 10*time.Minute, policy.FailureWindow)
+	assert.Equal(t, 30*time.Minute, policy.LockoutDuration)
+	assert.Equal(t, 2.0, policy.BackoffMultiplier)
+	assert.Equal(t, 90*time.Minute, policy.MaxLockoutDuration)
+}
+
+func TestLoadLockoutPolicyInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"bad-json":     `{"max_failed_attempts": `,
+		"bad-duration": `{"lockout_duration": "forever"}`,
+		"bad-window":   `{"failure_window": "-5m"}`,
+		"bad-backoff":  `{"backoff_multiplier": 0.5}`,
+		"bad-cap":      `{"lockout_duration": "2h", "max_lockout_duration": "1h"}`,
+	} {
+		path := filepath.Join(dir, name+".json")
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+		_, err := LoadLockoutPolicy(path)
+		assert.Error(t, err, name)
+	}
+}
+
+// useFakeClock makes authClock a FakeClock for the duration of the test
+func useFakeClock(t *testing.T) *FakeClock {
+	saved := authClock
+	t.Cleanup(func() { authClock = saved })
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	authClock = clock
+	return clock
+}
+
+func TestLockoutEscalation(t *testing.T) {
+	saved := lockoutPolicy
+	defer func() { lockoutPolicy = saved }()
+	clock := useFakeClock(t)
+	lockoutPolicy = LockoutPolicy{
+		MaxFailedAttempts:  2,
+		LockoutDuration:    30 * time.Minute,
+		BackoffMultiplier:  2,
+		MaxLockoutDuration: 90 * time.Minute,
+	}
+
+	user := &User{ID: 1, Username: "john"}
+	lockUser := func() time.Duration {
+		for i := 0; i < lockoutPolicy.MaxFailedAttempts; i++ {
+			recordFailedAttempt(user)
+		}
+		assert.True(t, isAccountLocked(user))
+		return user.LockedUntil.Sub(clock.Now())
+	}
+
+	assert.Equal(t, 30*time.Minute, lockUser())
+	assert.Equal(t, 60*time.Minute, lockUser())
+	assert.Equal(t, 90*time.Minute, lockUser(), "lockout must be capped")
+	assert.Equal(t, 3, user.LockoutCount)
+
+	// The lockout expires with the clock
+	clock.Advance(90*time.Minute - time.Nanosecond)
+	assert.True(t, isAccountLocked(user))
+	clock.Advance(time.Nanosecond)
+	assert.False(t, isAccountLocked(user))
+
+	resetFailedAttempts(user)
+	assert.False(t, isAccountLocked(user))
+	assert.Equal(t, 0, user.LockoutCount)
+}
+
+func TestFailureWindow(t *testing.T) {
+	saved := lockoutPolicy
+	defer func() { lockoutPolicy = saved }()
+	lockoutPolicy = defaultLockoutPolicy()
+	lockoutPolicy.MaxFailedAttempts = 3
+	lockoutPolicy.FailureWindow = 10 * time.Minute
+	clock := useFakeClock(t)
+
+	// Failures spread beyond the window age out
+	user := &User{ID: 1, Username: "john"}
+	for i := 0; i < 10; i++ {
+		recordFailedAttempt(user)
+		assert.False(t, isAccountLocked(user), "failure %d", i)
+		clock.Advance(11 * time.Minute)
+	}
+	assert.Len(t, user.FailedAt, 1)
+
+	// A burst within the window locks the account
+	for i := 0; i < 3; i++ {
+		recordFailedAttempt(user)
+		clock.Advance(time.Minute)
+	}
+	assert.True(t, isAccountLocked(user))
+	assert.Empty(t, user.FailedAt)
+	clock.Advance(lockoutPolicy.LockoutDuration)
+	assert.False(t, isAccountLocked(user))
+
+	// Without a window every failure counts
+	lockoutPolicy.FailureWindow = 0
+	other := &User{ID: 2, Username: "jane"}
+	for i := 0; i < 3; i++ {
+		recordFailedAttempt(other)
+		clock.Advance(24 * time.Hour)
+	}
+	assert.NotNil(t, other.LockedUntil)
+}
+
+func TestLoginLockoutExpiry(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	saved := lockoutPolicy
+	defer func() { lockoutPolicy = saved }()
+	lockoutPolicy = LockoutPolicy{MaxFailedAttempts: 2, LockoutDuration: 30 * time.Minute, BackoffMultiplier: 1, MaxLockoutDuration: time.Hour}
+	clock := useFakeClock(t)
+	router := setupRouter()
+
+	login := func(password string) int {
+		return performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "john", Password: password}).Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, login("WrongPassword1!"))
+	assert.Equal(t, http.StatusUnauthorized, login("WrongPassword1!"))
+	assert.Equal(t, http.StatusLocked, login("Password123!"))
+
+	clock.Advance(30*time.Minute - time.Second)
+	assert.Equal(t, http.StatusLocked, login("Password123!"))
+	clock.Advance(time.Second)
+	assert.Equal(t, http.StatusOK, login("Password123!"))
+}
+
+func TestFindUserReturnsCopy(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+
+	user := findUserByUsername("john")
+	user.Role = RoleAdmin
+	assert.Equal(t, RoleUser, findUserByID(user.ID).Role)
+
+	// Failed logins are recorded on the stored user, even once users grew
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("user%d", i)
+		w := performJSON(router, "POST", "/auth/register", "", RegisterRequest{
+			Username:        name,
+			Email:           name + "@example.com",
+			Password:        "Password123!",
+			ConfirmPassword: "Password123!",
+			FirstName:       "Test",
+			LastName:        "User",
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+	for i := 0; i < lockoutPolicy.MaxFailedAttempts; i++ {
+		w := performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "john", Password: "WrongPassword1!"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	assert.True(t, isAccountLocked(findUserByUsername("john")))
+
+	_, ok := updateUser(999, resetFailedAttempts)
+	assert.False(t, ok)
+}
+
+// resetStores clears every global store and adds a single active user.
+// Passwords are hashed at the lowest bcrypt cost to keep the tests fast.
+func resetStores(t *testing.T, username, password, role string) *User {
+	t.Helper()
+	useHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+	err := SeedUsers(User{
+		Username:  username,
+		Email:     username + "@example.com",
+		Password:  password,
+		FirstName: "Test",
+		LastName:  "User",
+		Role:      role,
+		IsActive:  true,
+	})
+	assert.NoError(t, err)
+	return &users[0]
+}
+
+func performJSON(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func loginAs(t *testing.T, router *gin.Engine, username, password string) TokenResponse {
+	t.Helper()
+	w := performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: username, Password: password})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data TokenResponse `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Data
+}
+
+func refreshWith(router *gin.Engine, refreshToken string) int {
+	w := performJSON(router, "POST", "/auth/refresh", "", gin.H{"refresh_token": refreshToken})
+	return w.Code
+}
+
+func TestLogoutSingleSession(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+
+	laptop := loginAs(t, router, "john", "Password123!")
+	phone := loginAs(t, router, "john", "Password123!")
+
+	w := performJSON(router, "POST", "/auth/logout", laptop.AccessToken, gin.H{"refresh_token": laptop.RefreshToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, laptop.RefreshToken))
+	assert.Equal(t, http.StatusOK, refreshWith(router, phone.RefreshToken))
+
+	w = performJSON(router, "GET", "/user/profile", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = performJSON(router, "GET", "/user/profile", phone.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLogoutAllSessions(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+
+	laptop := loginAs(t, router, "john", "Password123!")
+	phone := loginAs(t, router, "john", "Password123!")
+
+	w := performJSON(router, "POST", "/auth/logout-all", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, laptop.RefreshToken))
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, phone.RefreshToken))
+	assert.Empty(t, refreshTokens)
+	assert.Empty(t, blacklistedTokens)
+}
+
+// useRefreshClock sets the clock, refresh TTL and jitter for the duration of
+// the test, the returned function moves the clock forward
+func useRefreshClock(t *testing.T, ttl time.Duration, jitter float64) func(time.Duration) {
+	savedTTL, savedJitter := refreshTokenTTL, refreshTokenJitter
+	t.Cleanup(func() { refreshTokenTTL, refreshTokenJitter = savedTTL, savedJitter })
+	clock := useFakeClock(t)
+	refreshTokenTTL, refreshTokenJitter = ttl, jitter
+	return clock.Advance
+}
+
+func TestRefreshTokenJitter(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	useRefreshClock(t, 100*time.Hour, 0.2)
+	issued := authClock.Now()
+
+	for i := 0; i < 50; i++ {
+		_, err := issueTokens(1, "john", RoleUser, nil, 0)
+		assert.NoError(t, err)
+	}
+	distinct := map[time.Time]bool{}
+	for _, expiry := range refreshExpiries {
+		ttl := expiry.Sub(issued)
+		assert.GreaterOrEqual(t, ttl, 80*time.Hour)
+		assert.LessOrEqual(t, ttl, 120*time.Hour)
+		distinct[expiry] = true
+	}
+	assert.Len(t, refreshExpiries, 50)
+	assert.Greater(t, len(distinct), 1, "expected the expiries to be spread")
+
+	// Without jitter every token gets the exact TTL
+	refreshTokenJitter = 0
+	tokens, err := issueTokens(1, "john", RoleUser, nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, issued.Add(100*time.Hour), refreshExpiries[tokens.RefreshToken])
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+	advance := useRefreshClock(t, time.Hour, 0)
+
+	tokens := loginAs(t, router, "john", "Password123!")
+	other := loginAs(t, router, "john", "Password123!")
+	advance(59 * time.Minute)
+	assert.Equal(t, http.StatusOK, refreshWith(router, other.RefreshToken))
+
+	advance(time.Minute)
+	w := performJSON(router, "POST", "/auth/refresh", "", gin.H{"refresh_token": tokens.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Refresh token expired")
+
+	// The expired token is forgotten
+	assert.NotContains(t, refreshTokens, tokens.RefreshToken)
+	assert.NotContains(t, refreshExpiries, tokens.RefreshToken)
+	w = performJSON(router, "POST", "/auth/refresh", "", gin.H{"refresh_token": tokens.RefreshToken})
+	assert.Contains(t, w.Body.String(), "Invalid refresh token")
+}
+
+// useSessionLimit sets maxSessions and sessionLimitMode for the duration of the test
+func useSessionLimit(t *testing.T, max int, mode string) {
+	savedMax, savedMode := maxSessions, sessionLimitMode
+	t.Cleanup(func() { maxSessions, sessionLimitMode = savedMax, savedMode })
+	maxSessions, sessionLimitMode = max, mode
+}
+
+func TestMaxSessionsEvictOldest(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+	useSessionLimit(t, 2, SessionEvictOldest)
+
+	first := loginAs(t, router, "john", "Password123!")
+	second := loginAs(t, router, "john", "Password123!")
+	third := loginAs(t, router, "john", "Password123!")
+
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, first.RefreshToken))
+	assert.Equal(t, []string{second.RefreshToken, third.RefreshToken}, userSessions[1])
+	assert.Len(t, refreshTokens, 2)
+
+	// Logging out frees a slot, the next login evicts nobody
+	w := performJSON(router, "POST", "/auth/logout", second.AccessToken, gin.H{"refresh_token": second.RefreshToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+	fourth := loginAs(t, router, "john", "Password123!")
+	assert.Equal(t, []string{third.RefreshToken, fourth.RefreshToken}, userSessions[1])
+}
+
+func TestMaxSessionsSingleSession(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+	useSessionLimit(t, 1, SessionEvictOldest)
+
+	laptop := loginAs(t, router, "john", "Password123!")
+	phone := loginAs(t, router, "john", "Password123!")
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, laptop.RefreshToken))
+	assert.Equal(t, []string{phone.RefreshToken}, userSessions[1])
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+	useSessionLimit(t, 1, SessionEvictOldest)
+
+	tokens := loginAs(t, router, "john", "Password123!")
+	w := performJSON(router, "POST", "/auth/refresh", "", gin.H{"refresh_token": tokens.RefreshToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data TokenResponse `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	rotated := resp.Data.RefreshToken
+	assert.NotEqual(t, tokens.RefreshToken, rotated)
+
+	// The used token is gone, the session lives on in the new one
+	assert.Equal(t, []string{rotated}, userSessions[1])
+	assert.Len(t, refreshTokens, 1)
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, tokens.RefreshToken))
+	assert.Len(t, refreshTokens, 1)
+}
+
+func TestMaxSessionsReject(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+	useSessionLimit(t, 2, SessionRejectNew)
+	advance := useRefreshClock(t, time.Hour, 0)
+
+	first := loginAs(t, router, "john", "Password123!")
+	loginAs(t, router, "john", "Password123!")
+	w := performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "john", Password: "Password123!"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "Maximum active sessions reached")
+
+	// The existing sessions are untouched
+	assert.Equal(t, http.StatusOK, refreshWith(router, first.RefreshToken))
+
+	// Expired sessions do not count
+	advance(time.Hour)
+	w = performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "john", Password: "Password123!"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, userSessions[1], 1)
+}
+
+func TestLogoutAllRequiresAuth(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+
+	w := performJSON(router, "POST", "/auth/logout-all", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// addUser appends a user that never logs in to the store
+func addUser(username, role string) int {
+	id := nextUserID
+	users = append(users, User{ID: id, Username: username, Email: username + "@example.com", Role: role, IsActive: true})
+	nextUserID++
+	return id
+}
+
+func bulkRoleUpdate(t *testing.T, router *gin.Engine, token string, updates []RoleUpdate) (int, []RoleUpdateResult) {
+	t.Helper()
+	w := performJSON(router, "PUT", "/admin/users/roles", token, updates)
+
+	var resp struct {
+		Data struct {
+			Results []RoleUpdateResult `json:"results"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w.Code, resp.Data.Results
+}
+
+func TestBulkRoleChangeMixedBatch(t *testing.T) {
+	resetStores(t, "admin", "Admin123!", RoleAdmin)
+	alice := addUser("alice", RoleUser)
+	bob := addUser("bob", RoleUser)
+	router := setupRouter()
+	token := loginAs(t, router, "admin", "Admin123!").AccessToken
+
+	status, results := bulkRoleUpdate(t, router, token, []RoleUpdate{
+		{ID: alice, Role: RoleModerator},
+		{ID: bob, Role: "superuser"},
+		{ID: 42, Role: RoleUser},
+		{ID: alice, Role: RoleAdmin},
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, results, 4)
+
+	assert.True(t, results[0].Success)
+	assert.Equal(t, "Invalid role", results[1].Error)
+	assert.Equal(t, "User not found", results[2].Error)
+	assert.Equal(t, "Duplicate user ID in batch", results[3].Error)
+
+	assert.Equal(t, RoleModerator, findUserByID(alice).Role)
+	assert.Equal(t, RoleUser, findUserByID(bob).Role)
+}
+
+func TestBulkRoleChangeLastAdminProtection(t *testing.T) {
+	resetStores(t, "admin", "Admin123!", RoleAdmin)
+	other := addUser("other-admin", RoleAdmin)
+	alice := addUser("alice", RoleUser)
+	router := setupRouter()
+	token := loginAs(t, router, "admin", "Admin123!").AccessToken
+
+	// Each demotion alone is fine, but together they remove every admin
+	status, results := bulkRoleUpdate(t, router, token, []RoleUpdate{
+		{ID: 1, Role: RoleUser},
+		{ID: other, Role: RoleModerator},
+		{ID: alice, Role: RoleModerator},
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Cannot demote the last admin", results[0].Error)
+	assert.Equal(t, "Cannot demote the last admin", results[1].Error)
+	assert.True(t, results[2].Success)
+	assert.Equal(t, RoleAdmin, findUserByID(1).Role)
+	assert.Equal(t, RoleAdmin, findUserByID(other).Role)
+
+	// Promoting someone in the same batch keeps an admin around
+	_, results = bulkRoleUpdate(t, router, token, []RoleUpdate{
+		{ID: other, Role: RoleUser},
+		{ID: alice, Role: RoleAdmin},
+	})
+	assert.True(t, results[0].Success)
+	assert.True(t, results[1].Success)
+	assert.Equal(t, RoleAdmin, findUserByID(alice).Role)
+}
+
+func TestBulkRoleChangeInvalidRequest(t *testing.T) {
+	resetStores(t, "admin", "Admin123!", RoleAdmin)
+	router := setupRouter()
+	token := loginAs(t, router, "admin", "Admin123!").AccessToken
+
+	w := performJSON(router, "PUT", "/admin/users/roles", token, []RoleUpdate{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSeedUsers(t *testing.T) {
+	user := resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+	assert.Equal(t, 1, user.ID)
+	assert.Empty(t, user.Password)
+	assert.True(t, verifyPassword("Password123!", user.PasswordHash))
+
+	w := performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "john", Password: "Password123!"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, refreshTokens, 1)
+
+	// Re-seeding clears the previous users and tokens and restarts the IDs
+	err := SeedUsers(
+		User{ID: 9, Username: "alice", Email: "alice@example.com", Role: RoleAdmin, IsActive: true},
+		User{ID: 9, Username: "bob", Email: "bob@example.com", Role: RoleUser, IsActive: true},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, []string{users[0].Username, users[1].Username})
+	assert.Equal(t, []int{1, 2}, []int{users[0].ID, users[1].ID})
+	assert.Equal(t, 3, nextUserID)
+	assert.Empty(t, refreshTokens)
+	assert.Empty(t, blacklistedTokens)
+
+	w = performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "john", Password: "Password123!"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestPaginate(t *testing.T) {
+	items := make([]int, 45)
+	for i := range items {
+		items[i] = i + 1
+	}
+
+	tests := []struct {
+		name               string
+		page, size         int
+		first, count       int
+		wantPage, wantSize int
+		totalPages         int
+		hasNext            bool
+	}{
+		{"first page", 1, 10, 1, 10, 1, 10, 5, true},
+		{"last partial page", 5, 10, 41, 5, 5, 10, 5, false},
+		{"exact last page", 3, 15, 31, 15, 3, 15, 3, false},
+		{"page beyond end", 9, 10, 0, 0, 9, 10, 5, false},
+		{"page below 1", 0, 10, 1, 10, 1, 10, 5, true},
+		{"size below 1", 1, 0, 1, 1, 1, 1, 45, true},
+		{"size above total", 1, 1000, 1, 45, 1, 1000, 1, false},
+		{"huge page", math.MaxInt, 100, 0, 0, math.MaxInt, 100, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Paginate(items, tt.page, tt.size)
+			assert.Len(t, p.Items, tt.count)
+			assert.NotNil(t, p.Items)
+			if tt.count > 0 {
+				assert.Equal(t, tt.first, p.Items[0])
+			}
+			assert.Equal(t, tt.wantPage, p.Page)
+			assert.Equal(t, tt.wantSize, p.Size)
+			assert.Equal(t, 45, p.Total)
+			assert.Equal(t, tt.totalPages, p.TotalPages)
+			assert.Equal(t, tt.hasNext, p.HasNext)
+		})
+	}
+
+	empty := Paginate([]string(nil), 1, 10)
+	assert.NotNil(t, empty.Items)
+	assert.Equal(t, 0, empty.TotalPages)
+	assert.False(t, empty.HasNext)
+}
+
+func TestListUsersPagination(t *testing.T) {
+	resetStores(t, "admin", "Password123!", RoleAdmin)
+	for i := 0; i < 4; i++ {
+		addUser(fmt.Sprintf("user%d", i), RoleUser)
+	}
+	router := setupRouter()
+	token := loginAs(t, router, "admin", "Password123!").AccessToken
+
+	w := performJSON(router, "GET", "/admin/users?page=2&size=2", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data Page[User] `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []string{"user1", "user2"}, []string{resp.Data.Items[0].Username, resp.Data.Items[1].Username})
+	assert.Equal(t, 5, resp.Data.Total)
+	assert.Equal(t, 3, resp.Data.TotalPages)
+	assert.True(t, resp.Data.HasNext)
+
+	w = performJSON(router, "GET", "/admin/users?page=abc", token, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListOptionsParse(t *testing.T) {
+	opts := ListOptions{DefaultSize: 10, MaxSize: 50}
+	tests := []struct {
+		name     string
+		query    string
+		page     int
+		size     int
+		hasError bool
+	}{
+		{"defaults", "", 1, 10, false},
+		{"explicit", "page=3&size=25", 3, 25, false},
+		{"size clamped to max", "size=500", 1, 50, false},
+		{"zero size", "size=0", 0, 0, true},
+		{"negative size", "size=-5", 0, 0, true},
+		{"zero page", "page=0", 0, 0, true},
+		{"negative page", "page=-1", 0, 0, true},
+		{"non numeric size", "size=ten", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+
+			page, size, err := opts.Parse(c)
+			if tt.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.page, page)
+			assert.Equal(t, tt.size, size)
+		})
+	}
+}
+
+func TestListUsersSizeBounds(t *testing.T) {
+	resetStores(t, "admin", "Password123!", RoleAdmin)
+	router := setupRouter()
+	token := loginAs(t, router, "admin", "Password123!").AccessToken
+
+	for _, query := range []string{"size=0", "size=-1", "page=0"} {
+		w := performJSON(router, "GET", "/admin/users?"+query, token, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	w := performJSON(router, "GET", "/admin/users?size=1000", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data Page[User] `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, usersListOptions.MaxSize, resp.Data.Size)
+}
+
+func impersonate(t *testing.T, router *gin.Engine, token string, id int) (int, TokenResponse) {
+	t.Helper()
+	w := performJSON(router, "POST", fmt.Sprintf("/admin/users/%d/impersonate", id), token, nil)
+	var resp struct {
+		Data TokenResponse `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w.Code, resp.Data
+}
+
+func TestImpersonateUser(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	admin := resetStores(t, "admin", "Password123!", RoleAdmin)
+	adminID := admin.ID
+	bobID := addUser("bob", RoleUser)
+	router := setupRouter()
+	adminToken := loginAs(t, router, "admin", "Password123!").AccessToken
+
+	code, tokens := impersonate(t, router, adminToken, bobID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, tokens.RefreshToken)
+	assert.Equal(t, int64(impersonateTTL.Seconds()), tokens.ExpiresIn)
+
+	claims, err := validateToken(tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, bobID, claims.UserID)
+	assert.Equal(t, RoleUser, claims.Role)
+	if assert.NotNil(t, claims.Act) {
+		assert.Equal(t, adminID, claims.Act.UserID)
+		assert.Equal(t, "admin", claims.Act.Username)
+	}
+
+	// The token acts as bob and every use is audited
+	w := performJSON(router, "GET", "/user/profile", tokens.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"username":"bob"`)
+	assert.Contains(t, logs.String(), "started impersonating user")
+	assert.Contains(t, logs.String(), fmt.Sprintf("admin %d (admin) as user %d (bob): GET /user/profile", adminID, bobID))
+
+	// Nothing can refresh it
+	assert.Equal(t, http.StatusBadRequest, refreshWith(router, ""))
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, tokens.AccessToken))
+
+	code, _ = impersonate(t, router, adminToken, 999)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = impersonate(t, router, loginAs(t, router, "admin", "Password123!").AccessToken, adminID)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestImpersonateAdmin(t *testing.T) {
+	resetStores(t, "root", "Password123!", RoleAdmin)
+	otherID := addUser("other", RoleAdmin)
+	router := setupRouter()
+	token := loginAs(t, router, "root", "Password123!").AccessToken
+
+	code, _ := impersonate(t, router, token, otherID)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	users[0].SuperAdmin = true
+	code, tokens := impersonate(t, router, token, otherID)
+	assert.Equal(t, http.StatusOK, code)
+
+	// An impersonation token cannot impersonate again, even as an admin
+	code, _ = impersonate(t, router, tokens.AccessToken, users[0].ID)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestImpersonateRequiresAdmin(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	otherID := addUser("other", RoleUser)
+	router := setupRouter()
+	token := loginAs(t, router, "john", "Password123!").AccessToken
+
+	code, _ := impersonate(t, router, token, otherID)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestPasswordChangeRevokesTokens(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+
+	laptop := loginAs(t, router, "john", "Password123!")
+	phone := loginAs(t, router, "john", "Password123!")
+
+	w := performJSON(router, "POST", "/user/change-password", laptop.AccessToken, gin.H{
+		"current_password": "Password123!",
+		"new_password":     "NewPassword456!",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, users[0].TokenVersion)
+
+	// The session that changed the password stays open
+	w = performJSON(router, "GET", "/user/profile", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err := validateToken(phone.AccessToken)
+	assert.Error(t, err)
+	w = performJSON(router, "GET", "/user/profile", phone.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, refreshWith(router, phone.RefreshToken))
+	assert.Empty(t, blacklistedTokens)
+
+	fresh := loginAs(t, router, "john", "NewPassword456!")
+	claims, err := validateToken(fresh.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, claims.Version)
+	assert.Equal(t, http.StatusOK, refreshWith(router, fresh.RefreshToken))
+
+	// The kept session is revoked by the next version bump
+	w = performJSON(router, "POST", "/auth/logout-all", fresh.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performJSON(router, "GET", "/user/profile", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLogoutAllRevokesAccessTokens(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+
+	laptop := loginAs(t, router, "john", "Password123!")
+	phone := loginAs(t, router, "john", "Password123!")
+
+	w := performJSON(router, "POST", "/auth/logout-all", laptop.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performJSON(router, "GET", "/user/profile", phone.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, blacklistedTokens)
+
+	w = performJSON(router, "GET", "/user/profile", loginAs(t, router, "john", "Password123!").AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRegisterFieldErrors(t *testing.T) {
+	resetStores(t, "admin", "Admin123!", RoleAdmin)
+	router := setupRouter()
+
+	w := performJSON(router, "POST", "/auth/register", "", gin.H{
+		"username":         "ab",
+		"email":            "not-an-email",
+		"password":         "Secret1!",
+		"confirm_password": "Secret1!",
+		"first_name":       "Jo",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Success)
+	assert.Equal(t, "Validation failed", resp.Message)
+	assert.Equal(t, []FieldError{
+		{Field: "username", Rule: "min", Message: "username must be at least 3 characters"},
+		{Field: "email", Rule: "email", Message: "email must be a valid email address"},
+		{Field: "last_name", Rule: "required", Message: "last_name is required"},
+	}, resp.Errors)
+}
+
+func TestLoginFieldErrors(t *testing.T) {
+	resetStores(t, "admin", "Admin123!", RoleAdmin)
+	router := setupRouter()
+
+	w := performJSON(router, "POST", "/auth/login", "", gin.H{"password": "short"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp APIResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []FieldError{
+		{Field: "username", Rule: "required", Message: "username is required"},
+		{Field: "password", Rule: "min", Message: "password must be at least 8 characters"},
+	}, resp.Errors)
+}
+
+func TestMalformedJSON(t *testing.T) {
+	resetStores(t, "admin", "Admin123!", RoleAdmin)
+	router := setupRouter()
+
+	for _, body := range []string{`{"username": "alice",`, ``, `{"username": 42, "password": "Secret123!"}`} {
+		req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		var resp APIResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "Malformed JSON", resp.Message, body)
+		assert.NotEmpty(t, resp.Error, body)
+		assert.Empty(t, resp.Errors, body)
+	}
+}
+
+// seedAdminAndModerator seeds an admin (ID 1) and a moderator (ID 2) sharing
+// the same password
+func seedAdminAndModerator(t *testing.T) {
+	t.Helper()
+	useHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+	err := SeedUsers(
+		User{Username: "root", Email: "root@example.com", Password: "Password123!", Role: RoleAdmin, IsActive: true},
+		User{Username: "mod", Email: "mod@example.com", Password: "Password123!", Role: RoleModerator, IsActive: true},
+	)
+	assert.NoError(t, err)
+}
+
+func TestPermissionGrant(t *testing.T) {
+	seedAdminAndModerator(t)
+	router := setupRouter()
+	adminToken := loginAs(t, router, "root", "Password123!").AccessToken
+
+	modToken := loginAs(t, router, "mod", "Password123!").AccessToken
+	w := performJSON(router, "GET", "/admin/users", modToken, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Only an admin grants permissions, and they must be well formed
+	w = performJSON(router, "PUT", "/admin/users/2/permissions", modToken, gin.H{"permissions": []string{PermUsersRead}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performJSON(router, "PUT", "/admin/users/2/permissions", adminToken, gin.H{"permissions": []string{"users"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performJSON(router, "PUT", "/admin/users/2/permissions", adminToken, gin.H{"permissions": []string{PermUsersRead, PermUsersRead}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{PermUsersRead}, users[1].Permissions)
+
+	// The grant applies to the next token, which embeds it
+	modToken = loginAs(t, router, "mod", "Password123!").AccessToken
+	claims, err := validateToken(modToken)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleModerator, claims.Role)
+	assert.Equal(t, []string{PermUsersRead}, claims.Permissions)
+
+	w = performJSON(router, "GET", "/admin/users", modToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performJSON(router, "PUT", "/admin/users/1/role", modToken, gin.H{"role": RoleUser})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	code, _ := impersonate(t, router, modToken, 1)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestPrivilegeChangesRevokeTokens(t *testing.T) {
+	seedAdminAndModerator(t)
+	router := setupRouter()
+	adminToken := loginAs(t, router, "root", "Password123!").AccessToken
+
+	changes := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"role", "PUT", "/admin/users/2/role", gin.H{"role": RoleUser}},
+		{"bulk role", "PUT", "/admin/users/roles", []RoleUpdate{{ID: 2, Role: RoleModerator}}},
+		{"permissions", "PUT", "/admin/users/2/permissions", gin.H{"permissions": []string{PermUsersRead}}},
+	}
+	for _, change := range changes {
+		old := loginAs(t, router, "mod", "Password123!")
+		w := performJSON(router, change.method, change.path, adminToken, change.body)
+		assert.Equal(t, http.StatusOK, w.Code, change.name)
+
+		// The old token embeds the previous role and permissions
+		w = performJSON(router, "GET", "/user/profile", old.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, change.name)
+		assert.Equal(t, http.StatusUnauthorized, refreshWith(router, old.RefreshToken), change.name)
+	}
+
+	// Setting the same role again leaves the sessions open
+	current := loginAs(t, router, "mod", "Password123!")
+	w := performJSON(router, "PUT", "/admin/users/2/role", adminToken, gin.H{"role": RoleModerator})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performJSON(router, "GET", "/user/profile", current.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The admin's own session is untouched
+	w = performJSON(router, "GET", "/admin/users", adminToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// An admin changing its own permissions keeps the calling session only
+	other := loginAs(t, router, "root", "Password123!")
+	w = performJSON(router, "PUT", "/admin/users/1/permissions", adminToken, gin.H{"permissions": []string{"reports:read"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performJSON(router, "GET", "/user/profile", adminToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performJSON(router, "GET", "/user/profile", other.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestPermissionNotCoveredByRole(t *testing.T) {
+	const perm = "reports:export"
+	seedAdminAndModerator(t)
+	users[1].Permissions = []string{perm}
+	router := setupRouter()
+	router.GET("/reports", authMiddleware(), requirePermission(perm), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	// No role holds it, not even admin
+	w := performJSON(router, "GET", "/reports", loginAs(t, router, "root", "Password123!").AccessToken, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performJSON(router, "GET", "/reports", loginAs(t, router, "mod", "Password123!").AccessToken, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestPermissionCannotGrantAdmin(t *testing.T) {
+	seedAdminAndModerator(t)
+	users[1].Permissions = []string{PermUsersWrite}
+	userID := addUser("bob", RoleUser)
+	router := setupRouter()
+	modToken := loginAs(t, router, "mod", "Password123!").AccessToken
+
+	w := performJSON(router, "PUT", fmt.Sprintf("/admin/users/%d/role", userID), modToken, gin.H{"role": RoleModerator})
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, req := range []struct {
+		id   int
+		role string
+	}{{userID, RoleAdmin}, {2, RoleAdmin}, {1, RoleUser}} {
+		w = performJSON(router, "PUT", fmt.Sprintf("/admin/users/%d/role", req.id), modToken, gin.H{"role": req.role})
+		assert.Equal(t, http.StatusForbidden, w.Code, req)
+	}
+
+	code, results := bulkRoleUpdate(t, router, modToken, []RoleUpdate{{ID: userID, Role: RoleUser}, {ID: 2, Role: RoleAdmin}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, results[0].Success)
+	assert.False(t, results[1].Success)
+	assert.Equal(t, RoleModerator, users[1].Role)
+}
+
+func TestRequestLogRedaction(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	logRequestBodies = true
+	defer func() { logRequestBodies = false }()
+
+	resetStores(t, "admin", "Password123!", RoleAdmin)
+	router := setupRouter()
+
+	// The handlers still get the passwords
+	w := performJSON(router, "POST", "/auth/register", "", RegisterRequest{
+		Username:        "alice",
+		Email:           "alice@example.com",
+		Password:        "Secret-Register-1",
+		ConfirmPassword: "Secret-Register-1",
+		FirstName:       "Alice",
+		LastName:        "Smith",
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	tokens := loginAs(t, router, "alice", "Secret-Register-1")
+	accessToken := tokens.AccessToken
+	w = performJSON(router, "POST", "/user/change-password", accessToken, gin.H{
+		"current_password": "Secret-Register-1",
+		"new_password":     "Secret-Changed-2",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	tokens = loginAs(t, router, "alice", "Secret-Changed-2")
+	assert.Equal(t, http.StatusOK, refreshWith(router, tokens.RefreshToken))
+
+	logged := logs.String()
+	for _, secret := range []string{"Secret-Register-1", "Secret-Changed-2", accessToken, tokens.RefreshToken} {
+		assert.NotContains(t, logged, secret)
+	}
+	assert.Contains(t, logged, `"username":"alice"`)
+	assert.Contains(t, logged, `"new_password":"[REDACTED]"`)
+	assert.Contains(t, logged, `authorization="Bearer [REDACTED]"`)
+}
+
+func TestRedactBody(t *testing.T) {
+	fields := append(redactedFields, "pin")
+	body := `{"user":{"Password":"p1","pin":1234,"name":"bob"},"items":[{"new_password":"p2"}],"note":"bearer abc.def"}`
+	assert.Equal(t,
+		`{"items":[{"new_password":"[REDACTED]"}],"note":"bearer [REDACTED]","user":{"Password":"[REDACTED]","name":"bob","pin":"[REDACTED]"}}`,
+		redactBody([]byte(body), fields))
+
+	// Anything that is not JSON is not logged
+	assert.Equal(t, "[17 bytes, not JSON]", redactBody([]byte("password=p1&pin=2"), fields))
+	assert.Equal(t, "", redactBody(nil, fields))
+}
+
+// setAuthEnv clears the variables read by LoadConfig[AuthConfig], then sets
+// the given ones
+func setAuthEnv(t *testing.T, env map[string]string) {
+	for _, name := range []string{
+		"ADDR", "JWT_SECRET", "ACCESS_TOKEN_TTL", "REFRESH_TOKEN_TTL", "IMPERSONATE_TTL",
+		"LOCKOUT_POLICY_FILE", "LOG_REQUEST_BODIES", "PASSWORD_HASH", "BCRYPT_COST", "REFRESH_TOKEN_JITTER",
+		"MAX_SESSIONS", "SESSION_LIMIT_MODE",
+	} {
+		t.Setenv(name, "")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadAuthConfigDefaults(t *testing.T) {
+	setAuthEnv(t, nil)
+	_, err := LoadConfig[AuthConfig]()
+	assert.EqualError(t, err, "invalid configuration: JWT_SECRET is required")
+
+	setAuthEnv(t, map[string]string{"JWT_SECRET": "s3cret"})
+	cfg, err := LoadConfig[AuthConfig]()
+	assert.NoError(t, err)
+	assert.Equal(t, AuthConfig{
+		Addr:             ":8080",
+		JWTSecret:        "s3cret",
+		AccessTokenTTL:   accessTokenTTL,
+		RefreshTokenTTL:  refreshTokenTTL,
+		ImpersonateTTL:   impersonateTTL,
+		PasswordHash:     "bcrypt",
+		BcryptCost:       12,
+		SessionLimitMode: SessionEvictOldest,
+	}, cfg)
+}
+
+func TestLoadAuthConfigOverrides(t *testing.T) {
+	setAuthEnv(t, map[string]string{
+		"JWT_SECRET":           "s3cret",
+		"ADDR":                 ":9443",
+		"ACCESS_TOKEN_TTL":     "1m",
+		"LOCKOUT_POLICY_FILE":  "/etc/lockout.json",
+		"LOG_REQUEST_BODIES":   "true",
+		"PASSWORD_HASH":        "argon2id",
+		"REFRESH_TOKEN_JITTER": "0.1",
+		"MAX_SESSIONS":         "3",
+		"SESSION_LIMIT_MODE":   "reject",
+	})
+	cfg, err := LoadConfig[AuthConfig]()
+	assert.NoError(t, err)
+	assert.Equal(t, ":9443", cfg.Addr)
+	assert.Equal(t, time.Minute, cfg.AccessTokenTTL)
+	assert.Equal(t, refreshTokenTTL, cfg.RefreshTokenTTL)
+	assert.Equal(t, "/etc/lockout.json", cfg.LockoutPolicyFile)
+	assert.True(t, cfg.LogRequestBodies)
+	assert.Equal(t, "argon2id", cfg.PasswordHash)
+	assert.Equal(t, 0.1, cfg.RefreshJitter)
+	assert.Equal(t, 3, cfg.MaxSessions)
+	assert.Equal(t, SessionRejectNew, cfg.SessionLimitMode)
+}
+
+func TestLoadAuthConfigErrors(t *testing.T) {
+	// Every problem is listed at once
+	setAuthEnv(t, map[string]string{
+		"ACCESS_TOKEN_TTL":   "15",
+		"LOG_REQUEST_BODIES": "sometimes",
+	})
+	_, err := LoadConfig[AuthConfig]()
+	var configErr *ConfigError
+	if assert.ErrorAs(t, err, &configErr) {
+		assert.Equal(t, []string{
+			"JWT_SECRET is required",
+			`ACCESS_TOKEN_TTL: invalid duration "15"`,
+			`LOG_REQUEST_BODIES: invalid boolean "sometimes"`,
+		}, configErr.Problems)
+	}
+
+	// Once the fields are read, the config is validated
+	setAuthEnv(t, map[string]string{"JWT_SECRET": "s3cret", "IMPERSONATE_TTL": "-5m"})
+	_, err = LoadConfig[AuthConfig]()
+	assert.EqualError(t, err, "invalid configuration: AuthConfig: token lifetimes must be positive")
+
+	setAuthEnv(t, map[string]string{"JWT_SECRET": "s3cret", "PASSWORD_HASH": "md5"})
+	_, err = LoadConfig[AuthConfig]()
+	assert.EqualError(t, err, `invalid configuration: AuthConfig: unknown password hash algorithm "md5"`)
+	setAuthEnv(t, map[string]string{"JWT_SECRET": "s3cret", "BCRYPT_COST": "40"})
+	_, err = LoadConfig[AuthConfig]()
+	assert.EqualError(t, err, "invalid configuration: AuthConfig: bcrypt cost must be between 4 and 31")
+
+	setAuthEnv(t, map[string]string{"JWT_SECRET": "s3cret", "REFRESH_TOKEN_JITTER": "1.5"})
+	_, err = LoadConfig[AuthConfig]()
+	assert.EqualError(t, err, "invalid configuration: AuthConfig: refresh token jitter must be in [0, 1)")
+
+	setAuthEnv(t, map[string]string{"JWT_SECRET": "s3cret", "SESSION_LIMIT_MODE": "lifo"})
+	_, err = LoadConfig[AuthConfig]()
+	assert.EqualError(t, err, `invalid configuration: AuthConfig: unknown session limit mode "lifo"`)
+}
+
+// testArgon2id is a cheap argon2id hasher for the tests
+var testArgon2id = Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+// useHasher sets passwordHasher for the duration of the test
+func useHasher(t *testing.T, h PasswordHasher) {
+	saved := passwordHasher
+	passwordHasher = h
+	t.Cleanup(func() { passwordHasher = saved })
+}
+
+func TestPasswordHashersRoundTrip(t *testing.T) {
+	for _, h := range []PasswordHasher{BcryptHasher{Cost: bcrypt.MinCost}, testArgon2id} {
+		hash, err := h.Hash("S3cret!pass")
+		assert.NoError(t, err)
+		other, err := h.Hash("S3cret!pass")
+		assert.NoError(t, err)
+		assert.NotEqual(t, hash, other, "hashes must be salted")
+
+		ok, needsRehash := h.Verify("S3cret!pass", hash)
+		assert.True(t, ok)
+		assert.False(t, needsRehash)
+		ok, _ = h.Verify("wrong", hash)
+		assert.False(t, ok)
+		ok, _ = h.Verify("S3cret!pass", "garbage")
+		assert.False(t, ok)
+	}
+
+	hash, err := testArgon2id.Hash("S3cret!pass")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+}
+
+func TestPasswordHashersNeedsRehash(t *testing.T) {
+	low, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("S3cret!pass")
+	assert.NoError(t, err)
+	ok, needsRehash := BcryptHasher{Cost: bcrypt.MinCost + 1}.Verify("S3cret!pass", low)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+
+	stronger := testArgon2id
+	stronger.Time = 2
+	hash, err := testArgon2id.Hash("S3cret!pass")
+	assert.NoError(t, err)
+	ok, needsRehash = stronger.Verify("S3cret!pass", hash)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+
+	// Hashes of the other algorithm are verified and need a rehash
+	ok, needsRehash = testArgon2id.Verify("S3cret!pass", low)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+	ok, needsRehash = BcryptHasher{Cost: bcrypt.MinCost}.Verify("S3cret!pass", hash)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+	ok, _ = BcryptHasher{Cost: bcrypt.MinCost}.Verify("wrong", hash)
+	assert.False(t, ok)
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	useHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+	user := resetStores(t, "alice", "S3cret!pass", RoleUser)
+	router := setupRouter()
+	assert.True(t, strings.HasPrefix(user.PasswordHash, "$2"))
+
+	useHasher(t, testArgon2id)
+	loginAs(t, router, "alice", "S3cret!pass")
+	upgraded := users[0].PasswordHash
+	assert.True(t, strings.HasPrefix(upgraded, argon2idPrefix))
+
+	// The upgraded hash is kept by the next logins
+	loginAs(t, router, "alice", "S3cret!pass")
+	assert.Equal(t, upgraded, users[0].PasswordHash)
+
+	// A failed login leaves the hash alone
+	useHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+	w := performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "alice", Password: "Wr0ng!pass"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, upgraded, users[0].PasswordHash)
+}
+
+func TestLoginUpgradesBcryptCost(t *testing.T) {
+	useHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+	resetStores(t, "alice", "S3cret!pass", RoleUser)
+	router := setupRouter()
+
+	useHasher(t, BcryptHasher{Cost: bcrypt.MinCost + 2})
+	w := performJSON(router, "POST", "/auth/login", "", LoginRequest{Username: "alice", Password: "S3cret!pass"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	cost, err := bcrypt.Cost([]byte(users[0].PasswordHash))
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+2, cost)
+
+	// The next login verifies the new hash and keeps it
+	upgraded := users[0].PasswordHash
+	loginAs(t, router, "alice", "S3cret!pass")
+	assert.Equal(t, upgraded, users[0].PasswordHash)
+}
+
+// racingHasher accepts any password and changes the stored hash while
+// rehashing, as a concurrent password change would
+type racingHasher struct{}
+
+func (racingHasher) Hash(password string) (string, error) {
+	usersMutex.Lock()
+	users[0].PasswordHash = "changed"
+	usersMutex.Unlock()
+	return "rehashed", nil
+}
+
+func (racingHasher) Verify(password, hash string) (bool, bool) {
+	return true, true
+}
+
+func TestLoginRehashKeepsConcurrentPasswordChange(t *testing.T) {
+	useHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+	resetStores(t, "alice", "S3cret!pass", RoleUser)
+	router := setupRouter()
+
+	useHasher(t, racingHasher{})
+	loginAs(t, router, "alice", "S3cret!pass")
+	assert.Equal(t, "changed", users[0].PasswordHash)
+}
+
+func TestErrorContentNegotiation(t *testing.T) {
+	resetStores(t, "john", "Password123!", RoleUser)
+	router := setupRouter()
+	requestID := "123e4567-e89b-12d3-a456-426614174000"
+
+	register := func(accept string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(gin.H{"username": "ab", "email": "not-an-email"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/auth/register", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", requestID)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		return w
+	}
+
+	for _, accept := range []string{"", "application/json", "*/*", "image/png"} {
+		w := register(accept)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json", accept)
+		var resp APIResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), accept)
+		assert.False(t, resp.Success)
+		assert.NotEmpty(t, resp.Errors)
+		assert.Equal(t, requestID, resp.RequestID)
+	}
+
+	w := register("text/plain")
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	fields := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		assert.True(t, ok, line)
+		fields[key] = value
+	}
+	assert.Equal(t, "Validation failed", fields["error"])
+	assert.Equal(t, "username must be at least 3 characters", fields["field username"])
+	assert.Equal(t, requestID, fields["request_id"])
+
+	for _, accept := range []string{"application/xml", "text/xml"} {
+		w = register(accept)
+		assert.Contains(t, w.Header().Get("Content-Type"), "xml", accept)
+		var resp xmlError
+		assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &resp), accept)
+		assert.Equal(t, http.StatusBadRequest, resp.Status)
+		assert.Equal(t, "Validation failed", resp.Message)
+		assert.NotEmpty(t, resp.Fields)
+		assert.Equal(t, requestID, resp.RequestID)
+	}
+
+	// Successful responses stay JSON whatever the Accept header
+	token := loginAs(t, router, "john", "Password123!").AccessToken
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/plain")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+}